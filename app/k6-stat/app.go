@@ -1,8 +1,13 @@
 package k6_stat
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/goccy/go-json"
@@ -12,54 +17,265 @@ import (
 	"github.com/rs/zerolog"
 
 	"github.com/msaf1980/k6-stat/dbs"
+	"github.com/msaf1980/k6-stat/utils/tlsconfig"
+)
+
+// HeaderTotalCount is a response header with total count of matched items (for paginated requests)
+const HeaderTotalCount = "X-Total-Count"
+
+const (
+	// DefaultTestsLimit is a tests listing page size, if limit is not set
+	DefaultTestsLimit = 100
+	// MaxTestsLimit is a maximum tests listing page size (greater limit is truncated)
+	MaxTestsLimit = 1000
 )
 
 type App struct {
 	db       *dbs.DB
 	fiberApp *fiber.App
 	logger   *zerolog.Logger
+	config   Config
+	metrics  *apiMetrics
+	queries  *apiMetrics // DB queries statistic by query kind
+	auth     *auth
+	certs    *certReloader
+	notifier *Notifier
+	// canceled on shutdown (stop notifier and live views)
+	ctx    context.Context
+	cancel context.CancelFunc
+	// set to 1 on shutdown (readiness check fails), accessed with atomic functions
+	shutdown int32
 }
 
-func NewWithDB(db *sql.DB, logger *zerolog.Logger, tableTests, tableSamples string) (*App, error) {
+// Config is an optional App settings
+type Config struct {
+	// Quantile is a default quantile function for samples queries (used, if not set in request filter)
+	Quantile string
+	// TableAnnotations is a tests annotations companion table (annotations are disabled, if empty)
+	TableAnnotations string
+	// TableRollup is a pre-aggregated samples table (used, if exist)
+	TableRollup string
+	// Cluster is a cluster name for cluster deployment (used for mutations on local replicated tables)
+	Cluster string
+	// CacheSize is a samples queries results cache size in bytes (cache is disabled, if zero)
+	CacheSize int64
+	// CacheDir is an optional on-disk cache directory (limited by CacheSize)
+	CacheDir string
+	// CacheScope is a database identity (like address and database name) for cache keys
+	CacheScope string
+	// RunningTimeout is a timeout from the last test sample, after that test is assumed finished (and cached)
+	RunningTimeout time.Duration
+	// Metrics enable Prometheus /metrics endpoint
+	Metrics bool
+	// MetricsTests is a tests name patterns (LIKE format), the latest runs statistics (with delta to baseline) are exported to metrics
+	MetricsTests []string
+	// TLS is a HTTPS server settings (disabled, if certificate is not set)
+	TLS TLSConfig
+	// DBTLS is a ClickHouse connection TLS settings (used by New)
+	DBTLS tlsconfig.Config
+	// Auth is an API authentication settings (disabled, if no one method is set)
+	Auth AuthConfig
+	// CorsOrigins is a CORS allowed origins list (like https://grafana.example.com, `*` for any), CORS is disabled if empty
+	CorsOrigins []string
+	// Notify is a finished runs webhook notifications settings (disabled without webhooks)
+	Notify NotifyConfig
+	// Version is a server version, reported by /version endpoint
+	Version string
+}
+
+func NewWithDB(db *sql.DB, logger *zerolog.Logger, tableTests, tableSamples string, config ...Config) (*App, error) {
+	var cfg Config
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	if quantileFunc, err := dbs.QuantileFuncFromString(cfg.Quantile); err == nil {
+		cfg.Quantile = string(quantileFunc)
+	} else {
+		return nil, err
+	}
+
 	app := fiber.New(fiber.Config{
 		JSONEncoder: json.Marshal,
 		JSONDecoder: json.Unmarshal,
 	})
-	app.Use(cors.New())
+	if len(cfg.CorsOrigins) > 0 {
+		app.Use(cors.New(cors.Config{
+			AllowOrigins:  strings.Join(cfg.CorsOrigins, ","),
+			AllowMethods:  "GET,POST,HEAD,PUT,DELETE,PATCH",
+			AllowHeaders:  "Authorization,Content-Type,X-API-Key,If-None-Match",
+			ExposeHeaders: HeaderTotalCount + ",ETag",
+		}))
+	}
 
 	// Custom Config
 	app.Use(fiberlog.New(fiberlog.Config{
 		Logger: logger,
 		Next: func(ctx *fiber.Ctx) bool {
-			// skip if we hit /check
-			// 	return ctx.Path() == "/check"
-			return false
+			// skip health probes
+			return ctx.Path() == "/healthz" || ctx.Path() == "/readyz"
 		},
 	}))
 
+	appAuth, err := newAuth(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
 	a := &App{
 		db:       dbs.New(db, tableTests, tableSamples),
 		fiberApp: app,
 		logger:   logger,
+		config:   cfg,
+		auth:     appAuth,
 	}
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	// health probes, version and API specification are registered before authentication middleware
+	app.Get("/healthz", a.healthz)
+	app.Get("/readyz", a.readyz)
+	app.Get("/version", a.version)
+	app.Get("/api/openapi.json", a.openapi)
+	if appAuth != nil {
+		app.Use(appAuth.middleware)
+	}
+	if cfg.TLS.CertFile != "" {
+		if a.certs, err = newCertReloader(cfg.TLS, logger); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Metrics {
+		a.metrics = newApiMetrics()
+		a.queries = newApiMetrics()
+		a.db.SetQueryObserver(a.queries.observeQuery)
+		app.Use(a.metrics.middleware)
+		app.Get("/metrics", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
+			return a.getMetrics(c)
+		})
+	}
+	a.db.SetTableAnnotations(cfg.TableAnnotations)
+	a.db.SetTableRollup(cfg.TableRollup)
+	a.db.SetCluster(cfg.Cluster)
+	a.db.SetRunningTimeout(cfg.RunningTimeout)
+	if cfg.CacheSize > 0 {
+		cache, err := dbs.NewCache(cfg.CacheSize, cfg.CacheDir)
+		if err != nil {
+			return nil, err
+		}
+		a.db.SetCache(cache, cfg.RunningTimeout)
+		a.db.SetCacheScope(cfg.CacheScope)
+	}
+	if len(cfg.Notify.Webhooks) > 0 {
+		notifier, err := NewNotifier(a.db, logger, cfg.Notify, cfg.Quantile)
+		if err != nil {
+			return nil, err
+		}
+		a.notifier = notifier
+	}
+
+	app.Get("/api/cache", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
+		return a.getCacheStats(c)
+	})
 
-	app.Post("/api/tests", func(c *fiber.Ctx) error {
+	app.Delete("/api/cache", a.requireRole(RoleAdmin), func(c *fiber.Ctx) error {
+		return a.clearCache(c)
+	})
+
+	app.Post("/api/tests", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
 		return a.getTests(c)
 	})
 
-	app.Post("/api/test/http/duration", func(c *fiber.Ctx) error {
+	// stable resource urls (can be bookmarked, finished tests resources has ETag)
+	app.Get("/api/tests", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
+		return a.getTestsByQuery(c)
+	})
+
+	app.Get("/api/tests/:id/:start", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
+		return a.getTest(c)
+	})
+
+	app.Get("/api/tests/:id/:start/http/top", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
+		return a.getHttpTestTop(c)
+	})
+
+	app.Get("/api/compare/:id/:start/:refid/:refstart", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
+		return a.getCompare(c)
+	})
+
+	app.Patch("/api/test", a.requireRole(RoleWrite), func(c *fiber.Ctx) error {
+		return a.annotateTest(c)
+	})
+
+	app.Delete("/api/test", a.requireRole(RoleAdmin), func(c *fiber.Ctx) error {
+		return a.deleteTest(c)
+	})
+
+	app.Post("/api/test/http/duration", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
 		return a.getHttpSamplesDurations(c)
 	})
 
-	app.Post("/api/test/http/status", func(c *fiber.Ctx) error {
+	app.Post("/api/test/http/status", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
 		return a.getHttpSamplesStatus(c)
 	})
 
+	app.Post("/api/test/http/samples", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
+		return a.getHttpTestSamples(c)
+	})
+
+	app.Get("/api/test/live", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
+		return a.getLiveSamples(c)
+	})
+
+	app.Post("/api/test/checks", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
+		return a.getChecks(c)
+	})
+
+	app.Post("/api/test/range", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
+		return a.getTestRange(c)
+	})
+
+	app.Post("/api/test/rates", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
+		return a.getSamplesRates(c)
+	})
+
+	app.Post("/api/test/http/histogram", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
+		return a.getHttpSamplesHistogram(c)
+	})
+
+	app.Post("/api/test/http/histogram/diff", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
+		return a.getHttpSamplesHistogramDiff(c)
+	})
+
+	app.Post("/api/test/http/heatmap", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
+		return a.getHttpSamplesHeatmap(c)
+	})
+
+	// Grafana simple-JSON datasource
+	app.Get("/grafana", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
+		return c.SendStatus(http.StatusOK)
+	})
+
+	app.Post("/grafana/search", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
+		return a.grafanaSearch(c)
+	})
+
+	app.Post("/grafana/query", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
+		return a.grafanaQuery(c)
+	})
+
+	app.Post("/grafana/annotations", a.requireRole(RoleRead), func(c *fiber.Ctx) error {
+		return a.grafanaAnnotations(c)
+	})
+
 	return a, nil
 }
 
-func New(dbDSN string, maxConn int, logger *zerolog.Logger, tableTests, tableSamples string) (*App, error) {
-	db, err := sql.Open("clickhouse", dbDSN)
+func New(dbDSN string, maxConn int, logger *zerolog.Logger, tableTests, tableSamples string, config ...Config) (*App, error) {
+	var tlsConfig *tls.Config
+	if len(config) > 0 && config[0].DBTLS.Enabled() {
+		var err error
+		if tlsConfig, err = config[0].DBTLS.ClientConfig(); err != nil {
+			return nil, err
+		}
+	}
+	db, err := dbs.Open(dbDSN, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -68,15 +284,37 @@ func New(dbDSN string, maxConn int, logger *zerolog.Logger, tableTests, tableSam
 	db.SetMaxOpenConns(maxConn)
 	db.SetConnMaxIdleTime(time.Hour)
 
-	return NewWithDB(db, logger, tableTests, tableSamples)
+	return NewWithDB(db, logger, tableTests, tableSamples, config...)
 }
 
 func (app *App) Listen(address string) error {
-	return app.fiberApp.Listen(address)
+	if app.notifier != nil {
+		go app.notifier.Run(app.ctx)
+	}
+	if app.certs == nil {
+		return app.fiberApp.Listen(address)
+	}
+	ln, err := app.listener(address)
+	if err != nil {
+		return err
+	}
+	return app.fiberApp.Listener(ln)
 }
 
+// Shutdown stop notifier and live views and gracefully shutdown server (wait for active requests without limit)
 func (app *App) Shutdown() error {
-	return app.fiberApp.Shutdown()
+	return app.ShutdownWithTimeout(0)
+}
+
+// ShutdownWithTimeout stop notifier and live views, fail readiness checks and gracefully shutdown server,
+// active requests are interrupted after timeout (without limit, if zero)
+func (app *App) ShutdownWithTimeout(timeout time.Duration) error {
+	atomic.StoreInt32(&app.shutdown, 1)
+	app.cancel()
+	if timeout <= 0 {
+		return app.fiberApp.Shutdown()
+	}
+	return app.fiberApp.ShutdownWithTimeout(timeout)
 }
 
 func (app *App) Close() error {
@@ -87,31 +325,132 @@ func (app *App) getTests(c *fiber.Ctx) error {
 	var filter dbs.TestFilter
 	if err := c.BodyParser(&filter); err != nil {
 		if err != fiber.ErrUnprocessableEntity && len(c.Request().Body()) > 0 {
-			return c.Status(http.StatusBadRequest).SendString(err.Error())
+			return badRequest(c, err)
 		}
 	}
-	tests, err := app.db.GetTests(filter)
+	return app.sendTests(c, filter)
+}
+
+// sendTests send tests page (with total count header)
+func (app *App) sendTests(c *fiber.Ctx, filter dbs.TestFilter) error {
+	if filter.Limit == 0 {
+		filter.Limit = DefaultTestsLimit
+	} else if filter.Limit > MaxTestsLimit {
+		filter.Limit = MaxTestsLimit
+	}
+	tests, err := app.db.GetTests(c.UserContext(), filter)
 	if err != nil {
-		app.logger.Error().Uint64("id", c.Context().ID()).Str("sql", err.Query()).Err(err.Wrapped()).Msg("get tests")
-		return c.Status(err.Code()).SendString(err.Error())
+		return app.queryError(c, err, "get tests")
+	}
+
+	total := uint64(len(tests))
+	if total == filter.Limit || filter.Offset > 0 {
+		if total, err = app.db.CountTests(c.UserContext(), filter); err != nil {
+			return app.queryError(c, err, "count tests")
+		}
 	}
+	c.Set(HeaderTotalCount, strconv.FormatUint(total, 10))
 
 	return c.JSON(tests)
 }
 
+func (app *App) annotateTest(c *fiber.Ctx) error {
+	var patch dbs.TestPatch
+	if err := c.BodyParser(&patch); err != nil {
+		return badRequest(c, err)
+	}
+
+	annotation, err := app.db.AnnotateTest(c.UserContext(), patch)
+	if err != nil {
+		return app.queryError(c, err, "annotate test")
+	}
+
+	return c.JSON(annotation)
+}
+
+func (app *App) deleteTest(c *fiber.Ctx) error {
+	var filter dbs.TestIdFilter
+	if err := c.BodyParser(&filter); err != nil {
+		return badRequest(c, err)
+	}
+	if filter.Id == 0 {
+		return sendError(c, http.StatusBadRequest, dbs.ErrCodeInvalidFilter, "test id not set")
+	}
+
+	if err := app.db.DeleteTest(c.UserContext(), filter); err != nil {
+		return app.queryError(c, err, "delete test")
+	}
+	app.logger.Info().Uint64("id", c.Context().ID()).Uint64("test_id", filter.Id).Int64("test_time", filter.Time).Msg("test deleted")
+
+	return c.SendStatus(http.StatusNoContent)
+}
+
+func (app *App) getCacheStats(c *fiber.Ctx) error {
+	cache := app.db.Cache()
+	if cache == nil {
+		return sendError(c, http.StatusNotImplemented, dbs.ErrCodeNotImplemented, "cache disabled")
+	}
+	return c.JSON(cache.Stats())
+}
+
+func (app *App) clearCache(c *fiber.Ctx) error {
+	cache := app.db.Cache()
+	if cache == nil {
+		return sendError(c, http.StatusNotImplemented, dbs.ErrCodeNotImplemented, "cache disabled")
+	}
+	if err := cache.Clear(); err != nil {
+		app.logger.Error().Uint64("id", c.Context().ID()).Err(err).Msg("clear cache")
+		return sendError(c, http.StatusInternalServerError, dbs.ErrCodeInternal, "clear cache failed")
+	}
+
+	return c.SendStatus(http.StatusNoContent)
+}
+
 func (app *App) getHttpSamplesDurations(c *fiber.Ctx) error {
 	var filters dbs.SampleFilter
 
 	if err := c.BodyParser(&filters); err != nil {
 		if err != fiber.ErrUnprocessableEntity && len(c.Request().Body()) > 0 {
-			return c.Status(http.StatusBadRequest).SendString(err.Error())
+			return badRequest(c, err)
 		}
 	}
 
-	samples, err := app.db.GetHttpSamplesDurations(filters)
+	if filters.Quantile == "" {
+		filters.Quantile = app.config.Quantile
+	}
+
+	samples, err := app.db.GetHttpSamplesDurations(c.UserContext(), filters)
 	if err != nil {
-		app.logger.Error().Uint64("id", c.Context().ID()).Str("sql", err.Query()).Err(err.Wrapped()).Msg("get tests")
-		return c.Status(err.Code()).SendString(err.Error())
+		return app.queryError(c, err, "get tests")
+	}
+
+	return c.JSON(samples)
+}
+
+func (app *App) getHttpTestSamples(c *fiber.Ctx) error {
+	var filters dbs.SampleFilter
+
+	if err := c.BodyParser(&filters); err != nil {
+		if err != fiber.ErrUnprocessableEntity && len(c.Request().Body()) > 0 {
+			return badRequest(c, err)
+		}
+	}
+	if filters.Id == 0 {
+		return sendError(c, http.StatusBadRequest, dbs.ErrCodeInvalidFilter, "test id not set")
+	}
+
+	if filters.Quantile == "" {
+		filters.Quantile = app.config.Quantile
+	}
+
+	test, err := app.db.GetTestById(c.UserContext(), dbs.TestIdFilter{Id: filters.Id, Time: filters.Start})
+	if err != nil {
+		return app.queryError(c, err, "get test")
+	}
+
+	samples, err := app.db.GetHttpTestSamples(c.UserContext(), test, filters)
+	if err != nil {
+		return app.queryError(c, err, "get test samples")
 	}
 
 	return c.JSON(samples)
@@ -122,15 +461,164 @@ func (app *App) getHttpSamplesStatus(c *fiber.Ctx) error {
 
 	if err := c.BodyParser(&filters); err != nil {
 		if err != fiber.ErrUnprocessableEntity && len(c.Request().Body()) > 0 {
-			return c.Status(http.StatusBadRequest).SendString(err.Error())
+			return badRequest(c, err)
 		}
 	}
 
-	samples, err := app.db.GetHttpSamplesStatus(filters)
+	samples, err := app.db.GetHttpSamplesStatus(c.UserContext(), filters)
 	if err != nil {
-		app.logger.Error().Uint64("id", c.Context().ID()).Str("sql", err.Query()).Err(err.Wrapped()).Msg("get tests")
-		return c.Status(err.Code()).SendString(err.Error())
+		return app.queryError(c, err, "get tests")
 	}
 
 	return c.JSON(samples)
 }
+
+// ChecksFilter is a checks request (checks are compared with reference, if reference id is set)
+type ChecksFilter struct {
+	dbs.SampleFilter
+	RefId    uint64 `json:"ref-id,omitempty"`
+	RefStart int64  `json:"ref-start,omitempty"`
+	// Threshold is a pass rate drop (in percents) for mark check as dropped (dbs.ChecksDropThreshold, if not set)
+	Threshold float64 `json:"threshold,omitempty"`
+}
+
+func (app *App) getChecks(c *fiber.Ctx) error {
+	var filters ChecksFilter
+
+	if err := c.BodyParser(&filters); err != nil {
+		if err != fiber.ErrUnprocessableEntity && len(c.Request().Body()) > 0 {
+			return badRequest(c, err)
+		}
+	}
+
+	if filters.Threshold < 0 {
+		return sendError(c, http.StatusBadRequest, dbs.ErrCodeInvalidFilter, "negative checks threshold")
+	}
+
+	checks, err := app.db.GetChecks(c.UserContext(), filters.SampleFilter)
+	if err != nil {
+		return app.queryError(c, err, "get checks")
+	}
+	if filters.RefId == 0 {
+		return c.JSON(checks)
+	}
+
+	refFilters := filters.SampleFilter
+	refFilters.Id = filters.RefId
+	refFilters.Start = filters.RefStart
+	refChecks, err := app.db.GetChecks(c.UserContext(), refFilters)
+	if err != nil {
+		return app.queryError(c, err, "get reference checks")
+	}
+
+	threshold := filters.Threshold
+	if threshold == 0 {
+		threshold = dbs.ChecksDropThreshold
+	}
+
+	return c.JSON(dbs.DiffChecks(checks, refChecks, threshold))
+}
+
+func (app *App) getTestRange(c *fiber.Ctx) error {
+	var filters dbs.SampleFilter
+
+	if err := c.BodyParser(&filters); err != nil {
+		if err != fiber.ErrUnprocessableEntity && len(c.Request().Body()) > 0 {
+			return badRequest(c, err)
+		}
+	}
+
+	r, err := app.db.GetTestRange(c.UserContext(), filters)
+	if err != nil {
+		return app.queryError(c, err, "get test range")
+	}
+
+	return c.JSON(r)
+}
+
+func (app *App) getSamplesRates(c *fiber.Ctx) error {
+	var filters dbs.SampleFilter
+
+	if err := c.BodyParser(&filters); err != nil {
+		if err != fiber.ErrUnprocessableEntity && len(c.Request().Body()) > 0 {
+			return badRequest(c, err)
+		}
+	}
+
+	rates, err := app.db.GetSamplesRates(c.UserContext(), filters)
+	if err != nil {
+		return app.queryError(c, err, "get samples rates")
+	}
+
+	return c.JSON(rates)
+}
+
+func (app *App) getHttpSamplesHistogram(c *fiber.Ctx) error {
+	var filters dbs.HistogramFilter
+
+	if err := c.BodyParser(&filters); err != nil {
+		if err != fiber.ErrUnprocessableEntity && len(c.Request().Body()) > 0 {
+			return badRequest(c, err)
+		}
+	}
+
+	hist, err := app.db.GetHttpSamplesHistogram(c.UserContext(), filters)
+	if err != nil {
+		return app.queryError(c, err, "get samples histogram")
+	}
+
+	return c.JSON(hist)
+}
+
+// HistogramDiffFilter is a histogram diff request (reference use buckets and label/url filters from test)
+type HistogramDiffFilter struct {
+	Test dbs.HistogramFilter `json:"test"`
+	Ref  dbs.SampleFilter    `json:"ref"`
+}
+
+func (app *App) getHttpSamplesHistogramDiff(c *fiber.Ctx) error {
+	var filters HistogramDiffFilter
+
+	if err := c.BodyParser(&filters); err != nil {
+		if err != fiber.ErrUnprocessableEntity && len(c.Request().Body()) > 0 {
+			return badRequest(c, err)
+		}
+	}
+
+	hist, err := app.db.GetHttpSamplesHistogram(c.UserContext(), filters.Test)
+	if err != nil {
+		return app.queryError(c, err, "get samples histogram")
+	}
+
+	refFilters := filters.Test
+	refFilters.Id = filters.Ref.Id
+	refFilters.Start = filters.Ref.Start
+	refHist, err := app.db.GetHttpSamplesHistogram(c.UserContext(), refFilters)
+	if err != nil {
+		return app.queryError(c, err, "get reference samples histogram")
+	}
+
+	diff, diffErr := dbs.DiffHistograms(hist, refHist)
+	if diffErr != nil {
+		return sendError(c, http.StatusBadRequest, dbs.ErrCodeInvalidFilter, diffErr.Error())
+	}
+
+	return c.JSON(diff)
+}
+
+func (app *App) getHttpSamplesHeatmap(c *fiber.Ctx) error {
+	var filters dbs.HistogramFilter
+
+	if err := c.BodyParser(&filters); err != nil {
+		if err != fiber.ErrUnprocessableEntity && len(c.Request().Body()) > 0 {
+			return badRequest(c, err)
+		}
+	}
+
+	heatmap, err := app.db.GetHttpSamplesHeatmap(c.UserContext(), filters)
+	if err != nil {
+		return app.queryError(c, err, "get samples heatmap")
+	}
+
+	return c.JSON(heatmap)
+}
@@ -4,7 +4,9 @@
 package k6_stat
 
 import (
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -30,11 +32,14 @@ var (
 
 func init() {
 	t1, _ = time.Parse(time.RFC3339, "2006-01-02T15:04:05Z")
-	test1 = dbs.Test{Id: uint64(t1.UnixNano()), Ts: t1, Name: "graphite-clickhouse 1", Params: "1"}
+	test1 = dbs.Test{Id: uint64(t1.UnixNano()), Ts: t1, Name: "graphite-clickhouse 1", Params: "USERS=1 DURATION=1h",
+		ParamsMap: map[string]string{"USERS": "1", "DURATION": "1h"}}
 	t2, _ = time.Parse(time.RFC3339, "2006-01-02T18:04:05Z")
-	test2 = dbs.Test{Id: uint64(t2.UnixNano()), Ts: t2, Name: "carbonapi 1", Params: "1"}
+	test2 = dbs.Test{Id: uint64(t2.UnixNano()), Ts: t2, Name: "carbonapi 1", Params: "USERS=1",
+		ParamsMap: map[string]string{"USERS": "1"}}
 	t3, _ = time.Parse(time.RFC3339, "2006-01-03T15:04:05Z")
-	test3 = dbs.Test{Id: uint64(t3.UnixNano()), Ts: t3, Name: "graphite-clickhouse 2", Params: "2"}
+	test3 = dbs.Test{Id: uint64(t3.UnixNano()), Ts: t3, Name: "graphite-clickhouse 2", Params: "USERS=2",
+		ParamsMap: map[string]string{"USERS": "2"}}
 
 	allRows = sqlmock.NewRows([]string{"id", "ts", "name", "params"}).
 		AddRow(test1.Id, test1.Ts, test1.Name, test1.Params).
@@ -72,13 +77,13 @@ func TestUnitAppTests(t *testing.T) {
 		want        []dbs.Test
 	}{
 		{
-			sqlRegex:   `^SELECT id, ts, name, params FROM t_k6_tests ORDER BY id, ts, name$`,
+			sqlRegex:   `^SELECT id, ts, name, params FROM t_k6_tests ORDER BY id, ts, name LIMIT 100$`,
 			rows:       allRows,
 			wantStatus: http.StatusOK,
 			want:       []dbs.Test{test1, test2, test3},
 		},
 		{
-			sqlRegex:    `^SELECT id, ts, name, params FROM t_k6_tests WHERE ts >= \? AND ts < \? ORDER BY id, ts, name$`,
+			sqlRegex:    `^SELECT id, ts, name, params FROM t_k6_tests WHERE ts >= \? AND ts < \? ORDER BY id, ts, name LIMIT 100$`,
 			rows:        timeRows,
 			contentType: "application/json",
 			params:      `{ "from": 1, "until": 2}`,
@@ -86,13 +91,21 @@ func TestUnitAppTests(t *testing.T) {
 			want:        []dbs.Test{test1, test2},
 		},
 		{
-			sqlRegex:    `^SELECT id, ts, name, params FROM t_k6_tests WHERE ts >= \? AND ts < \? AND name LIKE \? ORDER BY id, ts, name$`,
+			sqlRegex:    `^SELECT id, ts, name, params FROM t_k6_tests WHERE ts >= \? AND ts < \? AND name LIKE \? ORDER BY id, ts, name LIMIT 100$`,
 			rows:        gchRows,
 			contentType: "application/json",
 			params:      `{ "from": 1, "until": 2, "name_prefix": "graphite-clickhouse"}`,
 			wantStatus:  http.StatusOK,
 			want:        []dbs.Test{test1, test3},
 		},
+		{
+			sqlRegex:    `^SELECT id, ts, name, params FROM t_k6_tests WHERE match\(params, \?\) AND match\(params, \?\) ORDER BY id, ts, name LIMIT 100$`,
+			rows:        sqlmock.NewRows([]string{"id", "ts", "name", "params"}).AddRow(test1.Id, test1.Ts, test1.Name, test1.Params),
+			contentType: "application/json",
+			params:      `{ "params": {"USERS": "1", "DURATION": ""}}`,
+			wantStatus:  http.StatusOK,
+			want:        []dbs.Test{test1},
+		},
 	}
 	for i, tt := range tests {
 		t.Run(fmt.Sprintf("[%d] %s", i, tt.sqlRegex), func(t *testing.T) {
@@ -143,3 +156,684 @@ func TestUnitAppTests(t *testing.T) {
 		})
 	}
 }
+
+func TestUnitAppInvalidQuantile(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = NewWithDB(db, &logger, "t_k6_tests", "t_k6_samples", Config{Quantile: "quantilesBFloat16"})
+	assert.Equal(t, dbs.ErrorInvalidQuantileFunc{Value: "quantilesBFloat16"}, err)
+}
+
+func TestUnitAppTestsPage(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE \(match\(name, \?\) OR match\(params, \?\)\) ORDER BY ts DESC, id DESC, name LIMIT 2 OFFSET 2$`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}).
+			AddRow(test1.Id, test1.Ts, test1.Name, test1.Params))
+	mock.ExpectQuery(`^SELECT count\(\) FROM t_k6_tests WHERE \(match\(name, \?\) OR match\(params, \?\)\)$`).
+		WillReturnRows(sqlmock.NewRows([]string{"count()"}).AddRow(uint64(3)))
+
+	app, err := NewWithDB(db, &logger, "t_k6_tests", "t_k6_samples")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("POST", "/api/tests",
+		strings.NewReader(`{"search": "^graphite|USERS=1", "desc": true, "limit": 2, "offset": 2}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/api/tests = %d (%s)", resp.StatusCode, string(body))
+	}
+	assert.Equal(t, "3", resp.Header.Get(HeaderTotalCount))
+	var tests []dbs.Test
+	if err = json.Unmarshal(body, &tests); err != nil {
+		t.Fatalf("/api/tests decode = %v", err)
+	}
+	assert.Equal(t, []dbs.Test{test1}, tests)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// page size is truncated
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests ORDER BY id, ts, name LIMIT 1000$`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}).
+			AddRow(test1.Id, test1.Ts, test1.Name, test1.Params))
+	req, _ = http.NewRequest("POST", "/api/tests", strings.NewReader(`{"limit": 100000}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get(HeaderTotalCount))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// invalid regexp
+	req, _ = http.NewRequest("POST", "/api/tests", strings.NewReader(`{"search": "("}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+type passValueConverter struct{}
+
+func (passValueConverter) ConvertValue(v any) (driver.Value, error) {
+	return v, nil
+}
+
+func TestUnitAppAnnotations(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE \(id, ts\) IN \(SELECT id, ts FROM t_k6_tests_annotations FINAL WHERE hasAll\(labels, \?\)\) AND NOT \(id, ts\) IN \(SELECT id, ts FROM t_k6_tests_annotations FINAL WHERE hidden = 1\) ORDER BY id, ts, name LIMIT 100$`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}).
+			AddRow(test1.Id, test1.Ts, test1.Name, test1.Params).
+			AddRow(test2.Id, test2.Ts, test2.Name, test2.Params))
+	updated := time.Unix(1674196900, 0).UTC()
+	mock.ExpectQuery(`^SELECT id, ts, name, text, labels, hidden, updated FROM t_k6_tests_annotations FINAL WHERE id IN \(` +
+		fmt.Sprintf("%d, %d", test1.Id, test2.Id) + `\)$`).
+		WillReturnRows(mock.NewRows([]string{"id", "ts", "name", "text", "labels", "hidden", "updated"}).
+			AddRow(test2.Id, test2.Ts, "", "cache warmed", []string{"baseline"}, uint8(0), updated))
+	mock.ExpectQuery(`^SELECT count\(\) FROM t_k6_tests WHERE id = @Id AND ts = @Time$`).
+		WillReturnRows(sqlmock.NewRows([]string{"count()"}).AddRow(uint64(1)))
+	mock.ExpectExec(`^DELETE FROM t_k6_samples WHERE id = @Id AND start = @Time$`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`^DELETE FROM t_k6_tests_annotations WHERE id = @Id AND ts = @Time$`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`^DELETE FROM t_k6_tests WHERE id = @Id AND ts = @Time$`).WillReturnResult(sqlmock.NewResult(0, 0))
+	// already deleted
+	mock.ExpectQuery(`^SELECT count\(\) FROM t_k6_tests WHERE id = @Id AND ts = @Time$`).
+		WillReturnRows(sqlmock.NewRows([]string{"count()"}).AddRow(uint64(0)))
+
+	app, err := NewWithDB(db, &logger, "t_k6_tests", "t_k6_samples", Config{TableAnnotations: "t_k6_tests_annotations"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("POST", "/api/tests", strings.NewReader(`{"labels": ["baseline"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/api/tests = %d (%s)", resp.StatusCode, string(body))
+	}
+	var tests []dbs.Test
+	if err = json.Unmarshal(body, &tests); err != nil {
+		t.Fatalf("/api/tests decode = %v", err)
+	}
+	annotated := test2
+	annotated.Annotation = &dbs.Annotation{
+		Id: test2.Id, Ts: test2.Ts, Text: "cache warmed", Labels: []string{"baseline"}, Updated: updated,
+	}
+	assert.Equal(t, []dbs.Test{test1, annotated}, tests)
+
+	req, _ = http.NewRequest("DELETE", "/api/test", strings.NewReader(fmt.Sprintf(`{"id": %d, "time": %d}`, test1.Id, test1.Ts.UnixNano())))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	req, _ = http.NewRequest("DELETE", "/api/test", strings.NewReader(fmt.Sprintf(`{"id": %d, "time": %d}`, test1.Id, test1.Ts.UnixNano())))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUnitAppAnnotationsDisabled(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := NewWithDB(db, &logger, "t_k6_tests", "t_k6_samples")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("PATCH", "/api/test", strings.NewReader(`{"id": 1, "time": 1, "text": "aborted"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+}
+
+func TestUnitAppTestSamplesNotFound(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := NewWithDB(db, &logger, "t_k6_tests", "t_k6_samples")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts = @Time AND id = @Id`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}))
+
+	req, _ := http.NewRequest("POST", "/api/test/http/samples", strings.NewReader(`{"id": 1, "start": 1}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	var errResp ErrorResponse
+	if err = json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ErrorResponse{Code: dbs.ErrCodeNotFound, Message: "test not found", RequestId: resp.Header.Get(HeaderRequestId)}, errResp)
+	assert.NotEmpty(t, errResp.RequestId)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUnitAppChecksDiff(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := NewWithDB(db, &logger, "t_k6_tests", "t_k6_samples")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns := []string{"id", "start", "label", "group", "check", "passes", "count"}
+	mock.ExpectQuery(`^SELECT id, start, label, tags\['group'\] AS check_group`).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(test2.Id, test2.Ts, "render", "", "status is 200", 90.0, 100.0).
+			AddRow(test2.Id, test2.Ts, "render", "", "body not empty", 100.0, 100.0))
+	mock.ExpectQuery(`^SELECT id, start, label, tags\['group'\] AS check_group`).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(test1.Id, test1.Ts, "render", "", "status is 200", 100.0, 100.0))
+
+	req, _ := http.NewRequest("POST", "/api/test/checks",
+		strings.NewReader(fmt.Sprintf(`{"id": %d, "start": %d, "ref-id": %d, "ref-start": %d}`, test2.Id, t2.UnixNano(), test1.Id, t1.UnixNano())))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !assert.Equal(t, http.StatusOK, resp.StatusCode, string(body)) {
+		return
+	}
+	var checks []dbs.SampleCheckDiff
+	if err = json.Unmarshal(body, &checks); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []dbs.SampleCheckDiff{
+		{
+			Label: "render", Check: "status is 200", Passes: 90, Count: 100, PassPcnt: 90,
+			PassesDiff: -10, PassPcntDiff: -10, Dropped: true,
+		},
+		{Label: "render", Check: "body not empty", Passes: 100, Count: 100, PassPcnt: 100, New: true},
+	}, checks)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// drop is less than threshold
+	mock.ExpectQuery(`^SELECT id, start, label, tags\['group'\] AS check_group`).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(test2.Id, test2.Ts, "render", "", "status is 200", 90.0, 100.0))
+	mock.ExpectQuery(`^SELECT id, start, label, tags\['group'\] AS check_group`).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(test1.Id, test1.Ts, "render", "", "status is 200", 100.0, 100.0))
+	req, _ = http.NewRequest("POST", "/api/test/checks",
+		strings.NewReader(fmt.Sprintf(`{"id": %d, "start": %d, "ref-id": %d, "ref-start": %d, "threshold": 20}`, test2.Id, t2.UnixNano(), test1.Id, t1.UnixNano())))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	if !assert.Equal(t, http.StatusOK, resp.StatusCode, string(body)) {
+		return
+	}
+	checks = nil
+	if err = json.Unmarshal(body, &checks); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []dbs.SampleCheckDiff{
+		{Label: "render", Check: "status is 200", Passes: 90, Count: 100, PassPcnt: 90, PassesDiff: -10, PassPcntDiff: -10},
+	}, checks)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	req, _ = http.NewRequest("POST", "/api/test/checks", strings.NewReader(`{"id": 1, "start": 1, "ref-id": 2, "threshold": -1}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestUnitAppQueryErrorSanitized(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := NewWithDB(db, &logger, "t_k6_tests", "t_k6_samples")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests`).
+		WillReturnError(errors.New("code: 62, message: Syntax error: failed at position 1 (SELECT id, ts, name, params FROM t_k6_tests)"))
+	req, _ := http.NewRequest("POST", "/api/tests", nil)
+	resp, err := app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	body, _ := io.ReadAll(resp.Body)
+	assert.NotContains(t, string(body), "SELECT")
+	var errResp ErrorResponse
+	if err = json.Unmarshal(body, &errResp); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ErrorResponse{Code: dbs.ErrCodeInternal, Message: "internal error", RequestId: resp.Header.Get(HeaderRequestId)}, errResp)
+
+	// invalid request body
+	req, _ = http.NewRequest("POST", "/api/tests", strings.NewReader(`{"limit": "x"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	if err = json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ErrCodeInvalidRequest, errResp.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUnitAppLiveFinished(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.MatchExpectationsInOrder(false)
+	app, err := NewWithDB(db, &logger, "t_k6_tests", "t_k6_samples")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts = @Time AND id = @Id`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}).AddRow(uint64(1), start, "graphite", ""))
+	mock.ExpectQuery(`^SELECT id, start, label, url, .* FROM t_k6_samples WHERE .* AND ts >= @From AND ts < @Until GROUP BY id, start, label, url ORDER`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "url", "max"}))
+	mock.ExpectQuery(`^SELECT id, start, label, url, status, sum\(value\) FROM t_k6_samples`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "url", "status", "count"}))
+	mock.ExpectQuery(`^SELECT id, start, label, tags\['group'\] AS check_group`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "group", "check", "passes", "count"}))
+	mock.ExpectQuery(`^SELECT id, start, min\(ts\), max\(ts\) FROM t_k6_samples`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "from", "until"}))
+	mock.ExpectQuery(`^SELECT id, start, label, url, metric, sum\(value\) FROM t_k6_samples`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "url", "metric", "value"}))
+	mock.ExpectQuery(`^SELECT max\(ts\) FROM t_k6_samples WHERE id = @Id AND start = @Time$`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(start.Add(10 * time.Minute)))
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/test/live?id=1&start=%d", start.UnixNano()), nil)
+	resp, err := app.fiberApp.Test(req, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "event: samples\ndata: {")
+	assert.True(t, strings.HasSuffix(string(body), "event: end\ndata: test finished\n\n"), string(body))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUnitAppLiveShutdown(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := NewWithDB(db, &logger, "t_k6_tests", "t_k6_samples")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// just started test, no samples before interval end lag
+	start := time.Now().Truncate(time.Second).UTC()
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts = @Time AND id = @Id`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}).AddRow(uint64(1), start, "graphite", ""))
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		app.cancel()
+	}()
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/test/live?id=1&start=%d&interval=1&lag=60", start.UnixNano()), nil)
+	resp, err := app.fiberApp.Test(req, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	// stream is closed on shutdown
+	assert.Equal(t, ": keep-alive\n\n", string(body))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUnitAppMetrics(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests ORDER BY id, ts, name LIMIT 100$`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}))
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests ORDER BY id, ts, name LIMIT 100$`).
+		WillReturnError(fmt.Errorf("connection reset"))
+
+	app, err := NewWithDB(db, &logger, "t_k6_tests", "t_k6_samples", Config{Metrics: true, CacheSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", "/api/tests", nil)
+		if _, err = app.fiberApp.Test(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	resp, err := app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "\n# TYPE k6_stat_api_request_duration_seconds histogram\n")
+	assert.Contains(t, string(body), "\nk6_stat_api_request_duration_seconds_bucket{endpoint=\"POST /api/tests\",le=\"60\"} 2\n")
+	assert.Contains(t, string(body), "\nk6_stat_api_request_duration_seconds_bucket{endpoint=\"POST /api/tests\",le=\"+Inf\"} 2\n")
+	assert.Contains(t, string(body), "\nk6_stat_api_request_duration_seconds_count{endpoint=\"POST /api/tests\"} 2\n")
+	assert.Contains(t, string(body), "\nk6_stat_api_errors_total{endpoint=\"POST /api/tests\"} 1\n")
+	assert.Contains(t, string(body), "\nk6_stat_db_query_duration_seconds_count{query=\"tests\"} 2\n")
+	assert.Contains(t, string(body), "\nk6_stat_db_query_errors_total{query=\"tests\"} 1\n")
+	assert.Contains(t, string(body), "\nk6_stat_cache_requests_total{result=\"miss\"} 0\n")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUnitAppMetricsTests(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-time.Minute).Truncate(time.Second).UTC()
+	// failed pattern is skipped
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE name LIKE \? ORDER BY ts DESC, id DESC, name LIMIT 2$`).
+		WillReturnError(fmt.Errorf("connection reset"))
+	// running test is skipped
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE name LIKE \? ORDER BY ts DESC, id DESC, name LIMIT 2$`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}).AddRow(uint64(1), start, "carbonapi", ""))
+	mock.ExpectQuery(`^SELECT max\(ts\) FROM t_k6_samples WHERE id = @Id AND start = @Time$`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(time.Now().UTC()))
+
+	app, err := NewWithDB(db, &logger, "t_k6_tests", "t_k6_samples", Config{Metrics: true, MetricsTests: []string{"graphite%", "carbonapi"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	resp, err := app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotContains(t, string(body), "\nk6_stat_test_start_timestamp_seconds{")
+	assert.Contains(t, string(body), "\nk6_stat_db_query_errors_total{query=\"tests\"} 1\n")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApiMetricsHistogram(t *testing.T) {
+	m := newApiMetrics()
+	m.add("GET /api/tests", 3*time.Millisecond, false)
+	m.add("GET /api/tests", 200*time.Millisecond, false)
+	m.add("GET /api/tests", 2*time.Minute, true)
+
+	var sb strings.Builder
+	m.write(&sb)
+	out := sb.String()
+	for _, line := range []string{
+		`k6_stat_api_request_duration_seconds_bucket{endpoint="GET /api/tests",le="0.005"} 1`,
+		`k6_stat_api_request_duration_seconds_bucket{endpoint="GET /api/tests",le="0.1"} 1`,
+		`k6_stat_api_request_duration_seconds_bucket{endpoint="GET /api/tests",le="0.25"} 2`,
+		`k6_stat_api_request_duration_seconds_bucket{endpoint="GET /api/tests",le="60"} 2`,
+		`k6_stat_api_request_duration_seconds_bucket{endpoint="GET /api/tests",le="+Inf"} 3`,
+		`k6_stat_api_request_duration_seconds_sum{endpoint="GET /api/tests"} 120.203`,
+		`k6_stat_api_request_duration_seconds_count{endpoint="GET /api/tests"} 3`,
+		`k6_stat_api_errors_total{endpoint="GET /api/tests"} 1`,
+	} {
+		assert.Contains(t, out, "\n"+line+"\n")
+	}
+}
+
+func TestWriteTestsMetrics(t *testing.T) {
+	diffs := []*dbs.TestSamplesDiff{
+		{
+			Test:      dbs.Test{Name: `graphite "nightly"`, Ts: time.Unix(1674196900, 0)},
+			Reference: dbs.Test{Name: `graphite "nightly"`, Ts: time.Unix(1674110500, 0)},
+			Stats:     []string{"p99"},
+			Samples: map[string][]dbs.SampleDurationsDiff{
+				"render": {
+					{Url: "target=b", Stats: map[string]float64{"p99": 105}, Count: 10, RPS: 1, ErrorsPcnt: 10},
+					{
+						Url: "target=a", Stats: map[string]float64{"p99": 150}, StatsDiff: map[string]float64{"p99": 50},
+						Count: 20, CountDiff: -1, RPS: 2, RPSDiff: -0.1, ErrorsPcnt: 0, ErrorsPcntDiff: -1.5,
+					},
+				},
+			},
+		},
+	}
+	var sb strings.Builder
+	writeTestsMetrics(&sb, diffs)
+	assert.Equal(t, `# HELP k6_stat_test_start_timestamp_seconds Latest run start time
+# TYPE k6_stat_test_start_timestamp_seconds gauge
+k6_stat_test_start_timestamp_seconds{test="graphite \"nightly\"",role="latest"} 1674196900
+k6_stat_test_start_timestamp_seconds{test="graphite \"nightly\"",role="baseline"} 1674110500
+# HELP k6_stat_http_req_duration Latest run http requests durations statistic (ms)
+# TYPE k6_stat_http_req_duration gauge
+k6_stat_http_req_duration{test="graphite \"nightly\"",label="render",url="target=a",stat="p99"} 150
+k6_stat_http_req_duration{test="graphite \"nightly\"",label="render",url="target=b",stat="p99"} 105
+# HELP k6_stat_http_req_duration_baseline_delta Latest run http requests durations statistic delta to baseline (ms)
+# TYPE k6_stat_http_req_duration_baseline_delta gauge
+k6_stat_http_req_duration_baseline_delta{test="graphite \"nightly\"",label="render",url="target=a",stat="p99"} 50
+# HELP k6_stat_http_reqs Latest run http requests count
+# TYPE k6_stat_http_reqs gauge
+k6_stat_http_reqs{test="graphite \"nightly\"",label="render",url="target=a"} 20
+k6_stat_http_reqs{test="graphite \"nightly\"",label="render",url="target=b"} 10
+# HELP k6_stat_http_reqs_baseline_delta Latest run http requests count delta to baseline
+# TYPE k6_stat_http_reqs_baseline_delta gauge
+k6_stat_http_reqs_baseline_delta{test="graphite \"nightly\"",label="render",url="target=a"} -1
+# HELP k6_stat_http_rps Latest run http requests per second
+# TYPE k6_stat_http_rps gauge
+k6_stat_http_rps{test="graphite \"nightly\"",label="render",url="target=a"} 2
+k6_stat_http_rps{test="graphite \"nightly\"",label="render",url="target=b"} 1
+# HELP k6_stat_http_rps_baseline_delta Latest run http requests per second delta to baseline
+# TYPE k6_stat_http_rps_baseline_delta gauge
+k6_stat_http_rps_baseline_delta{test="graphite \"nightly\"",label="render",url="target=a"} -0.1
+# HELP k6_stat_http_errors_percent Latest run http requests errors percent
+# TYPE k6_stat_http_errors_percent gauge
+k6_stat_http_errors_percent{test="graphite \"nightly\"",label="render",url="target=a"} 0
+k6_stat_http_errors_percent{test="graphite \"nightly\"",label="render",url="target=b"} 10
+# HELP k6_stat_http_errors_percent_baseline_delta Latest run http requests errors percent delta to baseline
+# TYPE k6_stat_http_errors_percent_baseline_delta gauge
+k6_stat_http_errors_percent_baseline_delta{test="graphite \"nightly\"",label="render",url="target=a"} -1.5
+`, sb.String())
+}
+
+func TestUnitAppGrafana(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := NewWithDB(db, &logger, "t_k6_tests", "t_k6_samples")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/grafana/", nil)
+	resp, err := app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// search
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests ORDER BY ts DESC, id DESC, name LIMIT 1000$`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}).
+			AddRow(test3.Id, test3.Ts, test3.Name, test3.Params).
+			AddRow(test2.Id, test2.Ts, test2.Name, test2.Params).
+			AddRow(test1.Id, test1.Ts, test1.Name, test1.Params).
+			AddRow(uint64(4), test1.Ts, "graphite_nightly 100%", ""))
+	req, _ = http.NewRequest("POST", "/grafana/search", strings.NewReader(`{"target": "graphite"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t,
+		`["top:graphite-clickhouse 1","top:graphite-clickhouse 2","top:graphite\\_nightly 100\\%",`+
+			`"diff:graphite-clickhouse 1","diff:graphite-clickhouse 2","diff:graphite\\_nightly 100\\%",`+
+			`"latency:graphite-clickhouse 1","latency:graphite-clickhouse 2","latency:graphite\\_nightly 100\\%"]`,
+		string(body),
+	)
+
+	// search result is matched literally
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts >= \? AND ts < \? AND name LIKE \? ORDER BY ts DESC, id DESC, name LIMIT 1$`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), `graphite\_nightly 100\%`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}))
+	req, _ = http.NewRequest("POST", "/grafana/query", strings.NewReader(
+		`{"range": {"from": "2006-01-02T00:00:00Z", "to": "2006-01-03T00:00:00Z"}, "targets": [{"target": "top:graphite\\_nightly 100\\%"}]}`,
+	))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `[{"type":"table","columns":[],"rows":[]}]`, string(body))
+
+	// annotations
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts >= \? AND ts < \? AND name LIKE \? ORDER BY ts DESC, id DESC, name$`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}).
+			AddRow(test1.Id, test1.Ts, test1.Name, test1.Params))
+	req, _ = http.NewRequest("POST", "/grafana/annotations", strings.NewReader(
+		`{"range": {"from": "2006-01-02T00:00:00Z", "to": "2006-01-03T00:00:00Z"}, "annotation": {"name": "tests", "query": "graphite%"}}`,
+	))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t,
+		`[{"annotation":{"name":"tests","query":"graphite%"},"time":1136214245000,"title":"graphite-clickhouse 1","text":"USERS=1 DURATION=1h","tags":[]}]`,
+		string(body),
+	)
+
+	// latency series
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts >= \? AND ts < \? AND name LIKE \? ORDER BY ts DESC, id DESC, name LIMIT 10$`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}).
+			AddRow(test1.Id, test1.Ts, test1.Name, test1.Params))
+	mock.ExpectQuery(`^SELECT toStartOfInterval\(ts, INTERVAL 30 SECOND\) AS t, label, url, quantilesExact\(0.99\)\(value\) FROM t_k6_samples WHERE`).
+		WillReturnRows(mock.NewRows([]string{"t", "label", "url", "q"}).
+			AddRow(t1, "find", "q=a.*", []float64{10}).
+			AddRow(t1.Add(30*time.Second), "find", "q=a.*", []float64{11}))
+	req, _ = http.NewRequest("POST", "/grafana/query", strings.NewReader(
+		`{"range": {"from": "2006-01-02T00:00:00Z", "to": "2006-01-03T00:00:00Z"}, "intervalMs": 30000, `+
+			`"targets": [{"target": "latency:graphite%", "refId": "A", "data": {"quantile": "quantilesExact", "stats": ["p99.00"]}}]}`,
+	))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t,
+		`[{"target":"graphite-clickhouse 1 find q=a.* p99","datapoints":[[10,1136214245000],[11,1136214275000]]}]`,
+		string(body),
+	)
+
+	// invalid target
+	req, _ = http.NewRequest("POST", "/grafana/query", strings.NewReader(`{"targets": [{"target": "graphite%"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = app.fiberApp.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGrafanaDiffTable(t *testing.T) {
+	diff := &dbs.TestSamplesDiff{
+		Stats: []string{"p99"},
+		Samples: map[string][]dbs.SampleDurationsDiff{
+			"render": {
+				{Url: "target=b", Stats: map[string]float64{"p99": 105}, Count: 10, RPS: 1, ErrorsPcnt: 10},
+				{
+					Url: "target=a", Stats: map[string]float64{"p99": 150}, StatsDiff: map[string]float64{"p99": 50},
+					Count: 20, CountDiff: -1, RPS: 2, RPSDiff: -0.1, ErrorsPcntDiff: -1.5,
+				},
+			},
+		},
+	}
+	table := grafanaDiffTable(diff)
+	assert.Equal(t, []string{"Label", "Url", "p99", "p99 diff", "Count", "Count diff", "RPS", "RPS diff", "Errors %", "Errors % diff"}, func() []string {
+		names := make([]string, len(table.Columns))
+		for i, c := range table.Columns {
+			names[i] = c.Text
+		}
+		return names
+	}())
+	assert.Equal(t, [][]any{
+		{"render", "target=a", 150.0, 50.0, 20.0, -1.0, 2.0, -0.1, 0.0, -1.5},
+		{"render", "target=b", 105.0, 0.0, 10.0, 0.0, 1.0, 0.0, 10.0, 0.0},
+	}, table.Rows)
+}
@@ -0,0 +1,373 @@
+package k6_stat
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role is an API access level (the higher role include the lower roles permissions)
+type Role int8
+
+const (
+	RoleNone  Role = iota
+	RoleRead       // query tests and samples
+	RoleWrite      // annotate tests (labels, baselines)
+	RoleAdmin      // delete tests, clear cache
+)
+
+var roleNames = []string{"none", "read", "write", "admin"}
+
+func (r Role) String() string {
+	if r < 0 || int(r) >= len(roleNames) {
+		return fmt.Sprintf("Role(%d)", r)
+	}
+	return roleNames[r]
+}
+
+var (
+	ErrInvalidRole        = errors.New("invalid role, must be read, write or admin")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// ParseRole parse role name (read, write or admin)
+func ParseRole(s string) (Role, error) {
+	for i := RoleRead; int(i) < len(roleNames); i++ {
+		if roleNames[i] == s {
+			return i, nil
+		}
+	}
+	return RoleNone, fmt.Errorf("%w: %q", ErrInvalidRole, s)
+}
+
+// User is an authenticated API user
+type User struct {
+	Name string
+	Role Role
+}
+
+// Authenticator check request credentials. It return nil user and nil error if request has no credentials for it,
+// error for invalid credentials.
+type Authenticator interface {
+	Authenticate(c *fiber.Ctx) (*User, error)
+}
+
+// AuthConfig is an API authentication settings (authentication is disabled, if no one method is set)
+type AuthConfig struct {
+	// TokensFile is a static bearer tokens (API keys) file with `token role [name]` lines
+	TokensFile string
+	// UsersFile is a HTTP basic auth users file with `user:bcrypt-hash:role` lines
+	UsersFile string
+	// ProxyHeader is a user name header, set by trusted authenticating proxy (like X-Forwarded-User)
+	ProxyHeader string
+	// ProxyRoleHeader is an optional role header, set by trusted proxy (RoleRead if not set)
+	ProxyRoleHeader string
+	// TrustedProxies is a trusted proxies addresses (IP or CIDR)
+	TrustedProxies []string
+	// ClientCertsFile is a client certificates users file with `common-name role` lines (certificates are verified by TLS server)
+	ClientCertsFile string
+	// AnonymousRole is a role for requests without credentials (denied if empty)
+	AnonymousRole string
+}
+
+func (cfg *AuthConfig) enabled() bool {
+	return cfg.TokensFile != "" || cfg.UsersFile != "" || cfg.ProxyHeader != "" || cfg.ClientCertsFile != ""
+}
+
+const userLocalsKey = "user"
+
+// readAuthFile read non-empty and not commented lines
+func readAuthFile(path string, parse func(line string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if err = parse(line); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+	}
+	return sc.Err()
+}
+
+// tokenAuth is a static bearer tokens (Authorization: Bearer or X-API-Key header) authenticator
+type tokenAuth struct {
+	tokens map[[sha256.Size]byte]*User
+}
+
+// newTokenAuth load tokens from file with `token role [name]` lines
+func newTokenAuth(path string) (*tokenAuth, error) {
+	a := &tokenAuth{tokens: make(map[[sha256.Size]byte]*User)}
+	err := readAuthFile(path, func(line string) error {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return errors.New("invalid token, must be `token role [name]`")
+		}
+		role, err := ParseRole(fields[1])
+		if err != nil {
+			return err
+		}
+		user := &User{Name: "token", Role: role}
+		if len(fields) == 3 {
+			user.Name = fields[2]
+		}
+		a.tokens[sha256.Sum256([]byte(fields[0]))] = user
+		return nil
+	})
+	return a, err
+}
+
+func (a *tokenAuth) Authenticate(c *fiber.Ctx) (*User, error) {
+	token := c.Get("X-API-Key")
+	if token == "" {
+		var ok bool
+		if token, ok = cutPrefixFold(c.Get(fiber.HeaderAuthorization), "Bearer "); !ok {
+			return nil, nil
+		}
+	}
+	// compare hashes, so lookup time don't depend on token
+	if user, ok := a.tokens[sha256.Sum256([]byte(token))]; ok {
+		return user, nil
+	}
+	return nil, ErrInvalidCredentials
+}
+
+type basicUser struct {
+	hash []byte
+	role Role
+}
+
+// basicAuth is a HTTP basic authenticator with bcrypt-hashed passwords
+type basicAuth struct {
+	users map[string]basicUser
+
+	mu sync.Mutex
+	// verified credentials (bcrypt is slow for check on each request)
+	verified map[string][sha256.Size]byte
+}
+
+// newBasicAuth load users from file with `user:bcrypt-hash:role` lines
+func newBasicAuth(path string) (*basicAuth, error) {
+	a := &basicAuth{users: make(map[string]basicUser), verified: make(map[string][sha256.Size]byte)}
+	err := readAuthFile(path, func(line string) error {
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 || fields[0] == "" {
+			return errors.New("invalid user, must be `user:bcrypt-hash:role`")
+		}
+		if _, err := bcrypt.Cost([]byte(fields[1])); err != nil {
+			return err
+		}
+		role, err := ParseRole(fields[2])
+		if err != nil {
+			return err
+		}
+		a.users[fields[0]] = basicUser{hash: []byte(fields[1]), role: role}
+		return nil
+	})
+	return a, err
+}
+
+func (a *basicAuth) Authenticate(c *fiber.Ctx) (*User, error) {
+	req := http.Request{Header: http.Header{"Authorization": []string{c.Get(fiber.HeaderAuthorization)}}}
+	name, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	user, ok := a.users[name]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	sum := sha256.Sum256([]byte(password))
+	a.mu.Lock()
+	verified, ok := a.verified[name]
+	a.mu.Unlock()
+	if ok && subtle.ConstantTimeCompare(verified[:], sum[:]) == 1 {
+		return &User{Name: name, Role: user.role}, nil
+	}
+	if bcrypt.CompareHashAndPassword(user.hash, []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	a.mu.Lock()
+	a.verified[name] = sum
+	a.mu.Unlock()
+	return &User{Name: name, Role: user.role}, nil
+}
+
+// proxyAuth trust user (and role) headers, set by authenticating proxy
+type proxyAuth struct {
+	header     string
+	roleHeader string
+	trusted    []*net.IPNet
+}
+
+func newProxyAuth(header, roleHeader string, trusted []string) (*proxyAuth, error) {
+	a := &proxyAuth{header: header, roleHeader: roleHeader, trusted: make([]*net.IPNet, 0, len(trusted))}
+	for _, s := range trusted {
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address: %q", s)
+			} else if ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy address: %w", err)
+		}
+		a.trusted = append(a.trusted, ipNet)
+	}
+	return a, nil
+}
+
+func (a *proxyAuth) isTrusted(ip net.IP) bool {
+	for _, ipNet := range a.trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *proxyAuth) Authenticate(c *fiber.Ctx) (*User, error) {
+	name := c.Get(a.header)
+	if name == "" {
+		return nil, nil
+	}
+	if !a.isTrusted(c.Context().RemoteIP()) {
+		// header is spoofed or proxy is misconfigured
+		return nil, ErrInvalidCredentials
+	}
+	user := &User{Name: name, Role: RoleRead}
+	if a.roleHeader != "" {
+		if s := c.Get(a.roleHeader); s != "" {
+			role, err := ParseRole(s)
+			if err != nil {
+				return nil, err
+			}
+			user.Role = role
+		}
+	}
+	return user, nil
+}
+
+// auth is an API authentication middleware and role checker
+type auth struct {
+	authenticators []Authenticator
+	anonymous      *User
+	basic          bool // send basic auth challenge
+}
+
+// newAuth create authenticators from config (nil if authentication is disabled)
+func newAuth(cfg AuthConfig) (*auth, error) {
+	if !cfg.enabled() {
+		return nil, nil
+	}
+	a := &auth{}
+	if cfg.ClientCertsFile != "" {
+		certs, err := newCertAuth(cfg.ClientCertsFile)
+		if err != nil {
+			return nil, err
+		}
+		a.authenticators = append(a.authenticators, certs)
+	}
+	if cfg.TokensFile != "" {
+		tokens, err := newTokenAuth(cfg.TokensFile)
+		if err != nil {
+			return nil, err
+		}
+		a.authenticators = append(a.authenticators, tokens)
+	}
+	if cfg.UsersFile != "" {
+		users, err := newBasicAuth(cfg.UsersFile)
+		if err != nil {
+			return nil, err
+		}
+		a.authenticators = append(a.authenticators, users)
+		a.basic = true
+	}
+	if cfg.ProxyHeader != "" {
+		proxy, err := newProxyAuth(cfg.ProxyHeader, cfg.ProxyRoleHeader, cfg.TrustedProxies)
+		if err != nil {
+			return nil, err
+		}
+		a.authenticators = append(a.authenticators, proxy)
+	}
+	if cfg.AnonymousRole != "" {
+		role, err := ParseRole(cfg.AnonymousRole)
+		if err != nil {
+			return nil, err
+		}
+		a.anonymous = &User{Name: "anonymous", Role: role}
+	}
+	return a, nil
+}
+
+func (a *auth) unauthorized(c *fiber.Ctx, err error) error {
+	if a.basic {
+		c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="k6-stat"`)
+	}
+	return sendError(c, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+}
+
+// middleware authenticate request and store user in context locals
+func (a *auth) middleware(c *fiber.Ctx) error {
+	if c.Method() == fiber.MethodOptions {
+		// CORS preflight
+		return c.Next()
+	}
+	for _, authenticator := range a.authenticators {
+		user, err := authenticator.Authenticate(c)
+		if err != nil {
+			return a.unauthorized(c, err)
+		}
+		if user != nil {
+			c.Locals(userLocalsKey, user)
+			return c.Next()
+		}
+	}
+	if a.anonymous == nil {
+		return a.unauthorized(c, errors.New("authentication required"))
+	}
+	c.Locals(userLocalsKey, a.anonymous)
+	return c.Next()
+}
+
+// requireRole return handler, which deny requests without role (pass all, if authentication is disabled)
+func (app *App) requireRole(role Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if app.auth == nil {
+			return c.Next()
+		}
+		user, _ := c.Locals(userLocalsKey).(*User)
+		if user == nil || user.Role < role {
+			return sendError(c, http.StatusForbidden, ErrCodeForbidden, "permission denied, "+role.String()+" role required")
+		}
+		return c.Next()
+	}
+}
+
+// cutPrefixFold is a strings.CutPrefix with case-insensitive prefix match
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
@@ -0,0 +1,165 @@
+//go:build !test_integration
+// +build !test_integration
+
+package k6_stat
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newAuthApp(t *testing.T, cfg Config) *App {
+	logger := zerolog.New(os.Stdout)
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	app, err := NewWithDB(db, &logger, "t_k6_tests", "t_k6_samples", cfg)
+	require.NoError(t, err)
+	return app
+}
+
+func TestUnitAppAuth(t *testing.T) {
+	dir := t.TempDir()
+	tokensFile := filepath.Join(dir, "tokens")
+	require.NoError(t, os.WriteFile(tokensFile, []byte("# comment\nreader-token read grafana\nadmin-token admin\n"), 0600))
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	usersFile := filepath.Join(dir, "users")
+	require.NoError(t, os.WriteFile(usersFile, []byte("ci:"+string(hash)+":write\n"), 0600))
+
+	app := newAuthApp(t, Config{
+		Auth: AuthConfig{
+			TokensFile: tokensFile, UsersFile: usersFile,
+			ProxyHeader: "X-Forwarded-User", ProxyRoleHeader: "X-Forwarded-Role", TrustedProxies: []string{"127.0.0.1", "0.0.0.0/32"},
+		},
+	})
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("ci:secret"))
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		want    int
+	}{
+		{name: "anonymous", method: "GET", path: "/api/cache", want: http.StatusUnauthorized},
+		{name: "invalid token", method: "GET", path: "/api/cache", headers: map[string]string{"Authorization": "Bearer invalid"}, want: http.StatusUnauthorized},
+		{name: "read token", method: "GET", path: "/api/cache", headers: map[string]string{"Authorization": "Bearer reader-token"}, want: http.StatusNotImplemented},
+		{name: "api key", method: "GET", path: "/api/cache", headers: map[string]string{"X-API-Key": "reader-token"}, want: http.StatusNotImplemented},
+		{name: "read token write", method: "PATCH", path: "/api/test", headers: map[string]string{"X-API-Key": "reader-token"}, want: http.StatusForbidden},
+		{name: "admin token", method: "DELETE", path: "/api/cache", headers: map[string]string{"Authorization": "bearer admin-token"}, want: http.StatusNotImplemented},
+		{name: "basic", method: "PATCH", path: "/api/test", headers: map[string]string{"Authorization": basic}, want: http.StatusBadRequest},
+		// verified credentials
+		{name: "basic again", method: "DELETE", path: "/api/cache", headers: map[string]string{"Authorization": basic}, want: http.StatusForbidden},
+		{
+			name: "basic invalid", method: "GET", path: "/api/cache",
+			headers: map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("ci:invalid"))},
+			want:    http.StatusUnauthorized,
+		},
+		{name: "proxy", method: "GET", path: "/api/cache", headers: map[string]string{"X-Forwarded-User": "user"}, want: http.StatusNotImplemented},
+		{name: "proxy read", method: "DELETE", path: "/api/cache", headers: map[string]string{"X-Forwarded-User": "user"}, want: http.StatusForbidden},
+		{
+			name: "proxy admin", method: "DELETE", path: "/api/cache",
+			headers: map[string]string{"X-Forwarded-User": "user", "X-Forwarded-Role": "admin"}, want: http.StatusNotImplemented,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			resp, err := app.fiberApp.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, resp.StatusCode)
+			if tt.want == http.StatusUnauthorized {
+				assert.Equal(t, `Basic realm="k6-stat"`, resp.Header.Get("WWW-Authenticate"))
+			}
+			if tt.want == http.StatusUnauthorized || tt.want == http.StatusForbidden {
+				var errResp ErrorResponse
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+				if tt.want == http.StatusUnauthorized {
+					assert.Equal(t, ErrCodeUnauthorized, errResp.Code)
+				} else {
+					assert.Equal(t, ErrCodeForbidden, errResp.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestUnitAppAuthAnonymous(t *testing.T) {
+	app := newAuthApp(t, Config{
+		Auth: AuthConfig{ProxyHeader: "X-Forwarded-User", TrustedProxies: []string{"10.0.0.0/8"}, AnonymousRole: "read"},
+	})
+
+	req, _ := http.NewRequest("GET", "/api/cache", nil)
+	resp, err := app.fiberApp.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+	req, _ = http.NewRequest("DELETE", "/api/cache", nil)
+	resp, err = app.fiberApp.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// untrusted proxy
+	req, _ = http.NewRequest("GET", "/api/cache", nil)
+	req.Header.Set("X-Forwarded-User", "admin")
+	resp, err = app.fiberApp.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestUnitAppCors(t *testing.T) {
+	app := newAuthApp(t, Config{CorsOrigins: []string{"https://grafana.example.com"}, Auth: AuthConfig{ProxyHeader: "X-Forwarded-User"}})
+
+	for _, tt := range []struct {
+		origin string
+		want   string
+	}{
+		{origin: "https://grafana.example.com", want: "https://grafana.example.com"},
+		{origin: "https://evil.example.com", want: ""},
+	} {
+		req, _ := http.NewRequest("OPTIONS", "/api/tests", nil)
+		req.Header.Set("Origin", tt.origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		resp, err := app.fiberApp.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, resp.Header.Get("Access-Control-Allow-Origin"), tt.origin)
+	}
+
+	// CORS is disabled by default
+	app = newAuthApp(t, Config{})
+	req, _ := http.NewRequest("OPTIONS", "/api/tests", nil)
+	req.Header.Set("Origin", "https://grafana.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	resp, err := app.fiberApp.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, "", resp.Header.Get("Access-Control-Allow-Origin"))
+}
+
+func TestNewAuthInvalid(t *testing.T) {
+	dir := t.TempDir()
+	tokensFile := filepath.Join(dir, "tokens")
+	require.NoError(t, os.WriteFile(tokensFile, []byte("token root\n"), 0600))
+	_, err := newAuth(AuthConfig{TokensFile: tokensFile})
+	assert.ErrorIs(t, err, ErrInvalidRole)
+
+	usersFile := filepath.Join(dir, "users")
+	require.NoError(t, os.WriteFile(usersFile, []byte("user:plain:read\n"), 0600))
+	_, err = newAuth(AuthConfig{UsersFile: usersFile})
+	assert.Error(t, err)
+
+	_, err = newAuth(AuthConfig{ProxyHeader: "X-Forwarded-User", TrustedProxies: []string{"localhost"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,76 @@
+//go:build !test_integration
+// +build !test_integration
+
+package k6_stat
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/msaf1980/k6-stat/client"
+	"github.com/msaf1980/k6-stat/dbs"
+)
+
+func TestUnitAppClient(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	require.NoError(t, err)
+	app, err := NewWithDB(db, &logger, "t_k6_tests", "t_k6_samples", Config{Version: "v1.0.0"})
+	require.NoError(t, err)
+
+	address := "127.0.0.1:8082"
+	go func() {
+		_ = app.Listen(address)
+	}()
+	defer func() { _ = app.Shutdown() }()
+	time.Sleep(time.Millisecond * 10)
+
+	c, err := client.New("http://" + address)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	v, err := c.Version(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "v1.0.0", v.Version)
+
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests ORDER BY id, ts, name LIMIT 100$`).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "ts", "name", "params"}).
+			AddRow(test1.Id, test1.Ts, test1.Name, test1.Params).
+			AddRow(test2.Id, test2.Ts, test2.Name, test2.Params),
+	)
+	tests, total, err := c.GetTests(ctx, dbs.TestFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, []dbs.Test{test1, test2}, tests)
+	assert.Equal(t, uint64(2), total)
+
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts = @Time AND id = @Id`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}))
+	_, err = c.GetHttpTestSamples(ctx, dbs.SampleFilter{Id: 1, Start: 1})
+	var apiErr *client.Error
+	require.True(t, errors.As(err, &apiErr), err)
+	assert.Equal(t, dbs.ErrCodeNotFound, apiErr.Code)
+	assert.Equal(t, "test not found", apiErr.Message)
+	assert.NotEmpty(t, apiErr.RequestId)
+
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts = @Time AND id = @Id`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}).AddRow(test1.Id, test1.Ts, test1.Name, test1.Params))
+	mock.ExpectQuery(`^SELECT max\(ts\) FROM t_k6_samples WHERE id = @Id AND start = @Time$`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(test1.Ts.Add(time.Hour)))
+	test, err := c.GetTest(ctx, test1.Id, test1.Ts.UnixNano())
+	require.NoError(t, err)
+	assert.Equal(t, test1, test)
+
+	_, err = c.CacheStats(ctx)
+	require.True(t, errors.As(err, &apiErr), err)
+	assert.Equal(t, dbs.ErrCodeNotImplemented, apiErr.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
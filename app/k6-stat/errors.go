@@ -0,0 +1,71 @@
+package k6_stat
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/msaf1980/k6-stat/dbs"
+)
+
+// HeaderRequestId is a request id header, returned with errors (the same id is logged)
+const HeaderRequestId = "X-Request-Id"
+
+// API error codes (in addition to dbs error codes)
+const (
+	ErrCodeInvalidRequest dbs.ErrorCode = "invalid_request"
+	ErrCodeUnauthorized   dbs.ErrorCode = "unauthorized"
+	ErrCodeForbidden      dbs.ErrorCode = "forbidden"
+	// ErrCodeShuttingDown is returned by readiness check on shutdown
+	ErrCodeShuttingDown dbs.ErrorCode = "shutting_down"
+)
+
+// ErrorResponse is an API error response body
+type ErrorResponse struct {
+	Code      dbs.ErrorCode `json:"code"`
+	Message   string        `json:"message"`
+	RequestId string        `json:"request_id"`
+}
+
+func requestId(c *fiber.Ctx) string {
+	return strconv.FormatUint(c.Context().ID(), 10)
+}
+
+func sendError(c *fiber.Ctx, status int, code dbs.ErrorCode, message string) error {
+	id := requestId(c)
+	c.Set(HeaderRequestId, id)
+	return c.Status(status).JSON(ErrorResponse{Code: code, Message: message, RequestId: id})
+}
+
+// badRequest send error for invalid (unparsed) request
+func badRequest(c *fiber.Ctx, err error) error {
+	return sendError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+}
+
+// queryErrorMessage return client error message. ClickHouse errors can contain queries and internal details,
+// so it's replaced with generic message (details are logged).
+func queryErrorMessage(err *dbs.QueryError) string {
+	switch err.ErrorCode() {
+	case dbs.ErrCodeInternal:
+		return "internal error"
+	case dbs.ErrCodeDBUnavailable:
+		return "database unavailable"
+	case dbs.ErrCodeTimeout:
+		return "query timeout"
+	case dbs.ErrCodeCanceled:
+		return "request canceled"
+	default:
+		return err.Error()
+	}
+}
+
+func sendQueryError(c *fiber.Ctx, err *dbs.QueryError) error {
+	return sendError(c, err.Code(), err.ErrorCode(), queryErrorMessage(err))
+}
+
+// queryError log query error (with query) and send sanitized error to client
+func (app *App) queryError(c *fiber.Ctx, err *dbs.QueryError, msg string) error {
+	app.logger.Error().Uint64("id", c.Context().ID()).Str("sql", err.Query()).Err(err.Wrapped()).Msg(msg)
+	return sendQueryError(c, err)
+}
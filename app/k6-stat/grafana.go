@@ -0,0 +1,370 @@
+package k6_stat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/msaf1980/k6-stat/dbs"
+)
+
+// Grafana simple-JSON datasource targets kinds (target is kind:name, name is a test name in LIKE format)
+const (
+	grafanaTop     = "top"     // table with the latest test in range statistics
+	grafanaDiff    = "diff"    // table with the latest test in range statistics, compared with baseline
+	grafanaLatency = "latency" // per-url durations time series for tests in range
+)
+
+var grafanaKinds = []string{grafanaTop, grafanaDiff, grafanaLatency}
+
+var ErrInvalidGrafanaTarget = errors.New("invalid target, must be top:<name>, diff:<name> or latency:<name>")
+
+// grafanaMaxTests is a tests limit for latency series
+const grafanaMaxTests = 10
+
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// grafanaTargetData is an optional target filter (additional JSON data in Grafana query editor)
+type grafanaTargetData struct {
+	Label    string   `json:"label"`
+	Url      string   `json:"url"`
+	SkipUrl  []string `json:"no-url"`
+	Stats    []string `json:"stats"`
+	Quantile string   `json:"quantile"`
+}
+
+type grafanaTarget struct {
+	Target string            `json:"target"`
+	RefId  string            `json:"refId"`
+	Data   grafanaTargetData `json:"data"`
+}
+
+type grafanaQuery struct {
+	Range      grafanaRange    `json:"range"`
+	IntervalMs int64           `json:"intervalMs"`
+	Targets    []grafanaTarget `json:"targets"`
+}
+
+type grafanaSearch struct {
+	Target string `json:"target"`
+}
+
+type grafanaAnnotationQuery struct {
+	Range      grafanaRange   `json:"range"`
+	Annotation map[string]any `json:"annotation"`
+}
+
+type grafanaAnnotation struct {
+	Annotation map[string]any `json:"annotation"`
+	Time       int64          `json:"time"` // epoch milliseconds
+	Title      string         `json:"title"`
+	Text       string         `json:"text"`
+	Tags       []string       `json:"tags"`
+}
+
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]any         `json:"rows"`
+}
+
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"` // [value, epoch milliseconds]
+}
+
+// parseGrafanaTarget split target to kind and test name pattern
+func parseGrafanaTarget(target string) (kind, name string, ok bool) {
+	kind, name, ok = strings.Cut(target, ":")
+	if !ok || name == "" {
+		return "", "", false
+	}
+	for _, k := range grafanaKinds {
+		if k == kind {
+			return kind, name, true
+		}
+	}
+	return "", "", false
+}
+
+func (t *grafanaTarget) sampleFilter(quantile string) dbs.SampleFilter {
+	f := dbs.SampleFilter{
+		Label: t.Data.Label, Url: t.Data.Url, SkipUrl: t.Data.SkipUrl, Stats: t.Data.Stats, Quantile: t.Data.Quantile,
+	}
+	if f.Quantile == "" {
+		f.Quantile = quantile
+	}
+	return f
+}
+
+func sortedLabels[T any](samples map[string][]T) []string {
+	labels := make([]string, 0, len(samples))
+	for label := range samples {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+func grafanaTopTable(samples *dbs.TestSamples) grafanaTable {
+	table := grafanaTable{
+		Type:    "table",
+		Columns: []grafanaColumn{{Text: "Label", Type: "string"}, {Text: "Url", Type: "string"}},
+		Rows:    [][]any{},
+	}
+	for _, stat := range samples.Stats {
+		table.Columns = append(table.Columns, grafanaColumn{Text: stat, Type: "number"})
+	}
+	table.Columns = append(table.Columns,
+		grafanaColumn{Text: "Count", Type: "number"}, grafanaColumn{Text: "RPS", Type: "number"}, grafanaColumn{Text: "Errors %", Type: "number"},
+	)
+	for _, label := range sortedLabels(samples.Samples) {
+		durations := samples.Samples[label]
+		sort.Slice(durations, func(i, j int) bool { return durations[i].Url < durations[j].Url })
+		for _, d := range durations {
+			row := make([]any, 0, len(table.Columns))
+			row = append(row, label, d.Url)
+			for _, stat := range samples.Stats {
+				row = append(row, d.Stats[stat])
+			}
+			row = append(row, d.Count, d.RPS, d.ErrorsPcnt)
+			table.Rows = append(table.Rows, row)
+		}
+	}
+	return table
+}
+
+func grafanaDiffTable(diff *dbs.TestSamplesDiff) grafanaTable {
+	table := grafanaTable{
+		Type:    "table",
+		Columns: []grafanaColumn{{Text: "Label", Type: "string"}, {Text: "Url", Type: "string"}},
+		Rows:    [][]any{},
+	}
+	for _, stat := range diff.Stats {
+		table.Columns = append(table.Columns, grafanaColumn{Text: stat, Type: "number"}, grafanaColumn{Text: stat + " diff", Type: "number"})
+	}
+	table.Columns = append(table.Columns,
+		grafanaColumn{Text: "Count", Type: "number"}, grafanaColumn{Text: "Count diff", Type: "number"},
+		grafanaColumn{Text: "RPS", Type: "number"}, grafanaColumn{Text: "RPS diff", Type: "number"},
+		grafanaColumn{Text: "Errors %", Type: "number"}, grafanaColumn{Text: "Errors % diff", Type: "number"},
+	)
+	for _, label := range sortedLabels(diff.Samples) {
+		durations := diff.Samples[label]
+		sort.Slice(durations, func(i, j int) bool { return durations[i].Url < durations[j].Url })
+		for _, d := range durations {
+			row := make([]any, 0, len(table.Columns))
+			row = append(row, label, d.Url)
+			for _, stat := range diff.Stats {
+				row = append(row, d.Stats[stat], d.StatsDiff[stat])
+			}
+			row = append(row, d.Count, d.CountDiff, d.RPS, d.RPSDiff, d.ErrorsPcnt, d.ErrorsPcntDiff)
+			table.Rows = append(table.Rows, row)
+		}
+	}
+	return table
+}
+
+func grafanaLatencySeries(test dbs.Test, series []dbs.SampleSeries, stats []string) []grafanaSeries {
+	result := make([]grafanaSeries, 0, len(series)*len(stats))
+	for _, s := range series {
+		for _, stat := range stats {
+			values := s.Stats[stat]
+			gs := grafanaSeries{
+				Target:     test.Name + " " + s.Label + " " + s.Url + " " + stat,
+				Datapoints: make([][2]float64, len(values)),
+			}
+			for i, v := range values {
+				gs.Datapoints[i] = [2]float64{v, float64(s.Times[i].UnixMilli())}
+			}
+			result = append(result, gs)
+		}
+	}
+	return result
+}
+
+// testsInRange return tests with name (LIKE format), started in range (newest first)
+func (app *App) testsInRange(ctx context.Context, name string, r grafanaRange, limit uint64) ([]dbs.Test, *dbs.QueryError) {
+	f := dbs.TestFilter{Name: name, Desc: true, Limit: limit}
+	if !r.From.IsZero() {
+		f.From = r.From.Unix()
+	}
+	if !r.To.IsZero() {
+		f.Until = r.To.Unix() + 1
+	}
+	return app.db.GetTests(ctx, f)
+}
+
+// grafanaTargetQuery return table or time series list for target
+func (app *App) grafanaTargetQuery(c *fiber.Ctx, q *grafanaQuery, t *grafanaTarget) ([]any, *dbs.QueryError) {
+	kind, name, ok := parseGrafanaTarget(t.Target)
+	if !ok {
+		return nil, dbs.NewQueryError(ErrInvalidGrafanaTarget, http.StatusBadRequest, "")
+	}
+	filter := t.sampleFilter(app.config.Quantile)
+	switch kind {
+	case grafanaTop, grafanaDiff:
+		tests, err := app.testsInRange(c.UserContext(), name, q.Range, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(tests) == 0 {
+			return []any{grafanaTable{Type: "table", Columns: []grafanaColumn{}, Rows: [][]any{}}}, nil
+		}
+		samples, err := app.db.GetHttpTestSamples(c.UserContext(), tests[0], filter)
+		if err != nil {
+			return nil, err
+		}
+		if kind == grafanaTop {
+			return []any{grafanaTopTable(samples)}, nil
+		}
+		ref := &dbs.TestSamples{Quantile: samples.Quantile, Stats: samples.Stats}
+		if baseline, err := app.db.GetBaseline(c.UserContext(), tests[0]); err == nil {
+			if ref, err = app.db.GetHttpTestSamples(c.UserContext(), baseline, filter); err != nil {
+				return nil, err
+			}
+		} else if err.Code() != http.StatusNotFound {
+			return nil, err
+		}
+		diff, dErr := dbs.DiffSamples(samples, ref)
+		if dErr != nil {
+			return nil, dbs.NewQueryError(dErr, http.StatusInternalServerError, "")
+		}
+		return []any{grafanaDiffTable(diff)}, nil
+	default:
+		tests, err := app.testsInRange(c.UserContext(), name, q.Range, grafanaMaxTests)
+		if err != nil {
+			return nil, err
+		}
+		stats := filter.Stats
+		if len(stats) == 0 {
+			// one series per url by default
+			stats = []string{"p99"}
+		}
+		// series stats are keyed by normalized names (p99.90 -> p99.9)
+		parsedStats, pErr := dbs.ParseStats(stats)
+		if pErr != nil {
+			return nil, dbs.NewQueryError(pErr, http.StatusBadRequest, "")
+		}
+		stats = parsedStats.Names()
+		filter.Stats = stats
+		interval := q.IntervalMs / 1000
+		if interval < 1 {
+			interval = 1
+		}
+		result := make([]any, 0, 32)
+		for _, test := range tests {
+			filter.Id = test.Id
+			filter.Start = test.Ts.UnixNano()
+			series, err := app.db.GetHttpSamplesSeries(c.UserContext(), dbs.SeriesFilter{SampleFilter: filter, Interval: interval})
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range grafanaLatencySeries(test, series, stats) {
+				result = append(result, s)
+			}
+		}
+		return result, nil
+	}
+}
+
+// grafanaQuery is a Grafana simple-JSON datasource query (tables and time series)
+func (app *App) grafanaQuery(c *fiber.Ctx) error {
+	var q grafanaQuery
+	if err := c.BodyParser(&q); err != nil {
+		return badRequest(c, err)
+	}
+
+	result := make([]any, 0, len(q.Targets))
+	for i := range q.Targets {
+		if q.Targets[i].Target == "" {
+			continue
+		}
+		r, err := app.grafanaTargetQuery(c, &q, &q.Targets[i])
+		if err != nil {
+			app.logger.Error().Uint64("id", c.Context().ID()).Str("target", q.Targets[i].Target).Str("sql", err.Query()).Err(err.Wrapped()).Msg("grafana query")
+			return sendQueryError(c, err)
+		}
+		result = append(result, r...)
+	}
+
+	return c.JSON(result)
+}
+
+// grafanaSearch return targets (kind:name) for recent tests names (names are escaped for match literally in LIKE format)
+func (app *App) grafanaSearch(c *fiber.Ctx) error {
+	var q grafanaSearch
+	if err := c.BodyParser(&q); err != nil {
+		if err != fiber.ErrUnprocessableEntity && len(c.Request().Body()) > 0 {
+			return badRequest(c, err)
+		}
+	}
+
+	tests, err := app.db.GetTests(c.UserContext(), dbs.TestFilter{Desc: true, Limit: 1000})
+	if err != nil {
+		return app.queryError(c, err, "grafana search")
+	}
+	names := make([]string, 0, len(tests))
+	seen := make(map[string]bool)
+	for _, test := range tests {
+		if !seen[test.Name] && strings.Contains(test.Name, q.Target) {
+			seen[test.Name] = true
+			names = append(names, test.Name)
+		}
+	}
+	sort.Strings(names)
+	targets := make([]string, 0, len(names)*len(grafanaKinds))
+	for _, kind := range grafanaKinds {
+		for _, name := range names {
+			targets = append(targets, kind+":"+dbs.EscapeLike(name))
+		}
+	}
+
+	return c.JSON(targets)
+}
+
+// grafanaAnnotations return tests (annotation query is a test name in LIKE format) started in range
+func (app *App) grafanaAnnotations(c *fiber.Ctx) error {
+	var q grafanaAnnotationQuery
+	if err := c.BodyParser(&q); err != nil {
+		return badRequest(c, err)
+	}
+	name, _ := q.Annotation["query"].(string)
+
+	tests, err := app.testsInRange(c.UserContext(), name, q.Range, 0)
+	if err != nil {
+		return app.queryError(c, err, "grafana annotations")
+	}
+	annotations := make([]grafanaAnnotation, 0, len(tests))
+	for _, test := range tests {
+		a := grafanaAnnotation{
+			Annotation: q.Annotation, Time: test.Ts.UnixMilli(), Title: test.Name, Text: test.Params, Tags: []string{},
+		}
+		if test.Annotation != nil {
+			if test.Annotation.Name != "" {
+				a.Title = test.Annotation.Name
+			}
+			if test.Annotation.Text != "" {
+				a.Text += "\n" + test.Annotation.Text
+			}
+			if test.Annotation.Labels != nil {
+				a.Tags = test.Annotation.Labels
+			}
+		}
+		annotations = append(annotations, a)
+	}
+
+	return c.JSON(annotations)
+}
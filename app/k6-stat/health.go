@@ -0,0 +1,50 @@
+package k6_stat
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/msaf1980/k6-stat/dbs"
+)
+
+// ReadyTimeout is a readiness check (ClickHouse ping and tables check) timeout
+const ReadyTimeout = 5 * time.Second
+
+// VersionInfo is a /version response
+type VersionInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+}
+
+// healthz report process liveness
+func (app *App) healthz(c *fiber.Ctx) error {
+	return c.SendString("ok")
+}
+
+// readyz report readiness: ClickHouse is available and tables exist (not ready on shutdown)
+func (app *App) readyz(c *fiber.Ctx) error {
+	if atomic.LoadInt32(&app.shutdown) == 1 {
+		return sendError(c, http.StatusServiceUnavailable, ErrCodeShuttingDown, "shutting down")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ReadyTimeout)
+	defer cancel()
+	// readiness check doesn't require authentication, so error details are only logged
+	if err := app.db.Ping(ctx); err != nil {
+		app.logger.Error().Uint64("id", c.Context().ID()).Err(err).Msg("readiness check: ClickHouse ping")
+		return sendError(c, http.StatusServiceUnavailable, dbs.ErrCodeDBUnavailable, "database unavailable")
+	}
+	if err := app.db.CheckTables(ctx); err != nil {
+		app.logger.Error().Uint64("id", c.Context().ID()).Str("sql", err.Query()).Err(err.Wrapped()).Msg("readiness check: tables")
+		return sendError(c, http.StatusServiceUnavailable, dbs.ErrCodeDBUnavailable, "database not ready")
+	}
+	return c.SendString("ok")
+}
+
+func (app *App) version(c *fiber.Ctx) error {
+	return c.JSON(VersionInfo{Version: app.config.Version, GoVersion: runtime.Version()})
+}
@@ -0,0 +1,100 @@
+//go:build !test_integration
+// +build !test_integration
+
+package k6_stat
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/msaf1980/k6-stat/dbs"
+)
+
+// assertErrorBody check API error response (request id is only checked for existence)
+func assertErrorBody(t *testing.T, want ErrorResponse, body string) {
+	t.Helper()
+	var got ErrorResponse
+	require.NoError(t, json.Unmarshal([]byte(body), &got), body)
+	assert.NotEmpty(t, got.RequestId)
+	got.RequestId = ""
+	assert.Equal(t, want, got)
+}
+
+func TestUnitAppHealth(t *testing.T) {
+	// authentication is required for API, but not for probes
+	tokensFile := filepath.Join(t.TempDir(), "tokens")
+	require.NoError(t, os.WriteFile(tokensFile, []byte("token read\n"), 0600))
+
+	logger := zerolog.New(os.Stdout)
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	app, err := NewWithDB(db, &logger, "t_k6_tests", "t_k6_samples", Config{
+		Auth: AuthConfig{TokensFile: tokensFile}, Version: "v1.0.0",
+	})
+	require.NoError(t, err)
+
+	get := func(path string) (int, string) {
+		req, _ := http.NewRequest("GET", path, nil)
+		resp, err := app.fiberApp.Test(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", body)
+
+	code, body = get("/version")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"version":"v1.0.0","go_version":"`+runtime.Version()+`"}`, body)
+
+	// ready
+	mock.ExpectPing()
+	mock.ExpectQuery(`^EXISTS TABLE t_k6_tests$`).WillReturnRows(sqlmock.NewRows([]string{"result"}).AddRow(uint8(1)))
+	mock.ExpectQuery(`^EXISTS TABLE t_k6_samples$`).WillReturnRows(sqlmock.NewRows([]string{"result"}).AddRow(uint8(1)))
+	code, body = get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", body)
+
+	// table not exist
+	mock.ExpectPing()
+	mock.ExpectQuery(`^EXISTS TABLE t_k6_tests$`).WillReturnRows(sqlmock.NewRows([]string{"result"}).AddRow(uint8(0)))
+	code, body = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assertErrorBody(t, ErrorResponse{Code: dbs.ErrCodeDBUnavailable, Message: "database not ready"}, body)
+	assert.NotContains(t, body, "t_k6_tests")
+
+	// ClickHouse unavailable
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	code, body = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assertErrorBody(t, ErrorResponse{Code: dbs.ErrCodeDBUnavailable, Message: "database unavailable"}, body)
+	assert.NotContains(t, body, "connection refused")
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	code, _ = get("/api/cache")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// not ready on shutdown
+	_ = app.ShutdownWithTimeout(0)
+	code, body = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assertErrorBody(t, ErrorResponse{Code: ErrCodeShuttingDown, Message: "shutting down"}, body)
+	code, _ = get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+}
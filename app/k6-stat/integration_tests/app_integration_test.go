@@ -24,6 +24,7 @@ import (
 
 	app "github.com/msaf1980/k6-stat/app/k6-stat"
 	"github.com/msaf1980/k6-stat/dbs"
+	"github.com/msaf1980/k6-stat/migrate"
 	"github.com/msaf1980/k6-stat/utils/env"
 )
 
@@ -94,6 +95,9 @@ func init() {
 	t3, _ = time.Parse(time.RFC3339, "2006-01-04T15:04:05Z")
 	t3 = t3.UTC()
 	test3 = dbs.Test{Id: uint64(t3.UnixNano()), Ts: t3, Name: "graphite-clickhouse 2006-01-04T15:04:05Z", Params: "RENDER_FORMAT=carbonapi_v3_pb FIND_FORMAT=carbonapi_v3_pb DELAY=1 DURATION=1h USERS_FIND=2 USERS_TAGS=2 USERS_1H_0=2"}
+	test1.ParamsMap = dbs.ParseParams(test1.Params)
+	test2.ParamsMap = dbs.ParseParams(test2.Params)
+	test3.ParamsMap = dbs.ParseParams(test3.Params)
 
 	samples1_1_d = dbs.Sample{
 		Id:     test1.Id,
@@ -355,30 +359,13 @@ func dbInit() {
 
 	// See https://github.com/msaf1980/xk6-output-clickhouse
 	var schema = []string{
+		`DROP TABLE IF EXISTS t_k6_samples_rollup_durations_mv`,
+		`DROP TABLE IF EXISTS t_k6_samples_rollup_reqs_mv`,
+		`DROP TABLE IF EXISTS t_k6_samples_rollup`,
 		`DROP TABLE IF EXISTS t_k6_samples`,
 		`DROP TABLE IF EXISTS t_k6_tests`,
-		`CREATE TABLE t_k6_samples (
-			id UInt64,
-			start DateTime64(9, 'UTC'),
-			ts DateTime64(9, 'UTC'),
-			metric String,
-			url String,
-			label String,
-			status String,
-			name String,
-			tags Map(String, String),
-			value Float64
-		) ENGINE = ReplacingMergeTree(start)
-		PARTITION BY toYYYYMM(ts)
-		ORDER BY (id, ts, metric, name);`,
-		`CREATE TABLE t_k6_tests (
-			id UInt64,
-			ts DateTime64(9, 'UTC'),
-			name String,
-			params String
-		) ENGINE = ReplacingMergeTree(id)
-		PARTITION BY toYYYYMM(ts)
-		ORDER BY (id, ts, name);`,
+		`DROP TABLE IF EXISTS t_k6_tests_annotations`,
+		`DROP TABLE IF EXISTS t_k6_stat_schema`,
 	}
 	for _, s := range schema {
 		_, err = db.Exec(s)
@@ -386,6 +373,15 @@ func dbInit() {
 			panic(err)
 		}
 	}
+	if _, err = migrate.New(db, migrate.Config{
+		TableTests:       "t_k6_tests",
+		TableSamples:     "t_k6_samples",
+		TableAnnotations: "t_k6_tests_annotations",
+		TableRollup:      "t_k6_samples_rollup",
+		TableSchema:      "t_k6_stat_schema",
+	}).Up(); err != nil {
+		panic(err)
+	}
 
 	// tests
 	tx, err := db.Begin()
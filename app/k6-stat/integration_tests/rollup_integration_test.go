@@ -0,0 +1,117 @@
+//go:build test_all || test_integration
+// +build test_all test_integration
+
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/msaf1980/k6-stat/dbs"
+)
+
+// insertRollupSamples insert samples for test, started after rollup views creation (in several batches, so rollup table has several parts)
+func insertRollupSamples(t *testing.T, db *sql.DB, id uint64, start time.Time) {
+	urls := []string{"render format=carbonapi_v3_pb target=a.*", "render format=carbonapi_v3_pb target=b.*"}
+	for batch := 0; batch < 3; batch++ {
+		tx, err := db.Begin()
+		require.NoError(t, err)
+		stmt, err := tx.Prepare(`INSERT INTO t_k6_samples (id, start, ts, metric, url, label, status, name, tags, value`)
+		require.NoError(t, err)
+		for i := 0; i < 40; i++ {
+			url := urls[i%2]
+			status := "200"
+			if i%7 == 0 {
+				status = "500"
+			}
+			// samples over 3 minutes
+			ts := start.Add(time.Duration(batch*40+i) * 1500 * time.Millisecond)
+			tags := map[string]string{"status": status, "label": "render", "url": url}
+			for _, s := range []struct {
+				metric string
+				value  float64
+			}{
+				{metric: "http_req_duration", value: float64((batch*40+i)*37%500 + 1)},
+				{metric: "http_reqs", value: 1},
+			} {
+				_, err = stmt.Exec(id, start, ts, s.metric, url, "render", status, s.metric+";status="+status, tags, s.value)
+				if err != nil {
+					tx.Rollback()
+					t.Fatal(err)
+				}
+			}
+		}
+		require.NoError(t, tx.Commit())
+	}
+}
+
+func TestIntegrationRollup(t *testing.T) {
+	db, err := sql.Open("clickhouse", dbDSN)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// test is started after rollup views creation (in dbInit), so it's read from rollup table
+	start := time.Now().UTC().Add(2 * time.Second).Truncate(time.Millisecond)
+	id := uint64(start.UnixNano())
+	insertRollupSamples(t, db, id, start)
+
+	var rollupCount uint64
+	require.NoError(t, db.QueryRow("SELECT count() FROM t_k6_samples_rollup WHERE id = "+strconv.FormatUint(id, 10)).Scan(&rollupCount))
+	require.NotZero(t, rollupCount, "rollup table is not populated")
+
+	raw := dbs.New(db, "t_k6_tests", "t_k6_samples")
+	rollup := dbs.New(db, "t_k6_tests", "t_k6_samples")
+	rollup.SetTableRollup("t_k6_samples_rollup")
+
+	ctx := context.Background()
+	stats := []string{"p50", "p90", "p99", "min", "max", "mean", "stddev"}
+	f := dbs.SampleFilter{Id: id, Start: start.UnixNano(), Stats: stats, Quantile: string(dbs.QuantileTiming)}
+
+	t.Run("durations", func(t *testing.T) {
+		want, qErr := raw.GetHttpSamplesDurations(ctx, f)
+		require.Nil(t, qErr)
+		got, qErr := rollup.GetHttpSamplesDurations(ctx, f)
+		require.Nil(t, qErr)
+		require.Equal(t, 2, len(want))
+		require.Equal(t, len(want), len(got))
+		for i := range want {
+			assert.Equal(t, want[i].Url, got[i].Url)
+			for _, stat := range stats {
+				assert.InDelta(t, want[i].Stats[stat], got[i].Stats[stat], 1e-6, "%s %s", want[i].Url, stat)
+			}
+		}
+	})
+
+	t.Run("status", func(t *testing.T) {
+		want, qErr := raw.GetHttpSamplesStatus(ctx, f)
+		require.Nil(t, qErr)
+		got, qErr := rollup.GetHttpSamplesStatus(ctx, f)
+		require.Nil(t, qErr)
+		require.Equal(t, 4, len(want))
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("series", func(t *testing.T) {
+		sf := dbs.SeriesFilter{SampleFilter: f, Interval: 60}
+		want, qErr := raw.GetHttpSamplesSeries(ctx, sf)
+		require.Nil(t, qErr)
+		got, qErr := rollup.GetHttpSamplesSeries(ctx, sf)
+		require.Nil(t, qErr)
+		require.Equal(t, 2, len(want))
+		require.Equal(t, len(want), len(got))
+		for i := range want {
+			assert.Equal(t, want[i].Url, got[i].Url)
+			assert.Equal(t, want[i].Times, got[i].Times)
+			for _, stat := range stats {
+				require.Equal(t, len(want[i].Stats[stat]), len(got[i].Stats[stat]), "%s %s", want[i].Url, stat)
+				assert.InDeltaSlice(t, want[i].Stats[stat], got[i].Stats[stat], 1e-6, "%s %s", want[i].Url, stat)
+			}
+		}
+	})
+}
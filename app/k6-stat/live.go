@@ -0,0 +1,154 @@
+package k6_stat
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/msaf1980/k6-stat/dbs"
+)
+
+// LiveFilter is a live view request (query params, for EventSource compatibility)
+type LiveFilter struct {
+	Id       uint64   `query:"id"`
+	Start    int64    `query:"start"`
+	RefId    uint64   `query:"ref-id"`
+	RefStart int64    `query:"ref-start"`
+	Label    string   `query:"label"`
+	Url      string   `query:"url"`
+	SkipUrl  []string `query:"no-url"`
+	Stats    []string `query:"stats"`
+	Quantile string   `query:"quantile"`
+	// refresh interval in seconds (dbs.DefaultLiveInterval if zero)
+	Interval int64 `query:"interval"`
+	// regression threshold in percents (dbs.DefaultRegressionThreshold if zero)
+	Threshold float64 `query:"threshold"`
+	// interval end lag in seconds (dbs.LiveLag if zero), samples are written with delay
+	Lag int64 `query:"lag"`
+	// aggregate samples from test start (not only new samples since previous interval), late samples are not lost
+	Cumulative bool `query:"cumulative"`
+}
+
+// writeEvent write server-sent event and flush it
+func writeEvent(w *bufio.Writer, event string, data []byte) error {
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// writeKeepAlive write server-sent events comment (ignored by clients) for detect client disconnect
+func writeKeepAlive(w *bufio.Writer) error {
+	if _, err := w.WriteString(": keep-alive\n\n"); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// getLiveSamples stream running test per-interval (or cumulative) aggregates (with reference compare) as server-sent events:
+// samples (dbs.LiveSamples), error (message) and end (test finished).
+// Stream is stopped on client disconnect (detected on write) and on server shutdown.
+func (app *App) getLiveSamples(c *fiber.Ctx) error {
+	var filter LiveFilter
+	if err := c.QueryParser(&filter); err != nil {
+		return badRequest(c, err)
+	}
+	if filter.Id == 0 {
+		return sendError(c, http.StatusBadRequest, dbs.ErrCodeInvalidFilter, "test id not set")
+	}
+	if filter.Quantile == "" {
+		filter.Quantile = app.config.Quantile
+	}
+	interval := dbs.DefaultLiveInterval
+	if filter.Interval > 0 {
+		interval = time.Duration(filter.Interval) * time.Second
+	}
+	threshold := filter.Threshold
+	if threshold <= 0 {
+		threshold = dbs.DefaultRegressionThreshold
+	}
+	lag := dbs.LiveLag
+	if filter.Lag > 0 {
+		lag = time.Duration(filter.Lag) * time.Second
+	}
+	samplesFilter := dbs.SampleFilter{
+		Label: filter.Label, Url: filter.Url, SkipUrl: filter.SkipUrl, Stats: filter.Stats, Quantile: filter.Quantile,
+	}
+
+	test, err := app.db.GetTestById(c.UserContext(), dbs.TestIdFilter{Id: filter.Id, Time: filter.Start})
+	if err != nil {
+		return app.queryError(c, err, "get test")
+	}
+	var ref *dbs.TestSamples
+	if filter.RefId > 0 {
+		refTest, err := app.db.GetTestById(c.UserContext(), dbs.TestIdFilter{Id: filter.RefId, Time: filter.RefStart})
+		if err != nil {
+			return app.queryError(c, err, "get reference test")
+		}
+		if ref, err = app.db.GetHttpTestSamples(c.UserContext(), refTest, samplesFilter); err != nil {
+			return app.queryError(c, err, "get reference test samples")
+		}
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+
+	id := c.Context().ID()
+	// stream writer is executed after handler return, so fiber.Ctx can't be used
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// canceled on server shutdown or on client disconnect
+		ctx, cancel := context.WithCancel(app.ctx)
+		defer cancel()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		from := test.Ts
+		for {
+			until := time.Now().Add(-lag).Truncate(time.Second)
+			if until.After(from) {
+				live, err := app.db.GetHttpLiveSamples(ctx, test, ref, samplesFilter, from, until, threshold)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					app.logger.Error().Uint64("id", id).Str("sql", err.Query()).Err(err.Wrapped()).Msg("get live samples")
+					_ = writeEvent(w, "error", []byte(queryErrorMessage(err)))
+					return
+				}
+				data, jErr := json.Marshal(live)
+				if jErr != nil {
+					_ = writeEvent(w, "error", []byte(jErr.Error()))
+					return
+				}
+				if writeEvent(w, "samples", data) != nil {
+					// client disconnected
+					return
+				}
+				if live.Finished {
+					_ = writeEvent(w, "end", []byte("test finished"))
+					return
+				}
+				if !filter.Cumulative {
+					from = until
+				}
+			} else if writeKeepAlive(w) != nil {
+				// client disconnected
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,337 @@
+package k6_stat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/msaf1980/k6-stat/dbs"
+)
+
+// apiDurationBuckets is an API requests (and DB queries) duration histogram buckets upper bounds (seconds)
+var apiDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// endpointStat is an API endpoint requests (or DB query kind) statistic
+type endpointStat struct {
+	count    uint64
+	errors   uint64 // server (query) errors
+	duration float64
+	buckets  []uint64 // requests count per apiDurationBuckets (not cumulative)
+}
+
+// apiMetrics is an API endpoints requests (or DB queries by kind) statistic (for operational metrics)
+type apiMetrics struct {
+	mu        sync.Mutex
+	endpoints map[string]*endpointStat
+}
+
+func newApiMetrics() *apiMetrics {
+	return &apiMetrics{endpoints: make(map[string]*endpointStat)}
+}
+
+func (m *apiMetrics) add(endpoint string, duration time.Duration, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.endpoints[endpoint]
+	if s == nil {
+		s = &endpointStat{buckets: make([]uint64, len(apiDurationBuckets))}
+		m.endpoints[endpoint] = s
+	}
+	seconds := duration.Seconds()
+	s.count++
+	s.duration += seconds
+	if i := sort.SearchFloat64s(apiDurationBuckets, seconds); i < len(apiDurationBuckets) {
+		s.buckets[i]++
+	}
+	if failed {
+		s.errors++
+	}
+}
+
+// middleware collect API requests duration (the whole handler, including DB queries) and errors by endpoint
+func (m *apiMetrics) middleware(c *fiber.Ctx) error {
+	if !strings.HasPrefix(c.Path(), "/api/") {
+		return c.Next()
+	}
+	start := time.Now()
+	err := c.Next()
+	m.add(c.Method()+" "+c.Route().Path, time.Since(start), err != nil || c.Response().StatusCode() >= http.StatusInternalServerError)
+	return err
+}
+
+func (m *apiMetrics) write(w io.Writer) {
+	m.writeHistogram(w, "endpoint",
+		"k6_stat_api_request_duration_seconds", "API requests handling duration by endpoint",
+		"k6_stat_api_errors_total", "API requests server errors by endpoint",
+	)
+}
+
+// observeQuery collect DB queries duration and errors by query kind (dbs.QueryObserver), not found errors are not counted
+func (m *apiMetrics) observeQuery(kind string, duration time.Duration, qErr *dbs.QueryError) {
+	m.add(kind, duration, qErr != nil && qErr.Code() >= http.StatusInternalServerError)
+}
+
+func (m *apiMetrics) writeQueries(w io.Writer) {
+	m.writeHistogram(w, "query",
+		"k6_stat_db_query_duration_seconds", "DB queries duration by query kind",
+		"k6_stat_db_query_errors_total", "DB queries errors by query kind",
+	)
+}
+
+// writeHistogram write duration histogram and errors counter (label is a key label name)
+func (m *apiMetrics) writeHistogram(w io.Writer, label, name, help, errorsName, errorsHelp string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	endpoints := make([]string, 0, len(m.endpoints))
+	for endpoint := range m.endpoints {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for _, endpoint := range endpoints {
+		s := m.endpoints[endpoint]
+		var count uint64
+		for i, le := range apiDurationBuckets {
+			count += s.buckets[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, metricLabels(label, endpoint, "le", formatMetricValue(le)), count)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, metricLabels(label, endpoint, "le", "+Inf"), s.count)
+		labels := metricLabels(label, endpoint)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatMetricValue(s.duration))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, labels, s.count)
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", errorsName, errorsHelp, errorsName)
+	for _, endpoint := range endpoints {
+		fmt.Fprintf(w, "%s%s %d\n", errorsName, metricLabels(label, endpoint), m.endpoints[endpoint].errors)
+	}
+}
+
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricLabels format Prometheus labels from name/value pairs
+func metricLabels(kv ...string) string {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i := 0; i < len(kv); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(kv[i])
+		sb.WriteString(`="`)
+		sb.WriteString(metricLabelEscaper.Replace(kv[i+1]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeCacheMetrics(w io.Writer, stats dbs.CacheStats) {
+	fmt.Fprintln(w, "# HELP k6_stat_cache_requests_total Samples cache requests by result (hit, disk-hit, miss, bypass)")
+	fmt.Fprintln(w, "# TYPE k6_stat_cache_requests_total counter")
+	fmt.Fprintf(w, "k6_stat_cache_requests_total{result=\"hit\"} %d\n", stats.Hits)
+	fmt.Fprintf(w, "k6_stat_cache_requests_total{result=\"disk-hit\"} %d\n", stats.DiskHits)
+	fmt.Fprintf(w, "k6_stat_cache_requests_total{result=\"miss\"} %d\n", stats.Misses)
+	fmt.Fprintf(w, "k6_stat_cache_requests_total{result=\"bypass\"} %d\n", stats.Bypass)
+	fmt.Fprintln(w, "# HELP k6_stat_cache_items Samples cache items")
+	fmt.Fprintln(w, "# TYPE k6_stat_cache_items gauge")
+	fmt.Fprintf(w, "k6_stat_cache_items %d\n", stats.Items)
+	fmt.Fprintln(w, "# HELP k6_stat_cache_size_bytes Samples cache size")
+	fmt.Fprintln(w, "# TYPE k6_stat_cache_size_bytes gauge")
+	fmt.Fprintf(w, "k6_stat_cache_size_bytes %d\n", stats.Size)
+}
+
+// testMetric is a latest run statistic gauge
+type testMetric struct {
+	name  string
+	help  string
+	value func(d *dbs.SampleDurationsDiff, stat string) (float64, bool)
+}
+
+var testMetrics = []testMetric{
+	{
+		name: "k6_stat_http_req_duration", help: "Latest run http requests durations statistic (ms)",
+		value: func(d *dbs.SampleDurationsDiff, stat string) (float64, bool) {
+			v, ok := d.Stats[stat]
+			return v, ok
+		},
+	},
+	{
+		name: "k6_stat_http_req_duration_baseline_delta", help: "Latest run http requests durations statistic delta to baseline (ms)",
+		value: func(d *dbs.SampleDurationsDiff, stat string) (float64, bool) {
+			v, ok := d.StatsDiff[stat]
+			return v, ok
+		},
+	},
+}
+
+type testCountMetric struct {
+	name  string
+	help  string
+	value func(d *dbs.SampleDurationsDiff) (float64, bool)
+}
+
+var testCountMetrics = []testCountMetric{
+	{
+		name: "k6_stat_http_reqs", help: "Latest run http requests count",
+		value: func(d *dbs.SampleDurationsDiff) (float64, bool) { return d.Count, true },
+	},
+	{
+		name: "k6_stat_http_reqs_baseline_delta", help: "Latest run http requests count delta to baseline",
+		value: func(d *dbs.SampleDurationsDiff) (float64, bool) { return d.CountDiff, d.StatsDiff != nil },
+	},
+	{
+		name: "k6_stat_http_rps", help: "Latest run http requests per second",
+		value: func(d *dbs.SampleDurationsDiff) (float64, bool) { return d.RPS, true },
+	},
+	{
+		name: "k6_stat_http_rps_baseline_delta", help: "Latest run http requests per second delta to baseline",
+		value: func(d *dbs.SampleDurationsDiff) (float64, bool) { return d.RPSDiff, d.StatsDiff != nil },
+	},
+	{
+		name: "k6_stat_http_errors_percent", help: "Latest run http requests errors percent",
+		value: func(d *dbs.SampleDurationsDiff) (float64, bool) { return d.ErrorsPcnt, true },
+	},
+	{
+		name: "k6_stat_http_errors_percent_baseline_delta", help: "Latest run http requests errors percent delta to baseline",
+		value: func(d *dbs.SampleDurationsDiff) (float64, bool) { return d.ErrorsPcntDiff, d.StatsDiff != nil },
+	},
+}
+
+// writeTestsMetrics write latest runs statistics (diff.Reference is empty, if baseline not found)
+func writeTestsMetrics(w io.Writer, diffs []*dbs.TestSamplesDiff) {
+	type sample struct {
+		test  string
+		label string
+		stats []string
+		d     *dbs.SampleDurationsDiff
+	}
+	samples := make([]sample, 0, 64)
+	for _, diff := range diffs {
+		labels := make([]string, 0, len(diff.Samples))
+		for label := range diff.Samples {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			durations := diff.Samples[label]
+			sort.Slice(durations, func(i, j int) bool { return durations[i].Url < durations[j].Url })
+			for i := range durations {
+				samples = append(samples, sample{test: diff.Test.Name, label: label, stats: diff.Stats, d: &durations[i]})
+			}
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP k6_stat_test_start_timestamp_seconds Latest run start time")
+	fmt.Fprintln(w, "# TYPE k6_stat_test_start_timestamp_seconds gauge")
+	for _, diff := range diffs {
+		fmt.Fprintf(w, "k6_stat_test_start_timestamp_seconds%s %d\n", metricLabels("test", diff.Test.Name, "role", "latest"), diff.Test.Ts.Unix())
+		if !diff.Reference.Ts.IsZero() {
+			fmt.Fprintf(w, "k6_stat_test_start_timestamp_seconds%s %d\n", metricLabels("test", diff.Test.Name, "role", "baseline"), diff.Reference.Ts.Unix())
+		}
+	}
+	for _, m := range testMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+		for _, s := range samples {
+			for _, stat := range s.stats {
+				if v, ok := m.value(s.d, stat); ok {
+					fmt.Fprintf(w, "%s%s %s\n", m.name, metricLabels("test", s.test, "label", s.label, "url", s.d.Url, "stat", stat), formatMetricValue(v))
+				}
+			}
+		}
+	}
+	for _, m := range testCountMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+		for _, s := range samples {
+			if v, ok := m.value(s.d); ok {
+				fmt.Fprintf(w, "%s%s %s\n", m.name, metricLabels("test", s.test, "label", s.label, "url", s.d.Url), formatMetricValue(v))
+			}
+		}
+	}
+}
+
+// latestFinishedTest return the most recent finished test with name (LIKE format), running test is skipped
+// (its samples are not cached and statistic is not complete)
+func (app *App) latestFinishedTest(ctx context.Context, pattern string) (dbs.Test, *dbs.QueryError) {
+	tests, err := app.db.GetTests(ctx, dbs.TestFilter{Name: pattern, Desc: true, Limit: 2})
+	if err != nil {
+		return dbs.Test{}, err
+	}
+	for _, test := range tests {
+		if app.db.TestFinished(ctx, test) {
+			return test, nil
+		}
+	}
+	return dbs.Test{}, dbs.NewQueryError(dbs.ErrTestNotFound, http.StatusNotFound, "")
+}
+
+// latestTestDiff return latest finished run (for name pattern) compared with baseline (diff.Reference is empty, if baseline not found)
+func (app *App) latestTestDiff(ctx context.Context, pattern string) (*dbs.TestSamplesDiff, *dbs.QueryError) {
+	filter := dbs.SampleFilter{Quantile: app.config.Quantile}
+	test, err := app.latestFinishedTest(ctx, pattern)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := app.db.GetHttpTestSamples(ctx, test, filter)
+	if err != nil {
+		return nil, err
+	}
+	ref := &dbs.TestSamples{Quantile: samples.Quantile, Stats: samples.Stats}
+	if baseline, err := app.db.GetBaseline(ctx, test); err == nil {
+		if ref, err = app.db.GetHttpTestSamples(ctx, baseline, filter); err != nil {
+			return nil, err
+		}
+	} else if err.Code() != http.StatusNotFound {
+		return nil, err
+	}
+	diff, dErr := dbs.DiffSamples(samples, ref)
+	if dErr != nil {
+		return nil, dbs.NewQueryError(dErr, http.StatusInternalServerError, "")
+	}
+	return diff, nil
+}
+
+// latestTestsDiffs return latest finished runs (for configured name patterns) compared with baselines.
+// Failed patterns are logged and skipped, so operational metrics are still exported.
+func (app *App) latestTestsDiffs(ctx context.Context) []*dbs.TestSamplesDiff {
+	diffs := make([]*dbs.TestSamplesDiff, 0, len(app.config.MetricsTests))
+	for _, pattern := range app.config.MetricsTests {
+		diff, err := app.latestTestDiff(ctx, pattern)
+		if err != nil {
+			if err.Code() != http.StatusNotFound {
+				app.logger.Error().Str("pattern", pattern).Str("sql", err.Query()).Err(err.Wrapped()).Msg("metrics tests")
+			}
+			continue
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
+
+func (app *App) getMetrics(c *fiber.Ctx) error {
+	diffs := app.latestTestsDiffs(c.UserContext())
+
+	c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	w := c.Response().BodyWriter()
+	writeTestsMetrics(w, diffs)
+	app.metrics.write(w)
+	app.queries.writeQueries(w)
+	if cache := app.db.Cache(); cache != nil {
+		writeCacheMetrics(w, cache.Stats())
+	}
+
+	return nil
+}
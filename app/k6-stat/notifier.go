@@ -0,0 +1,412 @@
+package k6_stat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/rs/zerolog"
+
+	"github.com/msaf1980/k6-stat/dbs"
+)
+
+// Webhook payload formats
+const (
+	WebhookGeneric    = "generic"    // JSON NotifyEvent with rendered message in text field
+	WebhookSlack      = "slack"      // Slack incoming webhook ({"text": message}, mrkdwn)
+	WebhookMattermost = "mattermost" // Mattermost incoming webhook ({"text": message}, markdown)
+)
+
+const (
+	DefaultNotifyInterval = time.Minute
+	DefaultNotifyLookback = 24 * time.Hour
+	DefaultNotifyRetries  = 3
+	DefaultNotifyBackoff  = time.Second
+)
+
+var ErrInvalidWebhookFormat = errors.New("invalid webhook format, must be generic, slack or mattermost")
+
+// Webhook is a notification receiver
+type Webhook struct {
+	Url    string
+	Format string // WebhookGeneric if empty
+	// Template is a message template (text/template with NotifyEvent), format default template if empty
+	Template string
+}
+
+// NotifyConfig is a finished runs notifier settings (notifier is disabled without webhooks)
+type NotifyConfig struct {
+	Webhooks []Webhook
+	// Tests is a tests name patterns (LIKE format), all tests if empty
+	Tests []string
+	// Interval is a new finished tests check interval (DefaultNotifyInterval if zero)
+	Interval time.Duration
+	// Lookback is a started tests lookup period (DefaultNotifyLookback if zero)
+	Lookback time.Duration
+	// Stats is a compared durations statistics (dbs.DefaultStats if empty)
+	Stats []string
+	// Threshold is a regression threshold in percents (dbs.DefaultRegressionThreshold if zero)
+	Threshold float64
+	// RegressionsOnly send notifications only for runs with regressions
+	RegressionsOnly bool
+	// Retries is a webhook send retries and failed (on transient query error) tests checks retries
+	// (DefaultNotifyRetries if zero, negative for disable)
+	Retries int
+	// Backoff is an initial retry delay, doubled on each retry (DefaultNotifyBackoff if zero)
+	Backoff time.Duration
+}
+
+// NotifyEvent is a finished run notification
+type NotifyEvent struct {
+	Test        dbs.Test             `json:"test"`
+	Baseline    *dbs.Test            `json:"baseline,omitempty"`
+	Duration    float64              `json:"duration"` // seconds
+	Threshold   float64              `json:"threshold"`
+	Regressions []dbs.Regression     `json:"regressions"`
+	Diff        *dbs.TestSamplesDiff `json:"diff"`
+	Text        string               `json:"text,omitempty"` // rendered message (for generic webhooks)
+}
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"time": func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}
+
+const defaultSlackTemplate = `{{ if .Regressions }}:warning:{{ else }}:white_check_mark:{{ end }} *{{ .Test.Name }}* finished ({{ time .Test.Ts }}, {{ printf "%.0f" .Duration }}s){{ if .Test.Params }}, params: ` + "`{{ .Test.Params }}`" + `{{ end }}
+{{ if .Baseline }}Baseline: {{ .Baseline.Name }} ({{ time .Baseline.Ts }}){{ else }}Baseline not found{{ end }}
+{{ if .Regressions }}Regressions (threshold {{ .Threshold }}%):
+{{ range .Regressions }}• {{ .Label }} {{ if .Check }}{{ .Check }}{{ else }}` + "`{{ .Url }}`" + `{{ end }} {{ .Stat }}: {{ printf "%.2f" .Value }} (ref {{ printf "%.2f" .RefValue }}, {{ printf "%+.1f" .DiffPcnt }}%)
+{{ end }}{{ else }}No regressions (threshold {{ .Threshold }}%)
+{{ end }}`
+
+const defaultMarkdownTemplate = `{{ if .Regressions }}:warning:{{ else }}:white_check_mark:{{ end }} **{{ .Test.Name }}** finished ({{ time .Test.Ts }}, {{ printf "%.0f" .Duration }}s){{ if .Test.Params }}, params: ` + "`{{ .Test.Params }}`" + `{{ end }}
+{{ if .Baseline }}Baseline: {{ .Baseline.Name }} ({{ time .Baseline.Ts }}){{ else }}Baseline not found{{ end }}
+{{ if .Regressions }}Regressions (threshold {{ .Threshold }}%):
+
+| Label | Url | Stat | Value | Reference | Diff % |
+|:------|:----|:-----|------:|----------:|-------:|
+{{ range .Regressions }}| {{ .Label }} | {{ if .Check }}{{ .Check }}{{ else }}` + "`{{ .Url }}`" + `{{ end }} | {{ .Stat }} | {{ printf "%.2f" .Value }} | {{ printf "%.2f" .RefValue }} | {{ printf "%+.1f" .DiffPcnt }} |
+{{ end }}{{ else }}No regressions (threshold {{ .Threshold }}%)
+{{ end }}`
+
+type webhookSender struct {
+	Webhook
+	tmpl *template.Template
+}
+
+// Notifier detect finished tests, compare them with baselines and send notifications to webhooks
+type Notifier struct {
+	db     *dbs.DB
+	logger *zerolog.Logger
+	config NotifyConfig
+	filter dbs.SampleFilter
+	client *http.Client
+
+	webhooks []webhookSender
+
+	mu sync.Mutex
+	// tests state (false for running or failed, true for notified or finished before notifier start)
+	tests map[dbs.TestIdFilter]bool
+	// failed (on transient query error) tests attempts
+	failures map[dbs.TestIdFilter]int
+	init     bool
+}
+
+// NewNotifier create notifier (quantile is a quantile function for samples queries)
+func NewNotifier(db *dbs.DB, logger *zerolog.Logger, config NotifyConfig, quantile string) (*Notifier, error) {
+	if config.Interval <= 0 {
+		config.Interval = DefaultNotifyInterval
+	}
+	if config.Lookback <= 0 {
+		config.Lookback = DefaultNotifyLookback
+	}
+	if config.Threshold <= 0 {
+		config.Threshold = dbs.DefaultRegressionThreshold
+	}
+	if config.Retries == 0 {
+		config.Retries = DefaultNotifyRetries
+	} else if config.Retries < 0 {
+		config.Retries = 0
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = DefaultNotifyBackoff
+	}
+	n := &Notifier{
+		db:       db,
+		logger:   logger,
+		config:   config,
+		filter:   dbs.SampleFilter{Stats: config.Stats, Quantile: quantile},
+		client:   &http.Client{Timeout: 10 * time.Second},
+		webhooks: make([]webhookSender, 0, len(config.Webhooks)),
+		tests:    make(map[dbs.TestIdFilter]bool),
+		failures: make(map[dbs.TestIdFilter]int),
+	}
+	for _, w := range config.Webhooks {
+		text := w.Template
+		switch w.Format {
+		case "":
+			w.Format = WebhookGeneric
+			if text == "" {
+				text = defaultMarkdownTemplate
+			}
+		case WebhookGeneric, WebhookMattermost:
+			if text == "" {
+				text = defaultMarkdownTemplate
+			}
+		case WebhookSlack:
+			if text == "" {
+				text = defaultSlackTemplate
+			}
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrInvalidWebhookFormat, w.Format)
+		}
+		tmpl, err := template.New(w.Format).Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		n.webhooks = append(n.webhooks, webhookSender{Webhook: w, tmpl: tmpl})
+	}
+	return n, nil
+}
+
+// Run check finished tests with interval until context canceled
+func (n *Notifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.config.Interval)
+	defer ticker.Stop()
+	for {
+		if err := n.Check(ctx); err != nil {
+			n.logger.Error().Str("sql", err.Query()).Err(err.Wrapped()).Msg("notifier check")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check find finished (since previous check) tests and send notifications.
+// Tests, finished before the first check, are skipped. Failed (on transient samples query error) tests are retried
+// on the next checks (up to Retries times).
+func (n *Notifier) Check(ctx context.Context) *dbs.QueryError {
+	tests, qErr := n.finishedTests(ctx)
+	for _, test := range tests {
+		event, err := n.event(ctx, test)
+		if err != nil {
+			n.failed(test, err)
+			continue
+		}
+		n.mu.Lock()
+		delete(n.failures, dbs.TestIdFilter{Id: test.Id, Time: test.Ts.UnixNano()})
+		n.mu.Unlock()
+		if n.config.RegressionsOnly && len(event.Regressions) == 0 {
+			continue
+		}
+		n.notify(ctx, event)
+	}
+
+	return qErr
+}
+
+// failed mark test for retry on the next check (for transient query error, up to Retries attempts)
+func (n *Notifier) failed(test dbs.Test, err *dbs.QueryError) {
+	key := dbs.TestIdFilter{Id: test.Id, Time: test.Ts.UnixNano()}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.failures[key]++
+	attempt := n.failures[key]
+	retry := transientError(err) && attempt <= n.config.Retries
+	n.logger.Error().Uint64("id", test.Id).Str("test", test.Name).Int("attempt", attempt).Bool("retry", retry).
+		Str("sql", err.Query()).Err(err.Wrapped()).Msg("notifier event")
+	if retry {
+		n.tests[key] = false
+	} else {
+		// give up, test is still marked as notified
+		delete(n.failures, key)
+	}
+}
+
+// transientError check, that query can be successful on retry (timeout, canceled query or DB unavailable)
+func transientError(err *dbs.QueryError) bool {
+	switch err.ErrorCode() {
+	case dbs.ErrCodeTimeout, dbs.ErrCodeCanceled, dbs.ErrCodeDBUnavailable:
+		return true
+	default:
+		return false
+	}
+}
+
+// finishedTests return tests, finished since previous check (they are marked as notified, so concurrent check skip them).
+// Tests query error for one pattern don't skip other patterns, the first error is returned.
+// Queries are executed without lock, so concurrent check (or failed test retry) are not blocked by slow DB.
+func (n *Notifier) finishedTests(ctx context.Context) ([]dbs.Test, *dbs.QueryError) {
+	patterns := n.config.Tests
+	if len(patterns) == 0 {
+		patterns = []string{""}
+	}
+	from := time.Now().Add(-n.config.Lookback).Unix()
+	var (
+		candidates []dbs.Test
+		qErr       *dbs.QueryError
+	)
+	for _, pattern := range patterns {
+		tests, err := n.db.GetTests(ctx, dbs.TestFilter{Name: pattern, From: from})
+		if err != nil {
+			if qErr == nil {
+				qErr = err
+			}
+			continue
+		}
+		candidates = append(candidates, tests...)
+	}
+
+	n.mu.Lock()
+	init := n.init
+	pending := make([]dbs.Test, 0, len(candidates))
+	for _, test := range candidates {
+		if !n.tests[dbs.TestIdFilter{Id: test.Id, Time: test.Ts.UnixNano()}] {
+			pending = append(pending, test)
+		}
+	}
+	n.mu.Unlock()
+
+	// check running state (one query per not notified test, finished state is memorized by DB)
+	finishedState := make([]bool, len(pending))
+	for i, test := range pending {
+		finishedState[i] = n.db.TestFinished(ctx, test)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var finished []dbs.Test
+	for i, test := range pending {
+		key := dbs.TestIdFilter{Id: test.Id, Time: test.Ts.UnixNano()}
+		if n.tests[key] {
+			// notified by concurrent check (or duplicated by patterns)
+			continue
+		}
+		if !finishedState[i] {
+			n.tests[key] = false
+			continue
+		}
+		// mark as notified before send, failed webhook must not block the next tests
+		n.tests[key] = true
+		// tests, finished before notifier start, are skipped
+		if init {
+			finished = append(finished, test)
+		}
+	}
+	if qErr == nil {
+		n.init = true
+	}
+	// forget tests out of lookback period
+	for key := range n.tests {
+		if key.Time < from*int64(time.Second) {
+			delete(n.tests, key)
+			delete(n.failures, key)
+		}
+	}
+
+	return finished, qErr
+}
+
+// event compare finished test with baseline
+func (n *Notifier) event(ctx context.Context, test dbs.Test) (*NotifyEvent, *dbs.QueryError) {
+	samples, err := n.db.GetHttpTestSamples(ctx, test, n.filter)
+	if err != nil {
+		return nil, err
+	}
+	event := &NotifyEvent{Test: test, Duration: samples.Duration, Threshold: n.config.Threshold}
+	ref := &dbs.TestSamples{Quantile: samples.Quantile, Stats: samples.Stats}
+	if baseline, err := n.db.GetBaseline(ctx, test); err == nil {
+		event.Baseline = &baseline
+		if ref, err = n.db.GetHttpTestSamples(ctx, baseline, n.filter); err != nil {
+			return nil, err
+		}
+	} else if err.Code() != http.StatusNotFound {
+		return nil, err
+	}
+	diff, dErr := dbs.DiffSamples(samples, ref)
+	if dErr != nil {
+		return nil, dbs.NewQueryError(dErr, http.StatusInternalServerError, "")
+	}
+	event.Diff = diff
+	event.Regressions = dbs.FindRegressions(diff, n.config.Threshold)
+	if event.Regressions == nil {
+		event.Regressions = []dbs.Regression{}
+	}
+	return event, nil
+}
+
+func (n *Notifier) notify(ctx context.Context, event *NotifyEvent) {
+	for i := range n.webhooks {
+		w := &n.webhooks[i]
+		if err := n.send(ctx, w, event); err != nil {
+			n.logger.Error().Str("webhook", w.Url).Str("test", event.Test.Name).Err(err).Msg("notify")
+		}
+	}
+}
+
+// payload render webhook payload for event
+func (w *webhookSender) payload(event *NotifyEvent) ([]byte, error) {
+	var sb strings.Builder
+	if err := w.tmpl.Execute(&sb, event); err != nil {
+		return nil, err
+	}
+	if w.Format == WebhookGeneric {
+		e := *event
+		e.Text = sb.String()
+		return json.Marshal(&e)
+	}
+	return json.Marshal(map[string]string{"text": sb.String()})
+}
+
+// send post event to webhook with retries (exponential backoff). Client errors (4xx, except 429) are not retried.
+func (n *Notifier) send(ctx context.Context, w *webhookSender, event *NotifyEvent) error {
+	body, err := w.payload(event)
+	if err != nil {
+		return err
+	}
+	backoff := n.config.Backoff
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		if retry, err = n.post(ctx, w.Url, body); err == nil || !retry || attempt >= n.config.Retries {
+			return err
+		}
+		n.logger.Warn().Str("webhook", w.Url).Int("attempt", attempt+1).Err(err).Msg("notify retry")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, url string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests,
+			fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return false, nil
+}
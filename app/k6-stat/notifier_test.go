@@ -0,0 +1,280 @@
+//go:build !test_integration
+// +build !test_integration
+
+package k6_stat
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/msaf1980/k6-stat/dbs"
+)
+
+// webhookStandIn is a local webhook receiver, failed the first requests
+type webhookStandIn struct {
+	mu       sync.Mutex
+	fails    int
+	requests int
+	bodies   []string
+}
+
+func (s *webhookStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if s.fails > 0 {
+		s.fails--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	s.bodies = append(s.bodies, string(body))
+}
+
+func expectNotifySamples(mock sqlmock.Sqlmock, test dbs.Test, max float64) {
+	mock.ExpectQuery(`^SELECT id, start, label, url, max\(value\) FROM t_k6_samples WHERE id = @Id AND start = @Time AND metric = @Metric `).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "url", "max"}).
+			AddRow(test.Id, test.Ts, "find", "q=a.*", max))
+	mock.ExpectQuery(`^SELECT id, start, label, url, status, sum\(value\) FROM t_k6_samples WHERE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "url", "status", "count"}).
+			AddRow(test.Id, test.Ts, "find", "q=a.*", "200", 10.0))
+	mock.ExpectQuery(`^SELECT id, start, label, tags\['group'\] AS check_group`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "group", "check", "passes", "count"}))
+	mock.ExpectQuery(`^SELECT id, start, min\(ts\), max\(ts\) FROM t_k6_samples`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "from", "until"}).
+			AddRow(test.Id, test.Ts, test.Ts, test.Ts.Add(10*time.Second)))
+	mock.ExpectQuery(`^SELECT id, start, label, url, metric, sum\(value\) FROM t_k6_samples`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "url", "metric", "value"}))
+}
+
+func TestNotifier(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// samples queries are executed in parallel (the first matched expectation is used)
+	mock.MatchExpectationsInOrder(false)
+	d := dbs.New(db, "t_k6_tests", "t_k6_samples")
+
+	generic := &webhookStandIn{fails: 2}
+	genericServer := httptest.NewServer(generic)
+	defer genericServer.Close()
+	slack := &webhookStandIn{}
+	slackServer := httptest.NewServer(slack)
+	defer slackServer.Close()
+
+	n, err := NewNotifier(d, &logger, NotifyConfig{
+		Webhooks: []Webhook{
+			{Url: genericServer.URL},
+			{Url: slackServer.URL, Format: WebhookSlack, Template: "{{ .Test.Name }}: {{ len .Regressions }} regressions"},
+		},
+		Stats:   []string{"max"},
+		Backoff: time.Millisecond,
+	}, string(dbs.QuantileDefault))
+	require.NoError(t, err)
+
+	now := time.Now().Truncate(time.Second).UTC()
+	old := dbs.Test{Id: 1, Ts: now.Add(-2 * time.Hour), Name: "graphite", Params: "USERS=1"}
+	running := dbs.Test{Id: 2, Ts: now.Add(-time.Hour), Name: "graphite", Params: "USERS=2"}
+	testsRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "ts", "name", "params"}).
+			AddRow(old.Id, old.Ts, old.Name, old.Params).
+			AddRow(running.Id, running.Ts, running.Name, running.Params)
+	}
+
+	// first check: old test is finished before notifier start
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts >= \? ORDER BY id, ts, name$`).WillReturnRows(testsRows())
+	mock.ExpectQuery(`^SELECT max\(ts\) FROM t_k6_samples WHERE id = @Id AND start = @Time$`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(old.Ts.Add(time.Hour)))
+	mock.ExpectQuery(`^SELECT max\(ts\) FROM t_k6_samples WHERE id = @Id AND start = @Time$`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(now))
+	require.Nil(t, n.Check(context.Background()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 0, generic.requests+slack.requests)
+
+	// second check: running test is finished, compared with previous run
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts >= \? ORDER BY id, ts, name$`).WillReturnRows(testsRows())
+	mock.ExpectQuery(`^SELECT max\(ts\) FROM t_k6_samples WHERE id = @Id AND start = @Time$`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(running.Ts.Add(10 * time.Second)))
+	expectNotifySamples(mock, running, 30)
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts < \? AND name LIKE \? ORDER BY ts DESC, id DESC, name LIMIT 2$`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}).
+			AddRow(running.Id, running.Ts, running.Name, running.Params).
+			AddRow(old.Id, old.Ts, old.Name, old.Params))
+	expectNotifySamples(mock, old, 20)
+	require.Nil(t, n.Check(context.Background()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// retried after failures
+	assert.Equal(t, 3, generic.requests)
+	require.Equal(t, 1, len(generic.bodies))
+	var event NotifyEvent
+	require.NoError(t, json.Unmarshal([]byte(generic.bodies[0]), &event))
+	assert.Equal(t, running.Id, event.Test.Id)
+	assert.Equal(t, old.Id, event.Baseline.Id)
+	assert.Equal(t, []dbs.Regression{{Label: "find", Url: "q=a.*", Stat: "max", Value: 30, RefValue: 20, DiffPcnt: 50}}, event.Regressions)
+	assert.True(t, strings.HasPrefix(event.Text, ":warning: **graphite** finished"), event.Text)
+	assert.Contains(t, event.Text, "| find | `q=a.*` | max | 30.00 | 20.00 | +50.0 |")
+
+	assert.Equal(t, []string{`{"text":"graphite: 1 regressions"}`}, slack.bodies)
+
+	// no new notifications
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts >= \? ORDER BY id, ts, name$`).WillReturnRows(testsRows())
+	require.Nil(t, n.Check(context.Background()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 1, slack.requests)
+}
+
+func TestNotifierFailedTest(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.MatchExpectationsInOrder(false)
+	d := dbs.New(db, "t_k6_tests", "t_k6_samples")
+
+	webhook := &webhookStandIn{}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	n, err := NewNotifier(d, &logger, NotifyConfig{
+		Webhooks: []Webhook{{Url: server.URL, Format: WebhookSlack, Template: "{{ .Test.Name }}"}},
+		Stats:    []string{"max"},
+	}, string(dbs.QuantileDefault))
+	require.NoError(t, err)
+
+	now := time.Now().Truncate(time.Second).UTC()
+	failed := dbs.Test{Id: 1, Ts: now.Add(-2 * time.Hour), Name: "graphite"}
+	passed := dbs.Test{Id: 2, Ts: now.Add(-time.Hour), Name: "carbonapi"}
+	testsRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "ts", "name", "params"}).
+			AddRow(failed.Id, failed.Ts, failed.Name, failed.Params).
+			AddRow(passed.Id, passed.Ts, passed.Name, passed.Params)
+	}
+	expectLast := func(last time.Time) {
+		mock.ExpectQuery(`^SELECT max\(ts\) FROM t_k6_samples WHERE id = @Id AND start = @Time$`).
+			WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(last))
+	}
+	expectBaseline := func() *sqlmock.ExpectedQuery {
+		return mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts < \? AND name LIKE \? ORDER BY ts DESC, id DESC, name LIMIT 2$`)
+	}
+
+	// both tests are running
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts >= \? ORDER BY id, ts, name$`).WillReturnRows(testsRows())
+	expectLast(now)
+	expectLast(now)
+	require.Nil(t, n.Check(context.Background()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// both tests are finished, the first test baseline query failed, but the next test is notified
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts >= \? ORDER BY id, ts, name$`).WillReturnRows(testsRows())
+	expectLast(now.Add(-time.Hour))
+	expectLast(now.Add(-time.Hour))
+	expectNotifySamples(mock, failed, 20)
+	expectBaseline().WillReturnError(&clickhouse.Exception{Code: 159, Message: "Timeout exceeded"})
+	expectNotifySamples(mock, passed, 20)
+	expectBaseline().WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}))
+	require.Nil(t, n.Check(context.Background()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, []string{`{"text":"carbonapi"}`}, webhook.bodies)
+
+	// failed test is retried
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts >= \? ORDER BY id, ts, name$`).WillReturnRows(testsRows())
+	expectNotifySamples(mock, failed, 20)
+	expectBaseline().WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}))
+	require.Nil(t, n.Check(context.Background()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, []string{`{"text":"carbonapi"}`, `{"text":"graphite"}`}, webhook.bodies)
+}
+
+func TestNotifierRetries(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.MatchExpectationsInOrder(false)
+	d := dbs.New(db, "t_k6_tests", "t_k6_samples")
+
+	webhook := &webhookStandIn{}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	n, err := NewNotifier(d, &logger, NotifyConfig{
+		Webhooks: []Webhook{{Url: server.URL, Format: WebhookSlack, Template: "{{ .Test.Name }}"}},
+		Stats:    []string{"max"},
+		Retries:  1,
+	}, string(dbs.QuantileDefault))
+	require.NoError(t, err)
+
+	now := time.Now().Truncate(time.Second).UTC()
+	invalid := dbs.Test{Id: 1, Ts: now.Add(-2 * time.Hour), Name: "graphite"}
+	timeout := dbs.Test{Id: 2, Ts: now.Add(-time.Hour), Name: "carbonapi"}
+	expectTests := func() {
+		mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts >= \? ORDER BY id, ts, name$`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}).
+				AddRow(invalid.Id, invalid.Ts, invalid.Name, invalid.Params).
+				AddRow(timeout.Id, timeout.Ts, timeout.Name, timeout.Params))
+	}
+	expectLast := func(last time.Time) {
+		mock.ExpectQuery(`^SELECT max\(ts\) FROM t_k6_samples WHERE id = @Id AND start = @Time$`).
+			WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(last))
+	}
+	expectBaseline := func() *sqlmock.ExpectedQuery {
+		return mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts < \? AND name LIKE \? ORDER BY ts DESC, id DESC, name LIMIT 2$`)
+	}
+
+	// both tests are running
+	expectTests()
+	expectLast(now)
+	expectLast(now)
+	require.Nil(t, n.Check(context.Background()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// both tests are finished and failed
+	expectTests()
+	expectLast(now.Add(-time.Hour))
+	expectLast(now.Add(-time.Hour))
+	expectNotifySamples(mock, invalid, 20)
+	expectBaseline().WillReturnError(&clickhouse.Exception{Code: 62, Message: "Syntax error"})
+	expectNotifySamples(mock, timeout, 20)
+	expectBaseline().WillReturnError(&clickhouse.Exception{Code: 159, Message: "Timeout exceeded"})
+	require.Nil(t, n.Check(context.Background()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// only transient error is retried
+	expectTests()
+	expectNotifySamples(mock, timeout, 20)
+	expectBaseline().WillReturnError(&clickhouse.Exception{Code: 159, Message: "Timeout exceeded"})
+	require.Nil(t, n.Check(context.Background()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// retries are exceeded
+	expectTests()
+	require.Nil(t, n.Check(context.Background()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, webhook.bodies)
+	assert.Empty(t, n.failures)
+}
+
+func TestNotifierInvalidFormat(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	_, err := NewNotifier(nil, &logger, NotifyConfig{Webhooks: []Webhook{{Url: "http://localhost", Format: "teams"}}}, "")
+	assert.ErrorIs(t, err, ErrInvalidWebhookFormat)
+}
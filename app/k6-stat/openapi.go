@@ -0,0 +1,17 @@
+package k6_stat
+
+import (
+	_ "embed"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// OpenAPI is an API OpenAPI 3 specification (served at /api/openapi.json)
+//
+//go:embed openapi.json
+var OpenAPI []byte
+
+func (app *App) openapi(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(OpenAPI)
+}
@@ -0,0 +1,261 @@
+//go:build !test_integration
+// +build !test_integration
+
+package k6_stat
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/msaf1980/k6-stat/dbs"
+)
+
+type openapiSchema struct {
+	Ref        string                    `json:"$ref"`
+	Properties map[string]*openapiSchema `json:"properties"`
+	AllOf      []*openapiSchema          `json:"allOf"`
+}
+
+type openapiParameter struct {
+	Name string `json:"name"`
+	In   string `json:"in"`
+}
+
+type openapiOperation struct {
+	OperationId string             `json:"operationId"`
+	Parameters  []openapiParameter `json:"parameters"`
+}
+
+type openapiSpec struct {
+	Paths      map[string]map[string]*openapiOperation `json:"paths"`
+	Components struct {
+		Schemas   map[string]*openapiSchema  `json:"schemas"`
+		Responses map[string]json.RawMessage `json:"responses"`
+	} `json:"components"`
+}
+
+// properties return schema properties names (with allOf and references)
+func (spec *openapiSpec) properties(t *testing.T, s *openapiSchema) []string {
+	var props []string
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+		ref, ok := spec.Components.Schemas[name]
+		require.True(t, ok, s.Ref)
+		return spec.properties(t, ref)
+	}
+	for name := range s.Properties {
+		props = append(props, name)
+	}
+	for _, sub := range s.AllOf {
+		props = append(props, spec.properties(t, sub)...)
+	}
+	sort.Strings(props)
+	return props
+}
+
+// fieldNames return struct fields names (from tag, like encoding/json)
+func fieldNames(typ reflect.Type, tagName string) []string {
+	var names []string
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag, ok := f.Tag.Lookup(tagName)
+		if f.Anonymous && !ok {
+			names = append(names, fieldNames(f.Type, tagName)...)
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func loadOpenAPI(t *testing.T) *openapiSpec {
+	var spec openapiSpec
+	require.NoError(t, json.Unmarshal(OpenAPI, &spec))
+	return &spec
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	spec := loadOpenAPI(t)
+
+	logger := zerolog.New(os.Stdout)
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	app, err := NewWithDB(db, &logger, "t_k6_tests", "t_k6_samples", Config{Metrics: true})
+	require.NoError(t, err)
+
+	routes := make(map[string]bool)
+	for _, r := range app.fiberApp.GetRoutes(true) {
+		if r.Method == http.MethodHead {
+			continue
+		}
+		routes[r.Method+" "+openapiPath(r.Path)] = true
+	}
+	operations := make(map[string]bool)
+	operationIds := make(map[string]bool)
+	for path, item := range spec.Paths {
+		for method, op := range item {
+			operations[strings.ToUpper(method)+" "+path] = true
+			assert.False(t, operationIds[op.OperationId], "duplicate operationId %s", op.OperationId)
+			operationIds[op.OperationId] = true
+		}
+	}
+	for route := range routes {
+		assert.True(t, operations[route], "route %s not described in OpenAPI spec", route)
+	}
+	for op := range operations {
+		assert.True(t, routes[op], "OpenAPI operation %s not routed", op)
+	}
+
+	// query params
+	topParams := fieldNames(reflect.TypeOf(TopFilter{}), "query")
+	for i, name := range topParams {
+		if name == "by-diff" {
+			// compare only
+			topParams = append(topParams[:i:i], topParams[i+1:]...)
+			break
+		}
+	}
+	for path, fields := range map[string][]string{
+		"/api/test/live":                               fieldNames(reflect.TypeOf(LiveFilter{}), "query"),
+		"/api/tests":                                   fieldNames(reflect.TypeOf(TestsQuery{}), "query"),
+		"/api/tests/{id}/{start}":                      nil,
+		"/api/tests/{id}/{start}/http/top":             topParams,
+		"/api/compare/{id}/{start}/{refid}/{refstart}": fieldNames(reflect.TypeOf(TopFilter{}), "query"),
+	} {
+		var params []string
+		for _, p := range spec.Paths[path]["get"].Parameters {
+			if p.In == "path" {
+				assert.Contains(t, path, "{"+p.Name+"}")
+				continue
+			}
+			assert.Equal(t, "query", p.In)
+			params = append(params, p.Name)
+		}
+		sort.Strings(params)
+		assert.Equal(t, fields, params, path)
+	}
+}
+
+// openapiPath convert route path params (:id) to OpenAPI format ({id})
+func openapiPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	spec := loadOpenAPI(t)
+
+	types := map[string]any{
+		"Error":                ErrorResponse{},
+		"VersionInfo":          VersionInfo{},
+		"CacheStats":           dbs.CacheStats{},
+		"Annotation":           dbs.Annotation{},
+		"Test":                 dbs.Test{},
+		"TestFilter":           dbs.TestFilter{},
+		"TestIdFilter":         dbs.TestIdFilter{},
+		"TestPatch":            dbs.TestPatch{},
+		"SampleFilter":         dbs.SampleFilter{},
+		"HistogramFilter":      dbs.HistogramFilter{},
+		"HistogramDiffFilter":  HistogramDiffFilter{},
+		"ChecksFilter":         ChecksFilter{},
+		"SampleQuantiles":      dbs.SampleQuantiles{},
+		"SampleStatus":         dbs.SampleStatus{},
+		"SampleDurations":      dbs.SampleDurations{},
+		"SampleCheck":          dbs.SampleCheck{},
+		"TestSamples":          dbs.TestSamples{},
+		"ParamDiff":            dbs.ParamDiff{},
+		"SampleDurationsDiff":  dbs.SampleDurationsDiff{},
+		"SampleCheckDiff":      dbs.SampleCheckDiff{},
+		"TestSamplesDiff":      dbs.TestSamplesDiff{},
+		"Regression":           dbs.Regression{},
+		"LiveSamples":          dbs.LiveSamples{},
+		"TestRange":            dbs.TestRange{},
+		"SampleRate":           dbs.SampleRate{},
+		"Histogram":            dbs.Histogram{},
+		"SamplesHistogram":     dbs.SamplesHistogram{},
+		"HistogramDiff":        dbs.HistogramDiff{},
+		"SamplesHistogramDiff": dbs.SamplesHistogramDiff{},
+		"SamplesHeatmap":       dbs.SamplesHeatmap{},
+	}
+	for name, v := range types {
+		t.Run(name, func(t *testing.T) {
+			schema, ok := spec.Components.Schemas[name]
+			require.True(t, ok, "schema not found")
+			assert.Equal(t, fieldNames(reflect.TypeOf(v), "json"), spec.properties(t, schema))
+		})
+	}
+
+	// all references are resolved
+	var refs []string
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for k, item := range v {
+				if s, ok := item.(string); ok && k == "$ref" {
+					refs = append(refs, s)
+				} else {
+					walk(item)
+				}
+			}
+		case []any:
+			for _, item := range v {
+				walk(item)
+			}
+		}
+	}
+	var raw any
+	require.NoError(t, json.Unmarshal(OpenAPI, &raw))
+	walk(raw)
+	require.NotEmpty(t, refs)
+	for _, ref := range refs {
+		switch {
+		case strings.HasPrefix(ref, "#/components/schemas/"):
+			assert.Contains(t, spec.Components.Schemas, strings.TrimPrefix(ref, "#/components/schemas/"), ref)
+		case strings.HasPrefix(ref, "#/components/responses/"):
+			assert.Contains(t, spec.Components.Responses, strings.TrimPrefix(ref, "#/components/responses/"), ref)
+		default:
+			t.Errorf("unexpected reference %s", ref)
+		}
+	}
+}
+
+func TestUnitAppOpenAPI(t *testing.T) {
+	// specification doesn't require authentication
+	tokensFile := filepath.Join(t.TempDir(), "tokens")
+	require.NoError(t, os.WriteFile(tokensFile, []byte("token read\n"), 0600))
+	app := newAuthApp(t, Config{Auth: AuthConfig{TokensFile: tokensFile}})
+
+	req, _ := http.NewRequest("GET", "/api/openapi.json", nil)
+	resp, err := app.fiberApp.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, OpenAPI, body)
+}
@@ -0,0 +1,237 @@
+package k6_stat
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/msaf1980/k6-stat/dbs"
+)
+
+// CacheControlFinished is a Cache-Control header for finished test resources
+// (samples are not changed, but annotation can be updated, so clients must revalidate with ETag on each request)
+const CacheControlFinished = "private, no-cache"
+
+// TestsQuery is a tests search request (query params, for GET /api/tests)
+type TestsQuery struct {
+	From   int64  `query:"from"`  // epoch seconds
+	Until  int64  `query:"until"` // epoch seconds
+	Name   string `query:"name"`
+	Search string `query:"search"`
+	Desc   bool   `query:"desc"`
+	Limit  uint64 `query:"limit"`
+	Offset uint64 `query:"offset"`
+	// params filter (K=V or K for any value)
+	Params []string `query:"param"`
+	Labels []string `query:"label"`
+	Hidden bool     `query:"hidden"`
+}
+
+func (q *TestsQuery) filter() dbs.TestFilter {
+	return dbs.TestFilter{
+		From:   q.From,
+		Until:  q.Until,
+		Name:   q.Name,
+		Search: q.Search,
+		Desc:   q.Desc,
+		Limit:  q.Limit,
+		Offset: q.Offset,
+		Params: dbs.ParseParams(strings.Join(q.Params, " ")),
+		Labels: q.Labels,
+		Hidden: q.Hidden,
+	}
+}
+
+// TopFilter is a test top or compare request (query params)
+type TopFilter struct {
+	Label    string   `query:"label"`
+	Url      string   `query:"url"`
+	SkipUrl  []string `query:"no-url"`
+	Stats    []string `query:"stats"`
+	Quantile string   `query:"quantile"`
+	// sort key (errors, count, rps or statistic name), p99 (or the first statistic) if empty
+	Sort string `query:"sort"`
+	// sort by diff with reference (for compare)
+	ByDiff bool `query:"by-diff"`
+	// top of N urls per label (all if zero)
+	Count int `query:"count"`
+}
+
+// parseStart parse test start from route param (epoch nanoseconds or RFC3339 time)
+func parseStart(s string) (int64, error) {
+	if start, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return start, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid test start %q, must be epoch nanoseconds or RFC3339 time", s)
+	}
+	return t.UnixNano(), nil
+}
+
+// testIdParams parse test id and start from route params
+func testIdParams(c *fiber.Ctx, idParam, startParam string) (dbs.TestIdFilter, error) {
+	id, err := strconv.ParseUint(c.Params(idParam), 10, 64)
+	if err != nil || id == 0 {
+		return dbs.TestIdFilter{}, fmt.Errorf("invalid test id %q", c.Params(idParam))
+	}
+	start, err := parseStart(c.Params(startParam))
+	if err != nil {
+		return dbs.TestIdFilter{}, err
+	}
+	return dbs.TestIdFilter{Id: id, Time: start}, nil
+}
+
+// parseTopFilter parse top request and return samples filter and sort key
+func (app *App) parseTopFilter(c *fiber.Ctx) (TopFilter, dbs.SampleFilter, dbs.SortBy, error) {
+	var top TopFilter
+	if err := c.QueryParser(&top); err != nil {
+		return top, dbs.SampleFilter{}, "", err
+	}
+	f := dbs.SampleFilter{
+		Label: top.Label, Url: top.Url, SkipUrl: top.SkipUrl, Stats: top.Stats, Quantile: top.Quantile,
+	}
+	if f.Quantile == "" {
+		f.Quantile = app.config.Quantile
+	}
+	sortBy := dbs.SortByDefault(f.Stats)
+	if top.Sort != "" {
+		var err error
+		if sortBy, err = dbs.SortByFromString(top.Sort); err != nil {
+			return top, f, sortBy, err
+		}
+		// sort by not requested statistic is ignored (all values are zero)
+		if err = dbs.CheckSortBy(sortBy, f.Stats); err != nil {
+			return top, f, sortBy, err
+		}
+	}
+	return top, f, sortBy, nil
+}
+
+// sendResource send JSON response. Finished test resources are stable, so ETag is set for revalidation
+// (and 304 Not Modified is returned for matched If-None-Match).
+func sendResource(c *fiber.Ctx, finished bool, v any) error {
+	if !finished {
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		return c.JSON(v)
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	c.Set(fiber.HeaderETag, `"`+hex.EncodeToString(sum[:16])+`"`)
+	c.Set(fiber.HeaderCacheControl, CacheControlFinished)
+	if c.Fresh() {
+		return c.SendStatus(http.StatusNotModified)
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(body)
+}
+
+// getTestsByQuery is a GET variant of getTests (with query params)
+func (app *App) getTestsByQuery(c *fiber.Ctx) error {
+	var q TestsQuery
+	if err := c.QueryParser(&q); err != nil {
+		return badRequest(c, err)
+	}
+	return app.sendTests(c, q.filter())
+}
+
+func (app *App) getTest(c *fiber.Ctx) error {
+	f, err := testIdParams(c, "id", "start")
+	if err != nil {
+		return sendError(c, http.StatusBadRequest, dbs.ErrCodeInvalidFilter, err.Error())
+	}
+	test, qErr := app.db.GetTestById(c.UserContext(), f)
+	if qErr != nil {
+		return app.queryError(c, qErr, "get test")
+	}
+
+	return sendResource(c, app.db.TestFinished(c.UserContext(), test), test)
+}
+
+// getHttpTestTop return test statistics, sorted (and limited to top of N) per label
+func (app *App) getHttpTestTop(c *fiber.Ctx) error {
+	f, err := testIdParams(c, "id", "start")
+	if err != nil {
+		return sendError(c, http.StatusBadRequest, dbs.ErrCodeInvalidFilter, err.Error())
+	}
+	top, filter, sortBy, err := app.parseTopFilter(c)
+	if err != nil {
+		return sendError(c, http.StatusBadRequest, dbs.ErrCodeInvalidFilter, err.Error())
+	}
+
+	test, qErr := app.db.GetTestById(c.UserContext(), f)
+	if qErr != nil {
+		return app.queryError(c, qErr, "get test")
+	}
+	samples, qErr := app.db.GetHttpTestSamples(c.UserContext(), test, filter)
+	if qErr != nil {
+		return app.queryError(c, qErr, "get test samples")
+	}
+	for label, durations := range samples.Samples {
+		dbs.SortSamplesDurations(durations, sortBy)
+		if top.Count > 0 && len(durations) > top.Count {
+			samples.Samples[label] = durations[:top.Count]
+		}
+	}
+
+	return sendResource(c, app.db.TestFinished(c.UserContext(), test), samples)
+}
+
+// getCompare return test and reference statistics diff, sorted (and limited to top of N) per label
+func (app *App) getCompare(c *fiber.Ctx) error {
+	f, err := testIdParams(c, "id", "start")
+	if err != nil {
+		return sendError(c, http.StatusBadRequest, dbs.ErrCodeInvalidFilter, err.Error())
+	}
+	refF, err := testIdParams(c, "refid", "refstart")
+	if err != nil {
+		return sendError(c, http.StatusBadRequest, dbs.ErrCodeInvalidFilter, err.Error())
+	}
+	top, filter, sortBy, err := app.parseTopFilter(c)
+	if err != nil {
+		return sendError(c, http.StatusBadRequest, dbs.ErrCodeInvalidFilter, err.Error())
+	}
+
+	test, qErr := app.db.GetTestById(c.UserContext(), f)
+	if qErr != nil {
+		return app.queryError(c, qErr, "get test")
+	}
+	refTest, qErr := app.db.GetTestById(c.UserContext(), refF)
+	if qErr != nil {
+		return app.queryError(c, qErr, "get reference test")
+	}
+	samples, qErr := app.db.GetHttpTestSamples(c.UserContext(), test, filter)
+	if qErr != nil {
+		return app.queryError(c, qErr, "get test samples")
+	}
+	refSamples, qErr := app.db.GetHttpTestSamples(c.UserContext(), refTest, filter)
+	if qErr != nil {
+		return app.queryError(c, qErr, "get reference test samples")
+	}
+	diff, err := dbs.DiffSamples(samples, refSamples)
+	if err != nil {
+		return sendError(c, http.StatusBadRequest, dbs.ErrCodeInvalidFilter, err.Error())
+	}
+	for label, durations := range diff.Samples {
+		if top.ByDiff {
+			dbs.SortSamplesDurationsByDiff(durations, sortBy)
+		} else {
+			dbs.SortSamplesDurationsDiff(durations, sortBy)
+		}
+		if top.Count > 0 && len(durations) > top.Count {
+			diff.Samples[label] = durations[:top.Count]
+		}
+	}
+
+	return sendResource(c, app.db.TestFinished(c.UserContext(), test) && app.db.TestFinished(c.UserContext(), refTest), diff)
+}
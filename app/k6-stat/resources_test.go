@@ -0,0 +1,193 @@
+//go:build !test_integration
+// +build !test_integration
+
+package k6_stat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/msaf1980/k6-stat/dbs"
+)
+
+func newResourcesApp(t *testing.T) (*App, sqlmock.Sqlmock) {
+	logger := zerolog.New(os.Stdout)
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	require.NoError(t, err)
+	mock.MatchExpectationsInOrder(false)
+	app, err := NewWithDB(db, &logger, "t_k6_tests", "t_k6_samples")
+	require.NoError(t, err)
+	return app, mock
+}
+
+func expectTestById(mock sqlmock.Sqlmock, test dbs.Test) {
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts = @Time AND id = @Id`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}).AddRow(test.Id, test.Ts, test.Name, test.Params))
+}
+
+// expectTestSamples expect test samples queries (max duration stat only)
+func expectTestSamples(mock sqlmock.Sqlmock, test dbs.Test, maxByUrl map[string]float64) {
+	rows := sqlmock.NewRows([]string{"id", "start", "label", "url", "max"})
+	for url, v := range maxByUrl {
+		rows.AddRow(test.Id, test.Ts, "render", url, v)
+	}
+	mock.ExpectQuery(`^SELECT id, start, label, url, max\(value\) FROM t_k6_samples`).WillReturnRows(rows)
+	mock.ExpectQuery(`^SELECT id, start, label, url, status, sum\(value\) FROM t_k6_samples`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "url", "status", "count"}))
+	mock.ExpectQuery(`^SELECT id, start, label, tags\['group'\] AS check_group`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "group", "check", "passes", "count"}))
+	mock.ExpectQuery(`^SELECT id, start, min\(ts\), max\(ts\) FROM t_k6_samples`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "from", "until"}))
+	mock.ExpectQuery(`^SELECT id, start, label, url, metric, sum\(value\) FROM t_k6_samples`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "url", "metric", "value"}))
+}
+
+func expectLastSample(mock sqlmock.Sqlmock, last time.Time) {
+	mock.ExpectQuery(`^SELECT max\(ts\) FROM t_k6_samples WHERE id = @Id AND start = @Time$`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(last))
+}
+
+func TestUnitAppTestsQuery(t *testing.T) {
+	app, mock := newResourcesApp(t)
+
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts >= \? AND ts < \? AND name LIKE \? ORDER BY ts DESC, id DESC, name LIMIT 1$`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}).AddRow(test1.Id, test1.Ts, test1.Name, test1.Params))
+	mock.ExpectQuery(`^SELECT count\(\) FROM t_k6_tests WHERE ts >= \? AND ts < \? AND name LIKE \?$`).
+		WillReturnRows(sqlmock.NewRows([]string{"count()"}).AddRow(uint64(2)))
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/tests?from=%d&until=%d&name=graphite%%25&desc=true&limit=1",
+		t1.Unix(), t3.Unix()+1), nil)
+	resp, err := app.fiberApp.Test(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.Equal(t, "2", resp.Header.Get(HeaderTotalCount))
+	var tests []dbs.Test
+	require.NoError(t, json.Unmarshal(body, &tests))
+	assert.Equal(t, []dbs.Test{test1}, tests)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// invalid query param
+	req, _ = http.NewRequest("GET", "/api/tests?limit=x", nil)
+	resp, err = app.fiberApp.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestUnitAppTestResource(t *testing.T) {
+	app, mock := newResourcesApp(t)
+
+	test := dbs.Test{Id: 1, Ts: t1, Name: "graphite", Params: ""}
+	expectTestById(mock, test)
+	expectLastSample(mock, t1.Add(time.Hour))
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/tests/1/%d", t1.UnixNano()), nil)
+	resp, err := app.fiberApp.Test(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, CacheControlFinished, resp.Header.Get("Cache-Control"))
+	etag := resp.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+	var got dbs.Test
+	require.NoError(t, json.Unmarshal(body, &got))
+	assert.Equal(t, test, got)
+
+	// not modified (start as RFC3339 time, finished state is memorized)
+	expectTestById(mock, test)
+	req, _ = http.NewRequest("GET", "/api/tests/1/"+t1.Format(time.RFC3339Nano), nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = app.fiberApp.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	for _, path := range []string{"/api/tests/x/1", "/api/tests/0/1", "/api/tests/1/yesterday"} {
+		req, _ = http.NewRequest("GET", path, nil)
+		resp, err = app.fiberApp.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, path)
+		var errResp ErrorResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+		assert.Equal(t, dbs.ErrCodeInvalidFilter, errResp.Code, path)
+	}
+}
+
+func TestUnitAppTestTop(t *testing.T) {
+	app, mock := newResourcesApp(t)
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+	test := dbs.Test{Id: 1, Ts: start, Name: "graphite", Params: ""}
+	expectTestById(mock, test)
+	expectTestSamples(mock, test, map[string]float64{"/a": 10, "/b": 30, "/c": 20})
+	// running test
+	expectLastSample(mock, time.Now())
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/tests/1/%d/http/top?stats=max&sort=max&count=2", start.UnixNano()), nil)
+	resp, err := app.fiberApp.Test(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+	assert.Empty(t, resp.Header.Get("ETag"))
+	var samples dbs.TestSamples
+	require.NoError(t, json.Unmarshal(body, &samples))
+	require.Len(t, samples.Samples["render"], 2)
+	assert.Equal(t, "/b", samples.Samples["render"][0].Url)
+	assert.Equal(t, "/c", samples.Samples["render"][1].Url)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// invalid sort key
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/tests/1/%d/http/top?sort=latency", start.UnixNano()), nil)
+	resp, err = app.fiberApp.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// not requested statistic
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/tests/1/%d/http/top?stats=p99&stats=max&sort=p95", start.UnixNano()), nil)
+	resp, err = app.fiberApp.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestUnitAppCompare(t *testing.T) {
+	app, mock := newResourcesApp(t)
+
+	test := dbs.Test{Id: 2, Ts: t2, Name: "graphite", Params: "USERS=2"}
+	ref := dbs.Test{Id: 1, Ts: t1, Name: "graphite", Params: "USERS=1"}
+	expectTestById(mock, test)
+	expectTestById(mock, ref)
+	// test and reference samples queries are unordered, so samples are the same
+	expectTestSamples(mock, test, map[string]float64{"/a": 10, "/b": 30})
+	expectTestSamples(mock, test, map[string]float64{"/a": 10, "/b": 30})
+	expectLastSample(mock, t2.Add(time.Hour))
+	expectLastSample(mock, t1.Add(time.Hour))
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/compare/2/%d/1/%d?stats=max&count=1", t2.UnixNano(), t1.UnixNano()), nil)
+	resp, err := app.fiberApp.Test(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.Equal(t, CacheControlFinished, resp.Header.Get("Cache-Control"))
+	assert.NotEmpty(t, resp.Header.Get("ETag"))
+	var diff dbs.TestSamplesDiff
+	require.NoError(t, json.Unmarshal(body, &diff))
+	assert.Equal(t, test.Id, diff.Test.Id)
+	assert.Equal(t, ref.Id, diff.Reference.Id)
+	assert.Equal(t, []dbs.ParamDiff{{Key: "USERS", Value: "2", RefValue: "1"}}, diff.ParamsDiff)
+	require.Len(t, diff.Samples["render"], 1)
+	assert.Equal(t, "/b", diff.Samples["render"][0].Url)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
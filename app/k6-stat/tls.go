@@ -0,0 +1,223 @@
+package k6_stat
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+
+	"github.com/msaf1980/k6-stat/utils/tlsconfig"
+)
+
+// Client certificates verification modes
+const (
+	ClientAuthNone     = "none"     // client certificates are not requested
+	ClientAuthOptional = "optional" // client certificate is verified, if given
+	ClientAuthRequire  = "require"  // valid client certificate is required
+)
+
+// DefaultTLSReloadInterval is a certificates files change check interval
+const DefaultTLSReloadInterval = 10 * time.Second
+
+var ErrInvalidClientAuth = errors.New("invalid client auth, must be none, optional or require")
+
+// TLSConfig is a HTTPS server settings (TLS is disabled, if CertFile is not set)
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is a CA certificates for client certificates verification (required for client auth)
+	ClientCAFile string
+	// ClientAuth is a client certificates verification mode (ClientAuthNone if empty, ClientAuthRequire if empty and ClientCAFile is set)
+	ClientAuth string
+	// ReloadInterval is a certificates files change check interval (DefaultTLSReloadInterval if zero)
+	ReloadInterval time.Duration
+}
+
+func parseClientAuth(s, clientCA string) (tls.ClientAuthType, error) {
+	switch s {
+	case "":
+		if clientCA != "" {
+			return tls.RequireAndVerifyClientCert, nil
+		}
+		return tls.NoClientCert, nil
+	case ClientAuthNone:
+		return tls.NoClientCert, nil
+	case ClientAuthOptional:
+		return tls.VerifyClientCertIfGiven, nil
+	case ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("%w: %q", ErrInvalidClientAuth, s)
+	}
+}
+
+// certReloader serve TLS config, reloaded on certificates files change
+type certReloader struct {
+	cfg        TLSConfig
+	clientAuth tls.ClientAuthType
+	logger     *zerolog.Logger
+
+	mu        sync.Mutex
+	tlsConfig *tls.Config
+	modTimes  []time.Time
+	checked   time.Time
+}
+
+func newCertReloader(cfg TLSConfig, logger *zerolog.Logger) (*certReloader, error) {
+	if cfg.KeyFile == "" {
+		return nil, errors.New("TLS key file not set")
+	}
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = DefaultTLSReloadInterval
+	}
+	clientAuth, err := parseClientAuth(cfg.ClientAuth, cfg.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	if clientAuth != tls.NoClientCert && cfg.ClientCAFile == "" {
+		return nil, errors.New("client CA file not set for client auth")
+	}
+	r := &certReloader{cfg: cfg, clientAuth: clientAuth, logger: logger}
+	if err = r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	if r.cfg.ClientCAFile == "" {
+		return []string{r.cfg.CertFile, r.cfg.KeyFile}
+	}
+	return []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.ClientCAFile}
+}
+
+func (r *certReloader) statFiles() ([]time.Time, error) {
+	files := r.files()
+	modTimes := make([]time.Time, len(files))
+	for i, path := range files {
+		st, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[i] = st.ModTime()
+	}
+	return modTimes, nil
+}
+
+// load certificates (must be called under lock or on init)
+func (r *certReloader) load() error {
+	modTimes, err := r.statFiles()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   r.clientAuth,
+	}
+	if r.cfg.ClientCAFile != "" {
+		if tlsConfig.ClientCAs, err = tlsconfig.LoadCertPool(r.cfg.ClientCAFile); err != nil {
+			return err
+		}
+	}
+	r.tlsConfig = tlsConfig
+	r.modTimes = modTimes
+	r.checked = time.Now()
+	return nil
+}
+
+func modTimesChanged(a, b []time.Time) bool {
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// getConfig return current TLS config, reload certificates if files changed (failed reload keep previous certificates)
+func (r *certReloader) getConfig(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) >= r.cfg.ReloadInterval {
+		r.checked = time.Now()
+		if modTimes, err := r.statFiles(); err != nil {
+			r.logger.Error().Err(err).Msg("TLS certificates check")
+		} else if modTimesChanged(r.modTimes, modTimes) {
+			if err = r.load(); err == nil {
+				r.logger.Info().Str("cert", r.cfg.CertFile).Msg("TLS certificates reloaded")
+			} else {
+				r.logger.Error().Err(err).Msg("TLS certificates reload")
+			}
+		}
+	}
+	return r.tlsConfig, nil
+}
+
+// serverConfig return TLS server config with certificates reload on change
+func (r *certReloader) serverConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: r.getConfig,
+	}
+}
+
+// listener return TCP listener, wrapped with TLS (if enabled)
+func (app *App) listener(address string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if app.certs != nil {
+		ln = tls.NewListener(ln, app.certs.serverConfig())
+	}
+	return ln, nil
+}
+
+// certAuth authenticate users by verified client certificates (subject common name)
+type certAuth struct {
+	users map[string]Role
+}
+
+// newCertAuth load certificates users from file with `common-name role` lines
+func newCertAuth(path string) (*certAuth, error) {
+	a := &certAuth{users: make(map[string]Role)}
+	err := readAuthFile(path, func(line string) error {
+		n := strings.LastIndexAny(line, " \t")
+		if n <= 0 {
+			return errors.New("invalid certificate user, must be `common-name role`")
+		}
+		role, err := ParseRole(line[n+1:])
+		if err != nil {
+			return err
+		}
+		a.users[strings.TrimSpace(line[:n])] = role
+		return nil
+	})
+	return a, err
+}
+
+func (a *certAuth) Authenticate(c *fiber.Ctx) (*User, error) {
+	state := c.Context().TLSConnectionState()
+	// only verified (with ClientCAFile) certificates
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil, nil
+	}
+	cert := state.VerifiedChains[0][0]
+	role, ok := a.users[cert.Subject.CommonName]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return &User{Name: cert.Subject.CommonName, Role: role}, nil
+}
@@ -0,0 +1,164 @@
+//go:build !test_integration
+// +build !test_integration
+
+package k6_stat
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert create certificate, signed by parent (self-signed CA if parent is nil)
+func newTestCert(t *testing.T, cn string, serial int64, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600))
+	if keyFile != "" {
+		der, err := x509.MarshalECPrivateKey(c.key)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+	}
+}
+
+func (c *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+func TestUnitAppMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "k6-stat CA", 1, nil)
+	caFile := filepath.Join(dir, "ca.pem")
+	ca.write(t, caFile, "")
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server.key")
+	newTestCert(t, "k6-stat", 2, ca).write(t, certFile, keyFile)
+	usersFile := filepath.Join(dir, "certs")
+	require.NoError(t, os.WriteFile(usersFile, []byte("ci write\n"), 0600))
+
+	app := newAuthApp(t, Config{
+		TLS:  TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientAuth: ClientAuthOptional},
+		Auth: AuthConfig{ClientCertsFile: usersFile},
+	})
+	ln, err := app.listener("127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = app.fiberApp.Listener(ln) }()
+	defer func() { _ = app.Shutdown() }()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	url := "https://" + ln.Addr().String() + "/api/cache"
+	tests := []struct {
+		name  string
+		certs []tls.Certificate
+		want  int
+	}{
+		{name: "no client cert", want: http.StatusUnauthorized},
+		{name: "ci", certs: []tls.Certificate{newTestCert(t, "ci", 3, ca).tlsCert()}, want: http.StatusNotImplemented},
+		{name: "unknown user", certs: []tls.Certificate{newTestCert(t, "unknown", 4, ca).tlsCert()}, want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: tt.certs, MinVersion: tls.VersionTLS12},
+			}}
+			resp, err := client.Get(url)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.want, resp.StatusCode)
+		})
+	}
+
+	// not signed by client CA, forced send (default client skip certificates, not matched with server acceptable CAs)
+	other := newTestCert(t, "other CA", 5, nil)
+	otherCert := newTestCert(t, "ci", 6, other).tlsCert()
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{
+			RootCAs: pool, MinVersion: tls.VersionTLS12,
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return &otherCert, nil },
+		},
+	}}
+	_, err = client.Get(url)
+	assert.Error(t, err)
+}
+
+func TestCertReloader(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	dir := t.TempDir()
+	ca := newTestCert(t, "k6-stat CA", 1, nil)
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server.key")
+	newTestCert(t, "k6-stat", 2, ca).write(t, certFile, keyFile)
+
+	r, err := newCertReloader(TLSConfig{CertFile: certFile, KeyFile: keyFile, ReloadInterval: time.Nanosecond}, &logger)
+	require.NoError(t, err)
+	serial := func() int64 {
+		cfg, err := r.getConfig(nil)
+		require.NoError(t, err)
+		cert, err := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+		require.NoError(t, err)
+		return cert.SerialNumber.Int64()
+	}
+	assert.Equal(t, int64(2), serial())
+
+	newTestCert(t, "k6-stat", 3, ca).write(t, certFile, keyFile)
+	modTime := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+	assert.Equal(t, int64(3), serial())
+
+	// invalid certificate keep previous
+	require.NoError(t, os.WriteFile(certFile, []byte("invalid"), 0600))
+	modTime = modTime.Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	assert.Equal(t, int64(3), serial())
+
+	_, err = newCertReloader(TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientAuth: "verify"}, &logger)
+	assert.ErrorIs(t, err, ErrInvalidClientAuth)
+	_, err = newCertReloader(TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientAuth: ClientAuthRequire}, &logger)
+	assert.Error(t, err)
+}
@@ -0,0 +1,381 @@
+// Package client is a typed k6-stat API client (see OpenAPI specification at /api/openapi.json)
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"github.com/msaf1980/k6-stat/dbs"
+	"github.com/msaf1980/k6-stat/utils/tlsconfig"
+)
+
+// DefaultTimeout is a default request timeout
+const DefaultTimeout = time.Minute
+
+// HeaderTotalCount is a response header with total count of matched items (for paginated requests)
+const HeaderTotalCount = "X-Total-Count"
+
+// Config is an optional Client settings
+type Config struct {
+	// Token is an API token (sent as bearer token)
+	Token string
+	// User and Password is a basic authentication credentials (used, if token is not set)
+	User     string
+	Password string
+	// TLS is a HTTPS client settings (server CA, client certificate)
+	TLS tlsconfig.Config
+	// Timeout is a request timeout (DefaultTimeout if zero, live view requests are not limited)
+	Timeout time.Duration
+}
+
+// Error is an API error response
+type Error struct {
+	// Status is a HTTP status
+	Status    int           `json:"-"`
+	Code      dbs.ErrorCode `json:"code"`
+	Message   string        `json:"message"`
+	RequestId string        `json:"request_id"`
+}
+
+func (e *Error) Error() string {
+	if e.RequestId == "" {
+		return fmt.Sprintf("%s (%d)", e.Message, e.Status)
+	}
+	return fmt.Sprintf("%s (%s, request id %s)", e.Message, e.Code, e.RequestId)
+}
+
+// VersionInfo is a server version
+type VersionInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+}
+
+// HistogramDiffFilter is a histogram diff request (reference use buckets and label/url filters from test)
+type HistogramDiffFilter struct {
+	Test dbs.HistogramFilter `json:"test"`
+	Ref  dbs.SampleFilter    `json:"ref"`
+}
+
+// TopFilter is a test top or compare request
+type TopFilter struct {
+	Label    string
+	Url      string
+	SkipUrl  []string
+	Stats    []string
+	Quantile string
+	// sort key (errors, count, rps or statistic name), p99 (or the first statistic) if empty
+	Sort string
+	// sort by diff with reference (for compare)
+	ByDiff bool
+	// top of N urls per label (all if zero)
+	Count int
+}
+
+func (f *TopFilter) query() url.Values {
+	q := url.Values{}
+	if f.Label != "" {
+		q.Set("label", f.Label)
+	}
+	if f.Url != "" {
+		q.Set("url", f.Url)
+	}
+	for _, u := range f.SkipUrl {
+		q.Add("no-url", u)
+	}
+	for _, stat := range f.Stats {
+		q.Add("stats", stat)
+	}
+	if f.Quantile != "" {
+		q.Set("quantile", f.Quantile)
+	}
+	if f.Sort != "" {
+		q.Set("sort", f.Sort)
+	}
+	if f.ByDiff {
+		q.Set("by-diff", "true")
+	}
+	if f.Count > 0 {
+		q.Set("count", strconv.Itoa(f.Count))
+	}
+	return q
+}
+
+// Client is a k6-stat API client
+type Client struct {
+	url        string
+	httpClient *http.Client
+	live       *http.Client
+	config     Config
+}
+
+// New create client for server url (like https://k6-stat.example.com)
+func New(serverURL string, config ...Config) (*Client, error) {
+	var cfg Config
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid server url %q, scheme must be http or https", serverURL)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid server url %q, host not set", serverURL)
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.TLS.Enabled() {
+		if u.Scheme != "https" {
+			return nil, fmt.Errorf("TLS settings with %s scheme in %q", u.Scheme, serverURL)
+		}
+		if transport.TLSClientConfig, err = cfg.TLS.ClientConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Client{
+		url:        strings.TrimRight(u.String(), "/"),
+		httpClient: &http.Client{Transport: transport, Timeout: cfg.Timeout},
+		live:       &http.Client{Transport: transport},
+		config:     cfg,
+	}, nil
+}
+
+// URL return server url
+func (c *Client) URL() string {
+	return c.url
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body any) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.Token)
+	} else if c.config.User != "" {
+		req.SetBasicAuth(c.config.User, c.config.Password)
+	}
+	return req, nil
+}
+
+// responseError decode API error response (or build error from status for non-API responses, like from proxy)
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	apiErr := &Error{Status: resp.StatusCode}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") && json.Unmarshal(body, apiErr) == nil && apiErr.Code != "" {
+		return apiErr
+	}
+	apiErr.Code = ""
+	apiErr.Message = strings.TrimSpace(string(body))
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
+
+// do send request (JSON-encoded body, if not nil) and decode JSON response to result (if not nil)
+func (c *Client) do(ctx context.Context, method, path string, body, result any) (http.Header, error) {
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return resp.Header, responseError(resp)
+	}
+	if result != nil {
+		if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return resp.Header, fmt.Errorf("%s %s: decode response: %w", method, path, err)
+		}
+	}
+	return resp.Header, nil
+}
+
+// Ready check server readiness (ClickHouse is available and tables exist)
+func (c *Client) Ready(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodGet, "/readyz", nil, nil)
+	return err
+}
+
+// Version return server version
+func (c *Client) Version(ctx context.Context) (VersionInfo, error) {
+	var v VersionInfo
+	_, err := c.do(ctx, http.MethodGet, "/version", nil, &v)
+	return v, err
+}
+
+// CacheStats return samples cache statistic
+func (c *Client) CacheStats(ctx context.Context) (dbs.CacheStats, error) {
+	var stats dbs.CacheStats
+	_, err := c.do(ctx, http.MethodGet, "/api/cache", nil, &stats)
+	return stats, err
+}
+
+// ClearCache clear samples cache (admin role required)
+func (c *Client) ClearCache(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodDelete, "/api/cache", nil, nil)
+	return err
+}
+
+// GetTests return tests and total matched tests count (differ from returned tests count for paginated requests)
+func (c *Client) GetTests(ctx context.Context, f dbs.TestFilter) ([]dbs.Test, uint64, error) {
+	var tests []dbs.Test
+	header, err := c.do(ctx, http.MethodPost, "/api/tests", f, &tests)
+	if err != nil {
+		return nil, 0, err
+	}
+	total := uint64(len(tests))
+	if v := header.Get(HeaderTotalCount); v != "" {
+		if total, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return nil, 0, fmt.Errorf("invalid %s header: %w", HeaderTotalCount, err)
+		}
+	}
+	return tests, total, nil
+}
+
+// AnnotateTest update test annotation (write role required)
+func (c *Client) AnnotateTest(ctx context.Context, p dbs.TestPatch) (*dbs.Annotation, error) {
+	var a dbs.Annotation
+	if _, err := c.do(ctx, http.MethodPatch, "/api/test", p, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// DeleteTest delete test with samples and annotation (admin role required)
+func (c *Client) DeleteTest(ctx context.Context, f dbs.TestIdFilter) error {
+	_, err := c.do(ctx, http.MethodDelete, "/api/test", f, nil)
+	return err
+}
+
+// testPath return test resource path (/api/tests/{id}/{start})
+func testPath(id uint64, start int64) string {
+	return "/api/tests/" + strconv.FormatUint(id, 10) + "/" + strconv.FormatInt(start, 10)
+}
+
+// GetTest return test by id and start (epoch nanoseconds)
+func (c *Client) GetTest(ctx context.Context, id uint64, start int64) (dbs.Test, error) {
+	var test dbs.Test
+	_, err := c.do(ctx, http.MethodGet, testPath(id, start), nil, &test)
+	return test, err
+}
+
+// GetHttpTestTop return test statistics, sorted (and limited to top of N) per label
+func (c *Client) GetHttpTestTop(ctx context.Context, id uint64, start int64, f TopFilter) (*dbs.TestSamples, error) {
+	var samples dbs.TestSamples
+	path := testPath(id, start) + "/http/top"
+	if q := f.query().Encode(); q != "" {
+		path += "?" + q
+	}
+	if _, err := c.do(ctx, http.MethodGet, path, nil, &samples); err != nil {
+		return nil, err
+	}
+	return &samples, nil
+}
+
+// Compare return test and reference statistics diff, sorted (and limited to top of N) per label
+func (c *Client) Compare(ctx context.Context, id uint64, start int64, refId uint64, refStart int64, f TopFilter) (*dbs.TestSamplesDiff, error) {
+	var diff dbs.TestSamplesDiff
+	path := "/api/compare/" + strconv.FormatUint(id, 10) + "/" + strconv.FormatInt(start, 10) + "/" +
+		strconv.FormatUint(refId, 10) + "/" + strconv.FormatInt(refStart, 10)
+	if q := f.query().Encode(); q != "" {
+		path += "?" + q
+	}
+	if _, err := c.do(ctx, http.MethodGet, path, nil, &diff); err != nil {
+		return nil, err
+	}
+	return &diff, nil
+}
+
+func (c *Client) GetHttpSamplesDurations(ctx context.Context, f dbs.SampleFilter) ([]dbs.SampleQuantiles, error) {
+	var samples []dbs.SampleQuantiles
+	_, err := c.do(ctx, http.MethodPost, "/api/test/http/duration", f, &samples)
+	return samples, err
+}
+
+func (c *Client) GetHttpSamplesStatus(ctx context.Context, f dbs.SampleFilter) ([]dbs.SampleStatus, error) {
+	var samples []dbs.SampleStatus
+	_, err := c.do(ctx, http.MethodPost, "/api/test/http/status", f, &samples)
+	return samples, err
+}
+
+// GetHttpTestSamples return test statistics (test is selected by f.Id and f.Start)
+func (c *Client) GetHttpTestSamples(ctx context.Context, f dbs.SampleFilter) (*dbs.TestSamples, error) {
+	var samples dbs.TestSamples
+	if _, err := c.do(ctx, http.MethodPost, "/api/test/http/samples", f, &samples); err != nil {
+		return nil, err
+	}
+	return &samples, nil
+}
+
+func (c *Client) GetChecks(ctx context.Context, f dbs.SampleFilter) ([]dbs.SampleCheck, error) {
+	var checks []dbs.SampleCheck
+	_, err := c.do(ctx, http.MethodPost, "/api/test/checks", f, &checks)
+	return checks, err
+}
+
+func (c *Client) GetTestRange(ctx context.Context, f dbs.SampleFilter) (dbs.TestRange, error) {
+	var r dbs.TestRange
+	_, err := c.do(ctx, http.MethodPost, "/api/test/range", f, &r)
+	return r, err
+}
+
+func (c *Client) GetSamplesRates(ctx context.Context, f dbs.SampleFilter) ([]dbs.SampleRate, error) {
+	var rates []dbs.SampleRate
+	_, err := c.do(ctx, http.MethodPost, "/api/test/rates", f, &rates)
+	return rates, err
+}
+
+func (c *Client) GetHttpSamplesHistogram(ctx context.Context, f dbs.HistogramFilter) (*dbs.SamplesHistogram, error) {
+	var hist dbs.SamplesHistogram
+	if _, err := c.do(ctx, http.MethodPost, "/api/test/http/histogram", f, &hist); err != nil {
+		return nil, err
+	}
+	return &hist, nil
+}
+
+func (c *Client) GetHttpSamplesHistogramDiff(ctx context.Context, f HistogramDiffFilter) (*dbs.SamplesHistogramDiff, error) {
+	var diff dbs.SamplesHistogramDiff
+	if _, err := c.do(ctx, http.MethodPost, "/api/test/http/histogram/diff", f, &diff); err != nil {
+		return nil, err
+	}
+	return &diff, nil
+}
+
+func (c *Client) GetHttpSamplesHeatmap(ctx context.Context, f dbs.HistogramFilter) (*dbs.SamplesHeatmap, error) {
+	var heatmap dbs.SamplesHeatmap
+	if _, err := c.do(ctx, http.MethodPost, "/api/test/http/heatmap", f, &heatmap); err != nil {
+		return nil, err
+	}
+	return &heatmap, nil
+}
@@ -0,0 +1,197 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/msaf1980/k6-stat/dbs"
+)
+
+func TestNew(t *testing.T) {
+	c, err := New("https://k6-stat.example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, "https://k6-stat.example.com", c.URL())
+
+	_, err = New("k6-stat.example.com:8080")
+	assert.Error(t, err)
+	_, err = New("http://")
+	assert.Error(t, err)
+	cfg := Config{}
+	cfg.TLS.SkipVerify = true
+	_, err = New("http://k6-stat.example.com", cfg)
+	assert.Error(t, err)
+}
+
+func TestClient(t *testing.T) {
+	ts := time.Unix(1674196900, 0).UTC()
+	test := dbs.Test{Id: 1, Ts: ts, Name: "graphite_nightly", Params: "USERS=1", ParamsMap: map[string]string{"USERS": "1"}}
+
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, fmt.Sprintf("%s %s %s %s", r.Method, r.URL.Path, r.Header.Get("Authorization"), body))
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/tests":
+			w.Header().Set(HeaderTotalCount, "10")
+			_ = json.NewEncoder(w).Encode([]dbs.Test{test})
+		case "/api/test/http/samples":
+			_ = json.NewEncoder(w).Encode(dbs.TestSamples{Test: test, Stats: []string{"p99"}})
+		case "/api/test":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"code":"not_found","message":"test not found","request_id":"3"}`))
+		case "/api/cache":
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, Config{Token: "secret"})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	tests, total, err := c.GetTests(ctx, dbs.TestFilter{Name: "graphite", Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []dbs.Test{test}, tests)
+	assert.Equal(t, uint64(10), total)
+
+	samples, err := c.GetHttpTestSamples(ctx, dbs.SampleFilter{Id: 1, Start: ts.UnixNano()})
+	require.NoError(t, err)
+	assert.Equal(t, test, samples.Test)
+	assert.Equal(t, []string{"p99"}, samples.Stats)
+
+	err = c.DeleteTest(ctx, dbs.TestIdFilter{Id: 2, Time: 3})
+	var apiErr *Error
+	require.True(t, errors.As(err, &apiErr), err)
+	assert.Equal(t, &Error{Status: http.StatusNotFound, Code: dbs.ErrCodeNotFound, Message: "test not found", RequestId: "3"}, apiErr)
+	assert.Equal(t, "test not found (not_found, request id 3)", err.Error())
+
+	// not API error (from proxy)
+	_, err = c.CacheStats(ctx)
+	require.True(t, errors.As(err, &apiErr), err)
+	assert.Equal(t, &Error{Status: http.StatusBadGateway, Message: "Bad Gateway"}, apiErr)
+
+	assert.Equal(t, []string{
+		`POST /api/tests Bearer secret {"from":0,"until":0,"name_prefix":"graphite","limit":1}`,
+		fmt.Sprintf(`POST /api/test/http/samples Bearer secret {"id":1,"start":%d}`, ts.UnixNano()),
+		`DELETE /api/test Bearer secret {"id":2,"time":3}`,
+		`GET /api/cache Bearer secret `,
+	}, requests)
+}
+
+func TestClientLive(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		if user != "ci" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		q := r.URL.Query()
+		w.Header().Set("Content-Type", "text/event-stream")
+		switch q.Get("id") {
+		case "1":
+			assert.Equal(t, []string{"p50", "p99"}, q["stats"])
+			assert.Equal(t, "2", q.Get("ref-id"))
+			_, _ = w.Write([]byte("event: samples\ndata: {\"from\":\"2023-01-20T06:41:40Z\",\"until\":\"2023-01-20T06:41:50Z\",\"samples\":null}\n\n"))
+			_, _ = w.Write([]byte("event: samples\ndata: {\"from\":\"2023-01-20T06:41:50Z\",\"until\":\"2023-01-20T06:42:00Z\",\"samples\":null,\"finished\":true}\n\n"))
+			_, _ = w.Write([]byte("event: end\ndata: test finished\n\n"))
+		case "2":
+			_, _ = w.Write([]byte("event: error\ndata: query timeout\n\n"))
+		default:
+			// interrupted
+			_, _ = w.Write([]byte("event: samples\ndata: {\"from\":\"2023-01-20T06:41:40Z\",\"until\":\"2023-01-20T06:41:50Z\"}\n\n"))
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, Config{User: "ci", Password: "secret"})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	var lives []*dbs.LiveSamples
+	collect := func(live *dbs.LiveSamples) error {
+		lives = append(lives, live)
+		return nil
+	}
+	require.NoError(t, c.Live(ctx, LiveFilter{Id: 1, Start: 1, RefId: 2, RefStart: 2, Stats: []string{"p50", "p99"}}, collect))
+	require.Len(t, lives, 2)
+	assert.False(t, lives[0].Finished)
+	assert.True(t, lives[1].Finished)
+
+	assert.EqualError(t, c.Live(ctx, LiveFilter{Id: 2}, collect), "live view: query timeout")
+	assert.ErrorIs(t, c.Live(ctx, LiveFilter{Id: 3}, collect), ErrLiveInterrupted)
+
+	c, err = New(srv.URL)
+	require.NoError(t, err)
+	err = c.Live(ctx, LiveFilter{Id: 1}, collect)
+	var apiErr *Error
+	require.True(t, errors.As(err, &apiErr), err)
+	assert.Equal(t, http.StatusUnauthorized, apiErr.Status)
+}
+
+func TestClientResources(t *testing.T) {
+	ts := time.Unix(1674196900, 0).UTC()
+	test := dbs.Test{Id: 1, Ts: ts, Name: "graphite_nightly"}
+	ref := dbs.Test{Id: 2, Ts: ts.Add(-time.Hour), Name: "graphite_nightly"}
+
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case fmt.Sprintf("/api/tests/1/%d", ts.UnixNano()):
+			_ = json.NewEncoder(w).Encode(test)
+		case fmt.Sprintf("/api/tests/1/%d/http/top", ts.UnixNano()):
+			_ = json.NewEncoder(w).Encode(dbs.TestSamples{Test: test, Stats: []string{"p99"}})
+		case fmt.Sprintf("/api/compare/1/%d/2/%d", ts.UnixNano(), ref.Ts.UnixNano()):
+			_ = json.NewEncoder(w).Encode(dbs.TestSamplesDiff{Test: test, Reference: ref, Stats: []string{"p99"}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"code":"not_found","message":"test not found","request_id":"1"}`))
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	got, err := c.GetTest(ctx, 1, ts.UnixNano())
+	require.NoError(t, err)
+	assert.Equal(t, test, got)
+
+	_, err = c.GetTest(ctx, 3, ts.UnixNano())
+	var apiErr *Error
+	require.True(t, errors.As(err, &apiErr), err)
+	assert.Equal(t, dbs.ErrCodeNotFound, apiErr.Code)
+
+	samples, err := c.GetHttpTestTop(ctx, 1, ts.UnixNano(), TopFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, test, samples.Test)
+
+	diff, err := c.Compare(ctx, 1, ts.UnixNano(), 2, ref.Ts.UnixNano(),
+		TopFilter{Label: "render", Stats: []string{"p50", "p99"}, Sort: "p99", ByDiff: true, Count: 5})
+	require.NoError(t, err)
+	assert.Equal(t, ref, diff.Reference)
+
+	assert.Equal(t, []string{
+		fmt.Sprintf("GET /api/tests/1/%d", ts.UnixNano()),
+		fmt.Sprintf("GET /api/tests/3/%d", ts.UnixNano()),
+		fmt.Sprintf("GET /api/tests/1/%d/http/top", ts.UnixNano()),
+		fmt.Sprintf("GET /api/compare/1/%d/2/%d?by-diff=true&count=5&label=render&sort=p99&stats=p50&stats=p99",
+			ts.UnixNano(), ref.Ts.UnixNano()),
+	}, requests)
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+
+	"github.com/msaf1980/k6-stat/dbs"
+)
+
+// ErrLiveInterrupted is returned, if live view stream is closed before test finished
+var ErrLiveInterrupted = errors.New("live view stream interrupted")
+
+// LiveFilter is a live view request
+type LiveFilter struct {
+	Id       uint64
+	Start    int64
+	RefId    uint64
+	RefStart int64
+	Label    string
+	Url      string
+	SkipUrl  []string
+	Stats    []string
+	Quantile string
+	// refresh interval in seconds (dbs.DefaultLiveInterval if zero)
+	Interval int64
+	// regression threshold in percents (dbs.DefaultRegressionThreshold if zero)
+	Threshold float64
+	// interval end lag in seconds (dbs.LiveLag if zero)
+	Lag int64
+	// aggregate samples from test start (not only new samples since previous interval)
+	Cumulative bool
+}
+
+func (f *LiveFilter) query() url.Values {
+	q := url.Values{}
+	q.Set("id", strconv.FormatUint(f.Id, 10))
+	q.Set("start", strconv.FormatInt(f.Start, 10))
+	if f.RefId > 0 {
+		q.Set("ref-id", strconv.FormatUint(f.RefId, 10))
+		q.Set("ref-start", strconv.FormatInt(f.RefStart, 10))
+	}
+	if f.Label != "" {
+		q.Set("label", f.Label)
+	}
+	if f.Url != "" {
+		q.Set("url", f.Url)
+	}
+	for _, u := range f.SkipUrl {
+		q.Add("no-url", u)
+	}
+	for _, stat := range f.Stats {
+		q.Add("stats", stat)
+	}
+	if f.Quantile != "" {
+		q.Set("quantile", f.Quantile)
+	}
+	if f.Interval > 0 {
+		q.Set("interval", strconv.FormatInt(f.Interval, 10))
+	}
+	if f.Threshold > 0 {
+		q.Set("threshold", strconv.FormatFloat(f.Threshold, 'f', -1, 64))
+	}
+	if f.Lag > 0 {
+		q.Set("lag", strconv.FormatInt(f.Lag, 10))
+	}
+	if f.Cumulative {
+		q.Set("cumulative", "true")
+	}
+	return q
+}
+
+// Live stream running test live view, fn is called for every interval aggregates.
+// Return nil after test finished, fn error or stream error event.
+func (c *Client) Live(ctx context.Context, f LiveFilter, fn func(*dbs.LiveSamples) error) error {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/test/live?"+f.query().Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.live.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return responseError(resp)
+	}
+
+	var event string
+	var data strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" {
+			if strings.HasPrefix(line, "event: ") {
+				event = line[len("event: "):]
+			} else if strings.HasPrefix(line, "data: ") {
+				if data.Len() > 0 {
+					data.WriteByte('\n')
+				}
+				data.WriteString(line[len("data: "):])
+			}
+			continue
+		}
+		// end of event
+		switch event {
+		case "samples":
+			var live dbs.LiveSamples
+			if err = json.Unmarshal([]byte(data.String()), &live); err != nil {
+				return err
+			}
+			if err = fn(&live); err != nil {
+				return err
+			}
+		case "error":
+			return fmt.Errorf("live view: %s", data.String())
+		case "end":
+			return nil
+		}
+		event = ""
+		data.Reset()
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	return ErrLiveInterrupted
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/msaf1980/k6-stat/config"
+)
+
+// argValue lookup option value (--name value, --name=value or -s value) before args parse
+func argValue(args []string, name, short string) (string, bool) {
+	for i, arg := range args {
+		if arg == "--"+name || (short != "" && arg == "-"+short) {
+			if i+1 < len(args) {
+				return args[i+1], true
+			}
+			return "", false
+		}
+		if v, ok := cutPrefix(arg, "--"+name+"="); ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func cutPrefix(s, prefix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+// configPath return config file path from args or K6_STAT_CONFIG env
+func configPath(args []string) string {
+	if path, ok := argValue(args, "config", "c"); ok {
+		return path
+	}
+	return os.Getenv("K6_STAT_CONFIG")
+}
+
+// loadConfig read config file and select ClickHouse profile (flags override env).
+// Config values are used as flags defaults, so precedence is config < env < flags.
+// Config is not validated here, validate it with ValidateClient after flags parse.
+func loadConfig(args []string) (*config.Config, *config.Profile, error) {
+	cfg, err := config.Load(configPath(args))
+	if err != nil {
+		return nil, nil, err
+	}
+	if name, ok := argValue(args, "profile", "P"); ok {
+		cfg.Profile = name
+	} else if name := os.Getenv("K6_STAT_PROFILE"); name != "" {
+		cfg.Profile = name
+	}
+	profile, err := cfg.SelectedProfile()
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, profile, nil
+}
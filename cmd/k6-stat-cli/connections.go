@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/msaf1980/k6-stat/client"
+	"github.com/msaf1980/k6-stat/config"
+	"github.com/msaf1980/k6-stat/dbs"
+)
+
+// remoteConnName is a primary connection name in remote mode (with --server)
+const remoteConnName = "server"
+
+// connection is a named ClickHouse or k6-stat server (remote mode) connection
+type connection struct {
+	name    string
+	profile config.Profile
+	// server is a k6-stat server url (empty for ClickHouse connection)
+	server string
+	src    source
+}
+
+// connections is a named connections, opened in CLI session (the first one is a primary, opened on start)
+type connections struct {
+	primary string
+	conns   map[string]*connection
+	cache   config.Cache
+	// remote is a k6-stat server client settings (for remote mode connections)
+	remote client.Config
+}
+
+// openDB open ClickHouse connection with profile settings
+func openDB(profile *config.Profile, cache config.Cache) (*dbs.DB, error) {
+	var tlsConfig *tls.Config
+	if dbTLS := profile.TLSConfig(); dbTLS.Enabled() {
+		var err error
+		if tlsConfig, err = dbTLS.ClientConfig(); err != nil {
+			return nil, err
+		}
+	}
+	d, err := dbs.Open(profile.DSN(), tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	d.SetMaxIdleConns(1)
+	d.SetMaxOpenConns(3)
+	d.SetConnMaxIdleTime(time.Hour)
+	db := dbs.New(d, profile.Tables.Tests, profile.Tables.Samples)
+	db.SetTableAnnotations(profile.Tables.Annotations)
+	db.SetTableRollup(profile.Tables.Rollup)
+	db.SetCluster(profile.Tables.Cluster)
+	if cache.Size > 0 {
+		c, err := dbs.NewCache(int64(cache.Size)*1024*1024, cache.Dir)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		db.SetCache(c, time.Duration(cache.RunningTimeout))
+		db.SetCacheScope(profile.Source())
+	}
+	return db, nil
+}
+
+func newConnections(cache config.Cache, remote client.Config) *connections {
+	return &connections{conns: make(map[string]*connection), cache: cache, remote: remote}
+}
+
+// add connection (the first one is a primary)
+func (c *connections) add(conn *connection) {
+	if c.primary == "" {
+		c.primary = conn.name
+	}
+	c.conns[conn.name] = conn
+}
+
+// get return connection by name (primary connection for empty name)
+func (c *connections) get(name string) (*connection, error) {
+	if name == "" {
+		name = c.primary
+	}
+	conn, ok := c.conns[name]
+	if !ok {
+		return nil, fmt.Errorf("connection %q not found, open it with 'connect' command", name)
+	}
+	return conn, nil
+}
+
+// connect open new named ClickHouse connection
+func (c *connections) connect(name string, profile *config.Profile) (*connection, error) {
+	if _, ok := c.conns[name]; ok {
+		return nil, fmt.Errorf("connection %q already opened", name)
+	}
+	cache := c.cache
+	if cache.Dir != "" && c.primary != "" {
+		// the same test ids are possible in different databases
+		cache.Dir = filepath.Join(cache.Dir, name)
+	}
+	db, err := openDB(profile, cache)
+	if err != nil {
+		return nil, err
+	}
+	conn := &connection{name: name, profile: *profile, src: dbSource{db}}
+	c.add(conn)
+	return conn, nil
+}
+
+// connectServer open new named k6-stat server connection
+func (c *connections) connectServer(name, server string) (*connection, error) {
+	if _, ok := c.conns[name]; ok {
+		return nil, fmt.Errorf("connection %q already opened", name)
+	}
+	src, err := newRemoteSource(server, c.remote, time.Duration(c.cache.RunningTimeout))
+	if err != nil {
+		return nil, err
+	}
+	conn := &connection{name: name, server: src.c.URL(), src: src}
+	c.add(conn)
+	return conn, nil
+}
+
+// close named connection (primary connection can't be closed)
+func (c *connections) close(name string) error {
+	if name == c.primary {
+		return fmt.Errorf("primary connection %q can't be closed", name)
+	}
+	conn, err := c.get(name)
+	if err != nil {
+		return err
+	}
+	delete(c.conns, name)
+	return conn.src.Close()
+}
+
+func (c *connections) closeAll() {
+	for _, conn := range c.conns {
+		conn.src.Close()
+	}
+}
+
+func printConnections(w io.Writer, c *connections) {
+	names := make([]string, 0, len(c.conns))
+	for name := range c.conns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		conn := c.conns[name]
+		primary := ""
+		if name == c.primary {
+			primary = " [primary]"
+		}
+		if conn.server != "" {
+			fmt.Fprintf(w, "%s%s: %s (k6-stat server)\n", name, primary, conn.server)
+			continue
+		}
+		p := conn.profile.Redacted()
+		fmt.Fprintf(w, "%s%s: %s/%s (%s, %s)\n", name, primary, p.Address, p.Database, p.Tables.Tests, p.Tables.Samples)
+	}
+}
+
+// sourceOf return connection data source (primary for nil connection)
+func (c *connections) sourceOf(conn *connection) source {
+	if conn == nil {
+		return c.conns[c.primary].src
+	}
+	return conn.src
+}
+
+// testConnection return connection for select test by id (named or primary) or by number from loaded tests (loaded tests connection)
+func (c *connections) testConnection(name string, byId bool, testsConn *connection) (*connection, error) {
+	if byId || testsConn == nil {
+		return c.get(name)
+	}
+	if name != "" && name != testsConn.name {
+		return nil, fmt.Errorf("tests are loaded from connection %q, select test from connection %q by id", testsConn.name, name)
+	}
+	return testsConn, nil
+}
+
+// connectProfileSettings return new connection settings: config profile (connection name, if profile is not set) with overrides.
+// Without config profile the primary connection settings are used (address override is required).
+func connectProfileSettings(cfg *config.Config, primary *config.Profile, name, profileName, address, database, params string) (*config.Profile, error) {
+	var p config.Profile
+	if profileName == "" {
+		profileName = name
+	}
+	if base := cfg.Profiles[profileName]; base != nil {
+		p = *base
+	} else if profileName == name && address != "" {
+		p = *primary
+	} else {
+		return nil, fmt.Errorf("profile %q not found, available: %s", profileName, strings.Join(cfg.ProfileNames(), ", "))
+	}
+	if address != "" {
+		p.Address = address
+	}
+	if database != "" {
+		p.Database = database
+	}
+	if params != "" {
+		p.Params = params
+	}
+	if err := config.ValidateProfile(profileName, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
@@ -1,31 +1,46 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
 	"github.com/msaf1980/go-clipper"
 	"github.com/peterh/liner"
 
+	"github.com/msaf1980/k6-stat/client"
 	"github.com/msaf1980/k6-stat/dbs"
 )
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
+// BuildVersion is set with ldflags on build
+var BuildVersion = "(development build)"
+
 var (
-	db *dbs.DB
+	db source
 
-	testsHead   = headLine(9 + 19 + 30 + 45 + 18)
-	topHead     = headLine(9*8 + 16)
-	topDiffHead = headLine(20*8 + 14)
+	testsHead      = headLine(9 + 19 + 30 + 45 + 18)
+	checksHead     = headLine(9*3 + 50)
+	checksDiffHead = headLine(23*3 + 10 + 50)
 )
 
+// printQueryError print query error (with query, if set, remote mode errors has no query)
+func printQueryError(w io.Writer, err *dbs.QueryError) {
+	if err.Query() == "" {
+		fmt.Fprintf(w, "Error: %s\n", err.Error())
+	} else {
+		fmt.Fprintf(w, "Error: %s, sql: %s\n", err.Error(), err.Query())
+	}
+}
+
 func headLine(n int) string {
 	out := make([]byte, 0, n)
 	for i := 0; i < n; i++ {
@@ -46,7 +61,42 @@ func printTests(w io.Writer, tests []dbs.Test) {
 	fmt.Fprintln(w, testsHead)
 	for i, t := range tests {
 		fmt.Fprintf(w, "%9d | %19d | %30s | %s\n%s\n", i, t.Id, t.Ts.Format(time.RFC3339Nano), t.Name, t.Params)
+		if a := t.Annotation; a != nil {
+			printAnnotation(w, a)
+		}
+	}
+}
+
+func printAnnotation(w io.Writer, a *dbs.Annotation) {
+	if a.Hidden {
+		fmt.Fprint(w, "  [hidden]")
+	}
+	if a.Name != "" {
+		fmt.Fprintf(w, "  Name: %s", a.Name)
+	}
+	if len(a.Labels) > 0 {
+		fmt.Fprintf(w, "  Labels: %s", strings.Join(a.Labels, ","))
+	}
+	if a.Text != "" {
+		fmt.Fprintf(w, "  Note: %s", a.Text)
+	}
+	fmt.Fprintln(w)
+}
+
+// countUrls return loaded urls count (for all labels)
+func countUrls(samples *dbs.TestSamples) (n int) {
+	for _, durations := range samples.Samples {
+		n += len(durations)
 	}
+	return
+}
+
+func printCacheStats(w io.Writer, stats dbs.CacheStats) {
+	fmt.Fprintf(w, "Items: %d, size: %.1f/%.1f MB\n", stats.Items, float64(stats.Size)/1024/1024, float64(stats.MaxSize)/1024/1024)
+	if stats.Dir != "" {
+		fmt.Fprintf(w, "Dir: %s\n", stats.Dir)
+	}
+	fmt.Fprintf(w, "Hits: %d (disk: %d), misses: %d, running tests bypass: %d\n", stats.Hits, stats.DiskHits, stats.Misses, stats.Bypass)
 }
 
 func printTest(w io.Writer, tests []dbs.Test, n int, descr string, head bool) (err error) {
@@ -66,27 +116,41 @@ func printTest(w io.Writer, tests []dbs.Test, n int, descr string, head bool) (e
 	return
 }
 
-func printHttpTop(w io.Writer, samplesDurations map[string][]dbs.SampleDurations, topNum int) (err error) {
+func printHttpTop(w io.Writer, stats []string, samplesDurations map[string][]dbs.SampleDurations, topNum int) (err error) {
 	labels := make([]string, 0, len(samplesDurations))
 	for k := range samplesDurations {
 		labels = append(labels, k)
 	}
 	sort.Strings(labels)
 
+	topHead := headLine(12*len(stats) + 9*2 + 6 + 19)
+
 	for _, label := range labels {
 		durations := samplesDurations[label]
 		if _, err = fmt.Fprintf(w, "\nLabel: %q, %d urls\n%s\n", label, len(durations), topHead); err != nil {
 			return
 		}
-		if _, err = fmt.Fprintf(w, "%9s | %9s | %9s | %9s | %9s | %9s | %6s | %s\n%s\n",
-			"P50", "P90", "P95", "P99", "Max", "Count", "Err%", "Status%", topHead); err != nil {
+		for _, stat := range stats {
+			if _, err = fmt.Fprintf(w, "%9s | ", dbs.StatTitle(stat)); err != nil {
+				return
+			}
+		}
+		if _, err = fmt.Fprintf(w, "%9s | %9s | %6s | %s\n%s\n",
+			"Count", "RPS", "Err%", "Status%", topHead); err != nil {
 			return
 		}
 		n := min(topNum, len(durations))
 		for i := 0; i < n; i++ {
 			d := durations[i]
-			if _, err = fmt.Fprintf(w, "%s\n%9.2f | %9.2f | %9.2f | %9.2f | %9.2f | %9.0f | %6.2f",
-				d.Url, d.P50, d.P90, d.P95, d.P99, d.Max, d.Count, d.ErrorsPcnt); err != nil {
+			if _, err = fmt.Fprintln(w, d.Url); err != nil {
+				return
+			}
+			for _, stat := range stats {
+				if _, err = fmt.Fprintf(w, "%9.2f | ", d.Stats[stat]); err != nil {
+					return
+				}
+			}
+			if _, err = fmt.Fprintf(w, "%9.0f | %9.2f | %6.2f", d.Count, d.RPS, d.ErrorsPcnt); err != nil {
 				return
 			}
 			if len(d.Status) > 0 {
@@ -136,32 +200,44 @@ func diffString(v, vDiff float64) string {
 	return fmt.Sprintf("%.2f (%.2f)", v, vDiff)
 }
 
-func printHttpTopDiff(w io.Writer, samplesDurations map[string][]dbs.SampleDurationsDiff, topNum int) (err error) {
+func printHttpTopDiff(w io.Writer, stats []string, samplesDurations map[string][]dbs.SampleDurationsDiff, topNum int) (err error) {
 	labels := make([]string, 0, len(samplesDurations))
 	for k := range samplesDurations {
 		labels = append(labels, k)
 	}
 	sort.Strings(labels)
 
+	topDiffHead := headLine(23*len(stats) + 23*2 + 14 + 19)
+
 	for _, label := range labels {
 		durations := samplesDurations[label]
 		if _, err = fmt.Fprintf(w, "\nLabel: %q, %d urls\n%s\n", label, len(durations), topDiffHead); err != nil {
 			return
 		}
-		if _, err = fmt.Fprintf(w, "%20s | %20s | %20s | %20s | %20s | %20s | %14s | %s\n%s\n",
-			"Url P50 (Diff)", "P90 (Diff)", "P95 (Diff)", "P99 (Diff)", "Max (Diff)",
-			"Count (Diff)", "Err% (Diff)", "Status% (Reference)", topDiffHead,
+		for _, stat := range stats {
+			if _, err = fmt.Fprintf(w, "%20s | ", dbs.StatTitle(stat)+" (Diff)"); err != nil {
+				return
+			}
+		}
+		if _, err = fmt.Fprintf(w, "%20s | %20s | %14s | %s\n%s\n",
+			"Count (Diff)", "RPS (Diff)", "Err% (Diff)", "Status% (Reference)", topDiffHead,
 		); err != nil {
 			return
 		}
 		n := min(topNum, len(durations))
 		for i := 0; i < n; i++ {
 			d := durations[i]
-			if _, err = fmt.Fprintf(w, "%s\n%20s | %20s | %20s | %20s | %20s | %20s | %14s",
-				d.Url, diffString(d.P50, d.P50Diff), diffString(d.P90, d.P90Diff),
-				diffString(d.P95, d.P95Diff), diffString(d.P99, d.P99Diff),
-				diffString(d.Max, d.MaxDiff),
-				countDiffString(d.Count, d.CountDiff), diffString(d.ErrorsPcnt, d.ErrorsPcntDiff),
+			if _, err = fmt.Fprintln(w, d.Url); err != nil {
+				return
+			}
+			for _, stat := range stats {
+				if _, err = fmt.Fprintf(w, "%20s | ", diffString(d.Stats[stat], d.StatsDiff[stat])); err != nil {
+					return
+				}
+			}
+			if _, err = fmt.Fprintf(w, "%20s | %20s | %14s",
+				countDiffString(d.Count, d.CountDiff), diffString(d.RPS, d.RPSDiff),
+				diffString(d.ErrorsPcnt, d.ErrorsPcntDiff),
 			); err != nil {
 				return
 			}
@@ -210,6 +286,190 @@ func printHttpTopDiff(w io.Writer, samplesDurations map[string][]dbs.SampleDurat
 	return
 }
 
+func printChecks(w io.Writer, checks []dbs.SampleCheck) (err error) {
+	if _, err = fmt.Fprintf(w, "\nChecks: %d\n%s\n", len(checks), checksHead); err != nil {
+		return
+	}
+	if _, err = fmt.Fprintf(w, "%9s | %9s | %9s | %s\n%s\n", "Pass%", "Passes", "Count", "Label / Group / Check", checksHead); err != nil {
+		return
+	}
+	for _, c := range checks {
+		if _, err = fmt.Fprintf(w, "%9.2f | %9.0f | %9.0f | %s / %s / %s\n",
+			c.PassPcnt, c.Passes, c.Count, c.Label, c.Group, c.Check); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func printChecksDiff(w io.Writer, checks []dbs.SampleCheckDiff) (err error) {
+	if _, err = fmt.Fprintf(w, "\nChecks: %d, dropped: %d\n%s\n", len(checks), len(dbs.ChecksDropped(checks)), checksDiffHead); err != nil {
+		return
+	}
+	if _, err = fmt.Fprintf(w, "%20s | %20s | %20s | %7s | %s\n%s\n",
+		"Pass% (Diff)", "Passes (Diff)", "Count (Diff)", "Verdict", "Label / Group / Check", checksDiffHead); err != nil {
+		return
+	}
+	for _, c := range checks {
+		verdict := ""
+		if c.Missing {
+			verdict = "MISSING"
+		} else if c.Dropped {
+			verdict = "DROPPED"
+		} else if c.New {
+			verdict = "NEW"
+		}
+		if _, err = fmt.Fprintf(w, "%20s | %20s | %20s | %7s | %s / %s / %s\n",
+			diffString(c.PassPcnt, c.PassPcntDiff), countDiffString(c.Passes, c.PassesDiff),
+			countDiffString(c.Count, c.CountDiff), verdict, c.Label, c.Group, c.Check); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func histBar(pcnt float64, width int) string {
+	n := int(pcnt/100*float64(width) + 0.5)
+	if n == 0 && pcnt > 0 {
+		n = 1
+	}
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = '#'
+	}
+	return string(out)
+}
+
+func printHistogram(w io.Writer, hist *dbs.SamplesHistogram, width int) (err error) {
+	for _, h := range hist.Histograms {
+		if _, err = fmt.Fprintf(w, "\nLabel: %q, url: %q, count %.0f\n", h.Label, h.Url, h.Count); err != nil {
+			return
+		}
+		for i, n := range h.Counts {
+			var pcnt float64
+			if h.Count > 0 {
+				pcnt = n / h.Count * 100
+			}
+			if _, err = fmt.Fprintf(w, "%12s | %9.0f | %6.2f | %s\n",
+				dbs.BucketTitle(hist.Buckets, i), n, pcnt, histBar(pcnt, width)); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func printHistogramDiff(w io.Writer, diff *dbs.SamplesHistogramDiff, width int) (err error) {
+	for _, h := range diff.Histograms {
+		if _, err = fmt.Fprintf(w, "\nLabel: %q, url: %q, count %.0f, ref count %.0f\n", h.Label, h.Url, h.Count, h.RefCount); err != nil {
+			return
+		}
+		for i := range h.Counts {
+			if _, err = fmt.Fprintf(w, "%12s | %20s | test %s\n%12s | %20s | ref  %s\n",
+				dbs.BucketTitle(diff.Buckets, i), diffString(h.Pcnt[i], h.PcntDiff[i]), histBar(h.Pcnt[i], width),
+				"", fmt.Sprintf("%.2f", h.RefPcnt[i]), histBar(h.RefPcnt[i], width)); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func printParamsDiff(w io.Writer, params []dbs.ParamDiff) (err error) {
+	if len(params) == 0 {
+		return
+	}
+	if _, err = fmt.Fprintln(w, "Params diff:"); err != nil {
+		return
+	}
+	for _, p := range params {
+		if p.New {
+			_, err = fmt.Fprintf(w, "  + %s=%s\n", p.Key, p.Value)
+		} else if p.Dropped {
+			_, err = fmt.Fprintf(w, "  - %s=%s\n", p.Key, p.RefValue)
+		} else {
+			_, err = fmt.Fprintf(w, "  ~ %s=%s (ref %s)\n", p.Key, p.Value, p.RefValue)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func printRegressions(w io.Writer, regressions []dbs.Regression, threshold float64) (err error) {
+	if len(regressions) == 0 {
+		return
+	}
+	if _, err = fmt.Fprintf(w, "\nRegressions (threshold %.2f%%):\n", threshold); err != nil {
+		return
+	}
+	for _, r := range regressions {
+		switch r.Stat {
+		case dbs.StatErrors:
+			_, err = fmt.Fprintf(w, "! %q %s: errors %.2f%% (ref %.2f%%, %+.2f)\n", r.Label, r.Url, r.Value, r.RefValue, r.DiffPcnt)
+		case dbs.StatChecks:
+			_, err = fmt.Fprintf(w, "! %q %q %s: pass %.2f%% (ref %.2f%%, %+.2f)\n", r.Label, r.Group, r.Check, r.Value, r.RefValue, r.DiffPcnt)
+		default:
+			_, err = fmt.Fprintf(w, "! %q %s: %s %.2f (ref %.2f, %+.2f%%)\n", r.Label, r.Url, dbs.StatTitle(r.Stat), r.Value, r.RefValue, r.DiffPcnt)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// watchTest print running test top (compared with reference, if not nil) for every interval, until test finished or interrupted
+func watchTest(db source, test dbs.Test, ref *dbs.TestSamples, filter dbs.SampleFilter, interval time.Duration, count int, sortBy dbs.SortBy, threshold float64) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	from := test.Ts
+	for {
+		until := time.Now().Add(-dbs.LiveLag).Truncate(time.Second)
+		if until.After(from) {
+			live, dbErr := db.GetHttpLiveSamples(ctx, test, ref, filter, from, until, threshold)
+			if dbErr != nil {
+				printQueryError(os.Stderr, dbErr)
+				return
+			}
+			// clear screen
+			fmt.Print("\033[H\033[2J")
+			_ = printTest(os.Stdout, []dbs.Test{test}, 0, "test", true)
+			if ref != nil {
+				_ = printTest(os.Stdout, []dbs.Test{ref.Test}, 0, "ref", false)
+			}
+			fmt.Printf("Interval: %s - %s (Ctrl-C for stop)\n", live.From.Format(time.RFC3339), live.Until.Format(time.RFC3339))
+			if live.Diff == nil {
+				for _, d := range live.Samples.Samples {
+					dbs.SortSamplesDurations(d, sortBy)
+				}
+				_ = printHttpTop(os.Stdout, live.Samples.Stats, live.Samples.Samples, count)
+			} else {
+				for _, d := range live.Diff.Samples {
+					dbs.SortSamplesDurationsDiff(d, sortBy)
+				}
+				_ = printHttpTopDiff(os.Stdout, live.Diff.Stats, live.Diff.Samples, count)
+				_ = printRegressions(os.Stdout, live.Regressions, threshold)
+			}
+			if live.Finished {
+				fmt.Println("Test finished")
+				return
+			}
+			from = until
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func saveTestSamples(test *dbs.TestSamples, path string) error {
 	if b, err := json.Marshal(test); err != nil {
 		return err
@@ -238,8 +498,7 @@ func main() {
 		dbErr *dbs.QueryError
 
 		// registry attached vars
-		chAddress, chPparam, chDB string
-		tableTests, tableSamples  string
+		stats []string
 
 		testsFrom  time.Time
 		testsUntil time.Time
@@ -249,6 +508,8 @@ func main() {
 		filterSkipUrl []string
 
 		testsFilter dbs.TestFilter
+		testsPage   uint64
+		testsParams []string
 
 		selectNum  int
 		selectId   uint64
@@ -279,8 +540,55 @@ func main() {
 		diffTopSave       string
 		diffTopAppend     bool
 
+		checksDropped   bool
+		checksThreshold float64
+
+		testsLabels []string
+		testsHidden bool
+
+		annotateNum    int
+		annotateId     uint64
+		annotateTime   time.Time
+		annotateName   string
+		annotateText   string
+		annotateLabels []string
+		annotateClear  bool
+		annotateHide   bool
+		annotateUnhide bool
+
+		deleteNum  int
+		deleteId   uint64
+		deleteTime time.Time
+		deleteYes  bool
+
+		histBuckets []string
+		histWidth   int
+		histRef     bool
+
+		cacheClear bool
+
+		watchInterval  time.Duration
+		watchCount     int
+		watchSortBy    dbs.SortBy
+		watchThreshold float64
+
+		testsConnName string
+		selectConn    string
+		refConn       string
+
+		connectArgs    []string
+		connectProfile string
+		connectAddress string
+		connectDB      string
+		connectParams  string
+		connectServer  string
+		connectClose   bool
+
 		// stored
-		tests []dbs.Test // loaded with tests
+		tests       []dbs.Test  // loaded with tests
+		testsConn   *connection // connection of loaded tests
+		testConn    *connection // connection of selected test (nil for loaded from file)
+		refTestConn *connection // connection of reference test (nil for loaded from file)
 		// filter
 		filterByLabel   string
 		filterByUrl     string
@@ -291,37 +599,124 @@ func main() {
 		refSamplesDurations *dbs.TestSamples
 	)
 
+	if len(os.Args) > 1 && os.Args[1] == "--version" {
+		fmt.Println(BuildVersion)
+		return
+	}
+
+	cliConfig, profile, err := loadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	configFile := configPath(os.Args[1:])
+	// CLI cache is enabled by default, without config file
+	defaultCacheSize := 64
+	if configFile != "" {
+		defaultCacheSize = cliConfig.Cache.Size
+	}
+	defaultStats := cliConfig.Stats
+	if len(defaultStats) == 0 {
+		defaultStats = dbs.DefaultStats
+	}
+
+	var (
+		// remote mode
+		serverURL string
+		remote    client.Config
+	)
+
 	chRegistry := clipper.NewRegistry("CLI for display xk6-output-clickhouse tests")
 	chCommand, _ := chRegistry.Register("", "Clickhouse settings")
-	chCommand.AddString("db", "d", "default", &chDB, "Database name").
+	chCommand.AddString("config", "c", configFile, &configFile, "Config file (YAML or TOML, .toml extension)").
+		AttachEnv("K6_STAT_CONFIG")
+	chCommand.AddString("profile", "P", cliConfig.Profile, &cliConfig.Profile, "ClickHouse profile from config file ("+strings.Join(cliConfig.ProfileNames(), ", ")+")").
+		AttachEnv("K6_STAT_PROFILE")
+	chCommand.AddString("db", "d", profile.Database, &profile.Database, "Database name").
 		AttachEnv("K6_STAT_DB")
-	chCommand.AddString("tests", "t", "k6_tests", &tableTests, "Tests table").
+	chCommand.AddString("tests", "t", profile.Tables.Tests, &profile.Tables.Tests, "Tests table").
 		AttachEnv("K6_STAT_TABLE_TESTS")
-	chCommand.AddString("samples", "s", "k6_samples", &tableSamples, "Samples table").
+	chCommand.AddString("samples", "s", profile.Tables.Samples, &profile.Tables.Samples, "Samples table").
 		AttachEnv("K6_STAT_TABLE_SAMPLES")
 
-	chCommand.AddString("address", "a", "http://localhost:8123", &chAddress, "Database address").
+	chCommand.AddString("address", "a", profile.Address, &profile.Address, "Database address").
 		AttachEnv("K6_STAT_DB_ADDR")
-	chCommand.AddString("params", "p", "dial_timeout=200ms&max_execution_time=60", &chPparam, "Connection params").
+	chCommand.AddString("params", "p", profile.Params, &profile.Params, "Connection params").
 		AttachEnv("K6_STAT_DB_PARAM")
+	chCommand.AddString("annotations", "A", profile.Tables.Annotations, &profile.Tables.Annotations, "Tests annotations table (disabled, if empty)").
+		AttachEnv("K6_STAT_TABLE_ANNOTATIONS")
+	chCommand.AddString("rollup", "R", profile.Tables.Rollup, &profile.Tables.Rollup, "Samples rollup table (used, if exist)").
+		AttachEnv("K6_STAT_TABLE_ROLLUP")
+	chCommand.AddString("cluster", "", profile.Tables.Cluster, &profile.Tables.Cluster, "Cluster name for cluster deployment (delete executed ON CLUSTER on local tables)").
+		AttachEnv("K6_STAT_CLUSTER")
+	chCommand.AddString("tls-ca", "", profile.TLS.CA, &profile.TLS.CA, "Database TLS CA certificates file (use https:// address for http protocol)").
+		AttachEnv("K6_STAT_DB_TLS_CA")
+	chCommand.AddString("tls-cert", "", profile.TLS.Cert, &profile.TLS.Cert, "Database TLS client certificate file").
+		AttachEnv("K6_STAT_DB_TLS_CERT")
+	chCommand.AddString("tls-key", "", profile.TLS.Key, &profile.TLS.Key, "Database TLS client key file").
+		AttachEnv("K6_STAT_DB_TLS_KEY")
+	chCommand.AddString("tls-server-name", "", profile.TLS.ServerName, &profile.TLS.ServerName, "Database TLS server name (for verification)").
+		AttachEnv("K6_STAT_DB_TLS_SERVER_NAME")
+	chCommand.AddFlag("tls-skip-verify", "", &profile.TLS.SkipVerify, "Skip database TLS server certificate verification (insecure)").
+		AttachEnv("K6_STAT_DB_TLS_SKIP_VERIFY")
+	chCommand.AddString("server", "", serverURL, &serverURL, "k6-stat server url (remote mode, ClickHouse settings are ignored)").
+		AttachEnv("K6_STAT_SERVER")
+	chCommand.AddString("server-token", "", remote.Token, &remote.Token, "k6-stat server API token").
+		AttachEnv("K6_STAT_SERVER_TOKEN")
+	chCommand.AddString("server-user", "", remote.User, &remote.User, "k6-stat server basic auth user (used, if token is not set)").
+		AttachEnv("K6_STAT_SERVER_USER")
+	chCommand.AddString("server-password", "", remote.Password, &remote.Password, "k6-stat server basic auth password").
+		AttachEnv("K6_STAT_SERVER_PASSWORD")
+	chCommand.AddString("server-tls-ca", "", remote.TLS.CAFile, &remote.TLS.CAFile, "k6-stat server TLS CA certificates file").
+		AttachEnv("K6_STAT_SERVER_TLS_CA")
+	chCommand.AddString("server-tls-cert", "", remote.TLS.CertFile, &remote.TLS.CertFile, "k6-stat server TLS client certificate file").
+		AttachEnv("K6_STAT_SERVER_TLS_CERT")
+	chCommand.AddString("server-tls-key", "", remote.TLS.KeyFile, &remote.TLS.KeyFile, "k6-stat server TLS client key file").
+		AttachEnv("K6_STAT_SERVER_TLS_KEY")
+	chCommand.AddFlag("server-tls-skip-verify", "", &remote.TLS.SkipVerify, "Skip k6-stat server TLS certificate verification (insecure)").
+		AttachEnv("K6_STAT_SERVER_TLS_SKIP_VERIFY")
+	chCommand.AddInt("cache-size", "C", defaultCacheSize, &cliConfig.Cache.Size, "Finished tests queries cache size (MB, 0 for disable)").
+		AttachEnv("K6_STAT_CACHE_SIZE")
+	chCommand.AddString("cache-dir", "D", cliConfig.Cache.Dir, &cliConfig.Cache.Dir, "On-disk queries cache directory (optional)").
+		AttachEnv("K6_STAT_CACHE_DIR")
+	chCommand.AddStringArray("stats", "S", defaultStats, &stats, "Durations statistics (min, max, mean, stddev or percentile like p99.9)").
+		AttachEnv("K6_STAT_STATS")
+	chCommand.AddString("quantile", "q", cliConfig.Quantile, &cliConfig.Quantile, "Quantile function "+dbs.QuantileFuncValuesString()).
+		SetValidValues(dbs.QuantileFuncValues()).
+		AttachEnv("K6_STAT_QUANTILE")
 
 	if _, err := chRegistry.Parse(os.Args[1:]); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
-	dsn := chAddress + "/" + chDB + "?" + chPparam
-	if d, err := sql.Open("clickhouse", dsn); err == nil {
-		d.SetMaxIdleConns(1)
-		d.SetMaxOpenConns(3)
-		d.SetConnMaxIdleTime(time.Hour)
-		db = dbs.New(d, tableTests, tableSamples)
+	if parsedStats, err := dbs.ParseStats(stats); err == nil {
+		stats = parsedStats.Names()
 	} else {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	cliConfig.Stats = stats
+	if serverURL == "" {
+		// ClickHouse settings are ignored in remote mode
+		if err = cliConfig.ValidateClient(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
 
-	defer db.Close()
+	conns := newConnections(cliConfig.Cache, remote)
+	if serverURL == "" {
+		_, err = conns.connect(cliConfig.Profile, profile)
+	} else {
+		_, err = conns.connectServer(remoteConnName, serverURL)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer conns.closeAll()
+	db = conns.sourceOf(nil)
 
 	timeLayout := "2006-01-02T15:04:05"
 	now := time.Now().UTC()
@@ -340,6 +735,14 @@ func main() {
 		"Select tests started before").
 		SetCompeterValue(now.Format(timeLayout))
 	testsCommand.AddString("name", "n", "", &testsFilter.Name, "Tests name filter (LIKE format)")
+	testsCommand.AddString("search", "s", "", &testsFilter.Search, "Tests name or params search (regular expression)")
+	testsCommand.AddUint64("limit", "l", 0, &testsFilter.Limit, "Tests page size (0 for no limit)")
+	testsCommand.AddUint64("page", "p", 1, &testsPage, "Tests page number (from 1, used with limit)")
+	testsCommand.AddFlag("desc", "d", &testsFilter.Desc, "Newest tests first")
+	testsCommand.AddStringArray("param", "P", []string{}, &testsParams, "Tests params filter (K=V or K for any value)")
+	testsCommand.AddStringArray("labels", "L", []string{}, &testsLabels, "Tests annotation labels filter")
+	testsCommand.AddFlag("hidden", "H", &testsHidden, "Show hidden tests")
+	testsCommand.AddString("conn", "c", "", &testsConnName, "Connection name (primary if empty)")
 
 	filterCommand, _ := registry.Register("filter", "Filter for load tests")
 	filterCommand.AddString("label", "l", "", &filterLabel, "Label filter (LIKE format)")
@@ -352,6 +755,7 @@ func main() {
 	selectCommand.AddTimeFromString("time", "t", now.Format(time.RFC3339Nano), &selectTime, time.RFC3339Nano,
 		"Test start time (used with id)").
 		SetCompeterValue(now.Format(time.RFC3339Nano))
+	selectCommand.AddString("conn", "c", "", &selectConn, "Connection name for id (loaded tests connection for number, primary if empty)")
 
 	refCommand, _ := registry.Register("reference", "Select reference test (used for compare)")
 	refCommand.AddInt("number", "n", -1, &refNum, "Select test from loaded tests by number")
@@ -359,6 +763,16 @@ func main() {
 	refCommand.AddTimeFromString("time", "t", now.Format(time.RFC3339Nano), &refTime, time.RFC3339Nano,
 		"Reference start time (used with id)").
 		SetCompeterValue(now.Format(time.RFC3339Nano))
+	refCommand.AddString("conn", "c", "", &refConn, "Connection name for id (loaded tests connection for number, primary if empty)")
+
+	connectCommand, _ := registry.Register("connect", "Open named connection (print connections without name)")
+	connectCommand.AddStringArgs(1, &connectArgs, "Connection name")
+	connectCommand.AddString("profile", "P", "", &connectProfile, "Config profile (connection name if empty)")
+	connectCommand.AddString("address", "a", "", &connectAddress, "Database address (override profile)")
+	connectCommand.AddString("db", "d", "", &connectDB, "Database name (override profile)")
+	connectCommand.AddString("params", "p", "", &connectParams, "Connection params (override profile)")
+	connectCommand.AddString("server", "S", "", &connectServer, "k6-stat server url (remote mode, instead of profile)")
+	connectCommand.AddFlag("close", "x", &connectClose, "Close connection")
 
 	saveCommand, _ := registry.Register("save", "Save tests")
 	saveCommand.AddString("test", "t", "", &saveTest, "Test file")
@@ -371,28 +785,70 @@ func main() {
 	topCommand, _ := registry.Register("top", "Print top of test queries")
 	topCommand.AddInt("count", "c", 10, &topTopCount, "Top of N queries")
 	// topCommand.AddFlag("no-label", "N", &topByLabel, "Top per label")
-	topCommand.AddValue("sort", "s", dbs.NewSortByValue(dbs.SortByP99, &topTopSortBy), false, "Sort by "+dbs.SortByValuesString()).
-		SetValidValues(dbs.SortByValues())
+	topCommand.AddValue("sort", "s", dbs.NewSortByValue(dbs.SortByDefault(stats), &topTopSortBy), false, "Sort by "+dbs.SortByValuesString(stats)).
+		SetValidValues(dbs.SortByValues(stats))
 	topCommand.AddString("out", "o", "", &topSave, "Save test top to file")
 	topCommand.AddFlag("append", "a", &topAppend, "Append to file")
 
 	topRefCommand, _ := registry.Register("ref-top", "Print top of reference test queries")
 	topRefCommand.AddInt("count", "c", 10, &topRefCount, "Top of N queries")
 	// topRefCommand.AddFlag("no-label", "N", &topRefByLabel, "Top per label")
-	topRefCommand.AddValue("sort", "s", dbs.NewSortByValue(dbs.SortByP99, &topRefSortBy), false, "Sort by "+dbs.SortByValuesString()).
-		SetValidValues(dbs.SortByValues())
+	topRefCommand.AddValue("sort", "s", dbs.NewSortByValue(dbs.SortByDefault(stats), &topRefSortBy), false, "Sort by "+dbs.SortByValuesString(stats)).
+		SetValidValues(dbs.SortByValues(stats))
 	topRefCommand.AddString("out", "o", "", &topRefSave, "Save reference test top to file")
 	topRefCommand.AddFlag("append", "a", &topRefAppend, "Append to file")
 
 	diffCommand, _ := registry.Register("diff", "Print top of diff test/reference queries")
 	diffCommand.AddInt("count", "c", 10, &diffTopCount, "Top of N queries")
 	// topCommand.AddFlag("no-label", "N", &topByLabel, "Top per label")
-	diffCommand.AddValue("sort", "s", dbs.NewSortByValue(dbs.SortByP99, &diffTopSortBy), false, "Sort by "+dbs.SortByValuesString()).
-		SetValidValues(dbs.SortByValues())
+	diffCommand.AddValue("sort", "s", dbs.NewSortByValue(dbs.SortByDefault(stats), &diffTopSortBy), false, "Sort by "+dbs.SortByValuesString(stats)).
+		SetValidValues(dbs.SortByValues(stats))
 	diffCommand.AddFlag("by-diff", "d", &diffTopSortByDiff, "Top by diff")
 	diffCommand.AddString("out", "o", "", &diffTopSave, "Save top of diff between tests to file")
 	diffCommand.AddFlag("append", "a", &diffTopAppend, "Append to file")
 
+	checksCommand, _ := registry.Register("checks", "Print checks pass rate (diff with reference, if selected)")
+	checksCommand.AddFlag("dropped", "d", &checksDropped, "Print only dropped checks")
+	checksCommand.AddFloat64("threshold", "T", dbs.ChecksDropThreshold, &checksThreshold, "Pass rate drop threshold for mark check as dropped (percents)")
+
+	annotateCommand, _ := registry.Register("annotate", "Annotate test (rename, add note or labels, hide)")
+	annotateCommand.AddInt("number", "n", -1, &annotateNum, "Test from loaded tests by number")
+	annotateCommand.AddUint64("id", "i", 0, &annotateId, "Test id (conflict with number)")
+	annotateCommand.AddTimeFromString("time", "t", now.Format(time.RFC3339Nano), &annotateTime, time.RFC3339Nano,
+		"Test start time (used with id)").
+		SetCompeterValue(now.Format(time.RFC3339Nano))
+	annotateCommand.AddString("name", "N", "", &annotateName, "Display name")
+	annotateCommand.AddString("text", "T", "", &annotateText, "Note")
+	annotateCommand.AddStringArray("labels", "L", []string{}, &annotateLabels, "Labels")
+	annotateCommand.AddFlag("clear", "c", &annotateClear, "Clear name, note and labels")
+	annotateCommand.AddFlag("hide", "H", &annotateHide, "Hide (archive) test")
+	annotateCommand.AddFlag("unhide", "U", &annotateUnhide, "Unhide test")
+
+	deleteCommand, _ := registry.Register("delete", "Delete test with samples")
+	deleteCommand.AddInt("number", "n", -1, &deleteNum, "Test from loaded tests by number")
+	deleteCommand.AddUint64("id", "i", 0, &deleteId, "Test id (conflict with number)")
+	deleteCommand.AddTimeFromString("time", "t", now.Format(time.RFC3339Nano), &deleteTime, time.RFC3339Nano,
+		"Test start time (used with id)").
+		SetCompeterValue(now.Format(time.RFC3339Nano))
+	deleteCommand.AddFlag("yes", "y", &deleteYes, "Confirm delete")
+
+	histCommand, _ := registry.Register("hist", "Print latency histogram (overlay with reference, if selected)")
+	histCommand.AddStringArray("buckets", "b", []string{}, &histBuckets, "Buckets upper bounds (ms, ascending), log-scale by default")
+	histCommand.AddInt("width", "w", 50, &histWidth, "Bar width")
+	histCommand.AddFlag("ref", "r", &histRef, "Overlay with reference test")
+
+	watchCommand, _ := registry.Register("watch", "Watch running selected test top (compare with reference, if selected)")
+	watchCommand.AddDuration("interval", "i", dbs.DefaultLiveInterval, &watchInterval, "Refresh interval")
+	watchCommand.AddInt("count", "c", 10, &watchCount, "Top of N queries")
+	watchCommand.AddValue("sort", "s", dbs.NewSortByValue(dbs.SortByDefault(stats), &watchSortBy), false, "Sort by "+dbs.SortByValuesString(stats)).
+		SetValidValues(dbs.SortByValues(stats))
+	watchCommand.AddFloat64("threshold", "T", dbs.DefaultRegressionThreshold, &watchThreshold, "Regression threshold (percents)")
+
+	cacheCommand, _ := registry.Register("cache", "Print queries cache statistic")
+	cacheCommand.AddFlag("clear", "c", &cacheClear, "Clear cache")
+
+	registry.Register("config", "Print effective config (with flags and env overrides)")
+
 	reader := liner.NewLiner()
 	defer reader.Close()
 
@@ -421,27 +877,54 @@ func main() {
 					// execute command
 					switch command {
 					case "tests":
+						conn, err := conns.get(testsConnName)
+						if err != nil {
+							fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+							break
+						}
 						testsFilter.From = testsFrom.Unix()
 						testsFilter.Until = testsUntil.Unix()
-						if tests, dbErr = db.GetTests(testsFilter); dbErr == nil {
+						testsFilter.Offset = 0
+						testsFilter.Params = dbs.ParseParams(strings.Join(testsParams, " "))
+						testsFilter.Labels = testsLabels
+						testsFilter.Hidden = testsHidden
+						if testsFilter.Limit > 0 && testsPage > 1 {
+							testsFilter.Offset = (testsPage - 1) * testsFilter.Limit
+						}
+						if tests, dbErr = conn.src.GetTests(context.Background(), testsFilter); dbErr == nil {
+							testsConn = conn
 							printTests(os.Stdout, tests)
+							if testsFilter.Limit > 0 {
+								var total uint64
+								if total, dbErr = conn.src.CountTests(context.Background(), testsFilter); dbErr == nil {
+									fmt.Printf("Page %d/%d, total %d tests\n",
+										testsPage, (total+testsFilter.Limit-1)/testsFilter.Limit, total)
+								} else {
+									printQueryError(os.Stderr, dbErr)
+								}
+							}
 						} else {
-							fmt.Fprintf(os.Stderr, "Error: %s, sql: %s\n", dbErr.Error(), dbErr.Query())
+							printQueryError(os.Stderr, dbErr)
 						}
 					case "filter":
 						filterByLabel = filterLabel
 						filterByUrl = filterUrl
 						filterBySkipUrl = filterSkipUrl
 					case "select":
+						conn, err := conns.testConnection(selectConn, selectId > 0, testsConn)
+						if err != nil {
+							fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+							break
+						}
 						var test dbs.Test
 						if selectId > 0 {
 							f := dbs.TestIdFilter{
 								Id:   selectId,
 								Time: selectTime.UnixNano(),
 							}
-							if test, dbErr = db.GetTestById(f); dbErr != nil {
+							if test, dbErr = conn.src.GetTestById(context.Background(), f); dbErr != nil {
 								registry.ResetCommand(command)
-								fmt.Fprintf(os.Stderr, "Error: %s, sql: %s\n", dbErr.Error(), dbErr.Query())
+								printQueryError(os.Stderr, dbErr)
 								continue
 							}
 							_ = printTest(os.Stdout, []dbs.Test{test}, 0, "test", true)
@@ -462,45 +945,39 @@ func main() {
 						fmt.Println()
 
 						filter := dbs.SampleFilter{
-							Id:      test.Id,
-							Start:   test.Ts.UnixNano(),
-							Label:   filterByLabel,
-							Url:     filterByUrl,
-							SkipUrl: filterBySkipUrl,
+							Id:       test.Id,
+							Start:    test.Ts.UnixNano(),
+							Label:    filterByLabel,
+							Url:      filterByUrl,
+							SkipUrl:  filterBySkipUrl,
+							Stats:    stats,
+							Quantile: cliConfig.Quantile,
 						}
 
-						var (
-							samplesQ      []dbs.SampleQuantiles
-							samplesStatus []dbs.SampleStatus
-						)
-
-						if samplesQ, dbErr = db.GetHttpSamplesDurations(filter); dbErr == nil {
-							if len(samplesQ) == 0 {
+						if testSamplesDurations, dbErr = conn.src.GetHttpTestSamples(context.Background(), test, filter); dbErr != nil {
+							printQueryError(os.Stderr, dbErr)
+						} else {
+							testConn = conn
+							if len(testSamplesDurations.Samples) == 0 {
 								fmt.Fprintln(os.Stderr, "Warning: no duration samples")
 							}
-							samplesStatus, dbErr = db.GetHttpSamplesStatus(filter)
-							if dbErr == nil {
-								if len(samplesStatus) == 0 {
-									fmt.Fprintln(os.Stderr, "Warning: no status samples")
-								}
-							}
-						}
-
-						if dbErr != nil {
-							fmt.Fprintf(os.Stderr, "Error: %s, sql: %s\n", dbErr.Error(), dbErr.Query())
-						} else {
-							testSamplesDurations = dbs.MergeSamples(test, samplesQ, samplesStatus)
-							fmt.Printf("Loaded %d duration samples, %d status samples\n", len(samplesQ), len(samplesStatus))
+							fmt.Printf("Loaded %d urls, %d checks, duration %.0fs\n",
+								countUrls(testSamplesDurations), len(testSamplesDurations.Checks), testSamplesDurations.Duration)
 						}
 					case "reference":
+						conn, err := conns.testConnection(refConn, refId > 0, testsConn)
+						if err != nil {
+							fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+							break
+						}
 						var test dbs.Test
 						if refId > 0 {
 							f := dbs.TestIdFilter{
 								Id:   refId,
 								Time: refTime.UnixNano(),
 							}
-							if test, dbErr = db.GetTestById(f); dbErr != nil {
-								fmt.Fprintf(os.Stderr, "Error: %s, sql: %s\n", dbErr.Error(), dbErr.Query())
+							if test, dbErr = conn.src.GetTestById(context.Background(), f); dbErr != nil {
+								printQueryError(os.Stderr, dbErr)
 							} else {
 								refNum = 0
 								_ = printTest(os.Stdout, []dbs.Test{test}, 0, "ref", true)
@@ -522,36 +999,24 @@ func main() {
 						fmt.Println()
 
 						filter := dbs.SampleFilter{
-							Id:      test.Id,
-							Start:   test.Ts.UnixNano(),
-							Label:   filterByLabel,
-							Url:     filterByUrl,
-							SkipUrl: filterBySkipUrl,
+							Id:       test.Id,
+							Start:    test.Ts.UnixNano(),
+							Label:    filterByLabel,
+							Url:      filterByUrl,
+							SkipUrl:  filterBySkipUrl,
+							Stats:    stats,
+							Quantile: cliConfig.Quantile,
 						}
 
-						var (
-							samplesQ      []dbs.SampleQuantiles
-							samplesStatus []dbs.SampleStatus
-						)
-
-						if samplesQ, dbErr = db.GetHttpSamplesDurations(filter); dbErr == nil {
-							if len(samplesQ) == 0 {
+						if refSamplesDurations, dbErr = conn.src.GetHttpTestSamples(context.Background(), test, filter); dbErr != nil {
+							printQueryError(os.Stderr, dbErr)
+						} else {
+							refTestConn = conn
+							if len(refSamplesDurations.Samples) == 0 {
 								fmt.Fprintln(os.Stderr, "Warning: no duration samples")
 							}
-
-							samplesStatus, dbErr = db.GetHttpSamplesStatus(filter)
-							if dbErr != nil {
-								if len(samplesStatus) == 0 {
-									fmt.Fprintln(os.Stderr, "Warning: no status samples")
-								}
-							}
-						}
-
-						if dbErr != nil {
-							fmt.Fprintf(os.Stderr, "Error: %s, sql: %s\n", dbErr.Error(), dbErr.Query())
-						} else {
-							refSamplesDurations = dbs.MergeSamples(test, samplesQ, samplesStatus)
-							fmt.Printf("Loaded reference %d duration samples, %d status samples\n", len(samplesQ), len(samplesStatus))
+							fmt.Printf("Loaded reference %d urls, %d checks, duration %.0fs\n",
+								countUrls(refSamplesDurations), len(refSamplesDurations.Checks), refSamplesDurations.Duration)
 						}
 					case "save":
 						if saveTest != "" {
@@ -570,11 +1035,13 @@ func main() {
 							if testSamplesDurations, err = loadTestSamples(loadTest); err != nil {
 								fmt.Fprintf(os.Stderr, "Error: load 'test' samples with %v\n", err)
 							}
+							testConn = nil
 						}
 						if loadRef != "" {
 							if refSamplesDurations, err = loadTestSamples(loadRef); err == nil {
 								fmt.Fprintf(os.Stderr, "Error: load 'ref' samples with %v\n", err)
 							}
+							refTestConn = nil
 						}
 					case "top":
 						if testSamplesDurations == nil {
@@ -586,7 +1053,7 @@ func main() {
 
 							_ = printTest(os.Stdout, []dbs.Test{testSamplesDurations.Test}, 0, "test", true)
 							fmt.Println()
-							_ = printHttpTop(os.Stdout, testSamplesDurations.Samples, topTopCount)
+							_ = printHttpTop(os.Stdout, testSamplesDurations.Stats, testSamplesDurations.Samples, topTopCount)
 
 							if topSave != "" {
 								var f *os.File
@@ -606,7 +1073,7 @@ func main() {
 										_, err = fmt.Fprintln(f)
 									}
 									if err == nil {
-										err = printHttpTop(f, testSamplesDurations.Samples, topTopCount)
+										err = printHttpTop(f, testSamplesDurations.Stats, testSamplesDurations.Samples, topTopCount)
 									}
 									if err != nil {
 										f.Close()
@@ -629,7 +1096,7 @@ func main() {
 
 							_ = printTest(os.Stdout, []dbs.Test{refSamplesDurations.Test}, 0, "ref", true)
 							fmt.Println()
-							_ = printHttpTop(os.Stdout, refSamplesDurations.Samples, topRefCount)
+							_ = printHttpTop(os.Stdout, refSamplesDurations.Stats, refSamplesDurations.Samples, topRefCount)
 
 							if topRefSave != "" {
 								var f *os.File
@@ -649,7 +1116,7 @@ func main() {
 										_, err = fmt.Fprintln(f)
 									}
 									if err == nil {
-										err = printHttpTop(f, refSamplesDurations.Samples, topRefCount)
+										err = printHttpTop(f, refSamplesDurations.Stats, refSamplesDurations.Samples, topRefCount)
 									}
 									if err != nil {
 										f.Close()
@@ -670,7 +1137,12 @@ func main() {
 							fmt.Fprintf(os.Stderr, "Error: select reference test with 'reference' command\n")
 						}
 						if testSamplesDurations != nil && refSamplesDurations != nil {
-							diff := dbs.DiffSamples(testSamplesDurations, refSamplesDurations)
+							diff, err := dbs.DiffSamples(testSamplesDurations, refSamplesDurations)
+							if err != nil {
+								registry.ResetCommand(command)
+								fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+								continue
+							}
 							if diffTopSortByDiff {
 								// sort by diff
 								for _, d := range diff.Samples {
@@ -684,8 +1156,9 @@ func main() {
 
 							_ = printTest(os.Stdout, []dbs.Test{testSamplesDurations.Test}, 0, "test", true)
 							_ = printTest(os.Stdout, []dbs.Test{refSamplesDurations.Test}, 0, "ref", false)
+							_ = printParamsDiff(os.Stdout, diff.ParamsDiff)
 							fmt.Println()
-							_ = printHttpTopDiff(os.Stdout, diff.Samples, diffTopCount)
+							_ = printHttpTopDiff(os.Stdout, diff.Stats, diff.Samples, diffTopCount)
 
 							if diffTopSave != "" {
 								var f *os.File
@@ -704,11 +1177,14 @@ func main() {
 									if err == nil {
 										err = printTest(os.Stdout, []dbs.Test{refSamplesDurations.Test}, 0, "ref", false)
 									}
+									if err == nil {
+										err = printParamsDiff(f, diff.ParamsDiff)
+									}
 									if err == nil {
 										_, err = fmt.Fprintln(f)
 									}
 									if err == nil {
-										err = printHttpTopDiff(f, diff.Samples, diffTopCount)
+										err = printHttpTopDiff(f, diff.Stats, diff.Samples, diffTopCount)
 									}
 									if err != nil {
 										f.Close()
@@ -721,6 +1197,183 @@ func main() {
 								}
 							}
 						}
+					case "checks":
+						if testSamplesDurations == nil {
+							fmt.Fprintf(os.Stderr, "Error: select test with 'select' command\n")
+						} else if refSamplesDurations == nil {
+							_ = printTest(os.Stdout, []dbs.Test{testSamplesDurations.Test}, 0, "test", true)
+							_ = printChecks(os.Stdout, testSamplesDurations.Checks)
+						} else {
+							if checksThreshold < 0 {
+								fmt.Fprintf(os.Stderr, "Error: negative checks threshold\n")
+								break
+							}
+							checks := dbs.DiffChecks(testSamplesDurations.Checks, refSamplesDurations.Checks, checksThreshold)
+							if checksDropped {
+								checks = dbs.ChecksDropped(checks)
+							}
+							dbs.SortChecksDiff(checks)
+
+							_ = printTest(os.Stdout, []dbs.Test{testSamplesDurations.Test}, 0, "test", true)
+							_ = printTest(os.Stdout, []dbs.Test{refSamplesDurations.Test}, 0, "ref", false)
+							_ = printChecksDiff(os.Stdout, checks)
+						}
+					case "annotate":
+						patch := dbs.TestPatch{Id: annotateId, Time: annotateTime.UnixNano()}
+						annotateDB := db
+						if annotateId == 0 {
+							if annotateNum < 0 || annotateNum >= len(tests) {
+								fmt.Fprintf(os.Stderr, "Error: set test number or id\n")
+								break
+							}
+							patch.Id = tests[annotateNum].Id
+							patch.Time = tests[annotateNum].Ts.UnixNano()
+							annotateDB = conns.sourceOf(testsConn)
+						}
+						if annotateClear {
+							empty := ""
+							patch.Name = &empty
+							patch.Text = &empty
+							patch.Labels = &[]string{}
+						}
+						if annotateName != "" {
+							patch.Name = &annotateName
+						}
+						if annotateText != "" {
+							patch.Text = &annotateText
+						}
+						if len(annotateLabels) > 0 {
+							patch.Labels = &annotateLabels
+						}
+						if annotateHide {
+							patch.Hidden = &annotateHide
+						} else if annotateUnhide {
+							hidden := false
+							patch.Hidden = &hidden
+						}
+						if a, dbErr := annotateDB.AnnotateTest(context.Background(), patch); dbErr == nil {
+							printAnnotation(os.Stdout, a)
+						} else {
+							printQueryError(os.Stderr, dbErr)
+						}
+					case "delete":
+						f := dbs.TestIdFilter{Id: deleteId, Time: deleteTime.UnixNano()}
+						deleteDB := db
+						if deleteId == 0 {
+							if deleteNum < 0 || deleteNum >= len(tests) {
+								fmt.Fprintf(os.Stderr, "Error: set test number or id\n")
+								break
+							}
+							f.Id = tests[deleteNum].Id
+							f.Time = tests[deleteNum].Ts.UnixNano()
+							deleteDB = conns.sourceOf(testsConn)
+						}
+						if !deleteYes {
+							fmt.Fprintf(os.Stderr, "Error: test %d (%s) and it's samples will be deleted, confirm with --yes\n",
+								f.Id, time.Unix(0, f.Time).UTC().Format(time.RFC3339Nano))
+						} else if dbErr = deleteDB.DeleteTest(context.Background(), f); dbErr == nil {
+							fmt.Printf("Test %d deleted\n", f.Id)
+						} else {
+							printQueryError(os.Stderr, dbErr)
+						}
+					case "hist":
+						if testSamplesDurations == nil {
+							fmt.Fprintf(os.Stderr, "Error: select test with 'select' command\n")
+						} else if histRef && refSamplesDurations == nil {
+							fmt.Fprintf(os.Stderr, "Error: select reference test with 'reference' command\n")
+						} else if buckets, err := dbs.ParseBuckets(histBuckets); err != nil {
+							fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+						} else {
+							filter := dbs.HistogramFilter{
+								SampleFilter: dbs.SampleFilter{
+									Id:      testSamplesDurations.Test.Id,
+									Start:   testSamplesDurations.Test.Ts.UnixNano(),
+									Label:   filterByLabel,
+									Url:     filterByUrl,
+									SkipUrl: filterBySkipUrl,
+								},
+								Buckets: buckets,
+							}
+							var hist, refHist *dbs.SamplesHistogram
+							hist, dbErr = conns.sourceOf(testConn).GetHttpSamplesHistogram(context.Background(), filter)
+							if dbErr == nil && histRef {
+								filter.Id = refSamplesDurations.Test.Id
+								filter.Start = refSamplesDurations.Test.Ts.UnixNano()
+								refHist, dbErr = conns.sourceOf(refTestConn).GetHttpSamplesHistogram(context.Background(), filter)
+							}
+							if dbErr != nil {
+								printQueryError(os.Stderr, dbErr)
+							} else if refHist == nil {
+								_ = printTest(os.Stdout, []dbs.Test{testSamplesDurations.Test}, 0, "test", true)
+								_ = printHistogram(os.Stdout, hist, histWidth)
+							} else if diff, err := dbs.DiffHistograms(hist, refHist); err != nil {
+								fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+							} else {
+								_ = printTest(os.Stdout, []dbs.Test{testSamplesDurations.Test}, 0, "test", true)
+								_ = printTest(os.Stdout, []dbs.Test{refSamplesDurations.Test}, 0, "ref", false)
+								_ = printHistogramDiff(os.Stdout, diff, histWidth)
+							}
+						}
+					case "watch":
+						if testSamplesDurations == nil {
+							fmt.Fprintf(os.Stderr, "Error: select test with 'select' command\n")
+						} else if watchInterval <= 0 {
+							fmt.Fprintf(os.Stderr, "Error: invalid interval\n")
+						} else {
+							filter := dbs.SampleFilter{
+								Label:    filterByLabel,
+								Url:      filterByUrl,
+								SkipUrl:  filterBySkipUrl,
+								Stats:    stats,
+								Quantile: cliConfig.Quantile,
+							}
+							watchTest(conns.sourceOf(testConn), testSamplesDurations.Test, refSamplesDurations, filter,
+								watchInterval, watchCount, watchSortBy, watchThreshold)
+						}
+					case "cache":
+						if cacheClear {
+							if err = db.ClearCache(); err == nil {
+								fmt.Println("Cache cleared")
+							} else {
+								fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+							}
+						} else if cacheStats, err := db.CacheStats(); err == nil {
+							printCacheStats(os.Stdout, cacheStats)
+						} else {
+							fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+						}
+					case "connect":
+						if len(connectArgs) == 0 {
+							printConnections(os.Stdout, conns)
+						} else if connectClose {
+							if err = conns.close(connectArgs[0]); err == nil {
+								fmt.Printf("Connection %q closed\n", connectArgs[0])
+							} else {
+								fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+							}
+						} else if connectServer != "" {
+							if conn, err := conns.connectServer(connectArgs[0], connectServer); err != nil {
+								fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+							} else if err = conn.src.Ping(context.Background()); err != nil {
+								fmt.Fprintf(os.Stderr, "Warning: %s\n", err.Error())
+							} else {
+								fmt.Printf("Connected %q\n", conn.name)
+							}
+						} else if p, err := connectProfileSettings(cliConfig, profile, connectArgs[0], connectProfile,
+							connectAddress, connectDB, connectParams); err != nil {
+							fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+						} else if conn, err := conns.connect(connectArgs[0], p); err != nil {
+							fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+						} else if err = conn.src.Ping(context.Background()); err != nil {
+							fmt.Fprintf(os.Stderr, "Warning: %s\n", err.Error())
+						} else {
+							fmt.Printf("Connected %q\n", conn.name)
+						}
+					case "config":
+						cliConfig.Stats = stats
+						if err = cliConfig.Print(os.Stdout); err != nil {
+							fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+						}
 					case "":
 						// ignore empty command
 					default:
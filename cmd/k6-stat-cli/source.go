@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/msaf1980/k6-stat/client"
+	"github.com/msaf1980/k6-stat/dbs"
+)
+
+var errCacheDisabled = errors.New("cache disabled")
+
+// source is a tests data source: ClickHouse database (direct mode) or k6-stat server (remote mode)
+type source interface {
+	GetTests(ctx context.Context, f dbs.TestFilter) ([]dbs.Test, *dbs.QueryError)
+	CountTests(ctx context.Context, f dbs.TestFilter) (uint64, *dbs.QueryError)
+	GetTestById(ctx context.Context, f dbs.TestIdFilter) (dbs.Test, *dbs.QueryError)
+	GetHttpTestSamples(ctx context.Context, test dbs.Test, f dbs.SampleFilter) (*dbs.TestSamples, *dbs.QueryError)
+	GetHttpLiveSamples(
+		ctx context.Context, test dbs.Test, ref *dbs.TestSamples, f dbs.SampleFilter, from, until time.Time, threshold float64,
+	) (*dbs.LiveSamples, *dbs.QueryError)
+	GetHttpSamplesHistogram(ctx context.Context, f dbs.HistogramFilter) (*dbs.SamplesHistogram, *dbs.QueryError)
+	AnnotateTest(ctx context.Context, p dbs.TestPatch) (*dbs.Annotation, *dbs.QueryError)
+	DeleteTest(ctx context.Context, f dbs.TestIdFilter) *dbs.QueryError
+	CacheStats() (dbs.CacheStats, error)
+	ClearCache() error
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// dbSource is a ClickHouse data source
+type dbSource struct {
+	*dbs.DB
+}
+
+func (s dbSource) CacheStats() (dbs.CacheStats, error) {
+	cache := s.Cache()
+	if cache == nil {
+		return dbs.CacheStats{}, errCacheDisabled
+	}
+	return cache.Stats(), nil
+}
+
+func (s dbSource) ClearCache() error {
+	cache := s.Cache()
+	if cache == nil {
+		return errCacheDisabled
+	}
+	return cache.Clear()
+}
+
+// remoteSource is a k6-stat server data source
+type remoteSource struct {
+	c *client.Client
+	// runningTimeout is a timeout from the last test sample, after that test is assumed finished (for live view)
+	runningTimeout time.Duration
+}
+
+func newRemoteSource(serverURL string, cfg client.Config, runningTimeout time.Duration) (*remoteSource, error) {
+	c, err := client.New(serverURL, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if runningTimeout <= 0 {
+		runningTimeout = dbs.DefaultRunningTimeout
+	}
+	return &remoteSource{c: c, runningTimeout: runningTimeout}, nil
+}
+
+// remoteError convert API error to query error (with the same code)
+func remoteError(err error) *dbs.QueryError {
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) {
+		if apiErr.Code != "" {
+			return dbs.NewQueryErrorCode(err, apiErr.Code, "")
+		}
+		return dbs.NewQueryError(err, apiErr.Status, "")
+	}
+	return dbs.NewQueryError(err, 0, "")
+}
+
+// isNotImplemented check for API error for not configured feature (like cache)
+func isNotImplemented(err error) bool {
+	var apiErr *client.Error
+	return errors.As(err, &apiErr) && apiErr.Code == dbs.ErrCodeNotImplemented
+}
+
+func (s *remoteSource) GetTests(ctx context.Context, f dbs.TestFilter) ([]dbs.Test, *dbs.QueryError) {
+	tests, _, err := s.c.GetTests(ctx, f)
+	if err != nil {
+		return nil, remoteError(err)
+	}
+	return tests, nil
+}
+
+func (s *remoteSource) CountTests(ctx context.Context, f dbs.TestFilter) (uint64, *dbs.QueryError) {
+	// total count is returned for paginated requests
+	f.Limit = 1
+	f.Offset = 0
+	_, total, err := s.c.GetTests(ctx, f)
+	if err != nil {
+		return 0, remoteError(err)
+	}
+	return total, nil
+}
+
+func (s *remoteSource) GetTestById(ctx context.Context, f dbs.TestIdFilter) (dbs.Test, *dbs.QueryError) {
+	test, err := s.c.GetTest(ctx, f.Id, f.Time)
+	if err != nil {
+		return dbs.Test{}, remoteError(err)
+	}
+	return test, nil
+}
+
+func (s *remoteSource) GetHttpTestSamples(ctx context.Context, test dbs.Test, f dbs.SampleFilter) (*dbs.TestSamples, *dbs.QueryError) {
+	f.Id = test.Id
+	f.Start = test.Ts.UnixNano()
+	samples, err := s.c.GetHttpTestSamples(ctx, f)
+	if err != nil {
+		return nil, remoteError(err)
+	}
+	return samples, nil
+}
+
+// GetHttpLiveSamples return test aggregates for samples in [from, until) interval, compared with reference (if not nil).
+// Like dbs.DB.GetHttpLiveSamples, but test is assumed finished by the last sample timestamp from server.
+func (s *remoteSource) GetHttpLiveSamples(
+	ctx context.Context, test dbs.Test, ref *dbs.TestSamples, f dbs.SampleFilter, from, until time.Time, threshold float64,
+) (*dbs.LiveSamples, *dbs.QueryError) {
+	f.From = from.UnixNano()
+	f.Until = until.UnixNano()
+
+	samples, qErr := s.GetHttpTestSamples(ctx, test, f)
+	if qErr != nil {
+		return nil, qErr
+	}
+	live := &dbs.LiveSamples{From: from.UTC(), Until: until.UTC(), Samples: samples}
+	if ref != nil {
+		diff, err := dbs.DiffSamples(samples, ref)
+		if err != nil {
+			return nil, dbs.NewQueryError(err, http.StatusBadRequest, "")
+		}
+		live.Diff = diff
+		live.Regressions = dbs.FindRegressions(diff, threshold)
+	}
+	r, err := s.c.GetTestRange(ctx, dbs.SampleFilter{Id: test.Id, Start: test.Ts.UnixNano()})
+	if err != nil {
+		return nil, remoteError(err)
+	}
+	live.Finished = r.Until.Unix() > 0 && time.Since(r.Until) >= s.runningTimeout
+
+	return live, nil
+}
+
+func (s *remoteSource) GetHttpSamplesHistogram(ctx context.Context, f dbs.HistogramFilter) (*dbs.SamplesHistogram, *dbs.QueryError) {
+	hist, err := s.c.GetHttpSamplesHistogram(ctx, f)
+	if err != nil {
+		return nil, remoteError(err)
+	}
+	return hist, nil
+}
+
+func (s *remoteSource) AnnotateTest(ctx context.Context, p dbs.TestPatch) (*dbs.Annotation, *dbs.QueryError) {
+	a, err := s.c.AnnotateTest(ctx, p)
+	if err != nil {
+		return nil, remoteError(err)
+	}
+	return a, nil
+}
+
+func (s *remoteSource) DeleteTest(ctx context.Context, f dbs.TestIdFilter) *dbs.QueryError {
+	if err := s.c.DeleteTest(ctx, f); err != nil {
+		return remoteError(err)
+	}
+	return nil
+}
+
+func (s *remoteSource) CacheStats() (dbs.CacheStats, error) {
+	stats, err := s.c.CacheStats(context.Background())
+	if isNotImplemented(err) {
+		return stats, errCacheDisabled
+	}
+	return stats, err
+}
+
+func (s *remoteSource) ClearCache() error {
+	err := s.c.ClearCache(context.Background())
+	if isNotImplemented(err) {
+		return errCacheDisabled
+	}
+	return err
+}
+
+// Ping check server readiness
+func (s *remoteSource) Ping(ctx context.Context) error {
+	return s.c.Ready(ctx)
+}
+
+func (s *remoteSource) Close() error {
+	return nil
+}
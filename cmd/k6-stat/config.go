@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func configUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: k6-stat config print")
+	fmt.Fprintln(os.Stderr, "Print effective config (config file from K6_STAT_CONFIG env, overridden with K6_STAT_* env) in YAML format.")
+}
+
+// runConfig execute config command and return exit code
+func runConfig(args []string) int {
+	if len(args) != 1 || args[0] != "print" {
+		configUsage()
+		return 2
+	}
+	if err := cfg.Print(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
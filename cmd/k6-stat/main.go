@@ -1,45 +1,197 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/rs/zerolog"
 
 	app "github.com/msaf1980/k6-stat/app/k6-stat"
-	"github.com/msaf1980/k6-stat/utils/env"
+	"github.com/msaf1980/k6-stat/config"
 )
 
+// BuildVersion is set with ldflags on build
+var BuildVersion = "(development build)"
+
 var (
-	listen       string
-	dbDSN        string
-	maxConn      int
-	tableTests   string
-	tableSamples string
+	cfg     *config.Config
+	profile *config.Profile
 )
 
-func init() {
-	listen = env.GetEnv("K6_STAT_LISTEN", ":8080")
+// loadConfig read config file (--config flag or K6_STAT_CONFIG env), override it with env and validate.
+// Profile, set with --profile flag, override K6_STAT_PROFILE env (and ClickHouse env are applied to it).
+func loadConfig(path, profileName string) (err error) {
+	if path == "" {
+		path = os.Getenv("K6_STAT_CONFIG")
+	}
+	if cfg, err = config.Load(path); err != nil {
+		return err
+	}
+	lookup := os.LookupEnv
+	if profileName != "" {
+		cfg.Profile = profileName
+		lookup = func(key string) (string, bool) {
+			if key == "K6_STAT_PROFILE" {
+				return "", false
+			}
+			return os.LookupEnv(key)
+		}
+	}
+	if err = cfg.ApplyEnv(lookup); err != nil {
+		return err
+	}
+	if err = cfg.Validate(); err != nil {
+		return err
+	}
+	profile, err = cfg.SelectedProfile()
+	return err
+}
 
-	chAddress := env.GetEnv("K6_STAT_DB_ADDR", "http://localhost:8123")
-	chDB := env.GetEnv("K6_STAT_DB", "default")
-	chPparam := env.GetEnv("K6_STAT_DB_PARAM", "dial_timeout=200ms&max_execution_time=60")
-	dbDSN = chAddress + "/" + chDB + "?" + chPparam
+func usage(flags *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintln(os.Stderr, "Usage: k6-stat [flags] [migrate|config|passwd] [args]")
+		fmt.Fprintln(os.Stderr, "Start server (without command) or execute command.")
+		flags.PrintDefaults()
+	}
+}
 
-	maxConn, _ = env.GetEnvInt("K6_STAT_DB_MAX_CONN", 10)
-	if maxConn <= 0 {
-		panic("invalid max connections")
+// appConfig map config to server settings
+func appConfig() (app.Config, error) {
+	s := &cfg.Server
+	notify := app.NotifyConfig{
+		Webhooks:        make([]app.Webhook, 0, len(s.Notify.Webhooks)),
+		Tests:           s.Notify.Tests,
+		Interval:        time.Duration(s.Notify.Interval),
+		Lookback:        time.Duration(s.Notify.Lookback),
+		Stats:           s.Notify.Stats,
+		Threshold:       s.Notify.Threshold,
+		RegressionsOnly: s.Notify.RegressionsOnly,
+		Retries:         s.Notify.Retries,
+		Backoff:         time.Duration(s.Notify.Backoff),
+	}
+	for _, w := range s.Notify.Webhooks {
+		webhook := app.Webhook{Url: w.Url, Format: w.Format, Template: w.Template}
+		if w.TemplateFile != "" {
+			b, err := os.ReadFile(w.TemplateFile)
+			if err != nil {
+				return app.Config{}, fmt.Errorf("invalid notify template: %w", err)
+			}
+			webhook.Template = string(b)
+		}
+		notify.Webhooks = append(notify.Webhooks, webhook)
 	}
-	tableTests = env.GetEnv("K6_STAT_TABLE_TESTS", "k6_tests")
-	tableSamples = env.GetEnv("K6_STAT_TABLE_SAMPLES", "k6_samples")
+
+	return app.Config{
+		Quantile:         cfg.Quantile,
+		TableAnnotations: profile.Tables.Annotations,
+		TableRollup:      profile.Tables.Rollup,
+		Cluster:          profile.Tables.Cluster,
+		CacheSize:        int64(cfg.Cache.Size) * 1024 * 1024,
+		CacheDir:         cfg.Cache.Dir,
+		CacheScope:       profile.Source(),
+		RunningTimeout:   time.Duration(cfg.Cache.RunningTimeout),
+		Metrics:          s.Metrics.Enabled,
+		MetricsTests:     s.Metrics.Tests,
+		Notify:           notify,
+		Auth: app.AuthConfig{
+			TokensFile:      s.Auth.TokensFile,
+			UsersFile:       s.Auth.UsersFile,
+			ProxyHeader:     s.Auth.ProxyHeader,
+			ProxyRoleHeader: s.Auth.ProxyRoleHeader,
+			TrustedProxies:  s.Auth.TrustedProxies,
+			ClientCertsFile: s.Auth.ClientCertsFile,
+			AnonymousRole:   s.Auth.AnonymousRole,
+		},
+		CorsOrigins: s.CorsOrigins,
+		TLS: app.TLSConfig{
+			CertFile:       s.TLS.Cert,
+			KeyFile:        s.TLS.Key,
+			ClientCAFile:   s.TLS.ClientCA,
+			ClientAuth:     s.TLS.ClientAuth,
+			ReloadInterval: time.Duration(s.TLS.ReloadInterval),
+		},
+		DBTLS:   profile.TLSConfig(),
+		Version: BuildVersion,
+	}, nil
 }
 
 func main() {
+	var (
+		configFile  string
+		profileName string
+		version     bool
+	)
+	flags := flag.NewFlagSet("k6-stat", flag.ExitOnError)
+	flags.StringVar(&configFile, "config", "", "Config file (YAML or TOML, .toml extension), K6_STAT_CONFIG env if empty")
+	flags.StringVar(&configFile, "c", "", "Config file (shorthand)")
+	flags.StringVar(&profileName, "profile", "", "ClickHouse profile from config file, K6_STAT_PROFILE env if empty")
+	flags.StringVar(&profileName, "P", "", "ClickHouse profile (shorthand)")
+	flags.BoolVar(&version, "version", false, "Print version")
+	flags.Usage = usage(flags)
+	_ = flags.Parse(os.Args[1:])
+	args := flags.Args()
+
+	if version {
+		fmt.Println(BuildVersion)
+		return
+	}
+	if len(args) > 0 && args[0] == "passwd" {
+		os.Exit(runPasswd(args[1:]))
+	}
+
+	if err := loadConfig(configFile, profileName); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			os.Exit(runMigrate(args[1:]))
+		case "config":
+			os.Exit(runConfig(args[1:]))
+		default:
+			flags.Usage()
+			os.Exit(2)
+		}
+	}
+
+	appCfg, err := appConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
 	logger := zerolog.New(os.Stdout)
-	app, err := app.New(dbDSN, maxConn, &logger, tableTests, tableSamples)
+	app, err := app.New(profile.DSN(), profile.MaxConn, &logger, profile.Tables.Tests, profile.Tables.Samples, appCfg)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	log.Fatal(app.Listen(listen))
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- app.Listen(cfg.Server.Listen)
+	}()
+
+	select {
+	case err = <-listenErr:
+		app.Close()
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	logger.Info().Str("timeout", time.Duration(cfg.Server.ShutdownTimeout).String()).Msg("shutdown")
+	if err = app.ShutdownWithTimeout(time.Duration(cfg.Server.ShutdownTimeout)); err != nil {
+		logger.Error().Err(err).Msg("shutdown")
+	}
+	if err = app.Close(); err != nil {
+		logger.Error().Err(err).Msg("close database")
+	}
 }
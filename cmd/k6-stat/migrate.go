@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/msaf1980/k6-stat/dbs"
+	"github.com/msaf1980/k6-stat/migrate"
+	"github.com/msaf1980/k6-stat/utils/env"
+)
+
+func migrateUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: k6-stat migrate up|status")
+	fmt.Fprintln(os.Stderr, "Tables are set with K6_STAT_TABLE_TESTS, K6_STAT_TABLE_SAMPLES, K6_STAT_TABLE_ANNOTATIONS, K6_STAT_TABLE_ROLLUP, K6_STAT_TABLE_SCHEMA env,")
+	fmt.Fprintln(os.Stderr, "rollup table and materialized views are created only if K6_STAT_TABLE_ROLLUP is set,")
+	fmt.Fprintln(os.Stderr, "for cluster deployment set cluster name with K6_STAT_CLUSTER env (DDL executed ON CLUSTER).")
+}
+
+// runMigrate execute migrate command and return exit code
+func runMigrate(args []string) int {
+	if len(args) != 1 || (args[0] != "up" && args[0] != "status") {
+		migrateUsage()
+		return 2
+	}
+
+	var tlsConfig *tls.Config
+	if dbTLSConfig := profile.TLSConfig(); dbTLSConfig.Enabled() {
+		var err error
+		if tlsConfig, err = dbTLSConfig.ClientConfig(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	db, err := dbs.Open(profile.DSN(), tlsConfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	m := migrate.New(db, migrate.Config{
+		TableTests:       profile.Tables.Tests,
+		TableSamples:     profile.Tables.Samples,
+		TableAnnotations: profile.Tables.Annotations,
+		TableRollup:      profile.Tables.Rollup,
+		TableSchema:      env.GetEnv("K6_STAT_TABLE_SCHEMA", migrate.DefaultTableSchema),
+		Cluster:          profile.Tables.Cluster,
+	})
+
+	switch args[0] {
+	case "up":
+		done, err := m.Up()
+		for _, migration := range done {
+			fmt.Printf("applied %d: %s\n", migration.Version, migration.Description)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(done) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "status":
+		status, err := m.Status()
+		for _, s := range status {
+			applied := "pending"
+			if !s.Applied.IsZero() {
+				applied = s.Applied.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d | %25s | %s\n", s.Version, applied, s.Description)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	app "github.com/msaf1980/k6-stat/app/k6-stat"
+)
+
+func passwdUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: k6-stat passwd USER read|write|admin")
+	fmt.Fprintln(os.Stderr, "Password is read from stdin, users file (K6_STAT_AUTH_USERS_FILE) line is printed to stdout.")
+}
+
+// runPasswd print users file line with bcrypt-hashed password and return exit code
+func runPasswd(args []string) int {
+	if len(args) != 2 || args[0] == "" || strings.Contains(args[0], ":") {
+		passwdUsage()
+		return 2
+	}
+	if _, err := app.ParseRole(args[1]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		} else {
+			fmt.Fprintln(os.Stderr, "empty password")
+		}
+		return 1
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%s:%s:%s\n", args[0], hash, args[1])
+
+	return 0
+}
@@ -0,0 +1,331 @@
+// Package config is a k6-stat server and CLI configuration file (YAML or TOML) loader.
+//
+// Settings precedence (from lowest): defaults, config file, K6_STAT_* env, CLI flags.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/msaf1980/k6-stat/utils/tlsconfig"
+)
+
+// DefaultProfile is a ClickHouse profile name, used if profile is not selected
+const DefaultProfile = "default"
+
+// Duration is a time.Duration, encoded as string (like 5m)
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// TLS is a client TLS settings
+type TLS struct {
+	CA         string `yaml:"ca,omitempty" toml:"ca,omitempty"`
+	Cert       string `yaml:"cert,omitempty" toml:"cert,omitempty"`
+	Key        string `yaml:"key,omitempty" toml:"key,omitempty"`
+	ServerName string `yaml:"server-name,omitempty" toml:"server-name,omitempty"`
+	SkipVerify bool   `yaml:"skip-verify,omitempty" toml:"skip-verify,omitempty"`
+}
+
+type Tables struct {
+	Tests       string `yaml:"tests" toml:"tests"`
+	Samples     string `yaml:"samples" toml:"samples"`
+	Annotations string `yaml:"annotations,omitempty" toml:"annotations,omitempty"`
+	Rollup      string `yaml:"rollup,omitempty" toml:"rollup,omitempty"`
+	// Cluster is a cluster name for cluster deployment (distributed tables over local replicated tables)
+	Cluster string `yaml:"cluster,omitempty" toml:"cluster,omitempty"`
+}
+
+// Profile is a named ClickHouse connection settings
+type Profile struct {
+	Address  string `yaml:"address" toml:"address"`
+	Database string `yaml:"database" toml:"database"`
+	Params   string `yaml:"params,omitempty" toml:"params,omitempty"`
+	MaxConn  int    `yaml:"max-conn" toml:"max-conn"`
+	Tables   Tables `yaml:"tables" toml:"tables"`
+	TLS      TLS    `yaml:"tls,omitempty" toml:"tls,omitempty"`
+}
+
+// DSN return ClickHouse connection string
+func (p *Profile) DSN() string {
+	return p.Address + "/" + p.Database + "?" + p.Params
+}
+
+// Source return ClickHouse database identity (address with hidden password and database name)
+func (p *Profile) Source() string {
+	return redactAddress(p.Address) + "/" + p.Database
+}
+
+// TLSConfig return ClickHouse connection TLS settings
+func (p *Profile) TLSConfig() tlsconfig.Config {
+	return tlsconfig.Config{
+		CAFile:     p.TLS.CA,
+		CertFile:   p.TLS.Cert,
+		KeyFile:    p.TLS.Key,
+		ServerName: p.TLS.ServerName,
+		SkipVerify: p.TLS.SkipVerify,
+	}
+}
+
+type Cache struct {
+	// Size in MB (0 for disable)
+	Size           int      `yaml:"size" toml:"size"`
+	Dir            string   `yaml:"dir,omitempty" toml:"dir,omitempty"`
+	RunningTimeout Duration `yaml:"running-timeout" toml:"running-timeout"`
+}
+
+type ServerTLS struct {
+	Cert           string   `yaml:"cert,omitempty" toml:"cert,omitempty"`
+	Key            string   `yaml:"key,omitempty" toml:"key,omitempty"`
+	ClientCA       string   `yaml:"client-ca,omitempty" toml:"client-ca,omitempty"`
+	ClientAuth     string   `yaml:"client-auth,omitempty" toml:"client-auth,omitempty"`
+	ReloadInterval Duration `yaml:"reload-interval" toml:"reload-interval"`
+}
+
+type Auth struct {
+	TokensFile      string   `yaml:"tokens-file,omitempty" toml:"tokens-file,omitempty"`
+	UsersFile       string   `yaml:"users-file,omitempty" toml:"users-file,omitempty"`
+	ProxyHeader     string   `yaml:"proxy-header,omitempty" toml:"proxy-header,omitempty"`
+	ProxyRoleHeader string   `yaml:"proxy-role-header,omitempty" toml:"proxy-role-header,omitempty"`
+	TrustedProxies  []string `yaml:"trusted-proxies,omitempty" toml:"trusted-proxies,omitempty"`
+	ClientCertsFile string   `yaml:"client-certs-file,omitempty" toml:"client-certs-file,omitempty"`
+	AnonymousRole   string   `yaml:"anonymous-role,omitempty" toml:"anonymous-role,omitempty"`
+}
+
+type Metrics struct {
+	Enabled bool     `yaml:"enabled" toml:"enabled"`
+	Tests   []string `yaml:"tests,omitempty" toml:"tests,omitempty"`
+}
+
+type Webhook struct {
+	Url    string `yaml:"url" toml:"url"`
+	Format string `yaml:"format,omitempty" toml:"format,omitempty"`
+	// Template is an inline message template (conflict with TemplateFile)
+	Template     string `yaml:"template,omitempty" toml:"template,omitempty"`
+	TemplateFile string `yaml:"template-file,omitempty" toml:"template-file,omitempty"`
+}
+
+type Notify struct {
+	Webhooks        []Webhook `yaml:"webhooks,omitempty" toml:"webhooks,omitempty"`
+	Tests           []string  `yaml:"tests,omitempty" toml:"tests,omitempty"`
+	Interval        Duration  `yaml:"interval" toml:"interval"`
+	Lookback        Duration  `yaml:"lookback" toml:"lookback"`
+	Stats           []string  `yaml:"stats,omitempty" toml:"stats,omitempty"`
+	Threshold       float64   `yaml:"threshold" toml:"threshold"`
+	RegressionsOnly bool      `yaml:"regressions-only,omitempty" toml:"regressions-only,omitempty"`
+	Retries         int       `yaml:"retries" toml:"retries"`
+	Backoff         Duration  `yaml:"backoff" toml:"backoff"`
+}
+
+type Server struct {
+	Listen string `yaml:"listen" toml:"listen"`
+	// ShutdownTimeout is an active requests drain timeout on shutdown
+	ShutdownTimeout Duration  `yaml:"shutdown-timeout" toml:"shutdown-timeout"`
+	TLS             ServerTLS `yaml:"tls" toml:"tls"`
+	CorsOrigins     []string  `yaml:"cors-origins,omitempty" toml:"cors-origins,omitempty"`
+	Auth            Auth      `yaml:"auth" toml:"auth"`
+	Metrics         Metrics   `yaml:"metrics" toml:"metrics"`
+	Notify          Notify    `yaml:"notify" toml:"notify"`
+}
+
+// Config is a server and CLI settings
+type Config struct {
+	// Profile is a selected ClickHouse profile (DefaultProfile if empty)
+	Profile  string              `yaml:"profile" toml:"profile"`
+	Profiles map[string]*Profile `yaml:"profiles" toml:"profiles"`
+	Quantile string              `yaml:"quantile" toml:"quantile"`
+	// Stats is a durations statistics (used by CLI)
+	Stats  []string `yaml:"stats,omitempty" toml:"stats,omitempty"`
+	Cache  Cache    `yaml:"cache" toml:"cache"`
+	Server Server   `yaml:"server" toml:"server"`
+}
+
+// DefaultProfileSettings return ClickHouse profile with default settings
+func DefaultProfileSettings() *Profile {
+	return &Profile{
+		Address:  "http://localhost:8123",
+		Database: "default",
+		Params:   "dial_timeout=200ms&max_execution_time=60",
+		MaxConn:  10,
+		Tables:   Tables{Tests: "k6_tests", Samples: "k6_samples"},
+	}
+}
+
+// Default return config with default settings
+func Default() *Config {
+	return &Config{
+		Profile:  DefaultProfile,
+		Profiles: map[string]*Profile{DefaultProfile: DefaultProfileSettings()},
+		Quantile: "quantiles",
+		Cache:    Cache{RunningTimeout: Duration(5 * time.Minute)},
+		Server: Server{
+			Listen:          ":8080",
+			ShutdownTimeout: Duration(30 * time.Second),
+			TLS:             ServerTLS{ReloadInterval: Duration(10 * time.Second)},
+			Notify: Notify{
+				Interval: Duration(time.Minute), Lookback: Duration(24 * time.Hour), Threshold: 10, Retries: 3,
+				Backoff: Duration(time.Second),
+			},
+		},
+	}
+}
+
+func isTOML(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".toml")
+}
+
+// Load read config file (TOML for .toml extension, else YAML) over defaults. Empty path return defaults.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path == "" {
+		return cfg, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// profiles in file are merged with defaults (missed fields), default profile is replaced
+	cfg.Profiles = nil
+	if isTOML(path) {
+		md, err := toml.Decode(string(b), cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("%s: unknown field %s", path, undecoded[0])
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err = dec.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if len(cfg.Profiles) == 0 {
+		cfg.Profiles = map[string]*Profile{DefaultProfile: DefaultProfileSettings()}
+	}
+	for _, p := range cfg.Profiles {
+		if p != nil {
+			p.setDefaults()
+		}
+	}
+	return cfg, nil
+}
+
+func (p *Profile) setDefaults() {
+	d := DefaultProfileSettings()
+	if p.Address == "" {
+		p.Address = d.Address
+	}
+	if p.Database == "" {
+		p.Database = d.Database
+	}
+	if p.Params == "" {
+		p.Params = d.Params
+	}
+	if p.MaxConn == 0 {
+		p.MaxConn = d.MaxConn
+	}
+	if p.Tables.Tests == "" {
+		p.Tables.Tests = d.Tables.Tests
+	}
+	if p.Tables.Samples == "" {
+		p.Tables.Samples = d.Tables.Samples
+	}
+}
+
+// ProfileNames return sorted profiles names
+func (cfg *Config) ProfileNames() []string {
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SelectedProfile return selected ClickHouse profile
+func (cfg *Config) SelectedProfile() (*Profile, error) {
+	name := cfg.Profile
+	if name == "" {
+		name = DefaultProfile
+	}
+	p := cfg.Profiles[name]
+	if p == nil {
+		return nil, fmt.Errorf("profile %q not found, available: %s", name, strings.Join(cfg.ProfileNames(), ", "))
+	}
+	return p, nil
+}
+
+const redacted = "xxxxx"
+
+// redactAddress hide password in address user info
+func redactAddress(address string) string {
+	u, err := url.Parse(address)
+	if err != nil || u.User == nil {
+		return address
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+		return u.String()
+	}
+	return address
+}
+
+// redactParams hide password connection param
+func redactParams(params string) string {
+	values, err := url.ParseQuery(params)
+	if err != nil || values.Get("password") == "" {
+		return params
+	}
+	values.Set("password", redacted)
+	return values.Encode()
+}
+
+// Redacted return profile copy with hidden passwords in address and params
+func (p *Profile) Redacted() *Profile {
+	out := *p
+	out.Address = redactAddress(p.Address)
+	out.Params = redactParams(p.Params)
+	return &out
+}
+
+// Print write config in YAML format (passwords in ClickHouse address and params are hidden)
+func (cfg *Config) Print(w io.Writer) error {
+	out := *cfg
+	out.Profiles = make(map[string]*Profile, len(cfg.Profiles))
+	for name, p := range cfg.Profiles {
+		if p != nil {
+			p = p.Redacted()
+		}
+		out.Profiles[name] = p
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&out); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LookupFunc is an env lookup function, like os.LookupEnv
+type LookupFunc func(key string) (string, bool)
+
+type envSetter struct {
+	lookup LookupFunc
+	err    error
+}
+
+func (e *envSetter) get(key string) (string, bool) {
+	v, ok := e.lookup(key)
+	return v, ok && v != ""
+}
+
+func (e *envSetter) fail(key, v string, err error) {
+	if e.err == nil {
+		e.err = fmt.Errorf("%s=%q: %w", key, v, err)
+	}
+}
+
+func (e *envSetter) str(key string, p *string) {
+	if v, ok := e.get(key); ok {
+		*p = v
+	}
+}
+
+func (e *envSetter) list(key string, p *[]string) {
+	if v, ok := e.get(key); ok {
+		*p = strings.Split(v, ",")
+	}
+}
+
+func (e *envSetter) int(key string, p *int) {
+	if v, ok := e.get(key); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			e.fail(key, v, err)
+			return
+		}
+		*p = n
+	}
+}
+
+func (e *envSetter) float(key string, p *float64) {
+	if v, ok := e.get(key); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			e.fail(key, v, err)
+			return
+		}
+		*p = f
+	}
+}
+
+func (e *envSetter) bool(key string, p *bool) {
+	if v, ok := e.get(key); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			e.fail(key, v, err)
+			return
+		}
+		*p = b
+	}
+}
+
+func (e *envSetter) duration(key string, p *Duration) {
+	if v, ok := e.get(key); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			e.fail(key, v, err)
+			return
+		}
+		*p = Duration(d)
+	}
+}
+
+// ApplyEnv override config settings with K6_STAT_* env variables.
+// ClickHouse settings (K6_STAT_DB_*, K6_STAT_TABLE_*) are applied to the selected (with K6_STAT_PROFILE) profile.
+func (cfg *Config) ApplyEnv(lookup LookupFunc) error {
+	e := &envSetter{lookup: lookup}
+
+	e.str("K6_STAT_PROFILE", &cfg.Profile)
+	if cfg.Profile == "" {
+		cfg.Profile = DefaultProfile
+	}
+	// not found profile is reported by Validate
+	if p := cfg.Profiles[cfg.Profile]; p != nil {
+		e.str("K6_STAT_DB_ADDR", &p.Address)
+		e.str("K6_STAT_DB", &p.Database)
+		e.str("K6_STAT_DB_PARAM", &p.Params)
+		e.int("K6_STAT_DB_MAX_CONN", &p.MaxConn)
+		e.str("K6_STAT_TABLE_TESTS", &p.Tables.Tests)
+		e.str("K6_STAT_TABLE_SAMPLES", &p.Tables.Samples)
+		e.str("K6_STAT_TABLE_ANNOTATIONS", &p.Tables.Annotations)
+		e.str("K6_STAT_TABLE_ROLLUP", &p.Tables.Rollup)
+		e.str("K6_STAT_CLUSTER", &p.Tables.Cluster)
+		e.str("K6_STAT_DB_TLS_CA", &p.TLS.CA)
+		e.str("K6_STAT_DB_TLS_CERT", &p.TLS.Cert)
+		e.str("K6_STAT_DB_TLS_KEY", &p.TLS.Key)
+		e.str("K6_STAT_DB_TLS_SERVER_NAME", &p.TLS.ServerName)
+		e.bool("K6_STAT_DB_TLS_SKIP_VERIFY", &p.TLS.SkipVerify)
+	}
+
+	e.str("K6_STAT_QUANTILE", &cfg.Quantile)
+	e.list("K6_STAT_STATS", &cfg.Stats)
+
+	e.int("K6_STAT_CACHE_SIZE", &cfg.Cache.Size)
+	e.str("K6_STAT_CACHE_DIR", &cfg.Cache.Dir)
+	e.duration("K6_STAT_RUNNING_TIMEOUT", &cfg.Cache.RunningTimeout)
+
+	s := &cfg.Server
+	e.str("K6_STAT_LISTEN", &s.Listen)
+	e.duration("K6_STAT_SHUTDOWN_TIMEOUT", &s.ShutdownTimeout)
+	e.bool("K6_STAT_METRICS", &s.Metrics.Enabled)
+	e.list("K6_STAT_METRICS_TESTS", &s.Metrics.Tests)
+
+	// webhooks in format [generic|slack|mattermost=]url, comma-separated
+	if v, ok := e.get("K6_STAT_NOTIFY_WEBHOOKS"); ok {
+		template, _ := e.get("K6_STAT_NOTIFY_TEMPLATE")
+		s.Notify.Webhooks = nil
+		for _, w := range strings.Split(v, ",") {
+			webhook := Webhook{Url: w, TemplateFile: template}
+			if format, url, ok := strings.Cut(w, "="); ok && !strings.Contains(format, "/") {
+				webhook.Format = format
+				webhook.Url = url
+			}
+			s.Notify.Webhooks = append(s.Notify.Webhooks, webhook)
+		}
+	}
+	e.list("K6_STAT_NOTIFY_TESTS", &s.Notify.Tests)
+	e.duration("K6_STAT_NOTIFY_INTERVAL", &s.Notify.Interval)
+	e.float("K6_STAT_NOTIFY_THRESHOLD", &s.Notify.Threshold)
+	e.bool("K6_STAT_NOTIFY_REGRESSIONS_ONLY", &s.Notify.RegressionsOnly)
+	e.int("K6_STAT_NOTIFY_RETRIES", &s.Notify.Retries)
+	e.duration("K6_STAT_NOTIFY_BACKOFF", &s.Notify.Backoff)
+
+	e.str("K6_STAT_AUTH_TOKENS_FILE", &s.Auth.TokensFile)
+	e.str("K6_STAT_AUTH_USERS_FILE", &s.Auth.UsersFile)
+	e.str("K6_STAT_AUTH_PROXY_HEADER", &s.Auth.ProxyHeader)
+	e.str("K6_STAT_AUTH_PROXY_ROLE_HEADER", &s.Auth.ProxyRoleHeader)
+	e.list("K6_STAT_AUTH_TRUSTED_PROXIES", &s.Auth.TrustedProxies)
+	e.str("K6_STAT_AUTH_CLIENT_CERTS_FILE", &s.Auth.ClientCertsFile)
+	e.str("K6_STAT_AUTH_ANONYMOUS_ROLE", &s.Auth.AnonymousRole)
+	e.list("K6_STAT_CORS_ORIGINS", &s.CorsOrigins)
+
+	e.str("K6_STAT_TLS_CERT", &s.TLS.Cert)
+	e.str("K6_STAT_TLS_KEY", &s.TLS.Key)
+	e.str("K6_STAT_TLS_CLIENT_CA", &s.TLS.ClientCA)
+	e.str("K6_STAT_TLS_CLIENT_AUTH", &s.TLS.ClientAuth)
+	e.duration("K6_STAT_TLS_RELOAD_INTERVAL", &s.TLS.ReloadInterval)
+
+	return e.err
+}
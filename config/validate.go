@@ -0,0 +1,184 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/msaf1980/k6-stat/dbs"
+)
+
+// ValidationError is a config validation errors list (with setting path, like profiles.prod.address)
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config:\n  " + strings.Join(e.Errors, "\n  ")
+}
+
+func (e *ValidationError) add(path string, format string, args ...interface{}) {
+	e.Errors = append(e.Errors, path+": "+fmt.Sprintf(format, args...))
+}
+
+var (
+	roles         = []string{"read", "write", "admin"}
+	clientAuths   = []string{"none", "optional", "require"}
+	webhookFormat = []string{"generic", "slack", "mattermost"}
+)
+
+func oneOf(s string, values []string) bool {
+	for _, v := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *ValidationError) profile(name string, p *Profile) {
+	path := "profiles." + name
+	if p == nil {
+		e.add(path, "empty profile")
+		return
+	}
+	if u, err := url.Parse(p.Address); err != nil {
+		e.add(path+".address", "%v", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "clickhouse" {
+		e.add(path+".address", "invalid scheme in %q, must be http, https or clickhouse", p.Address)
+	} else if u.Host == "" {
+		e.add(path+".address", "host not set in %q", p.Address)
+	}
+	if p.Database == "" {
+		e.add(path+".database", "not set")
+	}
+	if p.MaxConn <= 0 {
+		e.add(path+".max-conn", "must be positive, got %d", p.MaxConn)
+	}
+	if p.Tables.Tests == "" {
+		e.add(path+".tables.tests", "not set")
+	}
+	if p.Tables.Samples == "" {
+		e.add(path+".tables.samples", "not set")
+	}
+	if (p.TLS.Cert == "") != (p.TLS.Key == "") {
+		e.add(path+".tls", "cert and key must be set together")
+	}
+}
+
+func (e *ValidationError) err() error {
+	if len(e.Errors) > 0 {
+		return e
+	}
+	return nil
+}
+
+// Validate check settings and return *ValidationError with all found errors
+func (cfg *Config) Validate() error {
+	e := &ValidationError{}
+
+	if len(cfg.Profiles) == 0 {
+		e.add("profiles", "no profiles defined")
+	}
+	for _, name := range cfg.ProfileNames() {
+		e.profile(name, cfg.Profiles[name])
+	}
+	if _, err := cfg.SelectedProfile(); err != nil {
+		e.add("profile", "%v", err)
+	}
+	cfg.validateCommon(e)
+	cfg.validateServer(e)
+
+	return e.err()
+}
+
+// ValidateClient check settings, used by CLI: the selected profile (validated after overrides with env and flags)
+// and common settings. Other profiles are validated on connect (with ValidateProfile), server section is not checked.
+func (cfg *Config) ValidateClient() error {
+	e := &ValidationError{}
+
+	if p, err := cfg.SelectedProfile(); err != nil {
+		e.add("profile", "%v", err)
+	} else if cfg.Profile == "" {
+		e.profile(DefaultProfile, p)
+	} else {
+		e.profile(cfg.Profile, p)
+	}
+	cfg.validateCommon(e)
+
+	return e.err()
+}
+
+// ValidateProfile check ClickHouse profile settings
+func ValidateProfile(name string, p *Profile) error {
+	e := &ValidationError{}
+	e.profile(name, p)
+	return e.err()
+}
+
+func (cfg *Config) validateCommon(e *ValidationError) {
+	if _, err := dbs.QuantileFuncFromString(cfg.Quantile); err != nil {
+		e.add("quantile", "%v", err)
+	}
+	if len(cfg.Stats) > 0 {
+		if _, err := dbs.ParseStats(cfg.Stats); err != nil {
+			e.add("stats", "%v", err)
+		}
+	}
+	if cfg.Cache.Size < 0 {
+		e.add("cache.size", "must be positive or 0 (disabled), got %d", cfg.Cache.Size)
+	}
+	if cfg.Cache.RunningTimeout < 0 {
+		e.add("cache.running-timeout", "must be positive")
+	}
+}
+
+func (cfg *Config) validateServer(e *ValidationError) {
+	s := &cfg.Server
+	if _, _, err := net.SplitHostPort(s.Listen); err != nil {
+		e.add("server.listen", "%v", err)
+	}
+	if s.ShutdownTimeout < 0 {
+		e.add("server.shutdown-timeout", "must be positive or 0 (no limit)")
+	}
+	if (s.TLS.Cert == "") != (s.TLS.Key == "") {
+		e.add("server.tls", "cert and key must be set together")
+	}
+	if s.TLS.ClientAuth != "" && !oneOf(s.TLS.ClientAuth, clientAuths) {
+		e.add("server.tls.client-auth", "invalid value %q, must be %s", s.TLS.ClientAuth, strings.Join(clientAuths, ", "))
+	} else if s.TLS.ClientAuth != "" && s.TLS.ClientAuth != "none" && s.TLS.ClientCA == "" {
+		e.add("server.tls.client-ca", "required for client auth %q", s.TLS.ClientAuth)
+	}
+	if s.Auth.AnonymousRole != "" && !oneOf(s.Auth.AnonymousRole, roles) {
+		e.add("server.auth.anonymous-role", "invalid role %q, must be %s", s.Auth.AnonymousRole, strings.Join(roles, ", "))
+	}
+	if s.Auth.ProxyHeader != "" && len(s.Auth.TrustedProxies) == 0 {
+		e.add("server.auth.trusted-proxies", "required for proxy-header")
+	}
+	if s.Auth.ClientCertsFile != "" && s.TLS.ClientCA == "" {
+		e.add("server.auth.client-certs-file", "server.tls.client-ca required for client certificates")
+	}
+	for i, w := range s.Notify.Webhooks {
+		path := fmt.Sprintf("server.notify.webhooks[%d]", i)
+		if u, err := url.Parse(w.Url); err != nil {
+			e.add(path+".url", "%v", err)
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			e.add(path+".url", "invalid url %q, must be http or https", w.Url)
+		}
+		if w.Format != "" && !oneOf(w.Format, webhookFormat) {
+			e.add(path+".format", "invalid format %q, must be %s", w.Format, strings.Join(webhookFormat, ", "))
+		}
+		if w.Template != "" && w.TemplateFile != "" {
+			e.add(path, "template and template-file are mutually exclusive")
+		}
+	}
+	if len(s.Notify.Stats) > 0 {
+		if _, err := dbs.ParseStats(s.Notify.Stats); err != nil {
+			e.add("server.notify.stats", "%v", err)
+		}
+	}
+	if s.Notify.Threshold < 0 {
+		e.add("server.notify.threshold", "must be positive")
+	}
+}
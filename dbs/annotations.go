@@ -0,0 +1,259 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/msaf1980/go-stringutils"
+	"github.com/msaf1980/go-timeutils"
+	"github.com/msaf1980/k6-stat/migrate"
+)
+
+var (
+	ErrAnnotationsDisabled = NewQueryError(errors.New("annotations table not set"), http.StatusNotImplemented, "")
+	ErrTestNotFound        = errors.New("test not found")
+)
+
+// Annotation is a test annotation, stored in companion table
+type Annotation struct {
+	Id      uint64    `json:"id"`
+	Ts      time.Time `json:"ts"`
+	Name    string    `json:"name,omitempty"` // display name (original test name is not changed)
+	Text    string    `json:"text,omitempty"`
+	Labels  []string  `json:"labels,omitempty"`
+	Hidden  bool      `json:"hidden,omitempty"` // hidden (archived) tests are excluded from tests listing
+	Updated time.Time `json:"updated"`
+}
+
+// TestPatch is a test annotation update (nil fields are not changed)
+type TestPatch struct {
+	Id     uint64    `json:"id"`
+	Time   int64     `json:"time"` // epoch nanoseconds
+	Name   *string   `json:"name,omitempty"`
+	Text   *string   `json:"text,omitempty"`
+	Labels *[]string `json:"labels,omitempty"`
+	Hidden *bool     `json:"hidden,omitempty"`
+}
+
+// SetTableAnnotations set annotations table (empty for disable annotations)
+func (d *DB) SetTableAnnotations(tableAnnotations string) {
+	d.tableAnnotations = tableAnnotations
+}
+
+func (d *DB) TableAnnotations() string {
+	return d.tableAnnotations
+}
+
+// writeAnnotatedTests write subquery for tests (id, ts), annotated with condition
+func (d *DB) writeAnnotatedTests(query *stringutils.Builder, cond string) {
+	_, _ = query.WriteString("(id, ts) IN (SELECT id, ts FROM ")
+	_, _ = query.WriteString(d.tableAnnotations)
+	_, _ = query.WriteString(" FINAL WHERE ")
+	_, _ = query.WriteString(cond)
+	_ = query.WriteByte(')')
+}
+
+// loadAnnotations fill annotations for tests
+func (d *DB) loadAnnotations(ctx context.Context, tests []Test) *QueryError {
+	if d.tableAnnotations == "" || len(tests) == 0 {
+		return nil
+	}
+
+	var query stringutils.Builder
+
+	query.Grow(128)
+	_, _ = query.WriteString("SELECT id, ts, name, text, labels, hidden, updated FROM ")
+	_, _ = query.WriteString(d.tableAnnotations)
+	_, _ = query.WriteString(" FINAL WHERE id IN (")
+	for i := range tests {
+		if i > 0 {
+			_, _ = query.WriteString(", ")
+		}
+		_, _ = query.WriteString(strconv.FormatUint(tests[i].Id, 10))
+	}
+	_ = query.WriteByte(')')
+
+	rows, err := d.db.QueryContext(ctx, query.String())
+	if err != nil {
+		return NewQueryError(err, 0, query.String())
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			a      Annotation
+			hidden uint8
+		)
+		err = rows.Scan(&a.Id, &a.Ts, &a.Name, &a.Text, &a.Labels, &hidden, &a.Updated)
+		if err != nil {
+			return NewQueryError(err, 0, query.String())
+		}
+		a.Hidden = hidden == 1
+		for i := range tests {
+			if tests[i].Id == a.Id && tests[i].Ts.Equal(a.Ts) {
+				annotation := a
+				tests[i].Annotation = &annotation
+				break
+			}
+		}
+	}
+	// get any error encountered during iteration
+	err = rows.Err()
+	if err != nil {
+		return NewQueryError(err, 0, query.String())
+	}
+
+	return nil
+}
+
+// AnnotateTest update test annotation (name, text, labels or hidden state) and return it
+func (d *DB) AnnotateTest(ctx context.Context, p TestPatch) (*Annotation, *QueryError) {
+	return observed(d, "annotate", func() (*Annotation, *QueryError) { return d.annotateTest(ctx, p) })
+}
+
+func (d *DB) annotateTest(ctx context.Context, p TestPatch) (*Annotation, *QueryError) {
+	if d.tableAnnotations == "" {
+		return nil, ErrAnnotationsDisabled
+	}
+
+	var query stringutils.Builder
+
+	ts := timeutils.UnixNano(p.Time).UTC()
+
+	if qErr := d.checkTestExist(ctx, p.Id, ts); qErr != nil {
+		return nil, qErr
+	}
+
+	tests := []Test{{Id: p.Id, Ts: ts}}
+	if qErr := d.loadAnnotations(ctx, tests); qErr != nil {
+		return nil, qErr
+	}
+	a := tests[0].Annotation
+	if a == nil {
+		a = &Annotation{Id: p.Id}
+	}
+	a.Ts = ts
+	if p.Name != nil {
+		a.Name = *p.Name
+	}
+	if p.Text != nil {
+		a.Text = *p.Text
+	}
+	if p.Labels != nil {
+		a.Labels = *p.Labels
+	}
+	if p.Hidden != nil {
+		a.Hidden = *p.Hidden
+	}
+	a.Updated = time.Now().UTC().Truncate(time.Millisecond)
+
+	var hidden uint8
+	if a.Hidden {
+		hidden = 1
+	}
+	labels := a.Labels
+	if labels == nil {
+		labels = []string{}
+	}
+
+	query.Reset()
+	_, _ = query.WriteString("INSERT INTO ")
+	_, _ = query.WriteString(d.tableAnnotations)
+	_, _ = query.WriteString(" (id, ts, name, text, labels, hidden, updated)")
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, NewQueryError(err, 0, query.String())
+	}
+	stmt, err := tx.PrepareContext(ctx, query.String())
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, NewQueryError(err, 0, query.String())
+	}
+	if _, err = stmt.ExecContext(ctx, a.Id, a.Ts, a.Name, a.Text, labels, hidden, a.Updated); err != nil {
+		_ = tx.Rollback()
+		return nil, NewQueryError(err, 0, query.String())
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, NewQueryError(err, 0, query.String())
+	}
+
+	return a, nil
+}
+
+// checkTestExist return not found error, if test not exist
+func (d *DB) checkTestExist(ctx context.Context, id uint64, ts time.Time) *QueryError {
+	var query stringutils.Builder
+
+	query.Grow(64)
+	_, _ = query.WriteString("SELECT count() FROM ")
+	_, _ = query.WriteString(d.tableTests)
+	_, _ = query.WriteString(" WHERE id = @Id AND ts = @Time")
+
+	var count uint64
+	if err := d.db.QueryRowContext(ctx, query.String(), clickhouse.Named("Id", id), clickhouse.DateNamed("Time", ts, 3)).Scan(&count); err != nil {
+		return NewQueryError(err, 0, query.String())
+	}
+	if count == 0 {
+		return NewQueryError(ErrTestNotFound, http.StatusNotFound, "")
+	}
+
+	return nil
+}
+
+// DeleteTest delete test with samples, rollup and annotation. Test record is deleted last, so partially deleted test
+// is still listed and delete can be retried. Lightweight delete is used for single node, in cluster deployment
+// mutations are executed ON CLUSTER on local (replicated) tables (lightweight delete is not supported for distributed tables).
+func (d *DB) DeleteTest(ctx context.Context, f TestIdFilter) *QueryError {
+	_, qErr := observed(d, "delete", func() (struct{}, *QueryError) { return struct{}{}, d.deleteTest(ctx, f) })
+	return qErr
+}
+
+func (d *DB) deleteTest(ctx context.Context, f TestIdFilter) *QueryError {
+	ts := timeutils.UnixNano(f.Time).UTC()
+
+	if qErr := d.checkTestExist(ctx, f.Id, ts); qErr != nil {
+		return qErr
+	}
+
+	defer d.invalidateCache(f.Id, f.Time)
+
+	tables := []string{d.tableSamples}
+	if d.hasRollup(ctx) {
+		tables = append(tables, d.tableRollup)
+	}
+	if d.tableAnnotations != "" {
+		tables = append(tables, d.tableAnnotations)
+	}
+	tables = append(tables, d.tableTests)
+	for _, table := range tables {
+		var query stringutils.Builder
+
+		query.Grow(128)
+		if d.cluster == "" {
+			_, _ = query.WriteString("DELETE FROM ")
+			_, _ = query.WriteString(table)
+		} else {
+			_, _ = query.WriteString("ALTER TABLE ")
+			_, _ = query.WriteString(table)
+			_, _ = query.WriteString(migrate.LocalSuffix)
+			_, _ = query.WriteString(" ON CLUSTER ")
+			_, _ = query.WriteString(d.cluster)
+			_, _ = query.WriteString(" DELETE")
+		}
+		if table == d.tableSamples || table == d.tableRollup {
+			_, _ = query.WriteString(" WHERE id = @Id AND start = @Time")
+		} else {
+			_, _ = query.WriteString(" WHERE id = @Id AND ts = @Time")
+		}
+
+		if _, err := d.db.ExecContext(ctx, query.String(), clickhouse.Named("Id", f.Id), clickhouse.DateNamed("Time", ts, 3)); err != nil {
+			return NewQueryError(err, 0, query.String())
+		}
+	}
+
+	return nil
+}
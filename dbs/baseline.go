@@ -0,0 +1,56 @@
+package dbs
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// BaselineLabel is an annotation label for mark test as a baseline (reference) for the next runs with the same name
+const BaselineLabel = "baseline"
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// EscapeLike escape LIKE pattern special characters, so name is matched literally
+func EscapeLike(name string) string {
+	return likeEscaper.Replace(name)
+}
+
+// GetLatestTest return the most recent test with name (LIKE format)
+func (d *DB) GetLatestTest(ctx context.Context, name string) (Test, *QueryError) {
+	tests, qErr := d.GetTests(ctx, TestFilter{Name: name, Desc: true, Limit: 1})
+	if qErr != nil {
+		return Test{}, qErr
+	}
+	if len(tests) == 0 {
+		return Test{}, NewQueryError(ErrTestNotFound, http.StatusNotFound, "")
+	}
+	return tests[0], nil
+}
+
+// GetBaseline return baseline for test: the most recent test with the same name, annotated with BaselineLabel
+// (if annotations are enabled), else the previous run
+func (d *DB) GetBaseline(ctx context.Context, test Test) (Test, *QueryError) {
+	name := EscapeLike(test.Name)
+	if d.tableAnnotations != "" {
+		tests, qErr := d.GetTests(ctx, TestFilter{Name: name, Labels: []string{BaselineLabel}, Desc: true, Limit: 2})
+		if qErr != nil {
+			return Test{}, qErr
+		}
+		for _, t := range tests {
+			if t.Id != test.Id || !t.Ts.Equal(test.Ts) {
+				return t, nil
+			}
+		}
+	}
+	tests, qErr := d.GetTests(ctx, TestFilter{Name: name, Until: test.Ts.Unix() + 1, Desc: true, Limit: 2})
+	if qErr != nil {
+		return Test{}, qErr
+	}
+	for _, t := range tests {
+		if t.Ts.Before(test.Ts) {
+			return t, nil
+		}
+	}
+	return Test{}, NewQueryError(ErrTestNotFound, http.StatusNotFound, "")
+}
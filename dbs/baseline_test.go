@@ -0,0 +1,44 @@
+package dbs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBaseline(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(db, "t_k6_tests", "t_k6_samples")
+	d.SetTableAnnotations("t_k6_tests_annotations")
+
+	test := Test{Id: 3, Ts: time.Unix(1674196900, 0).UTC(), Name: "graphite_nightly"}
+	prev := Test{Id: 2, Ts: time.Unix(1674110500, 0).UTC(), Name: "graphite_nightly"}
+	annRows := []string{"id", "ts", "name", "text", "labels", "hidden", "updated"}
+
+	// the latest run is annotated as baseline, use the previous run
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE name LIKE \? AND \(id, ts\) IN \(SELECT id, ts FROM t_k6_tests_annotations FINAL WHERE hasAll\(labels, \?\)\) .* ORDER BY ts DESC, id DESC, name LIMIT 2$`).
+		WithArgs(`graphite\_nightly`, []string{BaselineLabel}).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}).AddRow(test.Id, test.Ts, test.Name, ""))
+	mock.ExpectQuery(`^SELECT id, ts, name, text, labels, hidden, updated FROM t_k6_tests_annotations FINAL WHERE id IN \(3\)$`).
+		WillReturnRows(mock.NewRows(annRows).AddRow(test.Id, test.Ts, "", "", []string{BaselineLabel}, uint8(0), test.Ts))
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts < \? AND name LIKE \? .* ORDER BY ts DESC, id DESC, name LIMIT 2$`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}).
+			AddRow(test.Id, test.Ts, test.Name, "").
+			AddRow(prev.Id, prev.Ts, prev.Name, ""))
+	mock.ExpectQuery(`^SELECT id, ts, name, text, labels, hidden, updated FROM t_k6_tests_annotations FINAL WHERE id IN \(3, 2\)$`).
+		WillReturnRows(mock.NewRows(annRows))
+
+	baseline, qErr := d.GetBaseline(context.Background(), test)
+	if qErr != nil {
+		t.Fatal(qErr)
+	}
+	assert.Equal(t, prev.Id, baseline.Id)
+	assert.Equal(t, prev.Ts, baseline.Ts)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,387 @@
+package dbs
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/msaf1980/go-timeutils"
+)
+
+// DefaultRunningTimeout is a default timeout from the last sample, after that test is assumed finished
+const DefaultRunningTimeout = 5 * time.Minute
+
+// maxFinishedTests is a memorized finished tests states limit
+const maxFinishedTests = 10000
+
+// CacheStats is a samples cache statistic
+type CacheStats struct {
+	Items    int    `json:"items"`
+	Size     int64  `json:"size"`     // bytes
+	MaxSize  int64  `json:"max-size"` // bytes
+	Dir      string `json:"dir,omitempty"`
+	DiskSize int64  `json:"disk-size,omitempty"` // bytes (limited by MaxSize)
+	Hits     uint64 `json:"hits"`
+	DiskHits uint64 `json:"disk-hits"` // hits from disk cache (also counted in Hits)
+	Misses   uint64 `json:"misses"`
+	Bypass   uint64 `json:"bypass"` // queries for running tests (not cached)
+}
+
+type cacheItem struct {
+	key   string
+	value []byte
+}
+
+// Cache is an in-memory LRU (limited by size) cache of finished tests samples queries results,
+// with optional on-disk cache directory (limited by the same size, the least recently used files are removed).
+type Cache struct {
+	// counters are accessed with atomic functions (placed first for 64-bit alignment)
+	hits     uint64
+	diskHits uint64
+	misses   uint64
+	bypass   uint64
+
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	ll      *list.List
+	items   map[string]*list.Element
+	dir     string
+
+	diskMu   sync.Mutex
+	diskSize int64
+}
+
+// NewCache create cache with maxSize (bytes) memory limit and optional dir for on-disk cache
+func NewCache(maxSize int64, dir string) (*Cache, error) {
+	c := &Cache{maxSize: maxSize, ll: list.New(), items: make(map[string]*list.Element), dir: dir}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return nil, err
+		}
+		c.diskMu.Lock()
+		err := c.pruneDisk()
+		c.diskMu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// cacheKey return key, prefixed with test id and start (for invalidate test results).
+// Key is unique for database (cache scope) and tables, so cache (and cache dir) can be shared between connections.
+func (d *DB) cacheKey(kind string, id uint64, start int64, f any) string {
+	b, _ := json.Marshal(f)
+	h := sha256.New()
+	for _, s := range []string{d.cacheScope, d.tableSamples, d.tableRollup, d.cluster, kind} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	h.Write(b)
+	return testCachePrefix(id, start) + hex.EncodeToString(h.Sum(nil))
+}
+
+func testCachePrefix(id uint64, start int64) string {
+	return strconv.FormatUint(id, 10) + "_" + strconv.FormatInt(start, 10) + "_"
+}
+
+func (c *Cache) getMem(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		return e.Value.(*cacheItem).value, true
+	}
+	return nil, false
+}
+
+func (c *Cache) putMem(key string, value []byte) {
+	if int64(len(value)) > c.maxSize {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		item := e.Value.(*cacheItem)
+		c.size += int64(len(value) - len(item.value))
+		item.value = value
+		c.ll.MoveToFront(e)
+	} else {
+		c.items[key] = c.ll.PushFront(&cacheItem{key: key, value: value})
+		c.size += int64(len(value))
+	}
+	for c.size > c.maxSize {
+		e := c.ll.Back()
+		item := e.Value.(*cacheItem)
+		c.ll.Remove(e)
+		delete(c.items, item.key)
+		c.size -= int64(len(item.value))
+	}
+}
+
+func (c *Cache) get(key string, v any) bool {
+	b, ok := c.getMem(key)
+	if !ok && c.dir != "" {
+		var err error
+		if b, err = os.ReadFile(filepath.Join(c.dir, key+".json")); err == nil {
+			ok = true
+			atomic.AddUint64(&c.diskHits, 1)
+			c.putMem(key, b)
+			// update modification time for the least recently used files eviction
+			now := time.Now()
+			_ = os.Chtimes(filepath.Join(c.dir, key+".json"), now, now)
+		}
+	}
+	if ok && json.Unmarshal(b, v) == nil {
+		atomic.AddUint64(&c.hits, 1)
+		return true
+	}
+	atomic.AddUint64(&c.misses, 1)
+	return false
+}
+
+func (c *Cache) put(key string, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	c.putMem(key, b)
+	if c.dir != "" && int64(len(b)) <= c.maxSize {
+		c.diskMu.Lock()
+		defer c.diskMu.Unlock()
+		// write to temporary file and rename for avoid partial reads
+		path := filepath.Join(c.dir, key+".json")
+		tmp := path + ".tmp"
+		if err = os.WriteFile(tmp, b, 0640); err == nil {
+			var prev int64
+			if st, err := os.Stat(path); err == nil {
+				prev = st.Size()
+			}
+			if err = os.Rename(tmp, path); err != nil {
+				_ = os.Remove(tmp)
+			} else {
+				c.diskSize += int64(len(b)) - prev
+			}
+		}
+		if c.diskSize > c.maxSize {
+			_ = c.pruneDisk()
+		}
+	}
+}
+
+// pruneDisk recalculate on-disk cache size and remove the least recently used files above maxSize (must be called with diskMu locked)
+func (c *Cache) pruneDisk() error {
+	files, err := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	type fileInfo struct {
+		path  string
+		size  int64
+		mtime time.Time
+	}
+	infos := make([]fileInfo, 0, len(files))
+	c.diskSize = 0
+	for _, file := range files {
+		st, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		infos = append(infos, fileInfo{path: file, size: st.Size(), mtime: st.ModTime()})
+		c.diskSize += st.Size()
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].mtime.Before(infos[j].mtime) })
+	for _, info := range infos {
+		if c.diskSize <= c.maxSize {
+			break
+		}
+		if err = os.Remove(info.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		c.diskSize -= info.size
+	}
+	return nil
+}
+
+// Remove drop cached test results (in memory and on disk)
+func (c *Cache) Remove(id uint64, start int64) error {
+	prefix := testCachePrefix(id, start)
+	c.mu.Lock()
+	for key, e := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.ll.Remove(e)
+			delete(c.items, key)
+			c.size -= int64(len(e.Value.(*cacheItem).value))
+		}
+	}
+	c.mu.Unlock()
+	if c.dir != "" {
+		c.diskMu.Lock()
+		defer c.diskMu.Unlock()
+		err := removeFiles(filepath.Join(c.dir, prefix+"*.json"))
+		if pErr := c.pruneDisk(); err == nil {
+			err = pErr
+		}
+		return err
+	}
+	return nil
+}
+
+func removeFiles(pattern string) error {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err = os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Clear drop cached items (in memory and on disk)
+func (c *Cache) Clear() error {
+	c.mu.Lock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.size = 0
+	c.mu.Unlock()
+	if c.dir != "" {
+		c.diskMu.Lock()
+		defer c.diskMu.Unlock()
+		err := removeFiles(filepath.Join(c.dir, "*.json"))
+		if pErr := c.pruneDisk(); err == nil {
+			err = pErr
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	items, size := len(c.items), c.size
+	c.mu.Unlock()
+	c.diskMu.Lock()
+	diskSize := c.diskSize
+	c.diskMu.Unlock()
+	return CacheStats{
+		Items: items, Size: size, MaxSize: c.maxSize, Dir: c.dir, DiskSize: diskSize,
+		Hits: atomic.LoadUint64(&c.hits), DiskHits: atomic.LoadUint64(&c.diskHits),
+		Misses: atomic.LoadUint64(&c.misses), Bypass: atomic.LoadUint64(&c.bypass),
+	}
+}
+
+// SetCache set samples queries cache (nil for disable) and running test detection timeout (from the last sample)
+func (d *DB) SetCache(cache *Cache, runningTimeout time.Duration) {
+	d.cache = cache
+	d.SetRunningTimeout(runningTimeout)
+}
+
+// SetRunningTimeout set timeout from the last test sample, after that test is assumed finished (DefaultRunningTimeout if zero)
+func (d *DB) SetRunningTimeout(runningTimeout time.Duration) {
+	if runningTimeout <= 0 {
+		runningTimeout = DefaultRunningTimeout
+	}
+	d.runningTimeout = runningTimeout
+}
+
+// SetCacheScope set database identity (like address and database name) for cache keys,
+// so the cache dir can be shared between different databases
+func (d *DB) SetCacheScope(scope string) {
+	d.cacheScope = scope
+}
+
+func (d *DB) Cache() *Cache {
+	return d.cache
+}
+
+type testKey struct {
+	Id    uint64
+	Start int64
+}
+
+// TestFinished check, that test is finished (no samples in running timeout)
+func (d *DB) TestFinished(ctx context.Context, test Test) bool {
+	return d.testFinished(ctx, test.Id, test.Ts.UnixNano())
+}
+
+// testFinished check, that test is finished (no samples in runningTimeout). Finished state is memorized.
+func (d *DB) testFinished(ctx context.Context, id uint64, start int64) bool {
+	key := testKey{Id: id, Start: start}
+	d.finishedMu.Lock()
+	finished := d.finished[key]
+	d.finishedMu.Unlock()
+	if finished {
+		return true
+	}
+
+	var query = "SELECT max(ts) FROM " + d.tableSamples + " WHERE id = @Id AND start = @Time"
+	var last time.Time
+	if err := d.db.QueryRowContext(
+		ctx, query, clickhouse.Named("Id", id), clickhouse.DateNamed("Time", timeutils.UnixNano(start).UTC(), 3),
+	).Scan(&last); err != nil {
+		return false
+	}
+	// no samples (max return zero time) or running test
+	if last.Unix() <= 0 || time.Since(last) < d.runningTimeout {
+		return false
+	}
+	d.finishedMu.Lock()
+	if d.finished == nil {
+		d.finished = make(map[testKey]bool)
+	} else if len(d.finished) >= maxFinishedTests {
+		// drop random state, it's just recheck by query
+		for k := range d.finished {
+			delete(d.finished, k)
+			break
+		}
+	}
+	d.finished[key] = true
+	d.finishedMu.Unlock()
+	return true
+}
+
+// invalidateCache drop cached test results and finished state
+func (d *DB) invalidateCache(id uint64, start int64) {
+	d.finishedMu.Lock()
+	delete(d.finished, testKey{Id: id, Start: start})
+	d.finishedMu.Unlock()
+	if d.cache != nil {
+		_ = d.cache.Remove(id, start)
+	}
+}
+
+// cachedQuery return cached query result or load it (and cache, if test is finished)
+func cachedQuery[T any](ctx context.Context, d *DB, kind string, id uint64, start int64, f any, load func() (T, *QueryError)) (T, *QueryError) {
+	if d.cache == nil {
+		return observed(d, kind, load)
+	}
+	key := d.cacheKey(kind, id, start, f)
+	var v T
+	if d.cache.get(key, &v) {
+		return v, nil
+	}
+	v, qErr := observed(d, kind, load)
+	if qErr == nil {
+		if d.testFinished(ctx, id, start) {
+			d.cache.put(key, v)
+		} else {
+			atomic.AddUint64(&d.cache.bypass, 1)
+		}
+	}
+	return v, qErr
+}
@@ -0,0 +1,136 @@
+package dbs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheLRU(t *testing.T) {
+	c, err := NewCache(20, "")
+	require.NoError(t, err)
+
+	c.put("a", "123456") // "123456" with quotes, 8 bytes
+	c.put("b", "abcdef") // 8 bytes
+	var v string
+	assert.True(t, c.get("a", &v)) // a is most recently used
+	assert.Equal(t, "123456", v)
+	c.put("c", "ABCDEF") // evict b
+	assert.False(t, c.get("b", &v))
+	assert.True(t, c.get("c", &v))
+	c.put("d", "too large item for this cache") // not cached
+	assert.False(t, c.get("d", &v))
+
+	assert.Equal(t, CacheStats{Items: 2, Size: 16, MaxSize: 20, Hits: 2, Misses: 2}, c.Stats())
+
+	require.NoError(t, c.Clear())
+	assert.False(t, c.get("a", &v))
+	assert.Equal(t, 0, c.Stats().Items)
+}
+
+func TestCacheDir(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewCache(1024, dir)
+	require.NoError(t, err)
+
+	d := New(nil, "t_k6_tests", "t_k6_samples")
+	d.SetCacheScope("http://localhost:8123/default")
+	key1 := d.cacheKey("status", 1, 100, SampleFilter{Id: 1, Start: 100})
+	key2 := d.cacheKey("status", 2, 100, SampleFilter{Id: 2, Start: 100})
+	c.put(key1, []int{1, 2})
+	c.put(key2, []int{3})
+
+	// other database or tables must not share keys
+	other := New(nil, "t_k6_tests", "t_k6_samples")
+	other.SetCacheScope("http://localhost:8123/staging")
+	assert.NotEqual(t, key1, other.cacheKey("status", 1, 100, SampleFilter{Id: 1, Start: 100}))
+	other = New(nil, "t_k6_tests", "t_k6_samples_other")
+	other.SetCacheScope("http://localhost:8123/default")
+	assert.NotEqual(t, key1, other.cacheKey("status", 1, 100, SampleFilter{Id: 1, Start: 100}))
+
+	// new cache (for example, after restart) read from disk
+	c, err = NewCache(1024, dir)
+	require.NoError(t, err)
+	var v []int
+	assert.True(t, c.get(key1, &v))
+	assert.Equal(t, []int{1, 2}, v)
+	assert.Equal(t, uint64(1), c.Stats().DiskHits)
+
+	require.NoError(t, c.Remove(1, 100))
+	assert.False(t, c.get(key1, &v))
+	_, err = os.Stat(filepath.Join(dir, key2+".json"))
+	assert.NoError(t, err)
+}
+
+func TestCacheDirLimit(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewCache(20, dir)
+	require.NoError(t, err)
+
+	c.put("a", "123456") // 8 bytes
+	// make "a" the least recently used file
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "a.json"), past, past))
+	c.put("b", "abcdef")
+	c.put("c", "ABCDEF") // evict a.json
+	assert.Equal(t, int64(16), c.Stats().DiskSize)
+	_, err = os.Stat(filepath.Join(dir, "a.json"))
+	assert.True(t, os.IsNotExist(err))
+
+	// limit is applied on open
+	c, err = NewCache(10, dir)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), c.Stats().DiskSize)
+
+	require.NoError(t, c.Clear())
+	assert.Equal(t, int64(0), c.Stats().DiskSize)
+}
+
+func TestCachedQuery(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(db, "t_k6_tests", "t_k6_samples")
+	c, err := NewCache(1024*1024, "")
+	require.NoError(t, err)
+	d.SetCache(c, time.Minute)
+
+	start := time.Unix(1674196900, 0).UTC()
+	now := time.Now().UTC()
+
+	// running test
+	mock.ExpectQuery(`^SELECT id, start, label, url, status, sum\(value\) FROM t_k6_samples WHERE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "url", "status", "count"}).
+			AddRow(uint64(1), start, "find", "q=a.*", "200", 10.0))
+	mock.ExpectQuery(`^SELECT max\(ts\) FROM t_k6_samples WHERE id = @Id AND start = @Time$`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(now))
+	// finished test
+	mock.ExpectQuery(`^SELECT id, start, label, url, status, sum\(value\) FROM t_k6_samples WHERE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "url", "status", "count"}).
+			AddRow(uint64(1), start, "find", "q=a.*", "200", 20.0))
+	mock.ExpectQuery(`^SELECT max\(ts\) FROM t_k6_samples WHERE id = @Id AND start = @Time$`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(now.Add(-2 * time.Minute)))
+
+	f := SampleFilter{Id: 1, Start: start.UnixNano()}
+	want := []SampleStatus{{Id: 1, Start: start, Label: "find", Url: "q=a.*", Status: "200", Count: 10}}
+	for i := 0; i < 3; i++ {
+		if i == 1 {
+			want[0].Count = 20
+		}
+		statuses, qErr := d.GetHttpSamplesStatus(context.Background(), f)
+		if qErr != nil {
+			t.Fatal(qErr)
+		}
+		assert.Equal(t, want, statuses, "query %d", i)
+	}
+
+	assert.Equal(t, CacheStats{Items: 1, Size: c.Stats().Size, MaxSize: 1024 * 1024, Hits: 1, Misses: 2, Bypass: 1}, c.Stats())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
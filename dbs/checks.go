@@ -0,0 +1,176 @@
+package dbs
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/msaf1980/go-stringutils"
+	"github.com/msaf1980/go-timeutils"
+)
+
+// ChecksDropThreshold is a default pass rate drop (in percents) for mark check as dropped
+const ChecksDropThreshold = 0.0
+
+type SampleCheck struct {
+	Id    uint64    `json:"id"`
+	Start time.Time `json:"start"` // ts from tests
+	Label string    `json:"label,omitempty"`
+	Group string    `json:"group,omitempty"`
+	Check string    `json:"check"`
+
+	Passes   float64 `json:"passes"`
+	Count    float64 `json:"count"`
+	PassPcnt float64 `json:"pass"`
+}
+
+type SampleCheckDiff struct {
+	Label string `json:"label,omitempty"`
+	Group string `json:"group,omitempty"`
+	Check string `json:"check"`
+
+	Passes   float64 `json:"passes"`
+	Count    float64 `json:"count"`
+	PassPcnt float64 `json:"pass"`
+
+	PassesDiff   float64 `json:"passes-diff"`
+	CountDiff    float64 `json:"count-diff"`
+	PassPcntDiff float64 `json:"pass-diff"`
+
+	// check not found in reference
+	New bool `json:"new,omitempty"`
+	// check found only in reference (also marked as dropped)
+	Missing bool `json:"missing,omitempty"`
+	// pass rate dropped against reference
+	Dropped bool `json:"dropped,omitempty"`
+}
+
+// GetChecks return pass rate per check name and group (k6 checks metric)
+func (d *DB) GetChecks(ctx context.Context, f SampleFilter) ([]SampleCheck, *QueryError) {
+	return cachedQuery(ctx, d, "checks", f.Id, f.Start, f, func() ([]SampleCheck, *QueryError) {
+		return d.getChecks(ctx, f)
+	})
+}
+
+func (d *DB) getChecks(ctx context.Context, f SampleFilter) ([]SampleCheck, *QueryError) {
+	var query stringutils.Builder
+
+	start := timeutils.UnixNano(f.Start).UTC()
+
+	query.Grow(64)
+	_, _ = query.WriteString("SELECT id, start, label, tags['group'] AS check_group, tags['check'] AS check_name, sum(value), count() FROM ")
+	_, _ = query.WriteString(d.tableSamples)
+	_, _ = query.WriteString(" WHERE id = @Id AND start = @Time AND metric = @Metric")
+	if f.Label != "" {
+		_, _ = query.WriteString(" AND label LIKE @Label")
+	}
+
+	writeTsRange(&query, &f)
+	_, _ = query.WriteString(" GROUP BY id, start, label, check_group, check_name ORDER BY label, check_group, check_name")
+
+	rows, err := d.db.QueryContext(
+		ctx, query.String(), clickhouse.Named("Id", f.Id), clickhouse.DateNamed("Time", start, 3),
+		f.dateNamedFrom(), f.dateNamedUntil(),
+		clickhouse.Named("Label", f.Label),
+		clickhouse.Named("Metric", "checks"),
+	)
+	if err != nil {
+		return nil, NewQueryError(err, 0, query.String())
+	}
+	defer rows.Close()
+	checks := make([]SampleCheck, 0, 10)
+	for rows.Next() {
+		var c SampleCheck
+		err = rows.Scan(&c.Id, &c.Start, &c.Label, &c.Group, &c.Check, &c.Passes, &c.Count)
+		if err != nil {
+			return nil, NewQueryError(err, 0, query.String())
+		}
+		c.PassPcnt = ChecksPassPcnt(c.Passes, c.Count)
+		checks = append(checks, c)
+	}
+	// get any error encountered during iteration
+	err = rows.Err()
+	if err != nil {
+		return nil, NewQueryError(err, 0, query.String())
+	}
+
+	return checks, nil
+}
+
+func ChecksPassPcnt(passes, count float64) float64 {
+	if count == 0 {
+		return 0
+	}
+	return passes / count * 100.0
+}
+
+type checkKey struct {
+	Label string
+	Group string
+	Check string
+}
+
+// DiffChecks compare checks pass rates with reference. Check marked as dropped, if pass rate dropped more than threshold (in percents).
+// Checks, found only in reference, are appended as missing (and dropped).
+func DiffChecks(checks, ref []SampleCheck, threshold float64) []SampleCheckDiff {
+	if len(checks) == 0 && len(ref) == 0 {
+		return nil
+	}
+	refMap := make(map[checkKey]*SampleCheck)
+	for i := range ref {
+		refMap[checkKey{Label: ref[i].Label, Group: ref[i].Group, Check: ref[i].Check}] = &ref[i]
+	}
+
+	found := make(map[checkKey]bool, len(checks))
+	diff := make([]SampleCheckDiff, 0, len(checks))
+	for _, c := range checks {
+		found[checkKey{Label: c.Label, Group: c.Group, Check: c.Check}] = true
+		d := SampleCheckDiff{
+			Label: c.Label, Group: c.Group, Check: c.Check,
+			Passes: c.Passes, Count: c.Count, PassPcnt: c.PassPcnt,
+		}
+		if r, exist := refMap[checkKey{Label: c.Label, Group: c.Group, Check: c.Check}]; exist && r.Count > 0 {
+			d.PassesDiff = c.Passes - r.Passes
+			d.CountDiff = c.Count - r.Count
+			d.PassPcntDiff = c.PassPcnt - r.PassPcnt
+			d.Dropped = d.PassPcntDiff < -threshold
+		} else {
+			d.New = true
+		}
+		diff = append(diff, d)
+	}
+	for _, r := range ref {
+		if found[checkKey{Label: r.Label, Group: r.Group, Check: r.Check}] {
+			continue
+		}
+		diff = append(diff, SampleCheckDiff{
+			Label: r.Label, Group: r.Group, Check: r.Check,
+			PassesDiff: -r.Passes, CountDiff: -r.Count, PassPcntDiff: -r.PassPcnt,
+			Missing: true, Dropped: true,
+		})
+	}
+
+	return diff
+}
+
+// ChecksDropped return only dropped checks
+func ChecksDropped(checks []SampleCheckDiff) []SampleCheckDiff {
+	dropped := make([]SampleCheckDiff, 0)
+	for _, c := range checks {
+		if c.Dropped {
+			dropped = append(dropped, c)
+		}
+	}
+	return dropped
+}
+
+// SortChecksDiff sort checks by pass rate diff (most dropped first)
+func SortChecksDiff(checks []SampleCheckDiff) {
+	sort.SliceStable(checks, func(i, j int) bool {
+		if checks[i].PassPcntDiff == checks[j].PassPcntDiff {
+			return checks[i].PassPcnt < checks[j].PassPcnt
+		}
+		return checks[i].PassPcntDiff < checks[j].PassPcntDiff
+	})
+}
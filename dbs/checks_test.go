@@ -0,0 +1,62 @@
+package dbs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffChecks(t *testing.T) {
+	checks := []SampleCheck{
+		{Label: "find", Check: "status is 200", Passes: 9, Count: 10, PassPcnt: 90},
+		{Label: "find", Group: "::tags", Check: "status is 200", Passes: 10, Count: 10, PassPcnt: 100},
+		{Label: "render", Check: "body not empty", Passes: 5, Count: 10, PassPcnt: 50},
+	}
+	ref := []SampleCheck{
+		{Label: "find", Check: "status is 200", Passes: 20, Count: 20, PassPcnt: 100},
+		{Label: "find", Group: "::tags", Check: "status is 200", Passes: 5, Count: 10, PassPcnt: 50},
+		{Label: "find", Check: "body not empty", Passes: 10, Count: 10, PassPcnt: 100},
+	}
+
+	want := []SampleCheckDiff{
+		{
+			Label: "find", Check: "status is 200", Passes: 9, Count: 10, PassPcnt: 90,
+			PassesDiff: -11, CountDiff: -10, PassPcntDiff: -10, Dropped: true,
+		},
+		{
+			Label: "find", Group: "::tags", Check: "status is 200", Passes: 10, Count: 10, PassPcnt: 100,
+			PassesDiff: 5, CountDiff: 0, PassPcntDiff: 50,
+		},
+		{
+			Label: "render", Check: "body not empty", Passes: 5, Count: 10, PassPcnt: 50,
+			New: true,
+		},
+		// not found in test
+		{
+			Label: "find", Check: "body not empty",
+			PassesDiff: -10, CountDiff: -10, PassPcntDiff: -100, Missing: true, Dropped: true,
+		},
+	}
+
+	diff := DiffChecks(checks, ref, ChecksDropThreshold)
+	assert.Equal(t, want, diff)
+
+	assert.Equal(t, []SampleCheckDiff{want[0], want[3]}, ChecksDropped(diff))
+
+	// with threshold missing check is still dropped
+	diff = DiffChecks(checks, ref, 10)
+	assert.Equal(t, []SampleCheckDiff{want[3]}, ChecksDropped(diff))
+
+	// all checks missing in test
+	diff = DiffChecks(nil, ref[:1], ChecksDropThreshold)
+	assert.Equal(t, []SampleCheckDiff{
+		{Label: "find", Check: "status is 200", PassesDiff: -20, CountDiff: -20, PassPcntDiff: -100, Missing: true, Dropped: true},
+	}, diff)
+
+	SortChecksDiff(want)
+	assert.Equal(t, "body not empty", want[0].Check)
+	assert.True(t, want[0].Missing)
+	assert.Equal(t, "status is 200", want[1].Check)
+	assert.Equal(t, "", want[1].Group)
+	assert.Equal(t, "body not empty", want[2].Check)
+}
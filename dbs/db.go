@@ -1,17 +1,121 @@
 package dbs
 
-import "database/sql"
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
 
 type DB struct {
 	db           *sql.DB
 	tableTests   string
 	tableSamples string
+	// optional companion tables
+	tableAnnotations string
+
+	tableRollup   string
+	rollupMu      sync.Mutex
+	rollupChecked time.Time // last rollup table and views existence check
+	rollupExist   bool
+	rollupSince   time.Time // materialized views creation time
+
+	// cluster is a cluster name for mutations on local (replicated) tables
+	cluster string
+
+	// observer is an optional queries duration and errors observer (for metrics)
+	observer QueryObserver
+
+	cache *Cache
+	// cacheScope is a database identity for cache keys
+	cacheScope     string
+	runningTimeout time.Duration
+	finishedMu     sync.Mutex
+	finished       map[testKey]bool
 }
 
 func New(db *sql.DB, tableTests, tableSamples string) *DB {
-	return &DB{db: db, tableTests: tableTests, tableSamples: tableSamples}
+	return &DB{db: db, tableTests: tableTests, tableSamples: tableSamples, runningTimeout: DefaultRunningTimeout}
+}
+
+// QueryObserver is called after DB query with query kind (like "tests" or "durations"), duration and error (nil on success)
+type QueryObserver func(kind string, duration time.Duration, qErr *QueryError)
+
+// SetQueryObserver set queries observer (nil for disable), must be set before queries
+func (d *DB) SetQueryObserver(observer QueryObserver) {
+	d.observer = observer
+}
+
+// observed run query and pass its duration and error to observer
+func observed[T any](d *DB, kind string, query func() (T, *QueryError)) (T, *QueryError) {
+	if d.observer == nil {
+		return query()
+	}
+	start := time.Now()
+	v, qErr := query()
+	d.observer(kind, time.Since(start), qErr)
+	return v, qErr
+}
+
+// SetCluster set cluster name for cluster deployment (tables are distributed over local replicated tables with "_local" suffix)
+func (d *DB) SetCluster(cluster string) {
+	d.cluster = cluster
+}
+
+// Open open ClickHouse connection with optional TLS config (for https:// or native protocol with TLS)
+func Open(dsn string, tlsConfig *tls.Config) (*sql.DB, error) {
+	if tlsConfig == nil {
+		return sql.Open("clickhouse", dsn)
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	// secure param is required for https scheme
+	params := u.Query()
+	params.Set("secure", "true")
+	u.RawQuery = params.Encode()
+	opt, err := clickhouse.ParseDSN(u.String())
+	if err != nil {
+		return nil, err
+	}
+	opt.TLS = tlsConfig
+	return clickhouse.OpenDB(opt), nil
 }
 
 func (d *DB) Close() error {
 	return d.db.Close()
 }
+
+// Ping verify database connection
+func (d *DB) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+var ErrTableNotFound = errors.New("table not found")
+
+// CheckTables verify, that tests, samples and annotations (if set) tables exist
+func (d *DB) CheckTables(ctx context.Context) *QueryError {
+	tables := []string{d.tableTests, d.tableSamples}
+	if d.tableAnnotations != "" {
+		tables = append(tables, d.tableAnnotations)
+	}
+	for _, table := range tables {
+		query := "EXISTS TABLE " + table
+		var exist uint8
+		if err := d.db.QueryRowContext(ctx, query).Scan(&exist); err != nil {
+			return NewQueryError(err, 0, query)
+		}
+		if exist != 1 {
+			return NewQueryError(fmt.Errorf("%w: %s", ErrTableNotFound, table), http.StatusServiceUnavailable, query)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package dbs
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpen(t *testing.T) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	db, err := Open("https://localhost:8443/default?dial_timeout=200ms", tlsConfig)
+	if assert.NoError(t, err) {
+		assert.NoError(t, db.Close())
+	}
+	db, err = Open("clickhouse://localhost:9440/default", tlsConfig)
+	if assert.NoError(t, err) {
+		assert.NoError(t, db.Close())
+	}
+
+	// TLS with plain http scheme
+	_, err = Open("http://localhost:8123/default", tlsConfig)
+	assert.Error(t, err)
+}
+
+func TestCheckTables(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(db, "t_k6_tests", "t_k6_samples")
+	d.SetTableAnnotations("t_k6_tests_annotations")
+
+	mock.ExpectQuery(`^EXISTS TABLE t_k6_tests$`).WillReturnRows(sqlmock.NewRows([]string{"result"}).AddRow(uint8(1)))
+	mock.ExpectQuery(`^EXISTS TABLE t_k6_samples$`).WillReturnRows(sqlmock.NewRows([]string{"result"}).AddRow(uint8(1)))
+	mock.ExpectQuery(`^EXISTS TABLE t_k6_tests_annotations$`).WillReturnRows(sqlmock.NewRows([]string{"result"}).AddRow(uint8(1)))
+	assert.Nil(t, d.CheckTables(context.Background()))
+
+	mock.ExpectQuery(`^EXISTS TABLE t_k6_tests$`).WillReturnRows(sqlmock.NewRows([]string{"result"}).AddRow(uint8(1)))
+	mock.ExpectQuery(`^EXISTS TABLE t_k6_samples$`).WillReturnRows(sqlmock.NewRows([]string{"result"}).AddRow(uint8(0)))
+	qErr := d.CheckTables(context.Background())
+	if assert.NotNil(t, qErr) {
+		assert.True(t, errors.Is(qErr.Wrapped(), ErrTableNotFound))
+		assert.Equal(t, "table not found: t_k6_samples", qErr.Error())
+		assert.Equal(t, http.StatusServiceUnavailable, qErr.Code())
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestQueryObserver(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(db, "t_k6_tests", "t_k6_samples")
+	var (
+		kinds  []string
+		failed []bool
+	)
+	d.SetQueryObserver(func(kind string, duration time.Duration, qErr *QueryError) {
+		kinds = append(kinds, kind)
+		failed = append(failed, qErr != nil)
+	})
+
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "name", "params"}))
+	mock.ExpectQuery(`^SELECT count\(\) FROM t_k6_tests`).WillReturnError(errors.New("connection reset"))
+
+	_, qErr := d.GetTests(context.Background(), TestFilter{})
+	assert.Nil(t, qErr)
+	_, qErr = d.CountTests(context.Background(), TestFilter{})
+	assert.NotNil(t, qErr)
+
+	assert.Equal(t, []string{"tests", "count"}, kinds)
+	assert.Equal(t, []bool{false, true}, failed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
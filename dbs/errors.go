@@ -1,28 +1,121 @@
 package dbs
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"net"
 	"net/http"
-	"net/url"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// ErrorCode is a machine-readable error kind, returned to API clients
+type ErrorCode string
+
+const (
+	ErrCodeInvalidFilter  ErrorCode = "invalid_filter"
+	ErrCodeNotFound       ErrorCode = "not_found"
+	ErrCodeDuplicateTest  ErrorCode = "duplicate_test"
+	ErrCodeNotImplemented ErrorCode = "not_implemented"
+	ErrCodeDBUnavailable  ErrorCode = "db_unavailable"
+	ErrCodeTimeout        ErrorCode = "timeout"
+	ErrCodeCanceled       ErrorCode = "canceled"
+	ErrCodeInternal       ErrorCode = "internal"
 )
 
+// StatusClientClosedRequest is a non-standard status (from nginx) for request, canceled by client
+const StatusClientClosedRequest = 499
+
+var errorCodeStatus = map[ErrorCode]int{
+	ErrCodeInvalidFilter:  http.StatusBadRequest,
+	ErrCodeNotFound:       http.StatusNotFound,
+	ErrCodeDuplicateTest:  http.StatusConflict,
+	ErrCodeNotImplemented: http.StatusNotImplemented,
+	ErrCodeDBUnavailable:  http.StatusServiceUnavailable,
+	ErrCodeTimeout:        http.StatusGatewayTimeout,
+	ErrCodeCanceled:       StatusClientClosedRequest,
+	ErrCodeInternal:       http.StatusInternalServerError,
+}
+
+// Status return HTTP status for error code
+func (c ErrorCode) Status() int {
+	if status, ok := errorCodeStatus[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// errorCodeFromStatus return error code for HTTP status
+func errorCodeFromStatus(status int) ErrorCode {
+	for c, s := range errorCodeStatus {
+		if s == status {
+			return c
+		}
+	}
+	if status >= 400 && status < 500 {
+		return ErrCodeInvalidFilter
+	}
+	return ErrCodeInternal
+}
+
+// ClickHouse exception codes
+const (
+	chTimeoutExceeded  = 159
+	chTooSlow          = 160
+	chQueryWasCanceled = 394
+)
+
+// classifyError return error code for query/connection error
+func classifyError(err error) ErrorCode {
+	var (
+		chErr  *clickhouse.Exception
+		netErr net.Error
+		opErr  *net.OpError
+	)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrCodeCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrCodeTimeout
+	case errors.As(err, &chErr):
+		switch chErr.Code {
+		case chTimeoutExceeded, chTooSlow:
+			return ErrCodeTimeout
+		case chQueryWasCanceled:
+			return ErrCodeCanceled
+		}
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrCodeTimeout
+	case errors.As(err, &opErr), errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone):
+		return ErrCodeDBUnavailable
+	}
+	return ErrCodeInternal
+}
+
 type QueryError struct {
 	code    int
+	errCode ErrorCode
 	wrapped error
 	query   string
 }
 
+// NewQueryError create query error with HTTP status code (or classify error by kind, if code is 0)
 func NewQueryError(wrapErr error, code int, query string) *QueryError {
+	var errCode ErrorCode
 	if code == 0 {
-		code = http.StatusInternalServerError
-		if uErr, ok := wrapErr.(*url.Error); ok {
-			if _, ok := uErr.Err.(*net.OpError); ok {
-				code = http.StatusServiceUnavailable
-			}
-		}
+		errCode = classifyError(wrapErr)
+		code = errCode.Status()
+	} else {
+		errCode = errorCodeFromStatus(code)
 	}
-	return &QueryError{code: code, wrapped: wrapErr, query: query}
+	return &QueryError{code: code, errCode: errCode, wrapped: wrapErr, query: query}
+}
+
+// NewQueryErrorCode create query error with error code
+func NewQueryErrorCode(wrapErr error, errCode ErrorCode, query string) *QueryError {
+	return &QueryError{code: errCode.Status(), errCode: errCode, wrapped: wrapErr, query: query}
 }
 
 func (e *QueryError) Error() string {
@@ -41,7 +134,13 @@ func (e *QueryError) Code() int {
 	return e.code
 }
 
+func (e *QueryError) ErrorCode() ErrorCode {
+	return e.errCode
+}
+
 var (
+	ErrDuplicateTest = errors.New("duplicate test id")
+
 	InvalidFrom  = NewQueryError(errors.New("invalid from"), http.StatusBadRequest, "")
 	InvalidUntil = NewQueryError(errors.New("invalid until"), http.StatusBadRequest, "")
 )
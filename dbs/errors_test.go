@@ -0,0 +1,60 @@
+package dbs
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestNewQueryError(t *testing.T) {
+	tests := []struct {
+		err        error
+		code       int
+		wantCode   ErrorCode
+		wantStatus int
+	}{
+		{err: errors.New("unknown"), wantCode: ErrCodeInternal, wantStatus: http.StatusInternalServerError},
+		{err: ErrInvalidInterval, code: http.StatusBadRequest, wantCode: ErrCodeInvalidFilter, wantStatus: http.StatusBadRequest},
+		{err: ErrTestNotFound, code: http.StatusNotFound, wantCode: ErrCodeNotFound, wantStatus: http.StatusNotFound},
+		{err: errors.New("annotations disabled"), code: http.StatusNotImplemented, wantCode: ErrCodeNotImplemented, wantStatus: http.StatusNotImplemented},
+		{err: context.DeadlineExceeded, wantCode: ErrCodeTimeout, wantStatus: http.StatusGatewayTimeout},
+		{err: fmt.Errorf("query: %w", context.Canceled), wantCode: ErrCodeCanceled, wantStatus: StatusClientClosedRequest},
+		{
+			err:      &url.Error{Op: "Post", URL: "http://localhost:8123", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}},
+			wantCode: ErrCodeDBUnavailable, wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			err:      &url.Error{Op: "Post", URL: "http://localhost:8123", Err: timeoutError{}},
+			wantCode: ErrCodeTimeout, wantStatus: http.StatusGatewayTimeout,
+		},
+		{err: driver.ErrBadConn, wantCode: ErrCodeDBUnavailable, wantStatus: http.StatusServiceUnavailable},
+		{err: &clickhouse.Exception{Code: 159, Message: "Timeout exceeded"}, wantCode: ErrCodeTimeout, wantStatus: http.StatusGatewayTimeout},
+		{err: &clickhouse.Exception{Code: 394, Message: "Query was cancelled"}, wantCode: ErrCodeCanceled, wantStatus: StatusClientClosedRequest},
+		{err: &clickhouse.Exception{Code: 62, Message: "Syntax error"}, wantCode: ErrCodeInternal, wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			qErr := NewQueryError(tt.err, tt.code, "SELECT 1")
+			assert.Equal(t, tt.wantCode, qErr.ErrorCode())
+			assert.Equal(t, tt.wantStatus, qErr.Code())
+			assert.Equal(t, "SELECT 1", qErr.Query())
+		})
+	}
+
+	qErr := NewQueryErrorCode(ErrDuplicateTest, ErrCodeDuplicateTest, "")
+	assert.Equal(t, http.StatusConflict, qErr.Code())
+}
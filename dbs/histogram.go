@@ -0,0 +1,381 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/msaf1980/go-stringutils"
+	"github.com/msaf1980/go-timeutils"
+)
+
+// DefaultHistogramBuckets is a log-scale (1-2-5) latency buckets upper bounds (ms)
+var DefaultHistogramBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 20000, 60000}
+
+// DefaultHeatmapInterval is a default heatmap time interval (seconds)
+const DefaultHeatmapInterval = 10
+
+var (
+	ErrInvalidBuckets       = errors.New("buckets must be positive and in ascending order")
+	ErrInvalidInterval      = errors.New("invalid heatmap interval")
+	ErrHistogramBucketsDiff = errors.New("histograms buckets mismatch")
+)
+
+type HistogramFilter struct {
+	SampleFilter
+	// buckets upper bounds (ms, ascending), DefaultHistogramBuckets if empty
+	Buckets []float64 `json:"buckets,omitempty"`
+	// heatmap time interval (seconds), DefaultHeatmapInterval if zero
+	Interval int64 `json:"interval,omitempty"`
+}
+
+// Histogram is a latency histogram for label/url. Counts has len(buckets)+1 items, last is an overflow bucket.
+type Histogram struct {
+	Label  string    `json:"label,omitempty"`
+	Url    string    `json:"url"`
+	Counts []float64 `json:"counts"`
+	Count  float64   `json:"count"`
+}
+
+type SamplesHistogram struct {
+	Id         uint64      `json:"id"`
+	Start      time.Time   `json:"start"` // ts from tests
+	Buckets    []float64   `json:"buckets"`
+	Histograms []Histogram `json:"histograms"`
+}
+
+// SamplesHeatmap is a time x latency matrix. Counts[i] is a histogram for Times[i] interval.
+type SamplesHeatmap struct {
+	Id       uint64      `json:"id"`
+	Start    time.Time   `json:"start"` // ts from tests
+	Buckets  []float64   `json:"buckets"`
+	Interval int64       `json:"interval"` // seconds
+	Times    []time.Time `json:"times"`
+	Counts   [][]float64 `json:"counts"`
+}
+
+// HistogramDiff is an overlay of test and reference histograms (fractions are in percents)
+type HistogramDiff struct {
+	Label     string    `json:"label,omitempty"`
+	Url       string    `json:"url"`
+	Counts    []float64 `json:"counts"`
+	Count     float64   `json:"count"`
+	RefCounts []float64 `json:"ref-counts"`
+	RefCount  float64   `json:"ref-count"`
+
+	Pcnt     []float64 `json:"pcnt"`
+	RefPcnt  []float64 `json:"ref-pcnt"`
+	PcntDiff []float64 `json:"pcnt-diff"`
+}
+
+type SamplesHistogramDiff struct {
+	Buckets    []float64       `json:"buckets"`
+	Histograms []HistogramDiff `json:"histograms"`
+}
+
+// LogBuckets return log-scale buckets upper bounds from min to max (with perDecade buckets per decade)
+func LogBuckets(min, max float64, perDecade int) []float64 {
+	if min <= 0 || max <= min || perDecade <= 0 {
+		return nil
+	}
+	step := math.Pow(10, 1/float64(perDecade))
+	buckets := make([]float64, 0, perDecade*int(math.Ceil(math.Log10(max/min)))+1)
+	for i := 0; ; i++ {
+		v := min * math.Pow(step, float64(i))
+		if v > max*(1+1e-9) {
+			break
+		}
+		// round to 3 significant digits
+		v, _ = strconv.ParseFloat(strconv.FormatFloat(v, 'g', 3, 64), 64)
+		buckets = append(buckets, v)
+	}
+	return buckets
+}
+
+// ParseBuckets parse buckets upper bounds. For empty list DefaultHistogramBuckets returned.
+func ParseBuckets(values []string) ([]float64, error) {
+	if len(values) == 0 {
+		return DefaultHistogramBuckets, nil
+	}
+	buckets := make([]float64, 0, len(values))
+	for _, v := range values {
+		b, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+	if err := checkBuckets(buckets); err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
+func checkBuckets(buckets []float64) error {
+	for i, b := range buckets {
+		if b <= 0 || math.IsInf(b, 0) || math.IsNaN(b) || (i > 0 && b <= buckets[i-1]) {
+			return ErrInvalidBuckets
+		}
+	}
+	return nil
+}
+
+// BucketTitle return bucket title for output (<= 100, > 60000 for overflow bucket)
+func BucketTitle(buckets []float64, n int) string {
+	if n < len(buckets) {
+		return "<= " + strconv.FormatFloat(buckets[n], 'f', -1, 64)
+	}
+	if len(buckets) == 0 {
+		return "> 0"
+	}
+	return "> " + strconv.FormatFloat(buckets[len(buckets)-1], 'f', -1, 64)
+}
+
+// writeBucketExpr write bucket index expression (0 is overflow bucket)
+func writeBucketExpr(query *stringutils.Builder, buckets []float64) {
+	_, _ = query.WriteString("arrayFirstIndex(b -> value <= b, [")
+	for i, b := range buckets {
+		if i > 0 {
+			_, _ = query.WriteString(", ")
+		}
+		_, _ = query.WriteString(strconv.FormatFloat(b, 'f', -1, 64))
+	}
+	_, _ = query.WriteString("]) AS bucket")
+}
+
+// bucketIndex convert arrayFirstIndex result to counts index (overflow bucket is the last)
+func bucketIndex(bucket uint64, buckets []float64) int {
+	if bucket == 0 || bucket > uint64(len(buckets)) {
+		return len(buckets)
+	}
+	return int(bucket - 1)
+}
+
+// writeSampleFilter write label/url filter conditions (values are bound by SampleFilter.queryArgs)
+func writeSampleFilter(query *stringutils.Builder, f *SampleFilter) {
+	if f.Label != "" {
+		_, _ = query.WriteString(" AND label LIKE @Label")
+	}
+	if f.Url != "" {
+		_, _ = query.WriteString(" AND url LIKE @Url")
+	}
+	for i, n := range f.SkipUrl {
+		if n != "" {
+			_, _ = query.WriteString(" AND url NOT LIKE @SkipUrl")
+			_, _ = query.WriteString(strconv.Itoa(i))
+		}
+	}
+}
+
+// queryArgs append label/url filter named params (for writeSampleFilter conditions) to args
+func (f *SampleFilter) queryArgs(args ...any) []any {
+	args = append(args, clickhouse.Named("Label", f.Label), clickhouse.Named("Url", f.Url))
+	for i, n := range f.SkipUrl {
+		if n != "" {
+			args = append(args, clickhouse.Named("SkipUrl"+strconv.Itoa(i), n))
+		}
+	}
+	return args
+}
+
+// GetHttpSamplesHistogram return latency histogram per label/url
+func (d *DB) GetHttpSamplesHistogram(ctx context.Context, f HistogramFilter) (*SamplesHistogram, *QueryError) {
+	return cachedQuery(ctx, d, "histogram", f.Id, f.Start, f, func() (*SamplesHistogram, *QueryError) {
+		return d.getHttpSamplesHistogram(ctx, f)
+	})
+}
+
+func (d *DB) getHttpSamplesHistogram(ctx context.Context, f HistogramFilter) (*SamplesHistogram, *QueryError) {
+	var query stringutils.Builder
+
+	buckets := f.Buckets
+	if len(buckets) == 0 {
+		buckets = DefaultHistogramBuckets
+	} else if err := checkBuckets(buckets); err != nil {
+		return nil, NewQueryError(err, http.StatusBadRequest, "")
+	}
+
+	start := timeutils.UnixNano(f.Start).UTC()
+
+	query.Grow(128)
+	_, _ = query.WriteString("SELECT label, url, ")
+	writeBucketExpr(&query, buckets)
+	_, _ = query.WriteString(", count() FROM ")
+	_, _ = query.WriteString(d.tableSamples)
+	_, _ = query.WriteString(" WHERE id = @Id AND start = @Time AND metric = @Metric")
+	writeSampleFilter(&query, &f.SampleFilter)
+	_, _ = query.WriteString(" GROUP BY label, url, bucket ORDER BY label, url, bucket")
+
+	rows, err := d.db.QueryContext(
+		ctx, query.String(), f.queryArgs(
+			clickhouse.Named("Id", f.Id), clickhouse.DateNamed("Time", start, 3),
+			clickhouse.Named("Metric", "http_req_duration"),
+		)...,
+	)
+	if err != nil {
+		return nil, NewQueryError(err, 0, query.String())
+	}
+	defer rows.Close()
+	hist := &SamplesHistogram{Id: f.Id, Start: start, Buckets: buckets, Histograms: make([]Histogram, 0, 50)}
+	var h *Histogram
+	for rows.Next() {
+		var (
+			label, url string
+			bucket     uint64
+			count      uint64
+		)
+		err = rows.Scan(&label, &url, &bucket, &count)
+		if err != nil {
+			return nil, NewQueryError(err, 0, query.String())
+		}
+		if h == nil || h.Label != label || h.Url != url {
+			hist.Histograms = append(hist.Histograms, Histogram{Label: label, Url: url, Counts: make([]float64, len(buckets)+1)})
+			h = &hist.Histograms[len(hist.Histograms)-1]
+		}
+		h.Counts[bucketIndex(bucket, buckets)] += float64(count)
+		h.Count += float64(count)
+	}
+	// get any error encountered during iteration
+	err = rows.Err()
+	if err != nil {
+		return nil, NewQueryError(err, 0, query.String())
+	}
+
+	return hist, nil
+}
+
+// GetHttpSamplesHeatmap return time x latency matrix for test (label/url filters are applied)
+func (d *DB) GetHttpSamplesHeatmap(ctx context.Context, f HistogramFilter) (*SamplesHeatmap, *QueryError) {
+	return cachedQuery(ctx, d, "heatmap", f.Id, f.Start, f, func() (*SamplesHeatmap, *QueryError) {
+		return d.getHttpSamplesHeatmap(ctx, f)
+	})
+}
+
+func (d *DB) getHttpSamplesHeatmap(ctx context.Context, f HistogramFilter) (*SamplesHeatmap, *QueryError) {
+	var query stringutils.Builder
+
+	buckets := f.Buckets
+	if len(buckets) == 0 {
+		buckets = DefaultHistogramBuckets
+	} else if err := checkBuckets(buckets); err != nil {
+		return nil, NewQueryError(err, http.StatusBadRequest, "")
+	}
+	interval := f.Interval
+	if interval == 0 {
+		interval = DefaultHeatmapInterval
+	} else if interval < 0 {
+		return nil, NewQueryError(ErrInvalidInterval, http.StatusBadRequest, "")
+	}
+
+	start := timeutils.UnixNano(f.Start).UTC()
+
+	query.Grow(128)
+	_, _ = query.WriteString("SELECT toDateTime(toStartOfInterval(ts, INTERVAL ")
+	_, _ = query.WriteString(strconv.FormatInt(interval, 10))
+	_, _ = query.WriteString(" SECOND), 'UTC') AS t, ")
+	writeBucketExpr(&query, buckets)
+	_, _ = query.WriteString(", count() FROM ")
+	_, _ = query.WriteString(d.tableSamples)
+	_, _ = query.WriteString(" WHERE id = @Id AND start = @Time AND metric = @Metric")
+	writeSampleFilter(&query, &f.SampleFilter)
+	_, _ = query.WriteString(" GROUP BY t, bucket ORDER BY t, bucket")
+
+	rows, err := d.db.QueryContext(
+		ctx, query.String(), f.queryArgs(
+			clickhouse.Named("Id", f.Id), clickhouse.DateNamed("Time", start, 3),
+			clickhouse.Named("Metric", "http_req_duration"),
+		)...,
+	)
+	if err != nil {
+		return nil, NewQueryError(err, 0, query.String())
+	}
+	defer rows.Close()
+	heatmap := &SamplesHeatmap{
+		Id: f.Id, Start: start, Buckets: buckets, Interval: interval,
+		Times: make([]time.Time, 0, 60), Counts: make([][]float64, 0, 60),
+	}
+	for rows.Next() {
+		var (
+			t      time.Time
+			bucket uint64
+			count  uint64
+		)
+		err = rows.Scan(&t, &bucket, &count)
+		if err != nil {
+			return nil, NewQueryError(err, 0, query.String())
+		}
+		n := len(heatmap.Times) - 1
+		if n == -1 || !heatmap.Times[n].Equal(t) {
+			heatmap.Times = append(heatmap.Times, t.UTC())
+			heatmap.Counts = append(heatmap.Counts, make([]float64, len(buckets)+1))
+			n++
+		}
+		heatmap.Counts[n][bucketIndex(bucket, buckets)] += float64(count)
+	}
+	// get any error encountered during iteration
+	err = rows.Err()
+	if err != nil {
+		return nil, NewQueryError(err, 0, query.String())
+	}
+
+	return heatmap, nil
+}
+
+func histogramPcnt(counts []float64, count float64) []float64 {
+	pcnt := make([]float64, len(counts))
+	if count > 0 {
+		for i, n := range counts {
+			pcnt[i] = n / count * 100.0
+		}
+	}
+	return pcnt
+}
+
+type histogramKey struct {
+	Label string
+	Url   string
+}
+
+// DiffHistograms overlay test and reference histograms (buckets must be the same)
+func DiffHistograms(test, ref *SamplesHistogram) (*SamplesHistogramDiff, error) {
+	if len(test.Buckets) != len(ref.Buckets) {
+		return nil, ErrHistogramBucketsDiff
+	}
+	for i := range test.Buckets {
+		if test.Buckets[i] != ref.Buckets[i] {
+			return nil, ErrHistogramBucketsDiff
+		}
+	}
+
+	refMap := make(map[histogramKey]*Histogram)
+	for i := range ref.Histograms {
+		refMap[histogramKey{Label: ref.Histograms[i].Label, Url: ref.Histograms[i].Url}] = &ref.Histograms[i]
+	}
+
+	diff := &SamplesHistogramDiff{Buckets: test.Buckets, Histograms: make([]HistogramDiff, 0, len(test.Histograms))}
+	for _, h := range test.Histograms {
+		d := HistogramDiff{
+			Label: h.Label, Url: h.Url, Counts: h.Counts, Count: h.Count,
+			Pcnt: histogramPcnt(h.Counts, h.Count),
+		}
+		if r, exist := refMap[histogramKey{Label: h.Label, Url: h.Url}]; exist {
+			d.RefCounts = r.Counts
+			d.RefCount = r.Count
+		} else {
+			d.RefCounts = make([]float64, len(h.Counts))
+		}
+		d.RefPcnt = histogramPcnt(d.RefCounts, d.RefCount)
+		d.PcntDiff = make([]float64, len(d.Pcnt))
+		for i := range d.Pcnt {
+			d.PcntDiff[i] = d.Pcnt[i] - d.RefPcnt[i]
+		}
+		diff.Histograms = append(diff.Histograms, d)
+	}
+
+	return diff, nil
+}
@@ -0,0 +1,97 @@
+package dbs
+
+import (
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/msaf1980/go-stringutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBuckets(t *testing.T) {
+	buckets, err := ParseBuckets(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultHistogramBuckets, buckets)
+
+	buckets, err = ParseBuckets([]string{"1", " 2.5", "10"})
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{1, 2.5, 10}, buckets)
+
+	_, err = ParseBuckets([]string{"1", "1"})
+	assert.Equal(t, ErrInvalidBuckets, err)
+	_, err = ParseBuckets([]string{"0", "1"})
+	assert.Equal(t, ErrInvalidBuckets, err)
+	_, err = ParseBuckets([]string{"a"})
+	assert.Error(t, err)
+}
+
+func TestLogBuckets(t *testing.T) {
+	assert.Equal(t, []float64{1, 10, 100, 1000}, LogBuckets(1, 1000, 1))
+	assert.Equal(t, []float64{10, 21.5, 46.4, 100}, LogBuckets(10, 100, 3))
+	assert.Nil(t, LogBuckets(0, 100, 3))
+}
+
+func TestBucketExpr(t *testing.T) {
+	var query stringutils.Builder
+	writeBucketExpr(&query, []float64{1, 2.5, 10})
+	assert.Equal(t, "arrayFirstIndex(b -> value <= b, [1, 2.5, 10]) AS bucket", query.String())
+
+	buckets := []float64{1, 2.5, 10}
+	assert.Equal(t, 0, bucketIndex(1, buckets))
+	assert.Equal(t, 2, bucketIndex(3, buckets))
+	assert.Equal(t, 3, bucketIndex(0, buckets))
+	assert.Equal(t, "<= 2.5", BucketTitle(buckets, 1))
+	assert.Equal(t, "> 10", BucketTitle(buckets, 3))
+}
+
+func TestSampleFilter(t *testing.T) {
+	f := SampleFilter{Label: "render", SkipUrl: []string{"/health' OR 1=1 --", "", "/metrics%"}}
+	var query stringutils.Builder
+	writeSampleFilter(&query, &f)
+	assert.Equal(t, " AND label LIKE @Label AND url NOT LIKE @SkipUrl0 AND url NOT LIKE @SkipUrl2", query.String())
+	assert.Equal(t, []any{
+		clickhouse.Named("Id", uint64(1)),
+		clickhouse.Named("Label", "render"), clickhouse.Named("Url", ""),
+		clickhouse.Named("SkipUrl0", "/health' OR 1=1 --"), clickhouse.Named("SkipUrl2", "/metrics%"),
+	}, f.queryArgs(clickhouse.Named("Id", uint64(1))))
+}
+
+func TestDiffHistograms(t *testing.T) {
+	buckets := []float64{10, 100}
+	test := &SamplesHistogram{
+		Buckets: buckets,
+		Histograms: []Histogram{
+			{Label: "find", Url: "q=a.*", Counts: []float64{5, 4, 1}, Count: 10},
+			{Label: "find", Url: "q=b.*", Counts: []float64{1, 1, 0}, Count: 2},
+		},
+	}
+	ref := &SamplesHistogram{
+		Buckets: buckets,
+		Histograms: []Histogram{
+			{Label: "find", Url: "q=a.*", Counts: []float64{16, 4, 0}, Count: 20},
+		},
+	}
+
+	diff, err := DiffHistograms(test, ref)
+	assert.NoError(t, err)
+	want := &SamplesHistogramDiff{
+		Buckets: buckets,
+		Histograms: []HistogramDiff{
+			{
+				Label: "find", Url: "q=a.*", Counts: []float64{5, 4, 1}, Count: 10,
+				RefCounts: []float64{16, 4, 0}, RefCount: 20,
+				Pcnt: []float64{50, 40, 10}, RefPcnt: []float64{80, 20, 0}, PcntDiff: []float64{-30, 20, 10},
+			},
+			{
+				Label: "find", Url: "q=b.*", Counts: []float64{1, 1, 0}, Count: 2,
+				RefCounts: []float64{0, 0, 0},
+				Pcnt:      []float64{50, 50, 0}, RefPcnt: []float64{0, 0, 0}, PcntDiff: []float64{50, 50, 0},
+			},
+		},
+	}
+	assert.Equal(t, want, diff)
+
+	ref.Buckets = []float64{10, 200}
+	_, err = DiffHistograms(test, ref)
+	assert.Equal(t, ErrHistogramBucketsDiff, err)
+}
@@ -0,0 +1,148 @@
+package dbs
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/msaf1980/go-stringutils"
+	"github.com/msaf1980/go-timeutils"
+)
+
+const (
+	// DefaultLiveInterval is a default live view refresh interval
+	DefaultLiveInterval = 10 * time.Second
+	// LiveLag is a delay for live view interval end (samples are written with delay)
+	LiveLag = 5 * time.Second
+	// DefaultRegressionThreshold is a default statistic grow (in percents) against reference for mark it as regression
+	DefaultRegressionThreshold = 10.0
+	// StatErrors is a regression statistic name for errors percent (threshold is compared with errors percent grow)
+	StatErrors = "errors"
+	// StatChecks is a regression statistic name for dropped check pass rate (value is a pass percent)
+	StatChecks = "checks"
+)
+
+// Regression is a statistic (or errors percent), grown against reference more than threshold (or dropped check pass rate)
+type Regression struct {
+	Label    string  `json:"label,omitempty"`
+	Url      string  `json:"url"`
+	Group    string  `json:"group,omitempty"` // for checks
+	Check    string  `json:"check,omitempty"` // for checks
+	Stat     string  `json:"stat"`
+	Value    float64 `json:"value"`
+	RefValue float64 `json:"ref-value"`
+	DiffPcnt float64 `json:"diff-pcnt"` // for errors it's a percent points
+}
+
+// LiveSamples is a live view interval aggregates (with optional reference compare)
+type LiveSamples struct {
+	From        time.Time        `json:"from"`
+	Until       time.Time        `json:"until"`
+	Samples     *TestSamples     `json:"samples"`
+	Diff        *TestSamplesDiff `json:"diff,omitempty"`
+	Regressions []Regression     `json:"regressions,omitempty"`
+	Finished    bool             `json:"finished,omitempty"` // no new samples in running timeout
+}
+
+func (f *SampleFilter) hasTsRange() bool {
+	return f.From > 0 || f.Until > 0
+}
+
+// writeTsRange write samples ts range condition (if set)
+func writeTsRange(query *stringutils.Builder, f *SampleFilter) {
+	if f.From > 0 {
+		_, _ = query.WriteString(" AND ts >= @From")
+	}
+	if f.Until > 0 {
+		_, _ = query.WriteString(" AND ts < @Until")
+	}
+}
+
+func (f *SampleFilter) dateNamedFrom() any {
+	return clickhouse.DateNamed("From", timeutils.UnixNano(f.From).UTC(), 3)
+}
+
+func (f *SampleFilter) dateNamedUntil() any {
+	return clickhouse.DateNamed("Until", timeutils.UnixNano(f.Until).UTC(), 3)
+}
+
+// FindRegressions return statistics (in diff stats order) and errors percent, grown against reference more than threshold (in percents),
+// and dropped checks
+func FindRegressions(diff *TestSamplesDiff, threshold float64) []Regression {
+	labels := make([]string, 0, len(diff.Samples))
+	for label := range diff.Samples {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	var regressions []Regression
+	for _, label := range labels {
+		samples := diff.Samples[label]
+		urls := make([]int, 0, len(samples))
+		for i := range samples {
+			if samples[i].StatsDiff != nil {
+				urls = append(urls, i)
+			}
+		}
+		sort.Slice(urls, func(i, j int) bool {
+			return samples[urls[i]].Url < samples[urls[j]].Url
+		})
+		for _, i := range urls {
+			s := &samples[i]
+			for _, stat := range diff.Stats {
+				ref := s.Stats[stat] - s.StatsDiff[stat]
+				if ref <= 0 {
+					continue
+				}
+				if pcnt := s.StatsDiff[stat] / ref * 100; pcnt > threshold {
+					regressions = append(regressions, Regression{
+						Label: label, Url: s.Url, Stat: stat, Value: s.Stats[stat], RefValue: ref, DiffPcnt: pcnt,
+					})
+				}
+			}
+			if s.ErrorsPcntDiff > threshold {
+				regressions = append(regressions, Regression{
+					Label: label, Url: s.Url, Stat: StatErrors, Value: s.ErrorsPcnt, RefValue: s.ErrorsPcnt - s.ErrorsPcntDiff,
+					DiffPcnt: s.ErrorsPcntDiff,
+				})
+			}
+		}
+	}
+	for _, c := range diff.Checks {
+		if c.Dropped {
+			regressions = append(regressions, Regression{
+				Label: c.Label, Group: c.Group, Check: c.Check, Stat: StatChecks, Value: c.PassPcnt, RefValue: c.PassPcnt - c.PassPcntDiff,
+				DiffPcnt: c.PassPcntDiff,
+			})
+		}
+	}
+
+	return regressions
+}
+
+// GetHttpLiveSamples return test aggregates for samples in [from, until) interval, compared with reference (if not nil)
+func (d *DB) GetHttpLiveSamples(
+	ctx context.Context, test Test, ref *TestSamples, f SampleFilter, from, until time.Time, threshold float64,
+) (*LiveSamples, *QueryError) {
+	f.From = from.UnixNano()
+	f.Until = until.UnixNano()
+
+	samples, qErr := d.GetHttpTestSamples(ctx, test, f)
+	if qErr != nil {
+		return nil, qErr
+	}
+	live := &LiveSamples{From: from.UTC(), Until: until.UTC(), Samples: samples}
+	if ref != nil {
+		diff, err := DiffSamples(samples, ref)
+		if err != nil {
+			return nil, NewQueryError(err, http.StatusBadRequest, "")
+		}
+		live.Diff = diff
+		live.Regressions = FindRegressions(diff, threshold)
+	}
+	live.Finished = d.testFinished(ctx, test.Id, test.Ts.UnixNano())
+
+	return live, nil
+}
@@ -0,0 +1,102 @@
+package dbs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindRegressions(t *testing.T) {
+	diff := &TestSamplesDiff{
+		Stats: []string{"p99", "max"},
+		Samples: map[string][]SampleDurationsDiff{
+			"render": {
+				{
+					Url: "target=b", Stats: map[string]float64{"p99": 105, "max": 300}, StatsDiff: map[string]float64{"p99": 5, "max": 200},
+					ErrorsPcnt: 20, ErrorsPcntDiff: 15,
+				},
+				{
+					Url: "target=a", Stats: map[string]float64{"p99": 150, "max": 200}, StatsDiff: map[string]float64{"p99": 50, "max": -10},
+				},
+				// not exist in reference
+				{Url: "target=c", Stats: map[string]float64{"p99": 1000, "max": 1000}},
+			},
+			"find": {
+				{Url: "query=a", Stats: map[string]float64{"p99": 10, "max": 22}, StatsDiff: map[string]float64{"p99": 0.5, "max": 2}},
+			},
+		},
+		Checks: []SampleCheckDiff{
+			{Label: "render", Check: "status is 200", Passes: 90, Count: 100, PassPcnt: 90, PassPcntDiff: -10, Dropped: true},
+			{Label: "render", Group: "api", Check: "body not empty", Passes: 100, Count: 100, PassPcnt: 100},
+		},
+	}
+
+	assert.Equal(t, []Regression{
+		{Label: "render", Url: "target=a", Stat: "p99", Value: 150, RefValue: 100, DiffPcnt: 50},
+		{Label: "render", Url: "target=b", Stat: "max", Value: 300, RefValue: 100, DiffPcnt: 200},
+		{Label: "render", Url: "target=b", Stat: StatErrors, Value: 20, RefValue: 5, DiffPcnt: 15},
+		{Label: "render", Check: "status is 200", Stat: StatChecks, Value: 90, RefValue: 100, DiffPcnt: -10},
+	}, FindRegressions(diff, DefaultRegressionThreshold))
+
+	// dropped checks are not depend on threshold
+	assert.Equal(t, []Regression{
+		{Label: "render", Check: "status is 200", Stat: StatChecks, Value: 90, RefValue: 100, DiffPcnt: -10},
+	}, FindRegressions(diff, 500))
+
+	diff.Checks = nil
+	assert.Nil(t, FindRegressions(diff, 500))
+}
+
+func TestGetHttpLiveSamples(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.MatchExpectationsInOrder(false)
+	d := New(db, "t_k6_tests", "t_k6_samples")
+	// rollup is not used for intervals
+	d.SetTableRollup("t_k6_samples_rollup")
+
+	start := time.Unix(1674196900, 0).UTC()
+	from := start.Add(10 * time.Second)
+	until := start.Add(20 * time.Second)
+	test := Test{Id: 1, Ts: start, Name: "graphite"}
+	ref := &TestSamples{
+		Test: Test{Id: 2, Ts: start.Add(-time.Hour)}, Quantile: string(QuantileDefault), Stats: []string{"max"},
+		Samples: map[string][]SampleDurations{
+			"find": {{Url: "q=a.*", Stats: map[string]float64{"max": 20}, Status: map[string]float64{"200": 10}, Count: 10}},
+		},
+	}
+
+	mock.ExpectQuery(`^SELECT id, start, label, url, max\(value\) FROM t_k6_samples WHERE id = @Id AND start = @Time AND metric = @Metric ` +
+		`AND ts >= @From AND ts < @Until GROUP BY`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "url", "max"}).
+			AddRow(uint64(1), start, "find", "q=a.*", 30.0))
+	mock.ExpectQuery(`^SELECT id, start, label, url, status, sum\(value\) FROM t_k6_samples WHERE .* AND ts >= @From AND ts < @Until GROUP BY`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "url", "status", "count"}).
+			AddRow(uint64(1), start, "find", "q=a.*", "200", 10.0))
+	mock.ExpectQuery(`^SELECT id, start, label, tags\['group'\] AS check_group.* AND ts >= @From AND ts < @Until GROUP BY`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "group", "check", "passes", "count"}))
+	mock.ExpectQuery(`^SELECT id, start, min\(ts\), max\(ts\) FROM t_k6_samples WHERE id = @Id AND start = @Time AND ts >= @From AND ts < @Until GROUP BY`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "from", "until"}).
+			AddRow(uint64(1), start, from, until))
+	mock.ExpectQuery(`^SELECT id, start, label, url, metric, sum\(value\) FROM t_k6_samples WHERE .* AND ts >= @From AND ts < @Until GROUP BY`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "url", "metric", "value"}))
+	mock.ExpectQuery(`^SELECT max\(ts\) FROM t_k6_samples WHERE id = @Id AND start = @Time$`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(time.Now().UTC()))
+
+	live, qErr := d.GetHttpLiveSamples(context.Background(), test, ref, SampleFilter{Stats: []string{"max"}}, from, until, DefaultRegressionThreshold)
+	if qErr != nil {
+		t.Fatal(qErr)
+	}
+	assert.Equal(t, from, live.From)
+	assert.Equal(t, until, live.Until)
+	assert.False(t, live.Finished)
+	assert.Equal(t, 10.0, live.Samples.Duration)
+	assert.Equal(t, []Regression{{Label: "find", Url: "q=a.*", Stat: "max", Value: 30, RefValue: 20, DiffPcnt: 50}}, live.Regressions)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,83 @@
+package dbs
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/msaf1980/go-stringutils"
+)
+
+// ParseParams parse test params in space-separated K=V format (like RENDER_FORMAT=carbonapi_v3_pb USERS_FIND=2).
+// Item without '=' stored as key with empty value, for duplicate keys last value is used.
+func ParseParams(params string) map[string]string {
+	fields := strings.Fields(params)
+	if len(fields) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(fields))
+	for _, field := range fields {
+		if n := strings.IndexByte(field, '='); n == -1 {
+			m[field] = ""
+		} else if n > 0 {
+			m[field[:n]] = field[n+1:]
+		}
+	}
+	return m
+}
+
+// ParamDiff is a param, changed between test and reference
+type ParamDiff struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	RefValue string `json:"ref-value"`
+	New      bool   `json:"new,omitempty"`     // not exist in reference
+	Dropped  bool   `json:"dropped,omitempty"` // not exist in test
+}
+
+// DiffParams return changed params (sorted by key)
+func DiffParams(params, refParams map[string]string) []ParamDiff {
+	var diff []ParamDiff
+	for k, v := range params {
+		if refV, exist := refParams[k]; !exist {
+			diff = append(diff, ParamDiff{Key: k, Value: v, New: true})
+		} else if v != refV {
+			diff = append(diff, ParamDiff{Key: k, Value: v, RefValue: refV})
+		}
+	}
+	for k, v := range refParams {
+		if _, exist := params[k]; !exist {
+			diff = append(diff, ParamDiff{Key: k, RefValue: v, Dropped: true})
+		}
+	}
+	sort.Slice(diff, func(i, j int) bool {
+		return diff[i].Key < diff[j].Key
+	})
+	return diff
+}
+
+// paramRegexp return regular expression for match param in params string (empty value match any value)
+func paramRegexp(key, value string) string {
+	var re stringutils.Builder
+	re.Grow(len(key) + len(value) + 16)
+	_, _ = re.WriteString(`(^|\s)`)
+	_, _ = re.WriteString(regexp.QuoteMeta(key))
+	if value == "" {
+		_, _ = re.WriteString(`(=|\s|$)`)
+	} else {
+		_ = re.WriteByte('=')
+		_, _ = re.WriteString(regexp.QuoteMeta(value))
+		_, _ = re.WriteString(`(\s|$)`)
+	}
+	return re.String()
+}
+
+// sortedKeys return sorted map keys (for stable query)
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
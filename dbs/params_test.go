@@ -0,0 +1,44 @@
+package dbs
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseParams(t *testing.T) {
+	assert.Equal(t,
+		map[string]string{"RENDER_FORMAT": "carbonapi_v3_pb", "USERS_FIND": "2", "DURATION": "1h", "DEBUG": "", "Q": "a=b"},
+		ParseParams("  RENDER_FORMAT=carbonapi_v3_pb\tUSERS_FIND=2  DURATION=1h DEBUG =skip Q=a=b"),
+	)
+	assert.Nil(t, ParseParams(" "))
+}
+
+func TestDiffParams(t *testing.T) {
+	diff := DiffParams(
+		ParseParams("USERS_FIND=2 DURATION=1h RENDER_FORMAT=json"),
+		ParseParams("USERS_FIND=1 DURATION=1h DEBUG"),
+	)
+	assert.Equal(t, []ParamDiff{
+		{Key: "DEBUG", Dropped: true},
+		{Key: "RENDER_FORMAT", Value: "json", New: true},
+		{Key: "USERS_FIND", Value: "2", RefValue: "1"},
+	}, diff)
+	assert.Nil(t, DiffParams(ParseParams("USERS=2"), ParseParams("USERS=2")))
+}
+
+func TestParamRegexp(t *testing.T) {
+	re := regexp.MustCompile(paramRegexp("USERS_FIND", "2"))
+	assert.True(t, re.MatchString("RENDER_FORMAT=json USERS_FIND=2 DURATION=1h"))
+	assert.True(t, re.MatchString("USERS_FIND=2"))
+	assert.False(t, re.MatchString("USERS_FIND=20"))
+	assert.False(t, re.MatchString("XUSERS_FIND=2"))
+
+	re = regexp.MustCompile(paramRegexp("DEBUG", ""))
+	assert.True(t, re.MatchString("USERS=1 DEBUG"))
+	assert.True(t, re.MatchString("DEBUG=1 USERS=1"))
+	assert.False(t, re.MatchString("DEBUGGER=1"))
+
+	assert.Equal(t, `(^|\s)A\.B=1\+(\s|$)`, paramRegexp("A.B", "1+"))
+}
@@ -0,0 +1,89 @@
+package dbs
+
+import (
+	"strings"
+)
+
+// ErrorInvalidQuantileFunc represents an invalid quantile function wrapped error
+type ErrorInvalidQuantileFunc struct {
+	Value string
+}
+
+func (e ErrorInvalidQuantileFunc) Error() string {
+	return e.Value + " not a quantile function " + quantileFuncString
+}
+
+// ErrorQuantileMismatch represents an error for compare results, calculated with different quantile functions
+type ErrorQuantileMismatch struct {
+	Test      QuantileFunc
+	Reference QuantileFunc
+}
+
+func (e ErrorQuantileMismatch) Error() string {
+	return "quantile function mismatch: test calculated with " + string(e.Test) + ", reference with " + string(e.Reference)
+}
+
+// QuantileFunc is a ClickHouse quantiles aggregate function
+type QuantileFunc string
+
+const (
+	// QuantileSampling is a sampling estimator (reservoir sampling, default)
+	QuantileSampling QuantileFunc = "quantiles"
+	// QuantileExact is an exact quantiles (memory consumption is proportional to the number of values)
+	QuantileExact QuantileFunc = "quantilesExact"
+	// QuantileTDigest is a t-digest estimator
+	QuantileTDigest QuantileFunc = "quantilesTDigest"
+	// QuantileTiming is a fixed precision estimator, optimized for timings in ms (calculated as Float32)
+	QuantileTiming QuantileFunc = "quantilesTiming"
+
+	QuantileDefault = QuantileSampling
+)
+
+var (
+	quantileFuncStrings []string = []string{
+		string(QuantileSampling), string(QuantileExact), string(QuantileTDigest), string(QuantileTiming),
+	}
+	quantileFuncString string = "[" + strings.Join(quantileFuncStrings, ",") + "]"
+)
+
+func QuantileFuncValues() []string {
+	return quantileFuncStrings
+}
+
+func QuantileFuncValuesString() string {
+	return quantileFuncString
+}
+
+// QuantileFuncFromString return quantile function. For empty value QuantileDefault returned.
+func QuantileFuncFromString(value string) (QuantileFunc, error) {
+	switch value {
+	case "":
+		return QuantileDefault, nil
+	case string(QuantileSampling):
+		return QuantileSampling, nil
+	case string(QuantileExact):
+		return QuantileExact, nil
+	case string(QuantileTDigest):
+		return QuantileTDigest, nil
+	case string(QuantileTiming):
+		return QuantileTiming, nil
+	default:
+		return QuantileDefault, ErrorInvalidQuantileFunc{value}
+	}
+}
+
+// CheckQuantileFunc verify, that results calculated with the same quantile function (empty value is QuantileDefault, for compatibility with previously saved results)
+func CheckQuantileFunc(test, ref string) error {
+	testFunc, err := QuantileFuncFromString(test)
+	if err != nil {
+		return err
+	}
+	refFunc, err := QuantileFuncFromString(ref)
+	if err != nil {
+		return err
+	}
+	if testFunc != refFunc {
+		return ErrorQuantileMismatch{Test: testFunc, Reference: refFunc}
+	}
+	return nil
+}
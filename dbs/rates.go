@@ -0,0 +1,194 @@
+package dbs
+
+import (
+	"context"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/msaf1980/go-stringutils"
+	"github.com/msaf1980/go-timeutils"
+)
+
+const (
+	MetricDataReceived = "data_received"
+	MetricDataSent     = "data_sent"
+	MetricIterations   = "iterations"
+)
+
+// TestRange is a first and last sample timestamps of the test
+type TestRange struct {
+	Id    uint64    `json:"id"`
+	Start time.Time `json:"start"` // ts from tests
+	From  time.Time `json:"from"`  // min ts from samples
+	Until time.Time `json:"until"` // max ts from samples
+}
+
+// Duration return test duration in seconds
+func (r TestRange) Duration() float64 {
+	if r.Until.After(r.From) {
+		return r.Until.Sub(r.From).Seconds()
+	}
+	return 0
+}
+
+type SampleRate struct {
+	Id     uint64    `json:"id"`
+	Start  time.Time `json:"start"` // ts from tests
+	Label  string    `json:"label,omitempty"`
+	Url    string    `json:"url"`
+	Metric string    `json:"metric"`
+	Value  float64   `json:"value"`
+}
+
+// GetTestRange return first and last sample timestamps (for calculate test duration)
+func (d *DB) GetTestRange(ctx context.Context, f SampleFilter) (TestRange, *QueryError) {
+	return cachedQuery(ctx, d, "range", f.Id, f.Start, f, func() (TestRange, *QueryError) {
+		return d.getTestRange(ctx, f)
+	})
+}
+
+func (d *DB) getTestRange(ctx context.Context, f SampleFilter) (TestRange, *QueryError) {
+	var query stringutils.Builder
+
+	start := timeutils.UnixNano(f.Start).UTC()
+
+	query.Grow(64)
+	_, _ = query.WriteString("SELECT id, start, min(ts), max(ts) FROM ")
+	_, _ = query.WriteString(d.tableSamples)
+	_, _ = query.WriteString(" WHERE id = @Id AND start = @Time")
+	writeTsRange(&query, &f)
+	_, _ = query.WriteString(" GROUP BY id, start")
+
+	rows, err := d.db.QueryContext(ctx, query.String(), clickhouse.Named("Id", f.Id), clickhouse.DateNamed("Time", start, 3),
+		f.dateNamedFrom(), f.dateNamedUntil(),
+	)
+	if err != nil {
+		return TestRange{}, NewQueryError(err, 0, query.String())
+	}
+	defer rows.Close()
+	r := TestRange{Id: f.Id, Start: start}
+	for rows.Next() {
+		err = rows.Scan(&r.Id, &r.Start, &r.From, &r.Until)
+		if err != nil {
+			return TestRange{}, NewQueryError(err, 0, query.String())
+		}
+	}
+	// get any error encountered during iteration
+	err = rows.Err()
+	if err != nil {
+		return TestRange{}, NewQueryError(err, 0, query.String())
+	}
+
+	return r, nil
+}
+
+// GetSamplesRates return totals for data_received, data_sent and iterations metrics (for calculate per-second rates)
+func (d *DB) GetSamplesRates(ctx context.Context, f SampleFilter) ([]SampleRate, *QueryError) {
+	return cachedQuery(ctx, d, "rates", f.Id, f.Start, f, func() ([]SampleRate, *QueryError) {
+		return d.getSamplesRates(ctx, f)
+	})
+}
+
+func (d *DB) getSamplesRates(ctx context.Context, f SampleFilter) ([]SampleRate, *QueryError) {
+	var query stringutils.Builder
+
+	start := timeutils.UnixNano(f.Start).UTC()
+
+	query.Grow(64)
+	_, _ = query.WriteString("SELECT id, start, label, url, metric, sum(value) FROM ")
+	_, _ = query.WriteString(d.tableSamples)
+	_, _ = query.WriteString(" WHERE id = @Id AND start = @Time AND metric IN (@Received, @Sent, @Iterations)")
+	writeSampleFilter(&query, &f)
+
+	writeTsRange(&query, &f)
+
+	_, _ = query.WriteString(" GROUP BY id, start, label, url, metric ORDER BY label, url, metric")
+
+	rows, err := d.db.QueryContext(
+		ctx, query.String(), f.queryArgs(
+			clickhouse.Named("Id", f.Id), clickhouse.DateNamed("Time", start, 3),
+			f.dateNamedFrom(), f.dateNamedUntil(),
+			clickhouse.Named("Received", MetricDataReceived),
+			clickhouse.Named("Sent", MetricDataSent),
+			clickhouse.Named("Iterations", MetricIterations),
+		)...,
+	)
+	if err != nil {
+		return nil, NewQueryError(err, 0, query.String())
+	}
+	defer rows.Close()
+	rates := make([]SampleRate, 0, 50)
+	for rows.Next() {
+		var r SampleRate
+		err = rows.Scan(&r.Id, &r.Start, &r.Label, &r.Url, &r.Metric, &r.Value)
+		if err != nil {
+			return nil, NewQueryError(err, 0, query.String())
+		}
+		rates = append(rates, r)
+	}
+	// get any error encountered during iteration
+	err = rows.Err()
+	if err != nil {
+		return nil, NewQueryError(err, 0, query.String())
+	}
+
+	return rates, nil
+}
+
+type rateKey struct {
+	Label string
+	Url   string
+}
+
+type rateValues struct {
+	Received   float64
+	Sent       float64
+	Iterations float64
+}
+
+// MergeRates set test duration and calculate per-second rates (RPS, bytes/s, iterations/s).
+// Iterations are usually not tagged with url, so label totals used for urls without own iterations.
+func MergeRates(samples *TestSamples, r TestRange, rates []SampleRate) {
+	duration := r.Duration()
+	samples.Duration = duration
+	if duration == 0 {
+		return
+	}
+
+	mRates := make(map[rateKey]*rateValues)
+	for _, rate := range rates {
+		key := rateKey{Label: rate.Label, Url: rate.Url}
+		v := mRates[key]
+		if v == nil {
+			v = &rateValues{}
+			mRates[key] = v
+		}
+		switch rate.Metric {
+		case MetricDataReceived:
+			v.Received += rate.Value
+		case MetricDataSent:
+			v.Sent += rate.Value
+		case MetricIterations:
+			v.Iterations += rate.Value
+		}
+	}
+
+	for label, durations := range samples.Samples {
+		labelRates := mRates[rateKey{Label: label}]
+		for i := range durations {
+			d := &durations[i]
+			d.RPS = d.Count / duration
+			if v := mRates[rateKey{Label: label, Url: d.Url}]; v != nil {
+				d.RecvRate = v.Received / duration
+				d.SentRate = v.Sent / duration
+				if v.Iterations > 0 {
+					d.IterationsRate = v.Iterations / duration
+				} else if labelRates != nil {
+					d.IterationsRate = labelRates.Iterations / duration
+				}
+			} else if labelRates != nil {
+				d.IterationsRate = labelRates.Iterations / duration
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package dbs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeRates(t *testing.T) {
+	start := time.Unix(1674196900, 0).UTC()
+	samples := &TestSamples{
+		Samples: map[string][]SampleDurations{
+			"find": {
+				{Url: "q=a.*", Count: 100},
+				{Url: "q=b.*", Count: 50},
+			},
+			"render": {
+				{Url: "target=a.*", Count: 10},
+			},
+		},
+	}
+	r := TestRange{Start: start, From: start, Until: start.Add(10 * time.Second)}
+	rates := []SampleRate{
+		{Label: "find", Metric: MetricIterations, Value: 150},
+		{Label: "find", Url: "q=a.*", Metric: MetricDataReceived, Value: 1000},
+		{Label: "find", Url: "q=a.*", Metric: MetricDataSent, Value: 200},
+		{Label: "render", Url: "target=a.*", Metric: MetricDataReceived, Value: 500},
+		{Label: "render", Url: "target=a.*", Metric: MetricIterations, Value: 20},
+	}
+
+	MergeRates(samples, r, rates)
+
+	want := &TestSamples{
+		Duration: 10,
+		Samples: map[string][]SampleDurations{
+			"find": {
+				{Url: "q=a.*", Count: 100, RPS: 10, RecvRate: 100, SentRate: 20, IterationsRate: 15},
+				{Url: "q=b.*", Count: 50, RPS: 5, IterationsRate: 15},
+			},
+			"render": {
+				{Url: "target=a.*", Count: 10, RPS: 1, RecvRate: 50, IterationsRate: 2},
+			},
+		},
+	}
+	assert.Equal(t, want, samples)
+
+	// empty range
+	samples = &TestSamples{
+		Samples: map[string][]SampleDurations{
+			"find": {{Url: "q=a.*", Count: 100}},
+		},
+	}
+	MergeRates(samples, TestRange{Start: start}, rates)
+	assert.Equal(t, 0.0, samples.Duration)
+	assert.Equal(t, 0.0, samples.Samples["find"][0].RPS)
+}
+
+func TestSortSamplesDurationsByDiffRPS(t *testing.T) {
+	durations := []SampleDurationsDiff{
+		{Url: "a", RPS: 10, RPSDiff: -5},
+		{Url: "b", RPS: 5, RPSDiff: 2},
+		{Url: "c", RPS: 20, RPSDiff: 0},
+	}
+	SortSamplesDurationsByDiff(durations, SortByRPS)
+	assert.Equal(t, []string{"b", "c", "a"}, []string{durations[0].Url, durations[1].Url, durations[2].Url})
+
+	SortSamplesDurationsDiff(durations, SortByRPS)
+	assert.Equal(t, []string{"c", "a", "b"}, []string{durations[0].Url, durations[1].Url, durations[2].Url})
+}
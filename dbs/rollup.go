@@ -0,0 +1,248 @@
+package dbs
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/msaf1980/go-stringutils"
+	"github.com/msaf1980/go-timeutils"
+	"github.com/msaf1980/k6-stat/migrate"
+)
+
+// Rollup table is an optional pre-aggregated (per minute) samples table (AggregatingMergeTree), populated by materialized views.
+// It's used for durations, statuses and series queries, if quantiles function state is stored in rollup table (quantilesTiming,
+// it's deterministic on merge) or no quantiles requested. Rollup is used only for tests, started after materialized views creation
+// (older tests may be partially written to rollup table), other tests are read from samples table.
+
+// rollupCheckInterval is a rollup table and views existence recheck interval
+const rollupCheckInterval = time.Minute
+
+// SetTableRollup set rollup table (empty for disable rollup). Table and views existence is checked at first use
+// and rechecked every rollupCheckInterval.
+func (d *DB) SetTableRollup(tableRollup string) {
+	d.rollupMu.Lock()
+	d.tableRollup = tableRollup
+	d.rollupChecked = time.Time{}
+	d.rollupExist = false
+	d.rollupSince = time.Time{}
+	d.rollupMu.Unlock()
+}
+
+func (d *DB) TableRollup() string {
+	return d.tableRollup
+}
+
+// rollupState check, that rollup table is set and exist and return materialized views creation time (zero, if views not exist)
+func (d *DB) rollupState(ctx context.Context) (bool, time.Time) {
+	d.rollupMu.Lock()
+	defer d.rollupMu.Unlock()
+
+	if d.tableRollup == "" {
+		return false, time.Time{}
+	}
+	if time.Since(d.rollupChecked) >= rollupCheckInterval {
+		var (
+			exist uint8
+			since time.Time
+		)
+		if err := d.db.QueryRowContext(ctx, "EXISTS TABLE "+d.tableRollup).Scan(&exist); err != nil {
+			// retry at next query
+			return false, time.Time{}
+		}
+		if exist == 1 {
+			// views are attached to local table in cluster deployment
+			rollup := d.tableRollup
+			if d.cluster != "" {
+				rollup += migrate.LocalSuffix
+			}
+			var (
+				views   uint64
+				created time.Time
+			)
+			if err := d.db.QueryRowContext(
+				ctx, "SELECT count(), max(metadata_modification_time) FROM system.tables WHERE database = currentDatabase() AND name IN (@Durations, @Reqs)",
+				clickhouse.Named("Durations", rollup+migrate.RollupDurationsView), clickhouse.Named("Reqs", rollup+migrate.RollupReqsView),
+			).Scan(&views, &created); err != nil {
+				return false, time.Time{}
+			}
+			if views == 2 {
+				// metadata modification time is truncated to seconds
+				since = created.Add(time.Second)
+			}
+		}
+		d.rollupChecked = time.Now()
+		d.rollupExist = exist == 1
+		d.rollupSince = since
+	}
+	return d.rollupExist, d.rollupSince
+}
+
+// hasRollup check, that rollup table is set and exist
+func (d *DB) hasRollup(ctx context.Context) bool {
+	exist, _ := d.rollupState(ctx)
+	return exist
+}
+
+// useRollup check, that test samples can be read from rollup table (test started after materialized views creation)
+func (d *DB) useRollup(ctx context.Context, start int64) bool {
+	exist, since := d.rollupState(ctx)
+	return exist && !since.IsZero() && start >= since.UnixNano()
+}
+
+// rollupQuantiles return rollup table column with quantiles function state (empty, if not stored)
+func rollupQuantiles(quantileFunc QuantileFunc) string {
+	switch quantileFunc {
+	case QuantileTiming:
+		return "d_quantiles"
+	default:
+		return ""
+	}
+}
+
+// rollupSupported check, that statistics can be calculated from rollup table
+func (s Stats) rollupSupported(quantileFunc QuantileFunc) bool {
+	if rollupQuantiles(quantileFunc) != "" {
+		return true
+	}
+	for i := range s {
+		if s[i].Kind == StatQuantile {
+			return false
+		}
+	}
+	return true
+}
+
+// writeRollupAggregates write aggregate functions for rollup table states (in writeAggregates order)
+func (s Stats) writeRollupAggregates(query *stringutils.Builder, quantileFunc QuantileFunc) {
+	var n int
+	for i := range s {
+		if s[i].Kind == StatQuantile {
+			if n == 0 {
+				if quantileFunc == QuantileTiming {
+					// quantilesTiming return Array(Float32)
+					_, _ = query.WriteString("arrayMap(x -> toFloat64(x), ")
+				}
+				_, _ = query.WriteString(string(quantileFunc))
+				_, _ = query.WriteString("Merge(")
+			} else {
+				_, _ = query.WriteString(", ")
+			}
+			_, _ = query.WriteString(strconv.FormatFloat(s[i].Level, 'f', -1, 64))
+			n++
+		}
+	}
+	if n > 0 {
+		_, _ = query.WriteString(")(")
+		_, _ = query.WriteString(rollupQuantiles(quantileFunc))
+		_ = query.WriteByte(')')
+		if quantileFunc == QuantileTiming {
+			_ = query.WriteByte(')')
+		}
+	}
+	for i := range s {
+		if s[i].Kind == StatQuantile {
+			continue
+		}
+		if n > 0 {
+			_, _ = query.WriteString(", ")
+		}
+		switch s[i].Kind {
+		case StatMin:
+			_, _ = query.WriteString("minMerge(d_min)")
+		case StatMax:
+			_, _ = query.WriteString("maxMerge(d_max)")
+		case StatMean:
+			_, _ = query.WriteString("sumMerge(d_sum) / countMerge(d_count)")
+		case StatStddev:
+			_, _ = query.WriteString("stddevPopMerge(d_stddev)")
+		}
+		n++
+	}
+}
+
+func (d *DB) getHttpSamplesDurationsRollup(ctx context.Context, f *SampleFilter, stats Stats, quantileFunc QuantileFunc) ([]SampleQuantiles, *QueryError) {
+	var query stringutils.Builder
+
+	start := timeutils.UnixNano(f.Start).UTC()
+
+	query.Grow(128)
+	_, _ = query.WriteString("SELECT id, start, label, url, ")
+	stats.writeRollupAggregates(&query, quantileFunc)
+	_, _ = query.WriteString(" FROM ")
+	_, _ = query.WriteString(d.tableRollup)
+	_, _ = query.WriteString(" WHERE id = @Id AND start = @Time")
+	writeSampleFilter(&query, f)
+	_, _ = query.WriteString(" GROUP BY id, start, label, url HAVING countMerge(d_count) > 0 ORDER BY label, url")
+
+	rows, err := d.db.QueryContext(
+		ctx, query.String(), f.queryArgs(
+			clickhouse.Named("Id", f.Id), clickhouse.DateNamed("Time", start, 3),
+		)...,
+	)
+	if err != nil {
+		return nil, NewQueryError(err, 0, query.String())
+	}
+	defer rows.Close()
+	samples := make([]SampleQuantiles, 0, 50)
+	sc := stats.newScanner()
+	dest := append([]any{nil, nil, nil, nil}, sc.dest...)
+	for rows.Next() {
+		var s SampleQuantiles
+		dest[0], dest[1], dest[2], dest[3] = &s.Id, &s.Start, &s.Label, &s.Url
+		err = rows.Scan(dest...)
+		if err != nil {
+			return nil, NewQueryError(err, 0, query.String())
+		}
+		s.Quantile = string(quantileFunc)
+		s.Stats = sc.result()
+		samples = append(samples, s)
+	}
+	// get any error encountered during iteration
+	err = rows.Err()
+	if err != nil {
+		return nil, NewQueryError(err, 0, query.String())
+	}
+
+	return samples, nil
+}
+
+func (d *DB) getHttpSamplesStatusRollup(ctx context.Context, f *SampleFilter) ([]SampleStatus, *QueryError) {
+	var query stringutils.Builder
+
+	start := timeutils.UnixNano(f.Start).UTC()
+
+	query.Grow(128)
+	_, _ = query.WriteString("SELECT id, start, label, url, status, sumMerge(reqs) AS reqs_count FROM ")
+	_, _ = query.WriteString(d.tableRollup)
+	_, _ = query.WriteString(" WHERE id = @Id AND start = @Time")
+	writeSampleFilter(&query, f)
+	_, _ = query.WriteString(" GROUP BY id, start, label, url, status HAVING reqs_count > 0 ORDER BY label, url, status")
+
+	rows, err := d.db.QueryContext(
+		ctx, query.String(), f.queryArgs(
+			clickhouse.Named("Id", f.Id), clickhouse.DateNamed("Time", start, 3),
+		)...,
+	)
+	if err != nil {
+		return nil, NewQueryError(err, 0, query.String())
+	}
+	defer rows.Close()
+	samples := make([]SampleStatus, 0, 50)
+	for rows.Next() {
+		var s SampleStatus
+		err = rows.Scan(&s.Id, &s.Start, &s.Label, &s.Url, &s.Status, &s.Count)
+		if err != nil {
+			return nil, NewQueryError(err, 0, query.String())
+		}
+		samples = append(samples, s)
+	}
+	// get any error encountered during iteration
+	err = rows.Err()
+	if err != nil {
+		return nil, NewQueryError(err, 0, query.String())
+	}
+
+	return samples, nil
+}
@@ -0,0 +1,124 @@
+package dbs
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+type passValueConverter struct{}
+
+func (passValueConverter) ConvertValue(v any) (driver.Value, error) {
+	return v, nil
+}
+
+func expectRollupViews(mock sqlmock.Sqlmock, created time.Time) {
+	mock.ExpectQuery(`^EXISTS TABLE t_k6_samples_rollup$`).WillReturnRows(sqlmock.NewRows([]string{"result"}).AddRow(uint8(1)))
+	mock.ExpectQuery(`^SELECT count\(\), max\(metadata_modification_time\) FROM system.tables WHERE database = currentDatabase\(\) AND name IN \(@Durations, @Reqs\)$`).
+		WillReturnRows(sqlmock.NewRows([]string{"count()", "max(metadata_modification_time)"}).AddRow(uint64(2), created))
+}
+
+func TestGetHttpSamplesDurationsRollup(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(db, "t_k6_tests", "t_k6_samples")
+	d.SetTableRollup("t_k6_samples_rollup")
+	start := time.Unix(1674196900, 0).UTC()
+
+	expectRollupViews(mock, start.Add(-time.Hour))
+	mock.ExpectQuery(`^SELECT id, start, label, url, arrayMap\(x -> toFloat64\(x\), quantilesTimingMerge\(0.5, 0.99\)\(d_quantiles\)\), maxMerge\(d_max\) ` +
+		`FROM t_k6_samples_rollup WHERE id = @Id AND start = @Time AND label LIKE @Label ` +
+		`GROUP BY id, start, label, url HAVING countMerge\(d_count\) > 0 ORDER BY label, url$`).
+		WillReturnRows(mock.NewRows([]string{"id", "start", "label", "url", "q", "max"}).
+			AddRow(uint64(1), start, "find", "q=a.*", []float64{10, 20}, 30.0))
+	mock.ExpectQuery(`^SELECT id, start, label, url, status, sumMerge\(reqs\) AS reqs_count FROM t_k6_samples_rollup WHERE id = @Id AND start = @Time AND label LIKE @Label `).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "url", "status", "reqs_count"}).
+			AddRow(uint64(1), start, "find", "q=a.*", "200", 10.0))
+	// default quantiles are read from samples table
+	mock.ExpectQuery(`^SELECT id, start, label, url, quantiles\(0.5, 0.99\)\(value\), max\(value\) ` +
+		`FROM t_k6_samples WHERE id = @Id AND start = @Time AND metric = @Metric AND label LIKE @Label `).
+		WillReturnRows(mock.NewRows([]string{"id", "start", "label", "url", "q", "max"}).
+			AddRow(uint64(1), start, "find", "q=a.*", []float64{11, 21}, 30.0))
+
+	f := SampleFilter{Id: 1, Start: start.UnixNano(), Label: "find", Stats: []string{"p50", "p99", "max"}, Quantile: string(QuantileTiming)}
+	samples, qErr := d.GetHttpSamplesDurations(context.Background(), f)
+	if qErr != nil {
+		t.Fatal(qErr)
+	}
+	assert.Equal(t, []SampleQuantiles{
+		{Id: 1, Start: start, Label: "find", Url: "q=a.*", Quantile: string(QuantileTiming), Stats: map[string]float64{"p50": 10, "p99": 20, "max": 30}},
+	}, samples)
+
+	statuses, qErr := d.GetHttpSamplesStatus(context.Background(), f)
+	if qErr != nil {
+		t.Fatal(qErr)
+	}
+	assert.Equal(t, []SampleStatus{{Id: 1, Start: start, Label: "find", Url: "q=a.*", Status: "200", Count: 10}}, statuses)
+
+	f.Quantile = ""
+	samples, qErr = d.GetHttpSamplesDurations(context.Background(), f)
+	if qErr != nil {
+		t.Fatal(qErr)
+	}
+	assert.Equal(t, []SampleQuantiles{
+		{Id: 1, Start: start, Label: "find", Url: "q=a.*", Quantile: string(QuantileSampling), Stats: map[string]float64{"p50": 11, "p99": 21, "max": 30}},
+	}, samples)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetHttpSamplesDurationsRollupOlderTest(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(db, "t_k6_tests", "t_k6_samples")
+	d.SetTableRollup("t_k6_samples_rollup")
+	start := time.Unix(1674196900, 0).UTC()
+
+	// test started before views creation (in the same second), so it's read from samples table
+	expectRollupViews(mock, start)
+	mock.ExpectQuery(`^SELECT id, start, label, url, arrayMap\(x -> toFloat64\(x\), quantilesTiming\(0.5\)\(value\)\) FROM t_k6_samples WHERE`).
+		WillReturnRows(mock.NewRows([]string{"id", "start", "label", "url", "q"}).
+			AddRow(uint64(1), start, "find", "q=a.*", []float64{10}))
+	mock.ExpectQuery(`^SELECT id, start, label, url, status, sum\(value\) FROM t_k6_samples WHERE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "url", "status", "count"}).
+			AddRow(uint64(1), start, "find", "q=a.*", "200", 10.0))
+
+	f := SampleFilter{Id: 1, Start: start.Add(100 * time.Millisecond).UnixNano(), Stats: []string{"p50"}, Quantile: string(QuantileTiming)}
+	_, qErr := d.GetHttpSamplesDurations(context.Background(), f)
+	assert.Nil(t, qErr)
+	_, qErr = d.GetHttpSamplesStatus(context.Background(), f)
+	assert.Nil(t, qErr)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRollupStateRecheck(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(db, "t_k6_tests", "t_k6_samples")
+	d.SetTableRollup("t_k6_samples_rollup")
+	created := time.Unix(1674196900, 0).UTC()
+
+	mock.ExpectQuery(`^EXISTS TABLE t_k6_samples_rollup$`).WillReturnRows(sqlmock.NewRows([]string{"result"}).AddRow(uint8(0)))
+	assert.False(t, d.hasRollup(context.Background()))
+	// cached until recheck interval
+	assert.False(t, d.hasRollup(context.Background()))
+
+	// rollup is created after migration
+	d.rollupChecked = time.Now().Add(-rollupCheckInterval)
+	expectRollupViews(mock, created)
+	assert.True(t, d.useRollup(context.Background(), created.Add(time.Second).UnixNano()))
+	assert.False(t, d.useRollup(context.Background(), created.UnixNano()))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
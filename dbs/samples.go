@@ -1,10 +1,12 @@
 package dbs
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
@@ -28,34 +30,27 @@ type Sample struct {
 type SampleDurations struct {
 	Url string `json:"url"`
 
-	// query durations
-	P50 float64 `json:"p50"`
-	P90 float64 `json:"p90"`
-	P95 float64 `json:"p95"`
-	P99 float64 `json:"p99"`
-	Max float64 `json:"max"`
+	// query durations statistics (see TestSamples.Stats for order)
+	Stats map[string]float64 `json:"stats"`
 
 	// status count map
 	Status     map[string]float64 `json:"status"`
 	Count      float64            `json:"count"`
 	ErrorsPcnt float64            `json:"errors"`
+
+	// per-second rates
+	RPS            float64 `json:"rps"`
+	RecvRate       float64 `json:"recv-rate"` // bytes/s
+	SentRate       float64 `json:"sent-rate"` // bytes/s
+	IterationsRate float64 `json:"iterations-rate"`
 }
 
 type SampleDurationsDiff struct {
 	Url string `json:"url"`
 
-	// query durations
-	P50 float64 `json:"p50"`
-	P90 float64 `json:"p90"`
-	P95 float64 `json:"p95"`
-	P99 float64 `json:"p99"`
-	Max float64 `json:"max"`
-
-	P50Diff float64 `json:"p50-diff"`
-	P90Diff float64 `json:"p90-diff"`
-	P95Diff float64 `json:"p95-diff"`
-	P99Diff float64 `json:"p99-diff"`
-	MaxDiff float64 `json:"max-diff"`
+	// query durations statistics (see TestSamplesDiff.Stats for order)
+	Stats     map[string]float64 `json:"stats"`
+	StatsDiff map[string]float64 `json:"stats-diff"`
 
 	// status count map
 	Status     map[string]float64 `json:"status"`
@@ -65,17 +60,38 @@ type SampleDurationsDiff struct {
 	StatusDiff     map[string]float64 `json:"status-diff"`
 	CountDiff      float64            `json:"count-diff"`
 	ErrorsPcntDiff float64            `json:"errors-diff"`
+
+	// per-second rates
+	RPS            float64 `json:"rps"`
+	RecvRate       float64 `json:"recv-rate"` // bytes/s
+	SentRate       float64 `json:"sent-rate"` // bytes/s
+	IterationsRate float64 `json:"iterations-rate"`
+
+	RPSDiff            float64 `json:"rps-diff"`
+	RecvRateDiff       float64 `json:"recv-rate-diff"`
+	SentRateDiff       float64 `json:"sent-rate-diff"`
+	IterationsRateDiff float64 `json:"iterations-rate-diff"`
 }
 
 type TestSamples struct {
-	Test    Test                         `json:"test"`
-	Samples map[string][]SampleDurations `json:"samples"`
+	Test     Test                         `json:"test"`
+	Quantile string                       `json:"quantile,omitempty"` // quantile function, used for calculate stats
+	Stats    []string                     `json:"stats"`              // ordered statistics names
+	Duration float64                      `json:"duration,omitempty"` // seconds, from min/max samples ts
+	Samples  map[string][]SampleDurations `json:"samples"`
+	Checks   []SampleCheck                `json:"checks,omitempty"`
 }
 
 type TestSamplesDiff struct {
-	Test      Test                             `json:"test"`
-	Reference Test                             `json:"ref"`
-	Samples   map[string][]SampleDurationsDiff `json:"samples"`
+	Test              Test                             `json:"test"`
+	Reference         Test                             `json:"ref"`
+	ParamsDiff        []ParamDiff                      `json:"params-diff,omitempty"`
+	Quantile          string                           `json:"quantile,omitempty"`
+	Stats             []string                         `json:"stats"` // ordered statistics names (exist in test and reference)
+	Duration          float64                          `json:"duration,omitempty"`
+	ReferenceDuration float64                          `json:"ref-duration,omitempty"`
+	Samples           map[string][]SampleDurationsDiff `json:"samples"`
+	Checks            []SampleCheckDiff                `json:"checks,omitempty"`
 }
 
 type SampleQuantiles struct {
@@ -84,12 +100,9 @@ type SampleQuantiles struct {
 	Label string    `json:"label,omitempty"`
 	Url   string    `json:"url"`
 
-	// query durations
-	P50 float64 `json:"p50"`
-	P90 float64 `json:"p90"`
-	P95 float64 `json:"p95"`
-	P99 float64 `json:"p99"`
-	Max float64 `json:"max"`
+	// query durations statistics
+	Quantile string             `json:"quantile"` // quantile function, used for calculate stats
+	Stats    map[string]float64 `json:"stats"`
 }
 
 type SampleStatus struct {
@@ -108,40 +121,57 @@ type SampleFilter struct {
 	Url     string   `json:"url,omitempty"`
 	SkipUrl []string `json:"no-url,omitempty"`
 	// Metrics []string `json:"metrics,omitempty"`
+	// durations statistics (min, max, mean, stddev or percentile like p99.9), DefaultStats if empty
+	Stats []string `json:"stats,omitempty"`
+	// quantile function (quantiles, quantilesExact, quantilesTDigest, quantilesTiming), QuantileDefault if empty
+	Quantile string `json:"quantile,omitempty"`
+	// optional samples ts range [From, Until) in epoch nanoseconds (for live view intervals)
+	From  int64 `json:"from,omitempty"`
+	Until int64 `json:"until,omitempty"`
+}
+
+func (d *DB) GetHttpSamplesDurations(ctx context.Context, f SampleFilter) ([]SampleQuantiles, *QueryError) {
+	return cachedQuery(ctx, d, "durations", f.Id, f.Start, f, func() ([]SampleQuantiles, *QueryError) {
+		return d.getHttpSamplesDurations(ctx, f)
+	})
 }
 
-func (d *DB) GetHttpSamplesDurations(f SampleFilter) ([]SampleQuantiles, *QueryError) {
+func (d *DB) getHttpSamplesDurations(ctx context.Context, f SampleFilter) ([]SampleQuantiles, *QueryError) {
 	var query stringutils.Builder
 
+	stats, err := ParseStats(f.Stats)
+	if err != nil {
+		return nil, NewQueryError(err, http.StatusBadRequest, "")
+	}
+	quantileFunc, err := QuantileFuncFromString(f.Quantile)
+	if err != nil {
+		return nil, NewQueryError(err, http.StatusBadRequest, "")
+	}
+
+	if stats.rollupSupported(quantileFunc) && !f.hasTsRange() && d.useRollup(ctx, f.Start) {
+		return d.getHttpSamplesDurationsRollup(ctx, &f, stats, quantileFunc)
+	}
+
 	start := timeutils.UnixNano(f.Start).UTC()
 
 	query.Grow(64)
-	_, _ = query.WriteString("SELECT id, start, label, url, quantiles(0.5, 0.9, 0.95, 0.99)(value), max(value) as max FROM ")
+	_, _ = query.WriteString("SELECT id, start, label, url, ")
+	stats.writeAggregates(&query, quantileFunc)
+	_, _ = query.WriteString(" FROM ")
 	_, _ = query.WriteString(d.tableSamples)
 	_, _ = query.WriteString(" WHERE id = @Id AND start = @Time AND metric = @Metric")
-	if f.Label != "" {
-		_, _ = query.WriteString(" AND label LIKE @Label")
-	}
-	if f.Url != "" {
-		_, _ = query.WriteString(" AND url LIKE @Url")
-	}
-	if len(f.SkipUrl) > 0 {
-		for _, n := range f.SkipUrl {
-			if n != "" {
-				_, _ = query.WriteString(" AND url NOT LIKE '")
-				_, _ = query.WriteString(n)
-				_, _ = query.WriteString("'")
-			}
-		}
-	}
+	writeSampleFilter(&query, &f)
+
+	writeTsRange(&query, &f)
 
 	_, _ = query.WriteString(" GROUP BY id, start, label, url ORDER BY label, url")
 
-	rows, err := d.db.Query(
-		query.String(), clickhouse.Named("Id", f.Id), clickhouse.DateNamed("Time", start, 3),
-		clickhouse.Named("Label", f.Label),
-		clickhouse.Named("Url", f.Url),
-		clickhouse.Named("Metric", "http_req_duration"),
+	rows, err := d.db.QueryContext(
+		ctx, query.String(), f.queryArgs(
+			clickhouse.Named("Id", f.Id), clickhouse.DateNamed("Time", start, 3),
+			f.dateNamedFrom(), f.dateNamedUntil(),
+			clickhouse.Named("Metric", "http_req_duration"),
+		)...,
 	)
 	if err != nil {
 		// app.logger.Error().Uint64("id", c.Context().ID()).Str("sql", query.String()).Err(err).Msg("get http samples duration")
@@ -149,20 +179,18 @@ func (d *DB) GetHttpSamplesDurations(f SampleFilter) ([]SampleQuantiles, *QueryE
 	}
 	defer rows.Close()
 	samples := make([]SampleQuantiles, 0, 50)
+	sc := stats.newScanner()
+	dest := append([]any{nil, nil, nil, nil}, sc.dest...)
 	for rows.Next() {
-		var (
-			s SampleQuantiles
-			q []float64
-		)
-		err = rows.Scan(&s.Id, &s.Start, &s.Label, &s.Url, &q, &s.Max)
+		var s SampleQuantiles
+		dest[0], dest[1], dest[2], dest[3] = &s.Id, &s.Start, &s.Label, &s.Url
+		err = rows.Scan(dest...)
 		if err != nil {
 			// handle this error
 			return nil, NewQueryError(err, 0, query.String())
 		}
-		s.P50 = q[0]
-		s.P90 = q[1]
-		s.P95 = q[2]
-		s.P99 = q[3]
+		s.Quantile = string(quantileFunc)
+		s.Stats = sc.result()
 		samples = append(samples, s)
 	}
 	// get any error encountered during iteration
@@ -174,42 +202,41 @@ func (d *DB) GetHttpSamplesDurations(f SampleFilter) ([]SampleQuantiles, *QueryE
 	return samples, nil
 }
 
-func (d *DB) GetHttpSamplesStatus(f SampleFilter) ([]SampleStatus, *QueryError) {
+func (d *DB) GetHttpSamplesStatus(ctx context.Context, f SampleFilter) ([]SampleStatus, *QueryError) {
+	return cachedQuery(ctx, d, "status", f.Id, f.Start, f, func() ([]SampleStatus, *QueryError) {
+		return d.getHttpSamplesStatus(ctx, f)
+	})
+}
+
+func (d *DB) getHttpSamplesStatus(ctx context.Context, f SampleFilter) ([]SampleStatus, *QueryError) {
 	var query stringutils.Builder
 
+	if !f.hasTsRange() && d.useRollup(ctx, f.Start) {
+		return d.getHttpSamplesStatusRollup(ctx, &f)
+	}
+
 	start := timeutils.UnixNano(f.Start).UTC()
 
 	query.Grow(64)
 	_, _ = query.WriteString("SELECT id, start, label, url, status, sum(value) FROM ")
 	_, _ = query.WriteString(d.tableSamples)
 	_, _ = query.WriteString(" WHERE id = @Id AND start = @Time AND metric = @Metric")
-	if f.Label != "" {
-		_, _ = query.WriteString(" AND label LIKE @Label")
-	}
-	if f.Url != "" {
-		_, _ = query.WriteString(" AND url LIKE @Url")
-	}
-	if len(f.SkipUrl) > 0 {
-		for _, s := range f.SkipUrl {
-			if s != "" {
-				_, _ = query.WriteString(" AND url NOT LIKE '")
-				_, _ = query.WriteString(s)
-				_, _ = query.WriteString("'")
-			}
-		}
-	}
+	writeSampleFilter(&query, &f)
+
+	writeTsRange(&query, &f)
 
 	_, _ = query.WriteString(" GROUP BY id, start, label, url, status ORDER BY label, url, status")
 
-	rows, err := d.db.Query(
-		query.String(), clickhouse.Named("Id", f.Id), clickhouse.DateNamed("Time", start, 3),
-		clickhouse.Named("Label", f.Label),
-		clickhouse.Named("Url", f.Url),
-		clickhouse.Named("Metric", "http_reqs"),
+	rows, err := d.db.QueryContext(
+		ctx, query.String(), f.queryArgs(
+			clickhouse.Named("Id", f.Id), clickhouse.DateNamed("Time", start, 3),
+			f.dateNamedFrom(), f.dateNamedUntil(),
+			clickhouse.Named("Metric", "http_reqs"),
+		)...,
 	)
 	if err != nil {
 		// app.logger.Error().Uint64("id", c.Context().ID()).Str("sql", query.String()).Err(err).Msg("get http samples status")
-		return nil, NewQueryError(err, http.StatusInternalServerError, query.String())
+		return nil, NewQueryError(err, 0, query.String())
 	}
 	defer rows.Close()
 	samples := make([]SampleStatus, 0, 50)
@@ -238,12 +265,16 @@ type mergeKey struct {
 	Url   string
 }
 
-func MergeSamples(test Test, quantiles []SampleQuantiles, statuses []SampleStatus) *TestSamples {
+// MergeSamples merge durations and statuses. stats is an ordered statistics names list (DefaultStats if empty).
+func MergeSamples(test Test, stats []string, quantiles []SampleQuantiles, statuses []SampleStatus) *TestSamples {
+	if len(stats) == 0 {
+		stats = DefaultStats
+	}
 	mDurations := make(map[mergeKey]*SampleDurations)
 	for _, q := range quantiles {
 		mDurations[mergeKey{Id: q.Id, Start: q.Start, Label: q.Label, Url: q.Url}] = &SampleDurations{
-			Url: q.Url,
-			P50: q.P50, P90: q.P90, P95: q.P95, P99: q.P99, Max: q.Max,
+			Url:    q.Url,
+			Stats:  q.Stats,
 			Status: make(map[string]float64),
 		}
 	}
@@ -255,6 +286,7 @@ func MergeSamples(test Test, quantiles []SampleQuantiles, statuses []SampleStatu
 			// it's some mistake, status without durations
 			m = &SampleDurations{
 				Url:    s.Url,
+				Stats:  make(map[string]float64),
 				Status: make(map[string]float64),
 			}
 			mDurations[key] = m
@@ -269,14 +301,133 @@ func MergeSamples(test Test, quantiles []SampleQuantiles, statuses []SampleStatu
 		durations[k.Label] = append(durations[k.Label], *m)
 	}
 
-	return &TestSamples{Test: test, Samples: durations}
+	return &TestSamples{Test: test, Stats: stats, Samples: durations}
 }
 
-func DiffSamples(test *TestSamples, ref *TestSamples) *TestSamplesDiff {
+// GetHttpTestSamples load test durations, statuses, checks and rates (queries are executed in parallel) and merge it
+func (d *DB) GetHttpTestSamples(ctx context.Context, test Test, f SampleFilter) (*TestSamples, *QueryError) {
+	stats, err := ParseStats(f.Stats)
+	if err != nil {
+		return nil, NewQueryError(err, http.StatusBadRequest, "")
+	}
+	quantileFunc, err := QuantileFuncFromString(f.Quantile)
+	if err != nil {
+		return nil, NewQueryError(err, http.StatusBadRequest, "")
+	}
+	f.Id = test.Id
+	f.Start = test.Ts.UnixNano()
+
+	// the first failed sub-query cancel others
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg        sync.WaitGroup
+		errOnce   sync.Once
+		qErr      *QueryError
+		quantiles []SampleQuantiles
+		statuses  []SampleStatus
+		checks    []SampleCheck
+		testRange TestRange
+		rates     []SampleRate
+	)
+	run := func(query func() *QueryError) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := query(); err != nil {
+				errOnce.Do(func() {
+					qErr = err
+					cancel()
+				})
+			}
+		}()
+	}
+	run(func() (err *QueryError) {
+		quantiles, err = d.GetHttpSamplesDurations(ctx, f)
+		return
+	})
+	run(func() (err *QueryError) {
+		statuses, err = d.GetHttpSamplesStatus(ctx, f)
+		return
+	})
+	run(func() (err *QueryError) {
+		checks, err = d.GetChecks(ctx, f)
+		return
+	})
+	run(func() (err *QueryError) {
+		testRange, err = d.GetTestRange(ctx, f)
+		return
+	})
+	run(func() (err *QueryError) {
+		rates, err = d.GetSamplesRates(ctx, f)
+		return
+	})
+	wg.Wait()
+	if qErr != nil {
+		return nil, qErr
+	}
+
+	samples := MergeSamples(test, stats.Names(), quantiles, statuses)
+	samples.Quantile = string(quantileFunc)
+	samples.Checks = checks
+	MergeRates(samples, testRange, rates)
+
+	return samples, nil
+}
+
+// commonStats return statistics names, exist in both lists (in the first list order)
+func commonStats(stats, ref []string) []string {
+	common := make([]string, 0, len(stats))
+	for _, name := range stats {
+		for _, refName := range ref {
+			if name == refName {
+				common = append(common, name)
+				break
+			}
+		}
+	}
+	return common
+}
+
+func copyStats(stats map[string]float64) map[string]float64 {
+	m := make(map[string]float64, len(stats))
+	for k, v := range stats {
+		m[k] = v
+	}
+	return m
+}
+
+func newSampleDurationsDiff(v *SampleDurations) SampleDurationsDiff {
+	return SampleDurationsDiff{
+		Url:        v.Url,
+		Stats:      v.Stats,
+		Status:     v.Status,
+		ErrorsPcnt: v.ErrorsPcnt,
+		Count:      v.Count,
+
+		RPS:            v.RPS,
+		RecvRate:       v.RecvRate,
+		SentRate:       v.SentRate,
+		IterationsRate: v.IterationsRate,
+	}
+}
+
+// DiffSamples compare test with reference. Test and reference must be calculated with the same quantile function.
+func DiffSamples(test *TestSamples, ref *TestSamples) (*TestSamplesDiff, error) {
+	if err := CheckQuantileFunc(test.Quantile, ref.Quantile); err != nil {
+		return nil, err
+	}
+	stats := commonStats(test.Stats, ref.Stats)
 	diff := &TestSamplesDiff{
-		Test:      test.Test,
-		Reference: ref.Test,
-		Samples:   make(map[string][]SampleDurationsDiff),
+		Test:              test.Test,
+		Reference:         ref.Test,
+		ParamsDiff:        DiffParams(ParseParams(test.Test.Params), ParseParams(ref.Test.Params)),
+		Quantile:          test.Quantile,
+		Stats:             stats,
+		Duration:          test.Duration,
+		ReferenceDuration: ref.Duration,
+		Samples:           make(map[string][]SampleDurationsDiff),
 	}
 	refMap := make(map[string]*SampleDurations)
 	for label, vt := range test.Samples {
@@ -284,45 +435,31 @@ func DiffSamples(test *TestSamples, ref *TestSamples) *TestSamplesDiff {
 			for k := range refMap {
 				delete(refMap, k)
 			}
-			for _, v := range vr {
-				refMap[v.Url] = &SampleDurations{
-					Url:        v.Url,
-					P50:        v.P50,
-					P90:        v.P90,
-					P95:        v.P95,
-					P99:        v.P99,
-					Max:        v.Max,
-					Status:     v.Status,
-					ErrorsPcnt: v.ErrorsPcnt,
-					Count:      v.Count,
-				}
+			for i := range vr {
+				refMap[vr[i].Url] = &vr[i]
 			}
 			samples := make([]SampleDurationsDiff, 0, len(vt))
-			for _, v := range vt {
+			for i := range vt {
+				v := &vt[i]
 				var s SampleDurationsDiff
 				if d, exist := refMap[v.Url]; exist {
-					s = SampleDurationsDiff{
-						Url:        v.Url,
-						P50:        v.P50,
-						P90:        v.P90,
-						P95:        v.P95,
-						P99:        v.P99,
-						Max:        v.Max,
-						ErrorsPcnt: v.ErrorsPcnt,
-						Count:      v.Count,
-					}
+					s = newSampleDurationsDiff(v)
+					s.Stats = copyStats(v.Stats)
 					s.Status = make(map[string]float64)
 					for status, val := range v.Status {
 						s.Status[status] = val
 					}
 					if v.Count > 0 && d.Count > 0 {
-						s.P50Diff = v.P50 - d.P50
-						s.P90Diff = v.P90 - d.P90
-						s.P95Diff = v.P95 - d.P95
-						s.P99Diff = v.P99 - d.P99
-						s.MaxDiff = v.Max - d.Max
+						s.StatsDiff = make(map[string]float64)
+						for _, name := range stats {
+							s.StatsDiff[name] = v.Stats[name] - d.Stats[name]
+						}
 						s.CountDiff = v.Count - d.Count
 						s.ErrorsPcntDiff = v.ErrorsPcnt - d.ErrorsPcnt
+						s.RPSDiff = v.RPS - d.RPS
+						s.RecvRateDiff = v.RecvRate - d.RecvRate
+						s.SentRateDiff = v.SentRate - d.SentRate
+						s.IterationsRateDiff = v.IterationsRate - d.IterationsRate
 						s.StatusDiff = make(map[string]float64)
 						for status, val := range v.Status {
 							s.StatusDiff[status] = val
@@ -336,17 +473,7 @@ func DiffSamples(test *TestSamples, ref *TestSamples) *TestSamplesDiff {
 					}
 				} else {
 					// no url in reference samples by label
-					s = SampleDurationsDiff{
-						Url:        v.Url,
-						P50:        v.P50,
-						P90:        v.P90,
-						P95:        v.P95,
-						P99:        v.P99,
-						Max:        v.Max,
-						Status:     v.Status,
-						ErrorsPcnt: v.ErrorsPcnt,
-						Count:      v.Count,
-					}
+					s = newSampleDurationsDiff(v)
 				}
 				samples = append(samples, s)
 			}
@@ -354,24 +481,16 @@ func DiffSamples(test *TestSamples, ref *TestSamples) *TestSamplesDiff {
 		} else {
 			// no label in reference samples
 			samples := make([]SampleDurationsDiff, 0, len(vt))
-			for _, v := range vt {
-				samples = append(samples, SampleDurationsDiff{
-					Url:        v.Url,
-					P50:        v.P50,
-					P90:        v.P90,
-					P95:        v.P95,
-					P99:        v.P99,
-					Max:        v.Max,
-					Status:     v.Status,
-					ErrorsPcnt: v.ErrorsPcnt,
-					Count:      v.Count,
-				})
+			for i := range vt {
+				samples = append(samples, newSampleDurationsDiff(&vt[i]))
 			}
 			diff.Samples[label] = samples
 		}
 	}
 
-	return diff
+	diff.Checks = DiffChecks(test.Checks, ref.Checks, ChecksDropThreshold)
+
+	return diff, nil
 }
 
 func HttpErrosPcnt(status map[string]float64) (total, errorsPcnt float64) {
@@ -392,278 +511,91 @@ func HttpErrosPcnt(status map[string]float64) (total, errorsPcnt float64) {
 	return
 }
 
-func SortSamplesDurations(durations []SampleDurations, sortBy SortBy) {
-	switch sortBy {
-	case SortByMax:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].Max == durations[j].Max {
-				if durations[i].ErrorsPcnt == durations[j].ErrorsPcnt {
-					return durations[i].P99 > durations[j].P99
-				}
-				return durations[i].ErrorsPcnt > durations[j].ErrorsPcnt
-			}
-			return durations[i].Max > durations[j].Max
-		})
-	case SortByP99:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].P99 == durations[j].P99 {
-				if durations[i].ErrorsPcnt == durations[j].ErrorsPcnt {
-					return durations[i].Max > durations[j].Max
-				}
-				return durations[i].ErrorsPcnt > durations[j].ErrorsPcnt
-			}
-			return durations[i].P99 > durations[j].P99
-		})
-	case SortByP95:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].P95 == durations[j].P95 {
-				if durations[i].ErrorsPcnt == durations[j].ErrorsPcnt {
-					return durations[i].Max > durations[j].Max
-				}
-				return durations[i].ErrorsPcnt > durations[j].ErrorsPcnt
-			}
-			return durations[i].P95 > durations[j].P95
-		})
-	case SortByP90:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].P90 == durations[j].P90 {
-				if durations[i].ErrorsPcnt == durations[j].ErrorsPcnt {
-					return durations[i].Max > durations[j].Max
-				}
-				return durations[i].ErrorsPcnt > durations[j].ErrorsPcnt
-			}
-			return durations[i].P90 > durations[j].P90
-		})
-	case SortByP50:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].P50 == durations[j].P50 {
-				if durations[i].ErrorsPcnt == durations[j].ErrorsPcnt {
-					return durations[i].P99 > durations[j].P99
-				}
-				return durations[i].ErrorsPcnt > durations[j].ErrorsPcnt
-			}
-			return durations[i].P50 > durations[j].P50
-		})
+func (d *SampleDurations) sortValue(key SortBy) float64 {
+	switch key {
 	case SortByErrors:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].ErrorsPcnt == durations[j].ErrorsPcnt {
-				if durations[i].Max == durations[j].Max {
-					return durations[i].P99 > durations[j].P99
-				}
-				return durations[i].Max > durations[j].Max
-			}
-			return durations[i].ErrorsPcnt > durations[j].ErrorsPcnt
-		})
+		return d.ErrorsPcnt
 	case SortByCount:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].Count == durations[j].Count {
-				if durations[i].ErrorsPcnt == durations[j].ErrorsPcnt {
-					return durations[i].Max > durations[j].Max
-				}
-				return durations[i].Max > durations[j].Max
-			}
-			return durations[i].Count > durations[j].Count
-
-		})
+		return d.Count
+	case SortByRPS:
+		return d.RPS
+	default:
+		return d.Stats[string(key)]
 	}
 }
 
-func SortSamplesDurationsDiff(durations []SampleDurationsDiff, sortBy SortBy) {
-	switch sortBy {
-	case SortByMax:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].Max == durations[j].Max {
-				if durations[i].ErrorsPcnt == durations[j].ErrorsPcnt {
-					return durations[i].P99 > durations[j].P99
-				}
-				return durations[i].ErrorsPcnt > durations[j].ErrorsPcnt
-			}
-			return durations[i].Max > durations[j].Max
-		})
-	case SortByP99:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].P99 == durations[j].P99 {
-				if durations[i].ErrorsPcnt == durations[j].ErrorsPcnt {
-					return durations[i].Max > durations[j].Max
-				}
-				return durations[i].ErrorsPcnt > durations[j].ErrorsPcnt
-			}
-			return durations[i].P99 > durations[j].P99
-		})
-	case SortByP95:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].P95 == durations[j].P95 {
-				if durations[i].ErrorsPcnt == durations[j].ErrorsPcnt {
-					return durations[i].Max > durations[j].Max
-				}
-				return durations[i].ErrorsPcnt > durations[j].ErrorsPcnt
-			}
-			return durations[i].P95 > durations[j].P95
-		})
-	case SortByP90:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].P90 == durations[j].P90 {
-				if durations[i].ErrorsPcnt == durations[j].ErrorsPcnt {
-					return durations[i].Max > durations[j].Max
-				}
-				return durations[i].ErrorsPcnt > durations[j].ErrorsPcnt
-			}
-			return durations[i].P90 > durations[j].P90
-		})
-	case SortByP50:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].P50 == durations[j].P50 {
-				if durations[i].ErrorsPcnt == durations[j].ErrorsPcnt {
-					return durations[i].P99 > durations[j].P99
-				}
-				return durations[i].ErrorsPcnt > durations[j].ErrorsPcnt
-			}
-			return durations[i].P50 > durations[j].P50
-		})
+func (d *SampleDurationsDiff) sortValue(key SortBy) float64 {
+	switch key {
 	case SortByErrors:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].ErrorsPcnt == durations[j].ErrorsPcnt {
-				if durations[i].Max == durations[j].Max {
-					return durations[i].P99 > durations[j].P99
-				}
-				return durations[i].Max > durations[j].Max
-			}
-			return durations[i].ErrorsPcnt > durations[j].ErrorsPcnt
-		})
+		return d.ErrorsPcnt
 	case SortByCount:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].Count == durations[j].Count {
-				if durations[i].ErrorsPcnt == durations[j].ErrorsPcnt {
-					return durations[i].Max > durations[j].Max
-				}
-				return durations[i].Max > durations[j].Max
-			}
-			return durations[i].Count > durations[j].Count
+		return d.Count
+	case SortByRPS:
+		return d.RPS
+	default:
+		return d.Stats[string(key)]
+	}
+}
 
-		})
+func (d *SampleDurationsDiff) sortDiffValue(key SortBy) float64 {
+	switch key {
+	case SortByErrors:
+		return d.ErrorsPcntDiff
+	case SortByCount:
+		return d.CountDiff
+	case SortByRPS:
+		return d.RPSDiff
+	default:
+		return d.StatsDiff[string(key)]
 	}
 }
 
-func SortSamplesDurationsByDiff(durations []SampleDurationsDiff, sortBy SortBy) {
-	switch sortBy {
-	case SortByMax:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].MaxDiff == durations[j].MaxDiff {
-				if durations[i].ErrorsPcntDiff == durations[j].ErrorsPcntDiff {
-					if durations[i].P99Diff == durations[j].P99Diff {
-						// no valid diff
-						if durations[i].Max == durations[j].Max {
-							return durations[i].ErrorsPcnt > durations[j].ErrorsPcnt
-						}
-						return durations[i].Max > durations[j].Max
-					}
-					return durations[i].P99Diff > durations[j].P99Diff
-				}
-				return durations[i].ErrorsPcntDiff > durations[j].ErrorsPcntDiff
-			}
-			return durations[i].MaxDiff > durations[j].MaxDiff
-		})
-	case SortByP99:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].P99Diff == durations[j].P99Diff {
-				if durations[i].MaxDiff == durations[j].MaxDiff {
-					if durations[i].ErrorsPcntDiff == durations[j].ErrorsPcntDiff {
-						// no valid diff
-						if durations[i].Max == durations[j].Max {
-							return durations[i].ErrorsPcnt > durations[j].ErrorsPcnt
-						}
-						return durations[i].Max > durations[j].Max
-					}
-					return durations[i].ErrorsPcntDiff > durations[j].ErrorsPcntDiff
-				}
-				return durations[i].MaxDiff > durations[j].MaxDiff
-			}
-			return durations[i].P99Diff > durations[j].P99Diff
-		})
-	case SortByP95:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].P95Diff == durations[j].P95Diff {
-				if durations[i].MaxDiff == durations[j].MaxDiff {
-					if durations[i].ErrorsPcntDiff == durations[j].ErrorsPcntDiff {
-						// no valid diff
-						if durations[i].Max == durations[j].Max {
-							return durations[i].ErrorsPcnt > durations[j].ErrorsPcnt
-						}
-						return durations[i].Max > durations[j].Max
-					}
-					return durations[i].ErrorsPcntDiff > durations[j].ErrorsPcntDiff
-				}
-				return durations[i].MaxDiff > durations[j].MaxDiff
-			}
-			return durations[i].P95Diff > durations[j].P95Diff
-		})
-	case SortByP90:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].P90Diff == durations[j].P90Diff {
-				if durations[i].MaxDiff == durations[j].MaxDiff {
-					if durations[i].ErrorsPcntDiff == durations[j].ErrorsPcntDiff {
-						// no valid diff
-						if durations[i].Max == durations[j].Max {
-							return durations[i].ErrorsPcnt > durations[j].ErrorsPcnt
-						}
-						return durations[i].Max > durations[j].Max
-					}
-					return durations[i].ErrorsPcntDiff > durations[j].ErrorsPcntDiff
-				}
-				return durations[i].MaxDiff > durations[j].MaxDiff
+// SortSamplesDurations sort by key (descending), ties are sorted by errors, max and p99
+func SortSamplesDurations(durations []SampleDurations, sortBy SortBy) {
+	keys := sortBy.sortKeys()
+	sort.Slice(durations, func(i, j int) bool {
+		for _, key := range keys {
+			vi, vj := durations[i].sortValue(key), durations[j].sortValue(key)
+			if vi != vj {
+				return vi > vj
 			}
-			return durations[i].P90Diff > durations[j].P90Diff
-		})
-	case SortByP50:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].P50Diff == durations[j].P50Diff {
-				if durations[i].MaxDiff == durations[j].MaxDiff {
-					if durations[i].ErrorsPcntDiff == durations[j].ErrorsPcntDiff {
-						// no valid diff
-						if durations[i].Max == durations[j].Max {
-							return durations[i].ErrorsPcnt > durations[j].ErrorsPcnt
-						}
-						return durations[i].Max > durations[j].Max
-					}
-					return durations[i].ErrorsPcntDiff > durations[j].ErrorsPcntDiff
-				}
-				return durations[i].MaxDiff > durations[j].MaxDiff
+		}
+		return false
+	})
+}
+
+// SortSamplesDurationsDiff sort by key (descending), ties are sorted by errors, max and p99
+func SortSamplesDurationsDiff(durations []SampleDurationsDiff, sortBy SortBy) {
+	keys := sortBy.sortKeys()
+	sort.Slice(durations, func(i, j int) bool {
+		for _, key := range keys {
+			vi, vj := durations[i].sortValue(key), durations[j].sortValue(key)
+			if vi != vj {
+				return vi > vj
 			}
-			return durations[i].P50Diff > durations[j].P50Diff
-		})
-	case SortByErrors:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].ErrorsPcntDiff == durations[j].ErrorsPcntDiff {
-				if durations[i].MaxDiff == durations[j].MaxDiff {
-					if durations[i].P99Diff == durations[j].P99Diff {
-						// no valid diff
-						if durations[i].Max == durations[j].Max {
-							return durations[i].ErrorsPcnt > durations[j].ErrorsPcnt
-						}
-						return durations[i].Max > durations[j].Max
-					}
-					return durations[i].P99Diff > durations[j].P99Diff
-				}
-				return durations[i].MaxDiff > durations[j].MaxDiff
+		}
+		return false
+	})
+}
+
+// SortSamplesDurationsByDiff sort by key diff (descending), ties are sorted by max, errors and p99 diffs.
+// Samples without valid diff are sorted by key value.
+func SortSamplesDurationsByDiff(durations []SampleDurationsDiff, sortBy SortBy) {
+	keys := sortBy.sortDiffKeys()
+	sort.Slice(durations, func(i, j int) bool {
+		for _, key := range keys {
+			vi, vj := durations[i].sortDiffValue(key), durations[j].sortDiffValue(key)
+			if vi != vj {
+				return vi > vj
 			}
-			return durations[i].ErrorsPcntDiff > durations[j].ErrorsPcntDiff
-		})
-	case SortByCount:
-		sort.Slice(durations, func(i, j int) bool {
-			if durations[i].CountDiff == durations[j].CountDiff {
-				if durations[i].MaxDiff == durations[j].MaxDiff {
-					if durations[i].ErrorsPcntDiff == durations[j].ErrorsPcntDiff {
-						// no valid diff
-						if durations[i].Max == durations[j].Max {
-							return durations[i].ErrorsPcnt > durations[j].ErrorsPcnt
-						}
-						return durations[i].Max > durations[j].Max
-					}
-					return durations[i].ErrorsPcntDiff > durations[j].ErrorsPcntDiff
-				}
-				return durations[i].MaxDiff > durations[j].MaxDiff
+		}
+		// no valid diff
+		for _, key := range []SortBy{sortBy, SortByErrors} {
+			vi, vj := durations[i].sortValue(key), durations[j].sortValue(key)
+			if vi != vj {
+				return vi > vj
 			}
-			return durations[i].CountDiff > durations[j].CountDiff
-		})
-	}
+		}
+		return false
+	})
 }
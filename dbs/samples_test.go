@@ -1,11 +1,16 @@
 package dbs
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"reflect"
 	"strconv"
 	"testing"
 	"time"
 
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/assert"
 )
@@ -22,21 +27,21 @@ func TestSortSamplesDurations(t *testing.T) {
 			sortBy: SortByP99,
 			durations: []SampleDurations{
 				{
-					P50: 1, P90: 2, P95: 3, P99: 4, Max: 4,
+					Stats: map[string]float64{"p50": 1, "p90": 2, "p95": 3, "p99": 4, "max": 4},
 					Status: map[string]float64{
 						"200": 1, "400": 1, "404": 1,
 						"500": 4, "503": 3,
 					},
 				},
 				{
-					P50: 1, P90: 2, P95: 3, P99: 5, Max: 5,
+					Stats: map[string]float64{"p50": 1, "p90": 2, "p95": 3, "p99": 5, "max": 5},
 					Status: map[string]float64{
 						"200": 1, "400": 1, "404": 1,
 						"500": 4, "503": 3,
 					},
 				},
 				{
-					P50: 1, P90: 2, P95: 2, P99: 4, Max: 4,
+					Stats: map[string]float64{"p50": 1, "p90": 2, "p95": 2, "p99": 4, "max": 4},
 					Status: map[string]float64{
 						"400": 1, "404": 1,
 						"500": 5, "503": 3,
@@ -45,7 +50,7 @@ func TestSortSamplesDurations(t *testing.T) {
 			},
 			want: []SampleDurations{
 				{
-					P50: 1, P90: 2, P95: 3, P99: 5, Max: 5,
+					Stats: map[string]float64{"p50": 1, "p90": 2, "p95": 3, "p99": 5, "max": 5},
 					Status: map[string]float64{
 						"200": 1, "400": 1, "404": 1,
 						"500": 4, "503": 3,
@@ -54,7 +59,7 @@ func TestSortSamplesDurations(t *testing.T) {
 					ErrorsPcnt: 70,
 				},
 				{
-					P50: 1, P90: 2, P95: 2, P99: 4, Max: 4,
+					Stats: map[string]float64{"p50": 1, "p90": 2, "p95": 2, "p99": 4, "max": 4},
 					Status: map[string]float64{
 						"400": 1, "404": 1,
 						"500": 5, "503": 3,
@@ -63,7 +68,7 @@ func TestSortSamplesDurations(t *testing.T) {
 					ErrorsPcnt: 80,
 				},
 				{
-					P50: 1, P90: 2, P95: 3, P99: 4, Max: 4,
+					Stats: map[string]float64{"p50": 1, "p90": 2, "p95": 3, "p99": 4, "max": 4},
 					Status: map[string]float64{
 						"200": 1, "400": 1, "404": 1,
 						"500": 4, "503": 3,
@@ -78,21 +83,21 @@ func TestSortSamplesDurations(t *testing.T) {
 			sortBy: SortByP95,
 			durations: []SampleDurations{
 				{
-					P50: 1, P90: 2, P95: 3, P99: 4, Max: 4,
+					Stats: map[string]float64{"p50": 1, "p90": 2, "p95": 3, "p99": 4, "max": 4},
 					Status: map[string]float64{
 						"200": 1, "400": 1, "404": 1,
 						"500": 4, "503": 3,
 					},
 				},
 				{
-					P50: 1, P90: 2, P95: 3, P99: 5, Max: 5,
+					Stats: map[string]float64{"p50": 1, "p90": 2, "p95": 3, "p99": 5, "max": 5},
 					Status: map[string]float64{
 						"200": 1, "400": 1, "404": 1,
 						"500": 4, "503": 3,
 					},
 				},
 				{
-					P50: 1, P90: 2, P95: 2, P99: 4, Max: 4,
+					Stats: map[string]float64{"p50": 1, "p90": 2, "p95": 2, "p99": 4, "max": 4},
 					Status: map[string]float64{
 						"400": 1, "404": 1,
 						"500": 5, "503": 3,
@@ -101,7 +106,7 @@ func TestSortSamplesDurations(t *testing.T) {
 			},
 			want: []SampleDurations{
 				{
-					P50: 1, P90: 2, P95: 3, P99: 5, Max: 5,
+					Stats: map[string]float64{"p50": 1, "p90": 2, "p95": 3, "p99": 5, "max": 5},
 					Status: map[string]float64{
 						"200": 1, "400": 1, "404": 1,
 						"500": 4, "503": 3,
@@ -110,7 +115,7 @@ func TestSortSamplesDurations(t *testing.T) {
 					ErrorsPcnt: 70,
 				},
 				{
-					P50: 1, P90: 2, P95: 3, P99: 4, Max: 4,
+					Stats: map[string]float64{"p50": 1, "p90": 2, "p95": 3, "p99": 4, "max": 4},
 					Status: map[string]float64{
 						"200": 1, "400": 1, "404": 1,
 						"500": 4, "503": 3,
@@ -119,7 +124,7 @@ func TestSortSamplesDurations(t *testing.T) {
 					ErrorsPcnt: 70,
 				},
 				{
-					P50: 1, P90: 2, P95: 2, P99: 4, Max: 4,
+					Stats: map[string]float64{"p50": 1, "p90": 2, "p95": 2, "p99": 4, "max": 4},
 					Status: map[string]float64{
 						"400": 1, "404": 1,
 						"500": 5, "503": 3,
@@ -134,21 +139,21 @@ func TestSortSamplesDurations(t *testing.T) {
 			sortBy: SortByErrors,
 			durations: []SampleDurations{
 				{
-					P50: 1, P90: 2, P95: 3, P99: 4, Max: 4,
+					Stats: map[string]float64{"p50": 1, "p90": 2, "p95": 3, "p99": 4, "max": 4},
 					Status: map[string]float64{
 						"200": 1, "400": 1, "404": 1,
 						"500": 4, "503": 3,
 					},
 				},
 				{
-					P50: 1, P90: 2, P95: 3, P99: 5, Max: 5,
+					Stats: map[string]float64{"p50": 1, "p90": 2, "p95": 3, "p99": 5, "max": 5},
 					Status: map[string]float64{
 						"200": 1, "400": 1, "404": 1,
 						"500": 4, "503": 3,
 					},
 				},
 				{
-					P50: 1, P90: 2, P95: 2, P99: 4, Max: 4,
+					Stats: map[string]float64{"p50": 1, "p90": 2, "p95": 2, "p99": 4, "max": 4},
 					Status: map[string]float64{
 						"400": 1, "404": 1,
 						"500": 5, "503": 3,
@@ -157,7 +162,7 @@ func TestSortSamplesDurations(t *testing.T) {
 			},
 			want: []SampleDurations{
 				{
-					P50: 1, P90: 2, P95: 2, P99: 4, Max: 4,
+					Stats: map[string]float64{"p50": 1, "p90": 2, "p95": 2, "p99": 4, "max": 4},
 					Status: map[string]float64{
 						"400": 1, "404": 1,
 						"500": 5, "503": 3,
@@ -166,7 +171,7 @@ func TestSortSamplesDurations(t *testing.T) {
 					ErrorsPcnt: 80,
 				},
 				{
-					P50: 1, P90: 2, P95: 3, P99: 5, Max: 5,
+					Stats: map[string]float64{"p50": 1, "p90": 2, "p95": 3, "p99": 5, "max": 5},
 					Status: map[string]float64{
 						"200": 1, "400": 1, "404": 1,
 						"500": 4, "503": 3,
@@ -175,7 +180,7 @@ func TestSortSamplesDurations(t *testing.T) {
 					ErrorsPcnt: 70,
 				},
 				{
-					P50: 1, P90: 2, P95: 3, P99: 4, Max: 4,
+					Stats: map[string]float64{"p50": 1, "p90": 2, "p95": 3, "p99": 4, "max": 4},
 					Status: map[string]float64{
 						"200": 1, "400": 1, "404": 1,
 						"500": 4, "503": 3,
@@ -209,22 +214,26 @@ func TestDiffSamples(t *testing.T) {
 					Id: 2, Ts: time.Unix(1674196902, 0).UTC(),
 					Name: "carbonapi 1.5.6", Params: "USERS=2",
 				},
+				Stats: DefaultStats,
 				Samples: map[string][]SampleDurations{
 					"find": {
 						{
-							Url: "q=a.*", P50: 1, P90: 2, P95: 3, P99: 4, Max: 5,
+							Url:    "q=a.*",
+							Stats:  map[string]float64{"p50": 1, "p90": 2, "p95": 3, "p99": 4, "max": 5},
 							Status: map[string]float64{"200": 9, "504": 1},
 							Count:  10, ErrorsPcnt: 10.0,
 						},
 						{
-							Url: "q=b.*", P50: 2, P90: 2, P95: 3, P99: 4, Max: 4,
+							Url:    "q=b.*",
+							Stats:  map[string]float64{"p50": 2, "p90": 2, "p95": 3, "p99": 4, "max": 4},
 							Status: map[string]float64{"200": 9, "504": 1},
 							Count:  10, ErrorsPcnt: 10.0,
 						},
 					},
 					"render 1h": {
 						{
-							Url: "target=a.*", P50: 1, P90: 2, P95: 3, P99: 4, Max: 5,
+							Url:    "target=a.*",
+							Stats:  map[string]float64{"p50": 1, "p90": 2, "p95": 3, "p99": 4, "max": 5},
 							Status: map[string]float64{"200": 9, "504": 1},
 							Count:  10, ErrorsPcnt: 10.0,
 						},
@@ -236,17 +245,20 @@ func TestDiffSamples(t *testing.T) {
 					Id: 1, Ts: time.Unix(1674196900, 0).UTC(),
 					Name: "carbonapi 1.1.2", Params: "USERS=2",
 				},
+				Stats: []string{"p50", "p90", "p99", "max", "mean"},
 				Samples: map[string][]SampleDurations{
 					"find": {
 						{
-							Url: "q=a.*", P50: 1, P90: 2, P95: 3, P99: 4, Max: 4,
+							Url:    "q=a.*",
+							Stats:  map[string]float64{"p50": 1, "p90": 2, "p95": 3, "p99": 4, "max": 4},
 							Status: map[string]float64{"200": 8, "400": 2},
 							Count:  10, ErrorsPcnt: 0.0,
 						},
 					},
 					"render 1d": {
 						{
-							Url: "target=a.*", P50: 1, P90: 2, P95: 3, P99: 4, Max: 5,
+							Url:    "target=a.*",
+							Stats:  map[string]float64{"p50": 1, "p90": 2, "p95": 3, "p99": 4, "max": 5},
 							Status: map[string]float64{"200": 9, "504": 1},
 							Count:  10, ErrorsPcnt: 10.0,
 						},
@@ -262,25 +274,30 @@ func TestDiffSamples(t *testing.T) {
 					Id: 1, Ts: time.Unix(1674196900, 0).UTC(),
 					Name: "carbonapi 1.1.2", Params: "USERS=2",
 				},
+				Stats: []string{"p50", "p90", "p99", "max"},
 				Samples: map[string][]SampleDurationsDiff{
 					"find": {
 						{
-							Url: "q=a.*", P50: 1, P90: 2, P95: 3, P99: 4, Max: 5,
+							Url:    "q=a.*",
+							Stats:  map[string]float64{"p50": 1, "p90": 2, "p95": 3, "p99": 4, "max": 5},
 							Status: map[string]float64{"200": 9, "400": 0, "504": 1},
 							Count:  10, ErrorsPcnt: 10.0,
 							// diff
-							MaxDiff: 1, ErrorsPcntDiff: 10.0,
-							StatusDiff: map[string]float64{"200": 1, "400": -2, "504": 1},
+							StatsDiff:      map[string]float64{"p50": 0, "p90": 0, "p99": 0, "max": 1},
+							ErrorsPcntDiff: 10.0,
+							StatusDiff:     map[string]float64{"200": 1, "400": -2, "504": 1},
 						},
 						{
-							Url: "q=b.*", P50: 2, P90: 2, P95: 3, P99: 4, Max: 4,
+							Url:    "q=b.*",
+							Stats:  map[string]float64{"p50": 2, "p90": 2, "p95": 3, "p99": 4, "max": 4},
 							Status: map[string]float64{"200": 9, "504": 1},
 							Count:  10, ErrorsPcnt: 10.0,
 						},
 					},
 					"render 1h": {
 						{
-							Url: "target=a.*", P50: 1, P90: 2, P95: 3, P99: 4, Max: 5,
+							Url:    "target=a.*",
+							Stats:  map[string]float64{"p50": 1, "p90": 2, "p95": 3, "p99": 4, "max": 5},
 							Status: map[string]float64{"200": 9, "504": 1},
 							Count:  10, ErrorsPcnt: 10.0,
 						},
@@ -291,9 +308,163 @@ func TestDiffSamples(t *testing.T) {
 	}
 	for i, tt := range tests {
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
-			if got := DiffSamples(tt.test, tt.ref); !reflect.DeepEqual(got, tt.want) {
+			got, err := DiffSamples(tt.test, tt.ref)
+			if err != nil {
+				t.Fatalf("DiffSamples() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("DiffSamples() = %s", cmp.Diff(tt.want, got))
 			}
 		})
 	}
 }
+
+func TestDiffSamplesQuantileMismatch(t *testing.T) {
+	test := &TestSamples{Quantile: string(QuantileExact), Stats: DefaultStats}
+	ref := &TestSamples{Quantile: string(QuantileTDigest), Stats: DefaultStats}
+	_, err := DiffSamples(test, ref)
+	assert.Equal(t, ErrorQuantileMismatch{Test: QuantileExact, Reference: QuantileTDigest}, err)
+
+	// empty is default (saved by previous versions)
+	test.Quantile = string(QuantileSampling)
+	ref.Quantile = ""
+	diff, err := DiffSamples(test, ref)
+	assert.NoError(t, err)
+	assert.Equal(t, string(QuantileSampling), diff.Quantile)
+}
+
+func TestGetHttpTestSamples(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// queries are executed in parallel
+	mock.MatchExpectationsInOrder(false)
+	d := New(db, "t_k6_tests", "t_k6_samples")
+	start := time.Unix(1674196900, 0).UTC()
+	test := Test{Id: 1, Ts: start, Name: "graphite", Params: "USERS=1"}
+
+	mock.ExpectQuery(`^SELECT id, start, label, url, max\(value\) FROM t_k6_samples WHERE id = @Id AND start = @Time AND metric = @Metric `).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "url", "max"}).
+			AddRow(uint64(1), start, "find", "q=a.*", 30.0))
+	mock.ExpectQuery(`^SELECT id, start, label, url, status, sum\(value\) FROM t_k6_samples WHERE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "url", "status", "count"}).
+			AddRow(uint64(1), start, "find", "q=a.*", "200", 9.0).
+			AddRow(uint64(1), start, "find", "q=a.*", "500", 1.0))
+	mock.ExpectQuery(`^SELECT id, start, label, tags\['group'\] AS check_group`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "group", "check", "passes", "count"}).
+			AddRow(uint64(1), start, "find", "", "status 200", 9.0, 10.0))
+	mock.ExpectQuery(`^SELECT id, start, min\(ts\), max\(ts\) FROM t_k6_samples`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "from", "until"}).
+			AddRow(uint64(1), start, start, start.Add(10*time.Second)))
+	mock.ExpectQuery(`^SELECT id, start, label, url, metric, sum\(value\) FROM t_k6_samples`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start", "label", "url", "metric", "value"}))
+
+	samples, qErr := d.GetHttpTestSamples(context.Background(), test, SampleFilter{Stats: []string{"max"}})
+	if qErr != nil {
+		t.Fatal(qErr)
+	}
+	assert.Equal(t, &TestSamples{
+		Test:     test,
+		Quantile: string(QuantileDefault),
+		Stats:    []string{"max"},
+		Duration: 10,
+		Samples: map[string][]SampleDurations{
+			"find": {
+				{
+					Url: "q=a.*", Stats: map[string]float64{"max": 30}, Status: map[string]float64{"200": 9, "500": 1},
+					Count: 10, ErrorsPcnt: 10, RPS: 1,
+				},
+			},
+		},
+		Checks: []SampleCheck{{Id: 1, Start: start, Label: "find", Check: "status 200", Passes: 9, Count: 10, PassPcnt: 90}},
+	}, samples)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetHttpSamplesStatusError(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(db, "t_k6_tests", "t_k6_samples")
+
+	mock.ExpectQuery(`^SELECT id, start, label, url, status, sum\(value\) FROM t_k6_samples WHERE`).
+		WillReturnError(&clickhouse.Exception{Code: 159, Message: "Timeout exceeded"})
+	_, qErr := d.GetHttpSamplesStatus(context.Background(), SampleFilter{Id: 1, Start: 1})
+	if qErr == nil {
+		t.Fatal("error not returned")
+	}
+	assert.Equal(t, ErrCodeTimeout, qErr.ErrorCode())
+	assert.Equal(t, http.StatusGatewayTimeout, qErr.Code())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetHttpTestSamplesCanceled(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(db, "t_k6_tests", "t_k6_samples")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// sub-queries are not sent for canceled request
+	_, qErr := d.GetHttpTestSamples(ctx, Test{Id: 1, Ts: time.Unix(1, 0)}, SampleFilter{})
+	if qErr == nil {
+		t.Fatal("error not returned")
+	}
+	assert.Equal(t, ErrCodeCanceled, qErr.ErrorCode())
+	for name, fn := range map[string]func(context.Context, SampleFilter) *QueryError{
+		"durations": func(ctx context.Context, f SampleFilter) *QueryError {
+			_, qErr := d.GetHttpSamplesDurations(ctx, f)
+			return qErr
+		},
+		"status": func(ctx context.Context, f SampleFilter) *QueryError {
+			_, qErr := d.GetHttpSamplesStatus(ctx, f)
+			return qErr
+		},
+		"checks": func(ctx context.Context, f SampleFilter) *QueryError {
+			_, qErr := d.GetChecks(ctx, f)
+			return qErr
+		},
+		"range": func(ctx context.Context, f SampleFilter) *QueryError {
+			_, qErr := d.GetTestRange(ctx, f)
+			return qErr
+		},
+		"rates": func(ctx context.Context, f SampleFilter) *QueryError {
+			_, qErr := d.GetSamplesRates(ctx, f)
+			return qErr
+		},
+	} {
+		qErr = fn(ctx, SampleFilter{Id: 1, Start: 1})
+		if assert.NotNil(t, qErr, name) {
+			assert.Equal(t, ErrCodeCanceled, qErr.ErrorCode(), name)
+		}
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetHttpTestSamplesFailed(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.MatchExpectationsInOrder(false)
+	d := New(db, "t_k6_tests", "t_k6_samples")
+
+	mock.ExpectQuery(`^SELECT id, start, label, url, quantiles`).WillReturnError(errors.New("code: 241, message: Memory limit exceeded"))
+	// other sub-queries are canceled
+	for i := 0; i < 4; i++ {
+		mock.ExpectQuery(`.`).WillDelayFor(10 * time.Second).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	}
+
+	begin := time.Now()
+	_, qErr := d.GetHttpTestSamples(context.Background(), Test{Id: 1, Ts: time.Unix(1, 0)}, SampleFilter{})
+	if qErr == nil {
+		t.Fatal("error not returned")
+	}
+	assert.Contains(t, qErr.Error(), "Memory limit exceeded")
+	assert.Less(t, time.Since(begin), 5*time.Second)
+}
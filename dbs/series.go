@@ -0,0 +1,127 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/msaf1980/go-stringutils"
+	"github.com/msaf1980/go-timeutils"
+)
+
+// DefaultSeriesInterval is a default durations series interval (seconds)
+const DefaultSeriesInterval = 60
+
+var ErrInvalidSeriesInterval = errors.New("invalid series interval")
+
+type SeriesFilter struct {
+	SampleFilter
+	// Interval is an aggregation interval in seconds (DefaultSeriesInterval if zero)
+	Interval int64 `json:"interval,omitempty"`
+}
+
+// SampleSeries is a per-interval durations statistics for label/url
+type SampleSeries struct {
+	Label string               `json:"label,omitempty"`
+	Url   string               `json:"url"`
+	Times []time.Time          `json:"times"` // interval start
+	Stats map[string][]float64 `json:"stats"` // statistic values per interval (in Times order)
+}
+
+// GetHttpSamplesSeries return per-interval durations statistics per label/url
+func (d *DB) GetHttpSamplesSeries(ctx context.Context, f SeriesFilter) ([]SampleSeries, *QueryError) {
+	return cachedQuery(ctx, d, "series", f.Id, f.Start, f, func() ([]SampleSeries, *QueryError) {
+		return d.getHttpSamplesSeries(ctx, f)
+	})
+}
+
+func (d *DB) getHttpSamplesSeries(ctx context.Context, f SeriesFilter) ([]SampleSeries, *QueryError) {
+	var query stringutils.Builder
+
+	stats, err := ParseStats(f.Stats)
+	if err != nil {
+		return nil, NewQueryError(err, http.StatusBadRequest, "")
+	}
+	quantileFunc, err := QuantileFuncFromString(f.Quantile)
+	if err != nil {
+		return nil, NewQueryError(err, http.StatusBadRequest, "")
+	}
+	if f.Interval == 0 {
+		f.Interval = DefaultSeriesInterval
+	} else if f.Interval < 0 {
+		return nil, NewQueryError(ErrInvalidSeriesInterval, http.StatusBadRequest, "")
+	}
+
+	start := timeutils.UnixNano(f.Start).UTC()
+
+	query.Grow(128)
+	_, _ = query.WriteString("SELECT toStartOfInterval(ts, INTERVAL ")
+	_, _ = query.WriteString(strconv.FormatInt(f.Interval, 10))
+	_, _ = query.WriteString(" SECOND) AS t, label, url, ")
+
+	var args []any
+	// rollup table is aggregated per minute, so it's used only for minute-aligned intervals
+	if f.Interval%60 == 0 && stats.rollupSupported(quantileFunc) && !f.hasTsRange() && d.useRollup(ctx, f.Start) {
+		stats.writeRollupAggregates(&query, quantileFunc)
+		_, _ = query.WriteString(" FROM ")
+		_, _ = query.WriteString(d.tableRollup)
+		_, _ = query.WriteString(" WHERE id = @Id AND start = @Time")
+		writeSampleFilter(&query, &f.SampleFilter)
+		_, _ = query.WriteString(" GROUP BY t, label, url HAVING countMerge(d_count) > 0 ORDER BY label, url, t")
+
+		args = f.queryArgs(clickhouse.Named("Id", f.Id), clickhouse.DateNamed("Time", start, 3))
+	} else {
+		stats.writeAggregates(&query, quantileFunc)
+		_, _ = query.WriteString(" FROM ")
+		_, _ = query.WriteString(d.tableSamples)
+		_, _ = query.WriteString(" WHERE id = @Id AND start = @Time AND metric = @Metric")
+		writeSampleFilter(&query, &f.SampleFilter)
+		writeTsRange(&query, &f.SampleFilter)
+		_, _ = query.WriteString(" GROUP BY t, label, url ORDER BY label, url, t")
+
+		args = f.queryArgs(
+			clickhouse.Named("Id", f.Id), clickhouse.DateNamed("Time", start, 3),
+			f.dateNamedFrom(), f.dateNamedUntil(),
+			clickhouse.Named("Metric", "http_req_duration"),
+		)
+	}
+
+	rows, err := d.db.QueryContext(ctx, query.String(), args...)
+	if err != nil {
+		return nil, NewQueryError(err, 0, query.String())
+	}
+	defer rows.Close()
+	series := make([]SampleSeries, 0, 50)
+	sc := stats.newScanner()
+	dest := append([]any{nil, nil, nil}, sc.dest...)
+	for rows.Next() {
+		var (
+			t          time.Time
+			label, url string
+		)
+		dest[0], dest[1], dest[2] = &t, &label, &url
+		if err = rows.Scan(dest...); err != nil {
+			return nil, NewQueryError(err, 0, query.String())
+		}
+		n := len(series) - 1
+		if n < 0 || series[n].Label != label || series[n].Url != url {
+			series = append(series, SampleSeries{Label: label, Url: url, Stats: make(map[string][]float64, len(stats))})
+			n++
+		}
+		s := &series[n]
+		s.Times = append(s.Times, t.UTC())
+		for name, v := range sc.result() {
+			s.Stats[name] = append(s.Stats[name], v)
+		}
+	}
+	// get any error encountered during iteration
+	err = rows.Err()
+	if err != nil {
+		return nil, NewQueryError(err, 0, query.String())
+	}
+
+	return series, nil
+}
@@ -0,0 +1,93 @@
+package dbs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetHttpSamplesSeries(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(db, "t_k6_tests", "t_k6_samples")
+	start := time.Unix(1674196900, 0).UTC()
+
+	mock.ExpectQuery(`^SELECT toStartOfInterval\(ts, INTERVAL 30 SECOND\) AS t, label, url, quantiles\(0.99\)\(value\), max\(value\) ` +
+		`FROM t_k6_samples WHERE id = @Id AND start = @Time AND metric = @Metric AND label LIKE @Label ` +
+		`GROUP BY t, label, url ORDER BY label, url, t$`).
+		WillReturnRows(mock.NewRows([]string{"t", "label", "url", "q", "max"}).
+			AddRow(start, "find", "q=a.*", []float64{10}, 20.0).
+			AddRow(start.Add(30*time.Second), "find", "q=a.*", []float64{11}, 21.0).
+			AddRow(start, "find", "q=b.*", []float64{12}, 22.0))
+
+	series, qErr := d.GetHttpSamplesSeries(context.Background(), SeriesFilter{
+		SampleFilter: SampleFilter{Id: 1, Start: start.UnixNano(), Label: "find", Stats: []string{"p99", "max"}},
+		Interval:     30,
+	})
+	if qErr != nil {
+		t.Fatal(qErr)
+	}
+	assert.Equal(t, []SampleSeries{
+		{
+			Label: "find", Url: "q=a.*", Times: []time.Time{start, start.Add(30 * time.Second)},
+			Stats: map[string][]float64{"p99": {10, 11}, "max": {20, 21}},
+		},
+		{Label: "find", Url: "q=b.*", Times: []time.Time{start}, Stats: map[string][]float64{"p99": {12}, "max": {22}}},
+	}, series)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	_, qErr = d.GetHttpSamplesSeries(context.Background(), SeriesFilter{SampleFilter: SampleFilter{Id: 1, Start: start.UnixNano()}, Interval: -1})
+	assert.Equal(t, ErrInvalidSeriesInterval, qErr.Wrapped())
+}
+
+func TestGetHttpSamplesSeriesRollup(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(db, "t_k6_tests", "t_k6_samples")
+	d.SetTableRollup("t_k6_samples_rollup")
+	start := time.Unix(1674196900, 0).UTC()
+
+	expectRollupViews(mock, start.Add(-time.Hour))
+	mock.ExpectQuery(`^SELECT toStartOfInterval\(ts, INTERVAL 120 SECOND\) AS t, label, url, arrayMap\(x -> toFloat64\(x\), quantilesTimingMerge\(0.99\)\(d_quantiles\)\), maxMerge\(d_max\) ` +
+		`FROM t_k6_samples_rollup WHERE id = @Id AND start = @Time AND label LIKE @Label ` +
+		`GROUP BY t, label, url HAVING countMerge\(d_count\) > 0 ORDER BY label, url, t$`).
+		WillReturnRows(mock.NewRows([]string{"t", "label", "url", "q", "max"}).
+			AddRow(start, "find", "q=a.*", []float64{10}, 20.0))
+	// not minute-aligned interval
+	mock.ExpectQuery(`^SELECT toStartOfInterval\(ts, INTERVAL 30 SECOND\) AS t, label, url, arrayMap\(x -> toFloat64\(x\), quantilesTiming\(0.99\)\(value\)\), max\(value\) FROM t_k6_samples WHERE`).
+		WillReturnRows(mock.NewRows([]string{"t", "label", "url", "q", "max"}).
+			AddRow(start, "find", "q=a.*", []float64{10}, 20.0))
+	// default quantiles
+	mock.ExpectQuery(`^SELECT toStartOfInterval\(ts, INTERVAL 120 SECOND\) AS t, label, url, quantiles\(0.99\)\(value\), max\(value\) FROM t_k6_samples WHERE`).
+		WillReturnRows(mock.NewRows([]string{"t", "label", "url", "q", "max"}).
+			AddRow(start, "find", "q=a.*", []float64{10}, 20.0))
+
+	f := SeriesFilter{
+		SampleFilter: SampleFilter{Id: 1, Start: start.UnixNano(), Label: "find", Stats: []string{"p99", "max"}, Quantile: string(QuantileTiming)},
+		Interval:     120,
+	}
+	series, qErr := d.GetHttpSamplesSeries(context.Background(), f)
+	if qErr != nil {
+		t.Fatal(qErr)
+	}
+	assert.Equal(t, []SampleSeries{
+		{Label: "find", Url: "q=a.*", Times: []time.Time{start}, Stats: map[string][]float64{"p99": {10}, "max": {20}}},
+	}, series)
+
+	f.Interval = 30
+	_, qErr = d.GetHttpSamplesSeries(context.Background(), f)
+	assert.Nil(t, qErr)
+
+	f.Interval = 120
+	f.Quantile = ""
+	_, qErr = d.GetHttpSamplesSeries(context.Background(), f)
+	assert.Nil(t, qErr)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -13,54 +13,109 @@ func (e ErrorInvalidSortBy) Error() string {
 	return e.Value + " not a sortBy key"
 }
 
-type SortBy uint8
+// SortBy is a sort key: errors, count, rps or any statistic name (see ParseStat)
+type SortBy string
 
 const (
-	SortByMax SortBy = iota
-	SortByP99
-	SortByP95
-	SortByP90
-	SortByP50
-	SortByErrors
-	SortByCount
+	SortByMax    SortBy = "max"
+	SortByP99    SortBy = "p99"
+	SortByP95    SortBy = "p95"
+	SortByP90    SortBy = "p90"
+	SortByP50    SortBy = "p50"
+	SortByErrors SortBy = "errors"
+	SortByCount  SortBy = "count"
+	SortByRPS    SortBy = "rps"
 )
 
-var (
-	sortByStrings []string = []string{"max", "p99", "p95", "p90", "p50", "errors", "count"}
-	sortByString  string   = "[" + strings.Join(sortByStrings, ",") + "]"
-)
+var sortByKeys []string = []string{"errors", "count", "rps"}
 
-func SortByValues() []string {
-	return sortByStrings
+// SortByValues return valid sort keys for statistics list (if empty, DefaultStats used)
+func SortByValues(stats []string) []string {
+	if len(stats) == 0 {
+		stats = DefaultStats
+	}
+	values := make([]string, 0, len(stats)+len(sortByKeys))
+	values = append(values, stats...)
+	values = append(values, sortByKeys...)
+	return values
 }
 
-func SortByValuesString() string {
-	return sortByString
+func SortByValuesString(stats []string) string {
+	return "[" + strings.Join(SortByValues(stats), ",") + "]"
+}
+
+// SortByDefault return default sort key for statistics list (p99 if exist, or first statistic)
+func SortByDefault(stats []string) SortBy {
+	if len(stats) == 0 {
+		return SortByP99
+	}
+	for _, s := range stats {
+		if s == string(SortByP99) {
+			return SortByP99
+		}
+	}
+	return SortBy(stats[0])
 }
 
 func SortByFromString(value string) (SortBy, error) {
 	switch value {
-	case "max":
-		return SortByMax, nil
-	case "p99":
-		return SortByP99, nil
-	case "p95":
-		return SortByP95, nil
-	case "p90":
-		return SortByP90, nil
-	case "p50":
-		return SortByP50, nil
 	case "errors":
 		return SortByErrors, nil
 	case "count":
 		return SortByCount, nil
+	case "rps":
+		return SortByRPS, nil
 	default:
+		if stat, err := ParseStat(value); err == nil {
+			return SortBy(stat.Name), nil
+		}
 		return SortByMax, ErrorInvalidSortBy{value}
 	}
 }
 
+// CheckSortBy verify, that sort key is errors, count, rps or one of statistics (if empty, DefaultStats used)
+func CheckSortBy(sortBy SortBy, stats []string) error {
+	for _, k := range sortByKeys {
+		if string(sortBy) == k {
+			return nil
+		}
+	}
+	if len(stats) == 0 {
+		stats = DefaultStats
+	}
+	for _, name := range stats {
+		// statistics names are normalized (p99.90 -> p99.9)
+		if stat, err := ParseStat(name); err == nil && stat.Name == string(sortBy) {
+			return nil
+		}
+	}
+	return ErrorInvalidSortBy{string(sortBy)}
+}
+
 func (u *SortBy) String() string {
-	return sortByStrings[*u]
+	return string(*u)
+}
+
+// sortKeys return sort keys with tie-breakers
+func (u SortBy) sortKeys() []SortBy {
+	keys := []SortBy{u}
+	for _, k := range []SortBy{SortByErrors, SortByMax, SortByP99} {
+		if k != u {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// sortDiffKeys return sort keys with tie-breakers for sort by diff
+func (u SortBy) sortDiffKeys() []SortBy {
+	keys := []SortBy{u}
+	for _, k := range []SortBy{SortByMax, SortByErrors, SortByP99} {
+		if k != u {
+			keys = append(keys, k)
+		}
+	}
+	return keys
 }
 
 type SortByValue SortBy
@@ -105,5 +160,5 @@ func (u *SortByValue) GetSortBy() SortBy {
 }
 
 func (u *SortByValue) String() string {
-	return sortByStrings[*u]
+	return string(*u)
 }
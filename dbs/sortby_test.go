@@ -0,0 +1,15 @@
+package dbs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckSortBy(t *testing.T) {
+	assert.NoError(t, CheckSortBy(SortByP95, nil))
+	assert.NoError(t, CheckSortBy(SortByErrors, []string{"max"}))
+	assert.NoError(t, CheckSortBy("p99.9", []string{"p99.90", "max"}))
+	assert.Equal(t, ErrorInvalidSortBy{"p95"}, CheckSortBy(SortByP95, []string{"p99", "max"}))
+	assert.Equal(t, ErrorInvalidSortBy{"min"}, CheckSortBy("min", nil))
+}
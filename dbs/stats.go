@@ -0,0 +1,218 @@
+package dbs
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/msaf1980/go-stringutils"
+)
+
+// ErrorInvalidStat represents an invalid statistic name wrapped error
+type ErrorInvalidStat struct {
+	Value string
+}
+
+func (e ErrorInvalidStat) Error() string {
+	return e.Value + " not a statistic (min, max, mean, stddev or percentile like p50, p99.9)"
+}
+
+type StatKind uint8
+
+const (
+	StatQuantile StatKind = iota
+	StatMin
+	StatMax
+	StatMean
+	StatStddev
+)
+
+// Stat is a durations statistic, calculated by aggregation query
+type Stat struct {
+	Name  string
+	Kind  StatKind
+	Level float64 // quantile level, in (0, 1]
+}
+
+// DefaultStats is a default (ordered) statistics list
+var DefaultStats = []string{"p50", "p90", "p95", "p99", "max"}
+
+// ParseStat parse statistic name (min, max, mean, stddev or percentile, like p99.9)
+func ParseStat(name string) (Stat, error) {
+	switch name {
+	case "min":
+		return Stat{Name: name, Kind: StatMin}, nil
+	case "max":
+		return Stat{Name: name, Kind: StatMax}, nil
+	case "mean":
+		return Stat{Name: name, Kind: StatMean}, nil
+	case "stddev":
+		return Stat{Name: name, Kind: StatStddev}, nil
+	}
+	if len(name) < 2 || name[0] != 'p' || !isDecimal(name[1:]) {
+		return Stat{}, ErrorInvalidStat{name}
+	}
+	p, err := strconv.ParseFloat(name[1:], 64)
+	if err != nil || math.IsNaN(p) || math.IsInf(p, 0) || p <= 0 || p > 100 {
+		return Stat{}, ErrorInvalidStat{name}
+	}
+	// round level for avoid float division artefacts (99.9 / 100 = 0.9990000000000001)
+	level := math.Round(p*1e7) / 1e9
+	// normalize name (p99.90 -> p99.9)
+	return Stat{Name: "p" + strconv.FormatFloat(p, 'f', -1, 64), Kind: StatQuantile, Level: level}, nil
+}
+
+// isDecimal check, that s is a decimal number without sign and exponent (like 99 or 99.9)
+func isDecimal(s string) bool {
+	var dot bool
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] >= '0' && s[i] <= '9':
+		case s[i] == '.' && !dot && i > 0 && i < len(s)-1:
+			dot = true
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// StatTitle return statistic title for output headers (p99.9 -> P99.9, max -> Max)
+func StatTitle(name string) string {
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// Stats is an ordered statistics list
+type Stats []Stat
+
+// ParseStats parse ordered statistics list. For empty list DefaultStats returned.
+func ParseStats(names []string) (Stats, error) {
+	if len(names) == 0 {
+		names = DefaultStats
+	}
+	stats := make(Stats, 0, len(names))
+	for _, name := range names {
+		stat, err := ParseStat(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		if stats.Has(stat.Name) {
+			// skip duplicate
+			continue
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// Names return (normalized) statistics names
+func (s Stats) Names() []string {
+	names := make([]string, len(s))
+	for i := range s {
+		names[i] = s[i].Name
+	}
+	return names
+}
+
+func (s Stats) Has(name string) bool {
+	for i := range s {
+		if s[i].Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// writeAggregates write aggregate functions (quantiles at first, in one function call, after other functions in stats order)
+func (s Stats) writeAggregates(query *stringutils.Builder, quantileFunc QuantileFunc) {
+	var n int
+	for i := range s {
+		if s[i].Kind == StatQuantile {
+			if n == 0 {
+				if quantileFunc == QuantileTiming {
+					// quantilesTiming return Array(Float32)
+					_, _ = query.WriteString("arrayMap(x -> toFloat64(x), ")
+				}
+				_, _ = query.WriteString(string(quantileFunc))
+				_ = query.WriteByte('(')
+			} else {
+				_, _ = query.WriteString(", ")
+			}
+			_, _ = query.WriteString(strconv.FormatFloat(s[i].Level, 'f', -1, 64))
+			n++
+		}
+	}
+	if n > 0 {
+		_, _ = query.WriteString(")(value)")
+		if quantileFunc == QuantileTiming {
+			_ = query.WriteByte(')')
+		}
+	}
+	for i := range s {
+		if s[i].Kind == StatQuantile {
+			continue
+		}
+		if n > 0 {
+			_, _ = query.WriteString(", ")
+		}
+		switch s[i].Kind {
+		case StatMin:
+			_, _ = query.WriteString("min(value)")
+		case StatMax:
+			_, _ = query.WriteString("max(value)")
+		case StatMean:
+			_, _ = query.WriteString("avg(value)")
+		case StatStddev:
+			_, _ = query.WriteString("stddevPop(value)")
+		}
+		n++
+	}
+}
+
+// statsScanner is a helper for scan aggregated statistics from query result
+type statsScanner struct {
+	stats  Stats
+	q      []float64
+	values []float64
+	dest   []any
+}
+
+func (s Stats) newScanner() *statsScanner {
+	sc := &statsScanner{stats: s}
+	for i := range s {
+		if s[i].Kind == StatQuantile {
+			sc.dest = append(sc.dest, &sc.q)
+			break
+		}
+	}
+	for i := range s {
+		if s[i].Kind != StatQuantile {
+			sc.values = append(sc.values, 0)
+		}
+	}
+	for i := range sc.values {
+		sc.dest = append(sc.dest, &sc.values[i])
+	}
+	return sc
+}
+
+// result return scanned statistics (after rows.Scan)
+func (sc *statsScanner) result() map[string]float64 {
+	m := make(map[string]float64, len(sc.stats))
+	var nq, nv int
+	for i := range sc.stats {
+		if sc.stats[i].Kind == StatQuantile {
+			if nq < len(sc.q) {
+				m[sc.stats[i].Name] = sc.q[nq]
+			}
+			nq++
+		} else {
+			m[sc.stats[i].Name] = sc.values[nv]
+			nv++
+		}
+	}
+	return m
+}
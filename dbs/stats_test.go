@@ -0,0 +1,169 @@
+package dbs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/msaf1980/go-stringutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStats(t *testing.T) {
+	tests := []struct {
+		names     []string
+		want      []string
+		wantQuery string
+		wantErr   bool
+	}{
+		{
+			want:      DefaultStats,
+			wantQuery: "quantiles(0.5, 0.9, 0.95, 0.99)(value), max(value)",
+		},
+		{
+			names:     []string{"min", "p75", "mean", "p99.90", "p99.9", "stddev", "max"},
+			want:      []string{"min", "p75", "mean", "p99.9", "stddev", "max"},
+			wantQuery: "quantiles(0.75, 0.999)(value), min(value), avg(value), stddevPop(value), max(value)",
+		},
+		{
+			names:     []string{"mean"},
+			want:      []string{"mean"},
+			wantQuery: "avg(value)",
+		},
+		{
+			names:   []string{"p0"},
+			wantErr: true,
+		},
+		{
+			names:   []string{"p101"},
+			wantErr: true,
+		},
+		{
+			names:   []string{"pNaN"},
+			wantErr: true,
+		},
+		{
+			names:   []string{"pnan"},
+			wantErr: true,
+		},
+		{
+			names:   []string{"pInf"},
+			wantErr: true,
+		},
+		{
+			names:   []string{"median"},
+			wantErr: true,
+		},
+		// not decimal levels
+		{
+			names:   []string{"p0x1p-2"},
+			wantErr: true,
+		},
+		{
+			names:   []string{"p9e1"},
+			wantErr: true,
+		},
+		{
+			names:   []string{"p+50"},
+			wantErr: true,
+		},
+		{
+			names:   []string{"p99."},
+			wantErr: true,
+		},
+		{
+			names:   []string{"p_50"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.names, ","), func(t *testing.T) {
+			stats, err := ParseStats(tt.names)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, stats.Names())
+
+			var query stringutils.Builder
+			stats.writeAggregates(&query, QuantileDefault)
+			assert.Equal(t, tt.wantQuery, query.String())
+		})
+	}
+}
+
+func TestStatsAggregatesTiming(t *testing.T) {
+	stats, err := ParseStats([]string{"p50", "max"})
+	assert.NoError(t, err)
+
+	var query stringutils.Builder
+	stats.writeAggregates(&query, QuantileTiming)
+	assert.Equal(t, "arrayMap(x -> toFloat64(x), quantilesTiming(0.5)(value)), max(value)", query.String())
+
+	query.Reset()
+	stats.writeAggregates(&query, QuantileExact)
+	assert.Equal(t, "quantilesExact(0.5)(value), max(value)", query.String())
+}
+
+func TestStatsScanner(t *testing.T) {
+	stats, err := ParseStats([]string{"min", "p75", "mean", "p99.9"})
+	assert.NoError(t, err)
+
+	sc := stats.newScanner()
+	assert.Equal(t, 3, len(sc.dest))
+	*(sc.dest[0].(*[]float64)) = []float64{2, 5}
+	*(sc.dest[1].(*float64)) = 1
+	*(sc.dest[2].(*float64)) = 3
+
+	assert.Equal(t, map[string]float64{"min": 1, "p75": 2, "mean": 3, "p99.9": 5}, sc.result())
+}
+
+func TestSortByFromString(t *testing.T) {
+	for value, want := range map[string]SortBy{
+		"p99": SortByP99, "p99.90": "p99.9", "mean": "mean",
+		"errors": SortByErrors, "count": SortByCount, "rps": SortByRPS,
+	} {
+		sortBy, err := SortByFromString(value)
+		assert.NoError(t, err)
+		assert.Equal(t, want, sortBy)
+	}
+	_, err := SortByFromString("unknown")
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"p75", "p99.9", "errors", "count", "rps"}, SortByValues([]string{"p75", "p99.9"}))
+	assert.Equal(t, SortBy("p75"), SortByDefault([]string{"p75", "p99.9"}))
+	assert.Equal(t, SortByP99, SortByDefault(DefaultStats))
+}
+
+func TestSortSamplesDurationsByStat(t *testing.T) {
+	durations := []SampleDurations{
+		{Url: "a", Stats: map[string]float64{"p99.9": 10, "mean": 3}},
+		{Url: "b", Stats: map[string]float64{"p99.9": 20, "mean": 1}},
+		{Url: "c", Stats: map[string]float64{"p99.9": 15, "mean": 2}},
+	}
+	SortSamplesDurations(durations, "p99.9")
+	assert.Equal(t, []string{"b", "c", "a"}, []string{durations[0].Url, durations[1].Url, durations[2].Url})
+
+	SortSamplesDurations(durations, "mean")
+	assert.Equal(t, []string{"a", "c", "b"}, []string{durations[0].Url, durations[1].Url, durations[2].Url})
+}
+
+func TestStatsRollupAggregates(t *testing.T) {
+	stats, err := ParseStats([]string{"min", "p75", "mean", "p99.9", "stddev", "max"})
+	assert.NoError(t, err)
+	assert.True(t, stats.rollupSupported(QuantileTiming))
+	// default quantiles state is not deterministic on merge, so read from samples table
+	assert.False(t, stats.rollupSupported(QuantileSampling))
+	assert.False(t, stats.rollupSupported(QuantileTDigest))
+
+	var query stringutils.Builder
+	stats.writeRollupAggregates(&query, QuantileTiming)
+	assert.Equal(t,
+		"arrayMap(x -> toFloat64(x), quantilesTimingMerge(0.75, 0.999)(d_quantiles)), minMerge(d_min), sumMerge(d_sum) / countMerge(d_count), stddevPopMerge(d_stddev), maxMerge(d_max)",
+		query.String(),
+	)
+
+	stats, err = ParseStats([]string{"mean", "max"})
+	assert.NoError(t, err)
+	assert.True(t, stats.rollupSupported(QuantileExact))
+}
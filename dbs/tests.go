@@ -1,7 +1,10 @@
 package dbs
 
 import (
-	"errors"
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
 	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
@@ -14,12 +17,28 @@ type Test struct {
 	Ts     time.Time
 	Name   string
 	Params string
+	// ParamsMap is a parsed Params (K=V pairs)
+	ParamsMap map[string]string `json:",omitempty"`
+	// Annotation is a test annotation (if annotations table is set)
+	Annotation *Annotation `json:",omitempty"`
 }
 
 type TestFilter struct {
 	From  int64  `json:"from"`  // epoch seconds
 	Until int64  `json:"until"` // epoch seconds
 	Name  string `json:"name_prefix,omitempty"`
+	// Search is a regular expression (re2 syntax), matched with name or params
+	Search string `json:"search,omitempty"`
+	// Desc is a reverse (newest first) sort order
+	Desc   bool   `json:"desc,omitempty"`
+	Limit  uint64 `json:"limit,omitempty"` // 0 for no limit
+	Offset uint64 `json:"offset,omitempty"`
+	// Params is a params filter (K=V in Params), empty value match any value of key
+	Params map[string]string `json:"params,omitempty"`
+	// Labels is an annotation labels filter (test must have all labels)
+	Labels []string `json:"labels,omitempty"`
+	// Hidden include hidden (archived) tests
+	Hidden bool `json:"hidden,omitempty"`
 }
 
 type TestIdFilter struct {
@@ -27,17 +46,11 @@ type TestIdFilter struct {
 	Time int64  `json:"time"` // epoch nanoseconds
 }
 
-func (d *DB) GetTests(f TestFilter) ([]Test, *QueryError) {
-	var (
-		query    stringutils.Builder
-		filtered bool
-	)
-
-	filter := make([]any, 0, 3)
-	query.Grow(64)
-	_, _ = query.WriteString("SELECT id, ts, name, params FROM ")
-	_, _ = query.WriteString(d.tableTests)
+// writeTestsWhere write WHERE clause for TestFilter and return query params
+func (d *DB) writeTestsWhere(query *stringutils.Builder, f *TestFilter) ([]any, *QueryError) {
+	var filtered bool
 
+	filter := make([]any, 0, 5)
 	if f.From > 0 {
 		if filtered {
 			_, _ = query.WriteString(" AND ts >= ?")
@@ -66,13 +79,90 @@ func (d *DB) GetTests(f TestFilter) ([]Test, *QueryError) {
 		if filtered {
 			_, _ = query.WriteString(" AND name LIKE ?")
 		} else {
+			filtered = true
 			_, _ = query.WriteString(" WHERE name LIKE ?")
 		}
 		filter = append(filter, f.Name)
 	}
+	if f.Search != "" {
+		if _, err := regexp.Compile(f.Search); err != nil {
+			return nil, NewQueryError(err, http.StatusBadRequest, "")
+		}
+		if filtered {
+			_, _ = query.WriteString(" AND (match(name, ?) OR match(params, ?))")
+		} else {
+			filtered = true
+			_, _ = query.WriteString(" WHERE (match(name, ?) OR match(params, ?))")
+		}
+		filter = append(filter, f.Search, f.Search)
+	}
+	for _, k := range sortedKeys(f.Params) {
+		if filtered {
+			_, _ = query.WriteString(" AND match(params, ?)")
+		} else {
+			filtered = true
+			_, _ = query.WriteString(" WHERE match(params, ?)")
+		}
+		filter = append(filter, paramRegexp(k, f.Params[k]))
+	}
+	if d.tableAnnotations == "" {
+		if len(f.Labels) > 0 {
+			return nil, ErrAnnotationsDisabled
+		}
+	} else {
+		if len(f.Labels) > 0 {
+			if filtered {
+				_, _ = query.WriteString(" AND ")
+			} else {
+				filtered = true
+				_, _ = query.WriteString(" WHERE ")
+			}
+			d.writeAnnotatedTests(query, "hasAll(labels, ?)")
+			filter = append(filter, f.Labels)
+		}
+		if !f.Hidden {
+			if filtered {
+				_, _ = query.WriteString(" AND NOT ")
+			} else {
+				_, _ = query.WriteString(" WHERE NOT ")
+			}
+			d.writeAnnotatedTests(query, "hidden = 1")
+		}
+	}
 
-	_, _ = query.WriteString(" ORDER BY id, ts, name")
-	rows, err := d.db.Query(query.String(), filter...)
+	return filter, nil
+}
+
+func (d *DB) GetTests(ctx context.Context, f TestFilter) ([]Test, *QueryError) {
+	return observed(d, "tests", func() ([]Test, *QueryError) { return d.getTests(ctx, f) })
+}
+
+func (d *DB) getTests(ctx context.Context, f TestFilter) ([]Test, *QueryError) {
+	var query stringutils.Builder
+
+	query.Grow(64)
+	_, _ = query.WriteString("SELECT id, ts, name, params FROM ")
+	_, _ = query.WriteString(d.tableTests)
+
+	filter, qErr := d.writeTestsWhere(&query, &f)
+	if qErr != nil {
+		return nil, qErr
+	}
+
+	if f.Desc {
+		_, _ = query.WriteString(" ORDER BY ts DESC, id DESC, name")
+	} else {
+		_, _ = query.WriteString(" ORDER BY id, ts, name")
+	}
+	if f.Limit > 0 {
+		_, _ = query.WriteString(" LIMIT ")
+		_, _ = query.WriteString(strconv.FormatUint(f.Limit, 10))
+	}
+	if f.Offset > 0 {
+		_, _ = query.WriteString(" OFFSET ")
+		_, _ = query.WriteString(strconv.FormatUint(f.Offset, 10))
+	}
+	rows, err := d.db.QueryContext(ctx, query.String(), filter...)
 	if err != nil {
 		// app.logger.Error().Uint64("id", c.Context().ID()).Str("sql", query.String()).Err(err).Msg("get tests")
 		return nil, NewQueryError(err, 0, query.String())
@@ -90,7 +180,7 @@ func (d *DB) GetTests(f TestFilter) ([]Test, *QueryError) {
 			// handle this error
 			return nil, NewQueryError(err, 0, query.String())
 		}
-		tests = append(tests, Test{Id: id, Ts: ts, Name: name, Params: params})
+		tests = append(tests, Test{Id: id, Ts: ts, Name: name, Params: params, ParamsMap: ParseParams(params)})
 	}
 	// get any error encountered during iteration
 	err = rows.Err()
@@ -98,10 +188,43 @@ func (d *DB) GetTests(f TestFilter) ([]Test, *QueryError) {
 		return nil, NewQueryError(err, 0, query.String())
 	}
 
+	if qErr := d.loadAnnotations(ctx, tests); qErr != nil {
+		return nil, qErr
+	}
+
 	return tests, nil
 }
 
-func (d *DB) GetTestById(f TestIdFilter) (Test, *QueryError) {
+// CountTests return total count of tests, matched with filter (Limit and Offset are ignored)
+func (d *DB) CountTests(ctx context.Context, f TestFilter) (uint64, *QueryError) {
+	return observed(d, "count", func() (uint64, *QueryError) { return d.countTests(ctx, f) })
+}
+
+func (d *DB) countTests(ctx context.Context, f TestFilter) (uint64, *QueryError) {
+	var query stringutils.Builder
+
+	query.Grow(64)
+	_, _ = query.WriteString("SELECT count() FROM ")
+	_, _ = query.WriteString(d.tableTests)
+
+	filter, qErr := d.writeTestsWhere(&query, &f)
+	if qErr != nil {
+		return 0, qErr
+	}
+
+	var count uint64
+	if err := d.db.QueryRowContext(ctx, query.String(), filter...).Scan(&count); err != nil {
+		return 0, NewQueryError(err, 0, query.String())
+	}
+
+	return count, nil
+}
+
+func (d *DB) GetTestById(ctx context.Context, f TestIdFilter) (Test, *QueryError) {
+	return observed(d, "test", func() (Test, *QueryError) { return d.getTestById(ctx, f) })
+}
+
+func (d *DB) getTestById(ctx context.Context, f TestIdFilter) (Test, *QueryError) {
 	var query stringutils.Builder
 
 	ts := timeutils.UnixNano(f.Time).UTC()
@@ -115,7 +238,7 @@ func (d *DB) GetTestById(f TestIdFilter) (Test, *QueryError) {
 
 	_, _ = query.WriteString(" ORDER BY id, ts, name")
 
-	rows, err := d.db.Query(query.String(), clickhouse.Named("Id", f.Id), clickhouse.DateNamed("Time", ts, 3))
+	rows, err := d.db.QueryContext(ctx, query.String(), clickhouse.Named("Id", f.Id), clickhouse.DateNamed("Time", ts, 3))
 	if err != nil {
 		// app.logger.Error().Uint64("id", c.Context().ID()).Str("sql", query.String()).Err(err).Msg("get tests")
 		return Test{}, NewQueryError(err, 0, query.String())
@@ -129,15 +252,23 @@ func (d *DB) GetTestById(f TestIdFilter) (Test, *QueryError) {
 			// handle this error
 			return Test{}, NewQueryError(err, 0, query.String())
 		}
-		if len(tests) > 1 {
-			return tests[0], NewQueryError(errors.New("duplicate test id"), 0, query.String())
+		test.ParamsMap = ParseParams(test.Params)
+		if len(tests) > 0 {
+			return Test{}, NewQueryErrorCode(ErrDuplicateTest, ErrCodeDuplicateTest, query.String())
 		}
 		tests = append(tests, test)
 	}
 	// get any error encountered during iteration
 	err = rows.Err()
 	if err != nil {
-		return tests[0], NewQueryError(err, 0, query.String())
+		return Test{}, NewQueryError(err, 0, query.String())
+	}
+	if len(tests) == 0 {
+		return Test{}, NewQueryError(ErrTestNotFound, http.StatusNotFound, "")
+	}
+
+	if qErr := d.loadAnnotations(ctx, tests); qErr != nil {
+		return Test{}, qErr
 	}
 
 	return tests[0], nil
@@ -0,0 +1,87 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTestById(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(db, "t_k6_tests", "t_k6_samples")
+
+	ts := time.Unix(1674196900, 0).UTC()
+	rows := []string{"id", "ts", "name", "params"}
+
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts = @Time AND id = @Id`).
+		WillReturnRows(sqlmock.NewRows(rows).AddRow(uint64(1), ts, "graphite_nightly", "USERS=1"))
+	test, qErr := d.GetTestById(context.Background(), TestIdFilter{Id: 1, Time: ts.UnixNano()})
+	if qErr != nil {
+		t.Fatal(qErr)
+	}
+	assert.Equal(t, Test{Id: 1, Ts: ts, Name: "graphite_nightly", Params: "USERS=1", ParamsMap: map[string]string{"USERS": "1"}}, test)
+
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts = @Time AND id = @Id`).
+		WillReturnRows(sqlmock.NewRows(rows))
+	_, qErr = d.GetTestById(context.Background(), TestIdFilter{Id: 1, Time: ts.UnixNano()})
+	if assert.NotNil(t, qErr) {
+		assert.Equal(t, ErrCodeNotFound, qErr.ErrorCode())
+		assert.Equal(t, http.StatusNotFound, qErr.Code())
+	}
+
+	mock.ExpectQuery(`^SELECT id, ts, name, params FROM t_k6_tests WHERE ts = @Time AND id = @Id`).
+		WillReturnRows(sqlmock.NewRows(rows).
+			AddRow(uint64(1), ts, "graphite_nightly", "USERS=1").
+			AddRow(uint64(1), ts, "graphite_nightly", "USERS=2"))
+	_, qErr = d.GetTestById(context.Background(), TestIdFilter{Id: 1, Time: ts.UnixNano()})
+	if assert.NotNil(t, qErr) {
+		assert.True(t, errors.Is(qErr.Wrapped(), ErrDuplicateTest))
+		assert.Equal(t, ErrCodeDuplicateTest, qErr.ErrorCode())
+		assert.Equal(t, http.StatusConflict, qErr.Code())
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteTestCluster(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passValueConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(db, "t_k6_tests", "t_k6_samples")
+	d.SetTableAnnotations("t_k6_tests_annotations")
+	d.SetTableRollup("t_k6_samples_rollup")
+	d.SetCluster("k6")
+
+	ts := time.Unix(1674196900, 0).UTC()
+
+	mock.ExpectQuery(`^SELECT count\(\) FROM t_k6_tests WHERE id = @Id AND ts = @Time$`).
+		WillReturnRows(sqlmock.NewRows([]string{"count()"}).AddRow(uint64(1)))
+	expectRollupViews(mock, ts)
+	mock.ExpectExec(`^ALTER TABLE t_k6_samples_local ON CLUSTER k6 DELETE WHERE id = @Id AND start = @Time$`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`^ALTER TABLE t_k6_samples_rollup_local ON CLUSTER k6 DELETE WHERE id = @Id AND start = @Time$`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`^ALTER TABLE t_k6_tests_annotations_local ON CLUSTER k6 DELETE WHERE id = @Id AND ts = @Time$`).
+		WillReturnError(errors.New("timeout"))
+	// test record is not deleted after failure
+	qErr := d.DeleteTest(context.Background(), TestIdFilter{Id: 1, Time: ts.UnixNano()})
+	assert.NotNil(t, qErr)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	mock.ExpectQuery(`^SELECT count\(\) FROM t_k6_tests WHERE id = @Id AND ts = @Time$`).
+		WillReturnRows(sqlmock.NewRows([]string{"count()"}).AddRow(uint64(0)))
+	qErr = d.DeleteTest(context.Background(), TestIdFilter{Id: 1, Time: ts.UnixNano()})
+	if assert.NotNil(t, qErr) {
+		assert.Equal(t, http.StatusNotFound, qErr.Code())
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -3,6 +3,7 @@ module github.com/msaf1980/k6-stat
 go 1.19
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/ClickHouse/clickhouse-go/v2 v2.5.1
 	github.com/DATA-DOG/go-sqlmock v1.5.0
 	github.com/goccy/go-json v0.10.0
@@ -16,6 +17,8 @@ require (
 	github.com/peterh/liner v1.2.2
 	github.com/rs/zerolog v1.28.0
 	github.com/stretchr/testify v1.8.1
+	golang.org/x/crypto v0.0.0-20220214200702-86341886e292
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -44,5 +47,4 @@ require (
 	go.opentelemetry.io/otel v1.11.2 // indirect
 	go.opentelemetry.io/otel/trace v1.11.2 // indirect
 	golang.org/x/sys v0.3.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,302 @@
+// Package migrate contains versioned DDL for k6-stat ClickHouse tables
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultTableTests       = "k6_tests"
+	DefaultTableSamples     = "k6_samples"
+	DefaultTableAnnotations = "k6_tests_annotations"
+	DefaultTableRollup      = "k6_samples_rollup"
+	DefaultTableSchema      = "k6_stat_schema"
+
+	// LocalSuffix is a suffix for local (replicated) tables in cluster deployment
+	LocalSuffix = "_local"
+
+	// RollupDurationsView and RollupReqsView are suffixes of rollup materialized views (attached to local rollup table)
+	RollupDurationsView = "_durations_mv"
+	RollupReqsView      = "_reqs_mv"
+)
+
+var ErrUnknownVersion = errors.New("database schema version is newer than known migrations")
+
+type Config struct {
+	TableTests       string
+	TableSamples     string
+	TableAnnotations string
+	TableRollup      string // per minute pre-aggregated samples (rollup migrations are skipped, if empty)
+	TableSchema      string // applied migrations table
+
+	// Cluster is a cluster name for ON CLUSTER DDL. If set, Replicated* local tables
+	// (with LocalSuffix) and Distributed tables (with configured names) are created.
+	Cluster string
+}
+
+func (cfg *Config) setDefaults() {
+	if cfg.TableTests == "" {
+		cfg.TableTests = DefaultTableTests
+	}
+	if cfg.TableSamples == "" {
+		cfg.TableSamples = DefaultTableSamples
+	}
+	if cfg.TableAnnotations == "" {
+		cfg.TableAnnotations = DefaultTableAnnotations
+	}
+	if cfg.TableSchema == "" {
+		cfg.TableSchema = DefaultTableSchema
+	}
+}
+
+func (cfg *Config) onCluster() string {
+	if cfg.Cluster == "" {
+		return ""
+	}
+	return " ON CLUSTER " + cfg.Cluster
+}
+
+// local return local table name (for cluster deployment)
+func (cfg *Config) local(name string) string {
+	if cfg.Cluster == "" {
+		return name
+	}
+	return name + LocalSuffix
+}
+
+// table return DDL for table (with distributed table for cluster), engine is a MergeTree engine family name without Replicated prefix
+func (cfg *Config) table(name, columns, engine, engineArgs, settings, shardingKey string) []string {
+	if cfg.Cluster == "" {
+		return []string{
+			"CREATE TABLE IF NOT EXISTS " + name + " (\n" + columns + "\n) ENGINE = " + engine + "(" + engineArgs + ")\n" + settings,
+		}
+	}
+	local := name + LocalSuffix
+	args := "'/clickhouse/tables/{shard}/{database}/" + local + "', '{replica}'"
+	if engineArgs != "" {
+		args += ", " + engineArgs
+	}
+	return []string{
+		"CREATE TABLE IF NOT EXISTS " + local + cfg.onCluster() + " (\n" + columns + "\n) ENGINE = Replicated" + engine + "(" + args + ")\n" + settings,
+		"CREATE TABLE IF NOT EXISTS " + name + cfg.onCluster() + " AS " + local +
+			" ENGINE = Distributed(" + cfg.Cluster + ", currentDatabase(), " + local + ", " + shardingKey + ")",
+	}
+}
+
+// Migration is a versioned schema change
+type Migration struct {
+	Version     uint32
+	Description string
+	// Up return DDL statements for apply migration
+	Up func(cfg *Config) []string
+	// Skip is an optional check for disabled migration (skipped migration is not marked as applied, so it can be applied later)
+	Skip func(cfg *Config) bool
+}
+
+func skipRollup(cfg *Config) bool {
+	return cfg.TableRollup == ""
+}
+
+// Migrations is an ordered list of known migrations. Never change applied migrations, add a new one.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create tests table",
+		Up: func(cfg *Config) []string {
+			return cfg.table(cfg.TableTests,
+				"\tid UInt64,\n\tts DateTime64(9, 'UTC'),\n\tname String,\n\tparams String",
+				"ReplacingMergeTree", "id",
+				"PARTITION BY toYYYYMM(ts)\nORDER BY (id, ts, name)",
+				"id",
+			)
+		},
+	},
+	{
+		Version:     2,
+		Description: "create samples table",
+		Up: func(cfg *Config) []string {
+			return cfg.table(cfg.TableSamples,
+				"\tid UInt64,\n\tstart DateTime64(9, 'UTC'),\n\tts DateTime64(9, 'UTC'),\n\tmetric String,\n\turl String,\n\tlabel String,\n"+
+					"\tstatus String,\n\tname String,\n\ttags Map(String, String),\n\tvalue Float64",
+				"ReplacingMergeTree", "start",
+				"PARTITION BY toYYYYMM(ts)\nORDER BY (id, ts, metric, name)",
+				"id",
+			)
+		},
+	},
+	{
+		Version:     3,
+		Description: "create tests annotations table",
+		Up: func(cfg *Config) []string {
+			return cfg.table(cfg.TableAnnotations,
+				"\tid UInt64,\n\tts DateTime64(9, 'UTC'),\n\tname String,\n\ttext String,\n\tlabels Array(String),\n"+
+					"\thidden UInt8,\n\tupdated DateTime64(3, 'UTC')",
+				"ReplacingMergeTree", "updated",
+				"ORDER BY (id, ts)",
+				"id",
+			)
+		},
+	},
+	{
+		Version:     4,
+		Description: "create samples rollup table and materialized views",
+		Skip:        skipRollup,
+		Up: func(cfg *Config) []string {
+			ddl := cfg.table(cfg.TableRollup,
+				"\tid UInt64,\n\tstart DateTime64(9, 'UTC'),\n\tlabel String,\n\turl String,\n\tstatus String,\n\tts DateTime('UTC'),\n"+
+					"\treqs AggregateFunction(sum, Float64),\n\td_count AggregateFunction(count),\n\td_sum AggregateFunction(sum, Float64),\n"+
+					"\td_min AggregateFunction(min, Float64),\n\td_max AggregateFunction(max, Float64),\n"+
+					"\td_stddev AggregateFunction(stddevPop, Float64),\n\td_quantiles AggregateFunction(quantilesTiming(0.5), Float64)",
+				"AggregatingMergeTree", "",
+				"PARTITION BY toYYYYMM(ts)\nORDER BY (id, start, label, url, status, ts)",
+				"id",
+			)
+			// views are attached to local tables (in cluster deployment)
+			samples := cfg.local(cfg.TableSamples)
+			rollup := cfg.local(cfg.TableRollup)
+			return append(ddl,
+				"CREATE MATERIALIZED VIEW IF NOT EXISTS "+rollup+RollupDurationsView+cfg.onCluster()+" TO "+rollup+" AS\n"+
+					"SELECT id, start, label, url, status, toStartOfMinute(ts) AS ts, countState() AS d_count, sumState(value) AS d_sum,\n"+
+					"minState(value) AS d_min, maxState(value) AS d_max, stddevPopState(value) AS d_stddev, quantilesTimingState(0.5)(value) AS d_quantiles\n"+
+					"FROM "+samples+" WHERE metric = 'http_req_duration' GROUP BY id, start, label, url, status, ts",
+				"CREATE MATERIALIZED VIEW IF NOT EXISTS "+rollup+RollupReqsView+cfg.onCluster()+" TO "+rollup+" AS\n"+
+					"SELECT id, start, label, url, status, toStartOfMinute(ts) AS ts, sumState(value) AS reqs\n"+
+					"FROM "+samples+" WHERE metric = 'http_reqs' GROUP BY id, start, label, url, status, ts",
+			)
+		},
+	},
+}
+
+// MigrationStatus is a migration apply status
+type MigrationStatus struct {
+	Version     uint32
+	Description string
+	Applied     time.Time // zero if not applied
+}
+
+type Migrator struct {
+	db  *sql.DB
+	cfg Config
+}
+
+func New(db *sql.DB, cfg Config) *Migrator {
+	cfg.setDefaults()
+	return &Migrator{db: db, cfg: cfg}
+}
+
+func (m *Migrator) Config() Config {
+	return m.cfg
+}
+
+// schemaTable return DDL for applied migrations table (in cluster it's replicated to all cluster nodes)
+func (m *Migrator) schemaTable() string {
+	var engine string
+	if m.cfg.Cluster == "" {
+		engine = "MergeTree()"
+	} else {
+		engine = "ReplicatedMergeTree('/clickhouse/tables/{database}/" + m.cfg.TableSchema + "', '{replica}')"
+	}
+	return "CREATE TABLE IF NOT EXISTS " + m.cfg.TableSchema + m.cfg.onCluster() +
+		" (\n\tversion UInt32,\n\tdescription String,\n\tapplied DateTime('UTC')\n) ENGINE = " + engine + "\nORDER BY version"
+}
+
+// applied return applied migrations (version -> apply time)
+func (m *Migrator) applied() (map[uint32]time.Time, error) {
+	if _, err := m.db.Exec(m.schemaTable()); err != nil {
+		return nil, fmt.Errorf("create %s: %w", m.cfg.TableSchema, err)
+	}
+	rows, err := m.db.Query("SELECT version, applied FROM " + m.cfg.TableSchema + " ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[uint32]time.Time)
+	for rows.Next() {
+		var (
+			version uint32
+			ts      time.Time
+		)
+		if err = rows.Scan(&version, &ts); err != nil {
+			return nil, err
+		}
+		applied[version] = ts.UTC()
+	}
+	return applied, rows.Err()
+}
+
+// Status return known migrations status
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, 0, len(Migrations))
+	for _, migration := range Migrations {
+		status = append(status, MigrationStatus{
+			Version: migration.Version, Description: migration.Description, Applied: applied[migration.Version],
+		})
+	}
+	for version := range applied {
+		if version > Migrations[len(Migrations)-1].Version {
+			return status, ErrUnknownVersion
+		}
+	}
+	return status, nil
+}
+
+// Up apply not applied migrations and return applied
+func (m *Migrator) Up() ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	for version := range applied {
+		if version > Migrations[len(Migrations)-1].Version {
+			return nil, ErrUnknownVersion
+		}
+	}
+	done := make([]Migration, 0, len(Migrations))
+	for _, migration := range Migrations {
+		if _, exist := applied[migration.Version]; exist {
+			continue
+		}
+		if migration.Skip != nil && migration.Skip(&m.cfg) {
+			continue
+		}
+		for _, ddl := range migration.Up(&m.cfg) {
+			if _, err = m.db.Exec(ddl); err != nil {
+				return done, fmt.Errorf("migration %d (%s): %w, sql: %s", migration.Version, migration.Description, err, oneLine(ddl))
+			}
+		}
+		if err = m.setApplied(migration); err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Description, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+func (m *Migrator) setApplied(migration Migration) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT INTO " + m.cfg.TableSchema + " (version, description, applied)")
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if _, err = stmt.Exec(migration.Version, migration.Description, time.Now().UTC().Truncate(time.Second)); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,110 @@
+package migrate
+
+import (
+	"database/sql/driver"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrationsOrder(t *testing.T) {
+	for i := range Migrations {
+		assert.Equal(t, uint32(i+1), Migrations[i].Version, Migrations[i].Description)
+	}
+}
+
+func TestMigrationsCluster(t *testing.T) {
+	cfg := Config{TableTests: "t_k6_tests", Cluster: "test"}
+	cfg.setDefaults()
+
+	ddl := Migrations[0].Up(&cfg)
+	assert.Equal(t, []string{
+		"CREATE TABLE IF NOT EXISTS t_k6_tests_local ON CLUSTER test (\n\tid UInt64,\n\tts DateTime64(9, 'UTC'),\n\tname String,\n\tparams String\n)" +
+			" ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/{shard}/{database}/t_k6_tests_local', '{replica}', id)\n" +
+			"PARTITION BY toYYYYMM(ts)\nORDER BY (id, ts, name)",
+		"CREATE TABLE IF NOT EXISTS t_k6_tests ON CLUSTER test AS t_k6_tests_local ENGINE = Distributed(test, currentDatabase(), t_k6_tests_local, id)",
+	}, ddl)
+
+	cfg.Cluster = ""
+	ddl = Migrations[0].Up(&cfg)
+	assert.Equal(t, []string{
+		"CREATE TABLE IF NOT EXISTS t_k6_tests (\n\tid UInt64,\n\tts DateTime64(9, 'UTC'),\n\tname String,\n\tparams String\n)" +
+			" ENGINE = ReplacingMergeTree(id)\nPARTITION BY toYYYYMM(ts)\nORDER BY (id, ts, name)",
+	}, ddl)
+}
+
+type anyValue struct{}
+
+func (anyValue) Match(driver.Value) bool {
+	return true
+}
+
+func expectMigration(mock sqlmock.Sqlmock, cfg *Config, migration Migration) {
+	for _, ddl := range migration.Up(cfg) {
+		mock.ExpectExec("^" + regexp.QuoteMeta(ddl) + "$").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectBegin()
+	mock.ExpectPrepare(`^INSERT INTO k6_stat_schema \(version, description, applied\)$`).
+		ExpectExec().WithArgs(migration.Version, migration.Description, anyValue{}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestMigratorUp(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	m := New(db, Config{})
+	schema := regexp.QuoteMeta(m.schemaTable())
+
+	mock.ExpectExec("^" + schema + "$").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`^SELECT version, applied FROM k6_stat_schema ORDER BY version$`).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied"}).AddRow(uint32(1), time.Now()))
+	// rollup migrations are skipped without rollup table
+	var skipped []uint32
+	for _, migration := range Migrations[1:] {
+		if migration.Skip != nil && migration.Skip(&m.cfg) {
+			skipped = append(skipped, migration.Version)
+			continue
+		}
+		expectMigration(mock, &m.cfg, migration)
+	}
+
+	done, err := m.Up()
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{4}, skipped)
+	assert.Equal(t, len(Migrations)-1-len(skipped), len(done))
+	assert.Equal(t, uint32(2), done[0].Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// rollup enabled later
+	m = New(db, Config{TableRollup: DefaultTableRollup})
+	rows := sqlmock.NewRows([]string{"version", "applied"})
+	for _, migration := range Migrations {
+		if migration.Skip == nil {
+			rows.AddRow(migration.Version, time.Now())
+		}
+	}
+	mock.ExpectExec("^" + schema + "$").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`^SELECT version, applied FROM k6_stat_schema ORDER BY version$`).WillReturnRows(rows)
+	for _, version := range skipped {
+		expectMigration(mock, &m.cfg, Migrations[version-1])
+	}
+	done, err = m.Up()
+	assert.NoError(t, err)
+	assert.Equal(t, len(skipped), len(done))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// newer schema
+	mock.ExpectExec("^" + schema + "$").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`^SELECT version, applied FROM k6_stat_schema ORDER BY version$`).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied"}).AddRow(uint32(len(Migrations)+1), time.Now()))
+	_, err = m.Up()
+	assert.Equal(t, ErrUnknownVersion, err)
+}
@@ -24,3 +24,15 @@ func GetEnvInt(key string, defaultValue int) (n int, err error) {
 	}
 	return
 }
+
+func GetEnvBool(key string, defaultValue bool) (b bool, err error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue, err
+	}
+
+	if b, err = strconv.ParseBool(v); err != nil {
+		b = defaultValue
+	}
+	return
+}